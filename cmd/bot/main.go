@@ -56,6 +56,7 @@ func main() {
 
         // Initialize services
         botManager := services.NewBotManagerService(db)
+        botManager.SetStoreBotFactory(bot.NewStoreBotFactory(db, nil, bot.DefaultUpdateWorkers))
 
         // Initialize and start the mother bot (CodeRoot)
         log.Println("🤖 Starting CodeRoot Mother Bot...")
@@ -0,0 +1,53 @@
+// Command rotate-token-key re-encrypts every stored bot token with the
+// current master key. To rotate, set TOKEN_ENCRYPTION_KEY to the new key and
+// TOKEN_ENCRYPTION_OLD_KEYS to the key being replaced, run this command, then
+// drop the old key. Tokens saved before encryption was enabled are encrypted
+// by the same run.
+package main
+
+import (
+	"flag"
+	"log"
+	"telegram-store-hub/internal/config"
+	"telegram-store-hub/internal/database"
+	"telegram-store-hub/internal/secrets"
+	"telegram-store-hub/internal/services"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report how many tokens need re-encryption")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("❌ Failed to load config: %v", err)
+	}
+
+	keyring, err := secrets.LoadKeyring(cfg.TokenEncryptionKey, cfg.TokenEncryptionOldKeys)
+	if err != nil {
+		log.Fatalf("❌ Invalid token encryption key: %v", err)
+	}
+	secrets.SetKeyring(keyring)
+
+	db, err := database.Connect(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("❌ Database connection failed: %v", err)
+	}
+
+	if *dryRun {
+		tx := db.Begin()
+		rotated, err := services.ReencryptBotTokens(tx, keyring)
+		tx.Rollback()
+		if err != nil {
+			log.Fatalf("❌ Dry run failed: %v", err)
+		}
+		log.Printf("🔍 %d bot tokens would be re-encrypted with key %s", rotated, keyring.PrimaryKeyID())
+		return
+	}
+
+	rotated, err := services.ReencryptBotTokens(db, keyring)
+	if err != nil {
+		log.Fatalf("❌ Rotation stopped after %d tokens: %v", rotated, err)
+	}
+	log.Printf("✅ %d bot tokens re-encrypted with key %s", rotated, keyring.PrimaryKeyID())
+}
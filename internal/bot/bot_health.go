@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// unhealthyBotsShown caps the admin list so it fits in one message
+const unhealthyBotsShown = 20
+
+// showUnhealthyBots lists the store bots that failed their last health check
+func (mb *MotherBot) showUnhealthyBots(chatID int64) {
+	checks, err := mb.botManager.GetUnhealthyBots()
+	if err != nil {
+		log.Printf("Failed to list unhealthy bots: %v", err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 بروزرسانی", "admin_bot_health"),
+		),
+	)
+
+	if len(checks) == 0 {
+		msg := tgbotapi.NewMessage(chatID, messages.BotHealthAllHealthy)
+		msg.ReplyMarkup = keyboard
+		mb.send(msg)
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "🩺 ربات‌های ناسالم: %d\n", len(checks))
+
+	for i, check := range checks {
+		if i == unhealthyBotsShown {
+			fmt.Fprintf(&text, "\n… و %d ربات دیگر", len(checks)-unhealthyBotsShown)
+			break
+		}
+
+		fmt.Fprintf(&text, "\n🏪 %s (ID: %d) - @%s\n", check.Store.Name, check.StoreID, check.Store.BotUsername)
+		if check.UnhealthySince != nil {
+			fmt.Fprintf(&text, "⏱ از %s", check.UnhealthySince.Format(messages.DateTimeFormat))
+		}
+		if check.AlertedAt == nil {
+			text.WriteString(" (اطلاع‌رسانی نشده)")
+		}
+		text.WriteString("\n")
+		for _, problem := range strings.Split(check.Problems, "\n") {
+			fmt.Fprintf(&text, "• %s\n", problem)
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = keyboard
+	mb.send(msg)
+}
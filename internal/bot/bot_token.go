@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// botTokenSession is the session data kept while waiting for a bot token
+type botTokenSession struct {
+	StoreID uint `json:"store_id"`
+}
+
+// startBotConnection asks the seller for the token of their store bot. The
+// same flow replaces the token of an already connected bot.
+func (mb *MotherBot) startBotConnection(chatID int64) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	if err := mb.sessionService.SetUserState(chatID, messages.StateWaitingBotToken, botTokenSession{StoreID: store.ID}); err != nil {
+		log.Printf("Error setting bot token state: %v", err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	text := messages.BotTokenInstructions
+	if store.BotUsername != "" {
+		text += fmt.Sprintf(messages.BotTokenRotationNote, store.BotUsername)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"),
+		),
+	)
+
+	mb.send(msg)
+}
+
+// handleBotTokenInput verifies a pasted token and connects it to the seller's store
+func (mb *MotherBot) handleBotTokenInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	// Keep the token out of the chat history
+	if _, err := mb.bot.Request(tgbotapi.NewDeleteMessage(chatID, message.MessageID)); err != nil {
+		log.Printf("❌ Failed to delete bot token message in chat %d: %v", chatID, err)
+	}
+
+	var data botTokenSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may connect a bot to the store
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	store, err = mb.botManager.ConnectBotToken(store.ID, message.Text)
+	switch {
+	case errors.Is(err, services.ErrBotTokenMalformed):
+		// Stay in the waiting state so the seller can paste again
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorBotTokenMalformed))
+		return
+	case errors.Is(err, services.ErrBotTokenRejected):
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorBotTokenRejected))
+		return
+	case errors.Is(err, services.ErrBotTokenInUse):
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorBotTokenInUse))
+		return
+	case err != nil:
+		log.Printf("Error connecting bot for store %d: %v", data.StoreID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorBotTokenCheck))
+		return
+	}
+
+	mb.sessionService.ClearUserState(chatID)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.SuccessBotConnected, store.BotUsername, store.Name, store.BotUsername))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("🔗 باز کردن ربات", fmt.Sprintf("https://t.me/%s", store.BotUsername)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonManageStore, "manage_store"),
+		),
+	)
+
+	mb.send(msg)
+}
+
+// getOwnerStore returns the store owned by the given Telegram user
+func (mb *MotherBot) getOwnerStore(chatID int64) (*models.Store, error) {
+	var user models.User
+	if err := mb.db.Where("telegram_id = ?", chatID).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	var store models.Store
+	if err := mb.db.Where("owner_id = ?", user.ID).First(&store).Error; err != nil {
+		return nil, err
+	}
+
+	return &store, nil
+}
@@ -2,6 +2,7 @@ package bot
 
 import (
         "encoding/json"
+        "errors"
         "fmt"
         "log"
         "strconv"
@@ -9,8 +10,10 @@ import (
 
         "telegram-store-hub/internal/messages"
         "telegram-store-hub/internal/models"
+        "telegram-store-hub/internal/services"
 
         tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+        "gorm.io/gorm"
 )
 
 func (mb *MotherBot) handleCallbackQuery(callback *tgbotapi.CallbackQuery) {
@@ -125,7 +128,7 @@ func (mb *MotherBot) handlePlanSelection(chatID int64, user *models.User, data s
         
         if planType == "free" {
                 // Free plan - activate immediately
-                mb.activateStore(store.ID, user)
+                mb.activateStore(mb.db, store.ID, user)
                 mb.sessionService.ClearSession(user.TelegramID)
                 mb.sendMessage(chatID, "🎉 فروشگاه رایگان شما فعال شد! از منوی اصلی 'فروشگاه‌های من' را انتخاب کنید.")
         } else {
@@ -237,7 +240,7 @@ func (mb *MotherBot) handleProductList(chatID int64, user *models.User, data str
 
                 msg := tgbotapi.NewMessage(chatID, productText)
                 msg.ReplyMarkup = keyboard
-                mb.bot.Send(msg)
+                mb.send(msg)
         }
 }
 
@@ -255,8 +258,7 @@ func (mb *MotherBot) handleOrdersList(chatID int64, user *models.User, data stri
                 return
         }
 
-        // This would be implemented with OrderService
-        mb.sendMessage(chatID, "🛒 بخش سفارش‌ها در حال توسعه است")
+        mb.showOrderInbox(chatID, 0, store, "", 0)
 }
 
 func (mb *MotherBot) handleSalesReport(chatID int64, user *models.User, data string) {
@@ -273,8 +275,7 @@ func (mb *MotherBot) handleSalesReport(chatID int64, user *models.User, data str
                 return
         }
 
-        // This would be implemented with OrderService
-        mb.sendMessage(chatID, "📈 بخش گزارش فروش در حال توسعه است")
+        mb.showSalesReport(chatID)
 }
 
 func (mb *MotherBot) handleRenewPlan(chatID int64, user *models.User, data string) {
@@ -306,7 +307,7 @@ func (mb *MotherBot) handleRenewPlan(chatID int64, user *models.User, data strin
         renewText := fmt.Sprintf("🔄 تمدید پلن %s\n\nمدت زمان تمدید را انتخاب کنید:", strings.ToUpper(store.PlanType))
         msg := tgbotapi.NewMessage(chatID, renewText)
         msg.ReplyMarkup = keyboard
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) handleStoreSettings(chatID int64, user *models.User, data string) {
@@ -355,7 +356,7 @@ func (mb *MotherBot) handleStoreSettings(chatID int64, user *models.User, data s
 
         msg := tgbotapi.NewMessage(chatID, settingsText)
         msg.ReplyMarkup = keyboard
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) handleAdminCallback(chatID int64, user *models.User, data string) {
@@ -431,11 +432,19 @@ func (mb *MotherBot) handleRenewalApproval(chatID int64, user *models.User, data
                 return
         }
 
-        err := mb.processRenewalApproval(uint(paymentID), months, user)
-        if err != nil {
+        var payment *models.Payment
+        err := mb.idempotency.Once(fmt.Sprintf("renewal:%d", paymentID), func(tx *gorm.DB) error {
+                var err error
+                payment, err = mb.processRenewalApproval(tx, uint(paymentID), months, user)
+                return err
+        })
+        if errors.Is(err, services.ErrActionDone) {
+                mb.sendMessage(chatID, messages.InfoAlreadyDone)
+        } else if err != nil {
                 mb.sendMessage(chatID, messages.ErrorGeneral)
         } else {
                 mb.sendMessage(chatID, "✅ تمدید تایید شد و فروشگاه تمدید شد")
+                mb.notifyRenewal(payment)
         }
 }
 
@@ -459,31 +468,50 @@ func (mb *MotherBot) handlePaymentApproval(chatID int64, user *models.User, data
                 return
         }
 
-        if approve {
-                err = mb.paymentService.ApprovePayment(uint(paymentID), user.ID)
-                if err == nil {
-                        // Activate store
-                        mb.activateStore(payment.StoreID, &payment.Store.Owner)
-                        mb.sendMessage(chatID, "✅ پرداخت تایید شد و فروشگاه فعال شد")
-                        
-                        // Notify store owner
-                        mb.sendMessage(payment.Store.Owner.TelegramID, fmt.Sprintf(messages.PaymentApproved, payment.Store.BotUsername, payment.Store.BotToken))
+        // Approving and rejecting share one key: a double tap, or a second
+        // admin deciding the same payment, has no effect
+        err = mb.idempotency.Once(fmt.Sprintf("payment:%d", paymentID), func(tx *gorm.DB) error {
+                payments := services.NewPaymentService(tx)
+                if !approve {
+                        return payments.RejectPayment(uint(paymentID), user.ID, messages.PaymentRejectedNote)
                 }
-        } else {
-                err = mb.paymentService.RejectPayment(uint(paymentID), user.ID)
-                if err == nil {
-                        mb.sendMessage(chatID, "❌ پرداخت رد شد")
-                        // Notify store owner
-                        mb.sendMessage(payment.Store.Owner.TelegramID, "❌ پرداخت شما رد شد. لطفاً با پشتیبانی تماس بگیرید.")
+                if err := payments.ApprovePayment(uint(paymentID), user.ID); err != nil {
+                        return err
                 }
-        }
+                return mb.activateStore(tx, payment.StoreID, &payment.Store.Owner)
+        })
 
-        if err != nil {
+        switch {
+        case errors.Is(err, services.ErrActionDone):
+                mb.sendMessage(chatID, messages.InfoAlreadyDone)
+        case err != nil:
+                log.Printf("Error deciding payment %d: %v", paymentID, err)
                 mb.sendMessage(chatID, messages.ErrorGeneral)
+        case approve:
+                mb.sendMessage(chatID, "✅ پرداخت تایید شد و فروشگاه فعال شد")
+                // Notify store owner
+                mb.sendMessage(payment.Store.Owner.TelegramID, fmt.Sprintf(messages.PaymentApproved, payment.Store.BotUsername, payment.Store.BotToken))
+        default:
+                mb.sendMessage(chatID, "❌ پرداخت رد شد")
+                // Notify store owner
+                mb.sendMessage(payment.Store.Owner.TelegramID, "❌ پرداخت شما رد شد. لطفاً با پشتیبانی تماس بگیرید.")
         }
 }
 
 // Helper methods
+
+// sendMessage sends a plain text message, logging when it can't be sent
+func (mb *MotherBot) sendMessage(chatID int64, text string) {
+        mb.send(tgbotapi.NewMessage(chatID, text))
+}
+
+// send sends a message, logging when it can't be sent
+func (mb *MotherBot) send(c tgbotapi.Chattable) {
+        if _, err := mb.bot.Send(c); err != nil {
+                log.Printf("❌ Failed to send message: %v", err)
+        }
+}
+
 func (mb *MotherBot) getPlanPrice(planType string) int {
         switch planType {
         case "free":
@@ -523,17 +551,20 @@ func (mb *MotherBot) formatPrice(price int) string {
         return result
 }
 
-func (mb *MotherBot) activateStore(storeID uint, owner *models.User) error {
+// activateStore activates a store in db, which may be a transaction
+func (mb *MotherBot) activateStore(db *gorm.DB, storeID uint, owner *models.User) error {
+        stores := services.NewStoreService(db)
+
         // Generate bot username and token (mock implementation)
-        store, err := mb.storeService.GetStoreByID(storeID)
+        store, err := stores.GetStoreByID(storeID)
         if err != nil {
                 return err
         }
 
-        botUsername := mb.storeService.GenerateBotUsername(store.Name, storeID)
+        botUsername := stores.GenerateBotUsername(store.Name, storeID)
         botToken := fmt.Sprintf("mock_token_%d", storeID) // In real implementation, create actual bot
 
-        return mb.storeService.ActivateStore(storeID, botToken, botUsername)
+        return stores.ActivateStore(storeID, botToken, botUsername)
 }
 
 func (mb *MotherBot) showAdminStats(chatID int64) {
@@ -586,7 +617,7 @@ func (mb *MotherBot) showAdminStores(chatID int64) {
 
                 msg := tgbotapi.NewMessage(chatID, storeText)
                 msg.ReplyMarkup = keyboard
-                mb.bot.Send(msg)
+                mb.send(msg)
         }
 }
 
@@ -624,11 +655,11 @@ func (mb *MotherBot) showAdminPayments(chatID int64) {
                         photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileURL(payment.ProofImageURL))
                         photoMsg.Caption = paymentText
                         photoMsg.ReplyMarkup = keyboard
-                        mb.bot.Send(photoMsg)
+                        mb.send(photoMsg)
                 } else {
                         msg := tgbotapi.NewMessage(chatID, paymentText)
                         msg.ReplyMarkup = keyboard
-                        mb.bot.Send(msg)
+                        mb.send(msg)
                 }
         }
 }
\ No newline at end of file
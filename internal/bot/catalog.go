@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// catalogPageSize is how many products a catalog page lists
+const catalogPageSize = 8
+
+// showProducts shows the store's product categories, or the first page of
+// its only category, as a new message or by editing messageID in place
+func (sb *SubBot) showProducts(chatID int64, messageID int) {
+	categories, err := sb.products.GetStoreCategories(sb.store.ID)
+	if err != nil {
+		log.Printf("❌ Failed to get categories of store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت محصولات")
+		return
+	}
+
+	switch len(categories) {
+	case 0:
+		sb.sendOrEdit(chatID, messageID, messages.CatalogEmpty, nil)
+		return
+	case 1:
+		sb.showCatalogPage(chatID, messageID, categories, 0, 0)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, category := range categories {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf(messages.ButtonCatalogCategory, categoryName(category.Category), category.Products),
+				fmt.Sprintf("catalog_%d_0", i),
+			),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🛒 مشاهده سبد خرید", "show_cart"),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.sendOrEdit(chatID, messageID, fmt.Sprintf(messages.CatalogTitle, sb.store.Name), &keyboard)
+}
+
+// showCatalogCallback handles the catalog page buttons:
+// catalog_<category index>_<page>
+func (sb *SubBot) showCatalogCallback(chatID int64, messageID int, data string) {
+	parts := strings.Split(strings.TrimPrefix(data, "catalog_"), "_")
+	if len(parts) != 2 {
+		sb.showProducts(chatID, messageID)
+		return
+	}
+	index, err := strconv.Atoi(parts[0])
+	if err != nil {
+		sb.showProducts(chatID, messageID)
+		return
+	}
+	page, _ := strconv.Atoi(parts[1])
+
+	categories, err := sb.products.GetStoreCategories(sb.store.ID)
+	if err != nil {
+		log.Printf("❌ Failed to get categories of store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت محصولات")
+		return
+	}
+	if index < 0 || index >= len(categories) {
+		// The categories changed since the menu was shown
+		sb.showProducts(chatID, messageID)
+		return
+	}
+	sb.showCatalogPage(chatID, messageID, categories, index, page)
+}
+
+// showCatalogPage shows a page of the products of a category, each with a
+// button to its card
+func (sb *SubBot) showCatalogPage(chatID int64, messageID int, categories []services.CategoryCount, index, page int) {
+	category := categories[index]
+	products, err := sb.products.GetProductsByCategory(sb.store.ID, category.Category)
+	if err != nil {
+		log.Printf("❌ Failed to get products of store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت محصولات")
+		return
+	}
+	if len(products) == 0 {
+		// Sold out since the menu was shown
+		sb.showProducts(chatID, messageID)
+		return
+	}
+
+	pages := (len(products) + catalogPageSize - 1) / catalogPageSize
+	page = min(max(page, 0), pages-1)
+	first := page * catalogPageSize
+	products = products[first:min(first+catalogPageSize, len(products))]
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.CatalogPageTitle, categoryName(category.Category))
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, product := range products {
+		fmt.Fprintf(&text, messages.CatalogLine, first+i+1, product.Name, services.FormatPrice(product.Price))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf(messages.ButtonCatalogProduct, product.Name),
+				fmt.Sprintf("buy_%d", product.ID),
+			),
+		))
+	}
+	if pages > 1 {
+		fmt.Fprintf(&text, messages.CatalogPage, page+1, pages)
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️", fmt.Sprintf("catalog_%d_%d", index, page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("▶️", fmt.Sprintf("catalog_%d_%d", index, page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+
+	var menu []tgbotapi.InlineKeyboardButton
+	if len(categories) > 1 {
+		menu = append(menu, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCategories, "show_products"))
+	}
+	menu = append(menu, tgbotapi.NewInlineKeyboardButtonData("🛒 مشاهده سبد خرید", "show_cart"))
+	rows = append(rows, menu)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.sendOrEdit(chatID, messageID, text.String(), &keyboard)
+}
+
+// categoryName is how a product category is shown to customers
+func categoryName(category string) string {
+	if category == "" {
+		return messages.CatalogUncategorized
+	}
+	return category
+}
@@ -0,0 +1,539 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Checkout reply keyboard buttons
+const (
+	checkoutCancelButton   = "❌ انصراف"
+	checkoutNoNotesButton  = "بدون توضیحات"
+	checkoutContactButton  = "📱 ارسال شماره تماس"
+	checkoutNoCouponButton = "بدون کد تخفیف"
+)
+
+// startCheckout begins collecting the delivery details for the cart
+func (sb *SubBot) startCheckout(chatID int64) {
+	err := sb.carts.StartCheckout(sb.store.ID, chatID)
+	if errors.Is(err, services.ErrCartEmpty) {
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutCartEmpty))
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to start checkout in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت سفارش")
+		return
+	}
+
+	// Stores with shipping methods start with their choice
+	step, err := sb.carts.CheckoutStep(sb.store.ID, chatID)
+	if err != nil {
+		log.Printf("❌ Failed to get checkout step in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت سفارش")
+		return
+	}
+	sb.askCheckoutStep(chatID, step)
+}
+
+// handleCheckoutAnswer takes the customer's answer to the checkout step
+// waiting for it and asks the next question
+func (sb *SubBot) handleCheckoutAnswer(message *tgbotapi.Message, step string) {
+	chatID := message.Chat.ID
+	if message.Text == checkoutCancelButton {
+		sb.cancelCheckout(chatID)
+		return
+	}
+
+	answer := strings.TrimSpace(message.Text)
+	switch step {
+	case services.CheckoutStepPhone:
+		if message.Contact != nil {
+			// Only the customer's own number, shared with the button, counts
+			if message.From == nil || message.Contact.UserID != message.From.ID {
+				sb.askCheckoutStepWith(chatID, step, messages.CheckoutForeignContact)
+				return
+			}
+			answer = message.Contact.PhoneNumber
+		}
+		phone, ok := normalizePhone(answer)
+		if !ok {
+			sb.askCheckoutStepWith(chatID, step, messages.CheckoutInvalidPhone)
+			return
+		}
+		answer = phone
+	case services.CheckoutStepNotes:
+		if answer == checkoutNoNotesButton {
+			answer = ""
+		} else if answer == "" {
+			sb.askCheckoutStepWith(chatID, step, messages.CheckoutTextOnly)
+			return
+		}
+	case services.CheckoutStepShipping:
+		// The method is chosen with the buttons under the options
+		sb.showShippingOptions(chatID)
+		return
+	case services.CheckoutStepConfirm:
+		// The order is confirmed with the buttons under the review
+		sb.showCheckoutReview(chatID)
+		return
+	case services.CheckoutStepCoupon:
+		sb.applyCoupon(chatID, answer)
+		return
+	default:
+		if answer == "" {
+			sb.askCheckoutStepWith(chatID, step, messages.CheckoutTextOnly)
+			return
+		}
+	}
+
+	next, err := sb.carts.SaveCheckoutAnswer(sb.store.ID, chatID, step, answer)
+	if err != nil {
+		log.Printf("❌ Failed to save checkout answer in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت اطلاعات سفارش")
+		return
+	}
+	sb.askCheckoutStep(chatID, next)
+}
+
+// askCheckoutStep asks the question of a checkout step
+func (sb *SubBot) askCheckoutStep(chatID int64, step string) {
+	switch step {
+	case services.CheckoutStepShipping:
+		sb.showShippingOptions(chatID)
+	case services.CheckoutStepCity:
+		sb.askCheckoutStepWith(chatID, step, messages.CheckoutAskCity)
+	case services.CheckoutStepAddress:
+		sb.askCheckoutStepWith(chatID, step, messages.CheckoutAskAddress)
+	case services.CheckoutStepPhone:
+		sb.askCheckoutStepWith(chatID, step, messages.CheckoutAskPhone)
+	case services.CheckoutStepNotes:
+		sb.askCheckoutStepWith(chatID, step, messages.CheckoutAskNotes)
+	case services.CheckoutStepConfirm:
+		sb.showCheckoutReview(chatID)
+	case services.CheckoutStepCoupon:
+		sb.askCheckoutStepWith(chatID, step, messages.CheckoutAskCoupon)
+	}
+}
+
+// askCheckoutStepWith sends text with the reply keyboard of a checkout step
+func (sb *SubBot) askCheckoutStepWith(chatID int64, step, text string) {
+	rows := [][]tgbotapi.KeyboardButton{}
+	switch step {
+	case services.CheckoutStepPhone:
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButtonContact(checkoutContactButton)))
+	case services.CheckoutStepNotes:
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(checkoutNoNotesButton)))
+	case services.CheckoutStepCoupon:
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(checkoutNoCouponButton)))
+	}
+	rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(checkoutCancelButton)))
+
+	keyboard := tgbotapi.NewReplyKeyboard(rows...)
+	keyboard.ResizeKeyboard = true
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	sb.send(msg)
+}
+
+// showShippingOptions lets the customer choose how the order is delivered,
+// with the fee of each method for the cart
+func (sb *SubBot) showShippingOptions(chatID int64) {
+	cart, err := sb.carts.GetCart(sb.store.ID, chatID)
+	if err != nil {
+		log.Printf("❌ Failed to get cart in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت سبد خرید")
+		return
+	}
+	methods, err := sb.carts.ShippingMethods(sb.store.ID)
+	if err != nil {
+		log.Printf("❌ Failed to get shipping methods of store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت روش‌های ارسال")
+		return
+	}
+	if len(methods) == 0 {
+		// The store withdrew its methods since the checkout started
+		sb.startCheckout(chatID)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, method := range methods {
+		fee := messages.ShippingFeeByRegion
+		if method.RateType != models.ShippingRateRegion {
+			fee = shippingFeeText(services.ShippingFee(&method, "", cart.Items))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s — %s", method.Name, fee), fmt.Sprintf("ship_%d", method.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(checkoutCancelButton, "checkout_cancel"),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, messages.CheckoutAskShipping)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.send(msg)
+}
+
+// chooseShippingMethod saves the shipping method the customer tapped and
+// asks the next question
+func (sb *SubBot) chooseShippingMethod(chatID int64, methodID uint) {
+	next, err := sb.carts.ChooseShippingMethod(sb.store.ID, chatID, methodID)
+	switch {
+	case errors.Is(err, services.ErrShippingUnavailable):
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutShippingUnavailable))
+		sb.showShippingOptions(chatID)
+	case errors.Is(err, services.ErrCheckoutNotReady):
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutNotActive))
+	case err != nil:
+		log.Printf("❌ Failed to choose shipping method in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت روش ارسال")
+	default:
+		sb.askCheckoutStep(chatID, next)
+	}
+}
+
+// showCheckoutReview shows the cart and delivery details for confirmation
+func (sb *SubBot) showCheckoutReview(chatID int64) {
+	cart, err := sb.carts.GetCart(sb.store.ID, chatID)
+	if err != nil {
+		log.Printf("❌ Failed to get cart in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت سبد خرید")
+		return
+	}
+
+	var items strings.Builder
+	for _, item := range cart.Items {
+		if services.CartItemAvailable(item) {
+			fmt.Fprintf(&items, messages.OrderItemLine, services.CartItemName(item), item.Quantity,
+				services.FormatPrice(services.CartItemPrice(item)*int64(item.Quantity)))
+		}
+	}
+	total := services.CartTotal(cart)
+	fmt.Fprintf(&items, messages.CartTotalLine+"\n", services.FormatPrice(total))
+
+	discount, err := sb.carts.CartDiscount(cart)
+	if services.IsCouponError(err) {
+		// The code ran out or the cart changed since it was applied
+		if err := sb.carts.RemoveCoupon(sb.store.ID, chatID); err != nil {
+			log.Printf("❌ Failed to remove coupon in store %d: %v", sb.store.ID, err)
+		}
+		sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CheckoutCouponDropped, cart.CouponCode, couponErrorText(err))))
+		cart.CouponCode, discount, err = "", 0, nil
+	}
+	if err != nil {
+		log.Printf("❌ Failed to check coupon in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در بررسی کد تخفیف")
+		return
+	}
+	if discount > 0 {
+		fmt.Fprintf(&items, messages.CheckoutDiscountLine, cart.CouponCode, services.FormatPrice(discount))
+	}
+
+	method, fee, err := sb.carts.CartShipping(cart)
+	if errors.Is(err, services.ErrShippingUnavailable) {
+		// The store withdrew the method; the delivery details are asked again
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutShippingUnavailable))
+		sb.startCheckout(chatID)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to get shipping method in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت روش ارسال")
+		return
+	}
+	if method != nil {
+		fmt.Fprintf(&items, messages.CheckoutShippingLine, method.Name, shippingFeeText(fee))
+	}
+	if discount > 0 || method != nil {
+		fmt.Fprintf(&items, messages.CheckoutPayableLine, services.FormatPrice(total-discount+fee))
+	}
+
+	address := cart.DeliveryAddress
+	switch {
+	case method != nil && !services.NeedsAddress(method):
+		address = messages.CheckoutPickupAddress
+	case cart.DeliveryCity != "":
+		address = cart.DeliveryCity + "، " + address
+	}
+	notes := cart.DeliveryNotes
+	if notes == "" {
+		notes = messages.CheckoutNoNotes
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CheckoutReview, items.String(), address, cart.DeliveryPhone, notes))
+	couponRow := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCheckoutCoupon, "checkout_coupon"))
+	if cart.CouponCode != "" {
+		couponRow = append(couponRow, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonRemoveCoupon, "checkout_coupon_remove"))
+	}
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ تایید و ثبت سفارش", "checkout_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData(checkoutCancelButton, "checkout_cancel"),
+		),
+		couponRow,
+	)
+	sb.send(msg)
+}
+
+// startCouponEntry asks the customer reviewing the order for a discount code
+func (sb *SubBot) startCouponEntry(chatID int64) {
+	err := sb.carts.StartCouponEntry(sb.store.ID, chatID)
+	if errors.Is(err, services.ErrCheckoutNotReady) {
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutNotActive))
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to start coupon entry in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت کد تخفیف")
+		return
+	}
+	sb.askCheckoutStep(chatID, services.CheckoutStepCoupon)
+}
+
+// applyCoupon takes the discount code the customer typed and shows the
+// review again with its discount. A code that can't be used is asked again.
+func (sb *SubBot) applyCoupon(chatID int64, code string) {
+	if code == checkoutNoCouponButton {
+		sb.removeCoupon(chatID)
+		return
+	}
+
+	discount, err := sb.carts.ApplyCoupon(sb.store.ID, chatID, code)
+	switch {
+	case services.IsCouponError(err):
+		sb.askCheckoutStepWith(chatID, services.CheckoutStepCoupon, couponErrorText(err))
+		return
+	case errors.Is(err, services.ErrCheckoutNotReady):
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutNotActive))
+		return
+	case err != nil:
+		log.Printf("❌ Failed to apply coupon in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت کد تخفیف")
+		return
+	}
+
+	sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CheckoutCouponApplied,
+		services.NormalizeCouponCode(code), services.FormatPrice(discount))))
+	sb.showCheckoutReview(chatID)
+}
+
+// removeCoupon drops the discount code of the checkout and shows the review
+func (sb *SubBot) removeCoupon(chatID int64) {
+	err := sb.carts.RemoveCoupon(sb.store.ID, chatID)
+	if errors.Is(err, services.ErrCheckoutNotReady) {
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutNotActive))
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to remove coupon in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در حذف کد تخفیف")
+		return
+	}
+	sb.showCheckoutReview(chatID)
+}
+
+// cancelCheckout stops the checkout and brings back the main menu
+func (sb *SubBot) cancelCheckout(chatID int64) {
+	if err := sb.carts.CancelCheckout(sb.store.ID, chatID); err != nil {
+		log.Printf("❌ Failed to cancel checkout in store %d: %v", sb.store.ID, err)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, messages.CheckoutCancelled)
+	msg.ReplyMarkup = mainMenuKeyboard()
+	sb.send(msg)
+}
+
+// placeOrder turns the confirmed checkout into an order, once for the tap
+// key, and tells the customer and the store owner. A checkout that can't be
+// ordered spends the tap too and is explained to the customer; other
+// failures leave it to be tapped again.
+func (sb *SubBot) placeOrder(chatID int64, key string, customer *tgbotapi.User) {
+	name := strings.TrimSpace(customer.FirstName + " " + customer.LastName)
+	var order *models.Order
+	var err error
+	placed := sb.once(chatID, key, func(tx *gorm.DB) error {
+		order, err = sb.orders.WithTx(tx).CreateOrderFromCart(sb.store.ID, chatID, name, customer.UserName)
+		if err != nil && !checkoutRefused(err) {
+			return err
+		}
+		return nil
+	})
+	if !placed {
+		return
+	}
+
+	var stockErr *services.OutOfStockError
+	switch {
+	case errors.As(err, &stockErr):
+		// Another customer took the stock since the product went in the cart
+		if err := sb.carts.CancelCheckout(sb.store.ID, chatID); err != nil {
+			log.Printf("❌ Failed to cancel checkout in store %d: %v", sb.store.ID, err)
+		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CheckoutOutOfStock, stockErr.Product, stockErr.Available))
+		msg.ReplyMarkup = mainMenuKeyboard()
+		sb.send(msg)
+		sb.showCart(chatID, 0)
+		return
+	case errors.Is(err, services.ErrCartEmpty):
+		msg := tgbotapi.NewMessage(chatID, messages.CheckoutCartEmpty)
+		msg.ReplyMarkup = mainMenuKeyboard()
+		sb.send(msg)
+		return
+	case errors.Is(err, services.ErrCheckoutNotReady):
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutNotActive))
+		return
+	case errors.Is(err, services.ErrShippingUnavailable):
+		sb.send(tgbotapi.NewMessage(chatID, messages.CheckoutShippingUnavailable))
+		sb.startCheckout(chatID)
+		return
+	case services.IsCouponError(err):
+		// The code ran out while the customer was reviewing the order
+		if err := sb.carts.RemoveCoupon(sb.store.ID, chatID); err != nil {
+			log.Printf("❌ Failed to remove coupon in store %d: %v", sb.store.ID, err)
+		}
+		sb.send(tgbotapi.NewMessage(chatID, couponErrorText(err)))
+		sb.showCheckoutReview(chatID)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.OrderPlacedCustomer, order.ID, services.FormatPrice(order.TotalAmount)))
+	msg.ReplyMarkup = mainMenuKeyboard()
+	sb.send(msg)
+
+	sb.notifyStoreOwner(order)
+}
+
+// checkoutRefused reports whether an order couldn't be placed because of the
+// checkout itself, which the customer has to change
+func checkoutRefused(err error) bool {
+	var stockErr *services.OutOfStockError
+	return errors.As(err, &stockErr) ||
+		errors.Is(err, services.ErrCartEmpty) ||
+		errors.Is(err, services.ErrCheckoutNotReady) ||
+		errors.Is(err, services.ErrShippingUnavailable) ||
+		services.IsCouponError(err)
+}
+
+// notifyStoreOwner sends the store owner the summary of a new order
+func (sb *SubBot) notifyStoreOwner(order *models.Order) {
+	if sb.store.Owner.TelegramID == 0 {
+		return
+	}
+
+	var items strings.Builder
+	for _, item := range order.OrderItems {
+		fmt.Fprintf(&items, messages.OrderItemLine, sellerItemName(item), item.Quantity, services.FormatPrice(item.SubTotal))
+	}
+
+	customer := order.CustomerName
+	if order.CustomerUsername != "" {
+		customer += " (@" + order.CustomerUsername + ")"
+	}
+	notes := order.DeliveryNotes
+	if notes == "" {
+		notes = messages.CheckoutNoNotes
+	}
+
+	text := fmt.Sprintf(messages.OrderOwnerSummary, order.ID, sb.store.Name, customer, order.DeliveryPhone,
+		orderAddress(order), notes, items.String(), services.FormatPrice(order.TotalAmount),
+		services.FormatPrice(order.CommissionAmount))
+	if order.DiscountAmount > 0 {
+		text += fmt.Sprintf(messages.OrderDiscountLine, order.CouponCode, services.FormatPrice(order.DiscountAmount))
+	}
+	if order.ShippingMethod != "" {
+		text += fmt.Sprintf(messages.OrderShippingLine, order.ShippingMethod, shippingFeeText(order.ShippingFee))
+	}
+	if _, err := sb.bot.Send(tgbotapi.NewMessage(sb.store.Owner.TelegramID, text)); err != nil {
+		log.Printf("❌ Failed to notify owner of store %d about order %d: %v", sb.store.ID, order.ID, err)
+	}
+}
+
+// orderAddress is the delivery address of an order with its city, if the
+// shipping method asked for one
+func orderAddress(order *models.Order) string {
+	if order.DeliveryCity == "" {
+		return order.DeliveryAddress
+	}
+	return order.DeliveryCity + "، " + order.DeliveryAddress
+}
+
+// shippingFeeText shows a shipping fee, or that shipping is free
+func shippingFeeText(fee int64) string {
+	if fee == 0 {
+		return messages.ShippingFeeFree
+	}
+	return fmt.Sprintf(messages.ShippingFeeAmount, services.FormatPrice(fee))
+}
+
+// couponErrorText tells the customer why a discount code can't be used
+func couponErrorText(err error) string {
+	var minErr *services.CouponMinOrderError
+	switch {
+	case errors.As(err, &minErr):
+		return fmt.Sprintf(messages.CouponMinOrder, services.FormatPrice(minErr.MinOrder))
+	case errors.Is(err, services.ErrCouponInactive):
+		return messages.CouponInactive
+	case errors.Is(err, services.ErrCouponUsedUp):
+		return messages.CouponUsedUp
+	case errors.Is(err, services.ErrCouponCustomerLimit):
+		return messages.CouponCustomerLimit
+	case errors.Is(err, services.ErrCouponNotApplicable):
+		return messages.CouponNotApplicable
+	default:
+		return messages.CouponNotFound
+	}
+}
+
+// normalizePhone checks a phone number typed or shared by a customer and
+// returns it with Latin digits and without separators
+func normalizePhone(phone string) (string, bool) {
+	var normalized strings.Builder
+	digits := 0
+	for i, r := range strings.TrimSpace(phone) {
+		switch {
+		case r >= '۰' && r <= '۹': // Persian digits
+			r = '0' + (r - '۰')
+		case r >= '٠' && r <= '٩': // Arabic digits
+			r = '0' + (r - '٠')
+		}
+
+		switch {
+		case r >= '0' && r <= '9':
+			normalized.WriteRune(r)
+			digits++
+		case r == '+' && i == 0:
+			normalized.WriteRune(r)
+		case r == '-' || r == '(' || r == ')' || unicode.IsSpace(r):
+		default:
+			return "", false
+		}
+	}
+
+	if digits < 10 || digits > 15 {
+		return "", false
+	}
+	return normalized.String(), true
+}
+
+// sellerItemName is the name of an order item as sellers see it: with the
+// variant ordered and its SKU
+func sellerItemName(item models.OrderItem) string {
+	name := services.OrderItemName(item)
+	if item.SKU != "" {
+		name += " [" + item.SKU + "]"
+	}
+	return name
+}
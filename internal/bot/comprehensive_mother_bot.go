@@ -87,6 +87,13 @@ func NewComprehensiveMotherBot(
 	}
 }
 
+// send sends a message, logging when it can't be sent
+func (mb *ComprehensiveMotherBot) send(c tgbotapi.Chattable) {
+	if _, err := mb.bot.Send(c); err != nil {
+		log.Printf("❌ Failed to send message: %v", err)
+	}
+}
+
 // Start starts the bot and listens for updates
 func (mb *ComprehensiveMotherBot) Start() {
 	u := tgbotapi.NewUpdate(0)
@@ -243,7 +250,7 @@ func (mb *ComprehensiveMotherBot) sendWelcome(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, messages.WelcomeMessage)
 	msg.ReplyMarkup = keyboard
 
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 // sendMainMenu sends main menu
@@ -283,7 +290,7 @@ func (mb *ComprehensiveMotherBot) showRegistrationMenu(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 // handlePlanSelection handles plan selection
@@ -328,7 +335,7 @@ func (mb *ComprehensiveMotherBot) handlePlanSelection(chatID int64, planType str
 	msg := tgbotapi.NewMessage(chatID, paymentText)
 	msg.ReplyMarkup = keyboard
 
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 // startStoreRegistration starts the store registration process
@@ -337,14 +344,14 @@ func (mb *ComprehensiveMotherBot) startStoreRegistration(chatID int64, planType
 	user, err := mb.userService.GetUserByTelegramID(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		mb.bot.Send(msg)
+		mb.send(msg)
 		return
 	}
 
 	stores, err := mb.userService.GetUserStores(chatID)
 	if err == nil && len(stores) > 0 {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorStoreExists)
-		mb.bot.Send(msg)
+		mb.send(msg)
 		return
 	}
 
@@ -359,7 +366,7 @@ func (mb *ComprehensiveMotherBot) startStoreRegistration(chatID int64, planType
 	msg := tgbotapi.NewMessage(chatID, "📝 لطفاً نام فروشگاه خود را وارد کنید:")
 	msg.ReplyMarkup = tgbotapi.NewReplyKeyboardRemove{RemoveKeyboard: true}
 	
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 // handleSessionState handles user session states
@@ -391,7 +398,7 @@ func (mb *ComprehensiveMotherBot) handleSessionState(message *tgbotapi.Message,
 func (mb *ComprehensiveMotherBot) handleStoreNameInput(chatID int64, storeName string, userState *models.UserSession) {
 	if len(storeName) < 3 || len(storeName) > 50 {
 		msg := tgbotapi.NewMessage(chatID, "❌ نام فروشگاه باید بین 3 تا 50 کاراکتر باشد. لطفاً دوباره وارد کنید:")
-		mb.bot.Send(msg)
+		mb.send(msg)
 		return
 	}
 
@@ -410,14 +417,14 @@ func (mb *ComprehensiveMotherBot) handleStoreNameInput(chatID int64, storeName s
 	mb.sessionService.SetUserState(chatID, messages.StateWaitingStoreDescription, registrationData)
 
 	msg := tgbotapi.NewMessage(chatID, "📝 حالا توضیح کوتاهی از فروشگاه خود بنویسید:")
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 // handleStoreDescriptionInput handles store description input
 func (mb *ComprehensiveMotherBot) handleStoreDescriptionInput(chatID int64, description string, userState *models.UserSession) {
 	if len(description) > 500 {
 		msg := tgbotapi.NewMessage(chatID, "❌ توضیحات نباید بیشتر از 500 کاراکتر باشد. لطفاً دوباره وارد کنید:")
-		mb.bot.Send(msg)
+		mb.send(msg)
 		return
 	}
 
@@ -442,7 +449,7 @@ func (mb *ComprehensiveMotherBot) createStore(chatID int64, registrationData Sto
 	user, err := mb.userService.GetUserByTelegramID(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		mb.bot.Send(msg)
+		mb.send(msg)
 		return
 	}
 
@@ -481,7 +488,7 @@ func (mb *ComprehensiveMotherBot) createStore(chatID int64, registrationData Sto
 	if err := mb.storeManager.CreateStore(store); err != nil {
 		log.Printf("Error creating store: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorCreateStore)
-		mb.bot.Send(msg)
+		mb.send(msg)
 		return
 	}
 
@@ -522,7 +529,7 @@ func (mb *ComprehensiveMotherBot) createStore(chatID int64, registrationData Sto
 	msg := tgbotapi.NewMessage(chatID, successText)
 	msg.ReplyMarkup = keyboard
 
-	mb.bot.Send(msg)
+	mb.send(msg)
 
 	// Trigger sub-bot creation (asynchronous)
 	go mb.createSubBot(store)
@@ -547,11 +554,11 @@ func (mb *ComprehensiveMotherBot) isAdmin(chatID int64) bool {
 // sendHelp sends help message
 func (mb *ComprehensiveMotherBot) sendHelp(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, messages.SupportMessage)
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 // sendSupport sends support message
 func (mb *ComprehensiveMotherBot) sendSupport(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, messages.SupportMessage)
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
\ No newline at end of file
@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// couponSession is the session data kept while waiting for the description
+// of a new coupon
+type couponSession struct {
+	StoreID uint `json:"store_id"`
+}
+
+// showCoupons lists the discount codes of the seller's store with buttons
+// to add one and to turn them on and off
+func (mb *MotherBot) showCoupons(chatID int64, messageID int) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	coupons, err := mb.coupons.ListCoupons(store.ID)
+	if err != nil {
+		log.Printf("Error listing coupons of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.CouponsTitle, store.Name)
+	if len(coupons) == 0 {
+		text.WriteString(messages.CouponsEmpty)
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, coupon := range coupons {
+		status, label := "⏸", messages.ButtonCouponEnable
+		if coupon.IsActive {
+			status, label = "🟢", messages.ButtonCouponDisable
+		}
+		fmt.Fprintf(&text, messages.CouponLine, status, coupon.Code, describeCoupon(&coupon))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(label, coupon.Code), fmt.Sprintf("coupons:toggle:%d", coupon.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonNewCoupon, "coupons:new"),
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text.String(), &keyboard)
+}
+
+// handleCouponCallback handles the coupon buttons: coupons:new and
+// coupons:toggle:<coupon ID>
+func (mb *MotherBot) handleCouponCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	switch {
+	case len(parts) == 2 && parts[1] == "new":
+		if err := mb.sessionService.SetUserState(chatID, messages.StateWaitingCouponSpec, couponSession{StoreID: store.ID}); err != nil {
+			log.Printf("Error setting coupon state: %v", err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, messages.CouponAskSpec)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"),
+			),
+		)
+		mb.send(msg)
+	case len(parts) == 3 && parts[1] == "toggle":
+		id, err := strconv.ParseUint(parts[2], 10, 32)
+		if err != nil {
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+			return
+		}
+		mb.toggleCoupon(chatID, callback.Message.MessageID, store, uint(id))
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+	}
+}
+
+// toggleCoupon turns a coupon of the store on or off
+func (mb *MotherBot) toggleCoupon(chatID int64, messageID int, store *models.Store, couponID uint) {
+	coupons, err := mb.coupons.ListCoupons(store.ID)
+	if err != nil {
+		log.Printf("Error listing coupons of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+	for _, coupon := range coupons {
+		if coupon.ID != couponID {
+			continue
+		}
+		if err := mb.coupons.SetCouponActive(store.ID, couponID, !coupon.IsActive); err != nil {
+			log.Printf("Error updating coupon %d: %v", couponID, err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		mb.showCoupons(chatID, messageID)
+		return
+	}
+	mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+}
+
+// handleCouponInput creates the coupon the seller described
+func (mb *MotherBot) handleCouponInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	var data couponSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may add discount codes to the store
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	// Stay in the waiting state until the description is usable
+	coupon, err := services.ParseCouponSpec(message.Text)
+	if err == nil {
+		coupon.StoreID = store.ID
+		err = mb.coupons.CreateCoupon(coupon)
+	}
+	var specErr *services.CouponSpecError
+	switch {
+	case errors.As(err, &specErr):
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CouponSpecInvalid, specErr.Part)))
+		return
+	case errors.Is(err, services.ErrCouponExists):
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CouponExists, coupon.Code)))
+		return
+	}
+	mb.sessionService.ClearUserState(chatID)
+	if err != nil {
+		log.Printf("Error creating coupon for store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CouponCreated, coupon.Code)))
+	mb.showCoupons(chatID, 0)
+}
+
+// describeCoupon sums up the discount, limits and scope of a coupon
+func describeCoupon(coupon *models.Coupon) string {
+	var parts []string
+	if coupon.Type == models.CouponPercent {
+		parts = append(parts, fmt.Sprintf(messages.CouponPercentValue, coupon.Value))
+	} else {
+		parts = append(parts, fmt.Sprintf(messages.CouponFixedValue, services.FormatPrice(coupon.Value)))
+	}
+	if coupon.MinOrder > 0 {
+		parts = append(parts, fmt.Sprintf(messages.CouponMinOrderPart, services.FormatPrice(coupon.MinOrder)))
+	}
+	if coupon.MaxUses > 0 {
+		parts = append(parts, fmt.Sprintf(messages.CouponUsesPart, coupon.UsedCount, coupon.MaxUses))
+	} else {
+		parts = append(parts, fmt.Sprintf(messages.CouponUsedPart, coupon.UsedCount))
+	}
+	if coupon.MaxUsesPerCustomer > 0 {
+		parts = append(parts, fmt.Sprintf(messages.CouponPerCustomerPart, coupon.MaxUsesPerCustomer))
+	}
+	if coupon.StartsAt != nil {
+		parts = append(parts, fmt.Sprintf(messages.CouponStartsPart, coupon.StartsAt.Format(messages.DateFormat)))
+	}
+	if coupon.EndsAt != nil {
+		// EndsAt is the start of the day after the last one
+		parts = append(parts, fmt.Sprintf(messages.CouponEndsPart, coupon.EndsAt.AddDate(0, 0, -1).Format(messages.DateFormat)))
+	}
+	if coupon.ProductID != nil {
+		parts = append(parts, fmt.Sprintf(messages.CouponProductPart, *coupon.ProductID))
+	}
+	if coupon.Category != "" {
+		parts = append(parts, fmt.Sprintf(messages.CouponCategoryPart, coupon.Category))
+	}
+	return strings.Join(parts, " — ")
+}
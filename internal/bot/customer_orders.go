@@ -0,0 +1,280 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// customerOrdersLimit is how many of their latest orders customers see
+const customerOrdersLimit = 20
+
+// askCancelOrder asks the customer to confirm cancelling an order
+func (sb *SubBot) askCancelOrder(chatID int64, orderID uint) {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CustomerCancelConfirm, orderID))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonConfirmCancel, fmt.Sprintf("order_cancel_yes_%d", orderID)),
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonKeepOrder, "show_orders"),
+		),
+	)
+	sb.send(msg)
+}
+
+// cancelOrder cancels a pending order of the customer and tells the store
+// owner
+func (sb *SubBot) cancelOrder(chatID int64, orderID uint) {
+	order, err := sb.orders.CancelCustomerOrder(sb.store.ID, chatID, orderID)
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		// Accepted by the store, or already cancelled, in the meantime
+		sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CustomerCancelTooLate, orderID, services.OrderStatusLabel(transitionErr.From))))
+		return
+	case errors.Is(err, services.ErrOrderNotFound):
+		sb.sendError(chatID, "سفارش یافت نشد")
+		return
+	case err != nil:
+		log.Printf("❌ Failed to cancel order %d in store %d: %v", orderID, sb.store.ID, err)
+		sb.sendError(chatID, "خطا در لغو سفارش")
+		return
+	}
+
+	sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.CustomerOrderCancelled, order.ID)))
+	sb.notifyOwner(fmt.Sprintf(messages.CustomerCancelOwnerNotice, order.ID))
+}
+
+// startReturn opens a return request for a delivered order and asks why it
+// is sent back
+func (sb *SubBot) startReturn(chatID int64, orderID uint) {
+	request, err := sb.returns.StartReturn(sb.store.ID, chatID, orderID)
+	switch {
+	case errors.Is(err, services.ErrReturnNotAllowed):
+		sb.send(tgbotapi.NewMessage(chatID, messages.ReturnNotAllowed))
+		return
+	case errors.Is(err, services.ErrReturnExists):
+		sb.send(tgbotapi.NewMessage(chatID, messages.ReturnExists))
+		return
+	case errors.Is(err, services.ErrOrderNotFound):
+		sb.sendError(chatID, "سفارش یافت نشد")
+		return
+	case err != nil:
+		log.Printf("❌ Failed to start return of order %d in store %d: %v", orderID, sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت درخواست مرجوعی")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ReturnAskReason, request.OrderID))
+	msg.ReplyMarkup = returnKeyboard(false)
+	sb.send(msg)
+}
+
+// handleReturnAnswer takes the reason or a photo for the return request
+// the customer is writing. A photo's caption counts as the reason when
+// there is none yet.
+func (sb *SubBot) handleReturnAnswer(message *tgbotapi.Message, draft *models.ReturnRequest) {
+	chatID := message.Chat.ID
+
+	if len(message.Photo) > 0 {
+		// The last size is the largest
+		photo := message.Photo[len(message.Photo)-1]
+		count, err := sb.returns.AddReturnPhoto(draft.ID, photo.FileID)
+		if errors.Is(err, services.ErrReturnPhotoLimit) {
+			sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ReturnPhotoLimit, services.MaxReturnPhotos)))
+			return
+		}
+		if err != nil {
+			log.Printf("❌ Failed to add return photo in store %d: %v", sb.store.ID, err)
+			sb.sendError(chatID, "خطا در ذخیره عکس")
+			return
+		}
+		if draft.Reason == "" && strings.TrimSpace(message.Caption) != "" {
+			if err := sb.returns.SetReturnReason(draft.ID, message.Caption); err == nil {
+				draft.Reason = message.Caption
+			}
+		}
+
+		text := fmt.Sprintf(messages.ReturnPhotoAdded, count, services.MaxReturnPhotos)
+		if draft.Reason == "" {
+			text += "\n\n" + fmt.Sprintf(messages.ReturnAskReason, draft.OrderID)
+		}
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = returnKeyboard(draft.Reason != "")
+		sb.send(msg)
+		return
+	}
+
+	err := sb.returns.SetReturnReason(draft.ID, message.Text)
+	switch {
+	case errors.Is(err, services.ErrReturnNoReason):
+		sb.send(tgbotapi.NewMessage(chatID, messages.ReturnTextOrPhoto))
+		return
+	case errors.Is(err, services.ErrReturnTooLong):
+		sb.send(tgbotapi.NewMessage(chatID, messages.ReturnReasonTooLong))
+		return
+	case err != nil:
+		log.Printf("❌ Failed to save return reason in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت درخواست مرجوعی")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ReturnAskPhotos, services.MaxReturnPhotos))
+	msg.ReplyMarkup = returnKeyboard(true)
+	sb.send(msg)
+}
+
+// submitReturn sends the customer's return request to the store owner,
+// with its photos
+func (sb *SubBot) submitReturn(chatID int64, from *tgbotapi.User) {
+	request, err := sb.returns.SubmitReturn(sb.store.ID, chatID)
+	switch {
+	case errors.Is(err, services.ErrReturnNoReason):
+		sb.send(tgbotapi.NewMessage(chatID, messages.ReturnNeedsReason))
+		return
+	case errors.Is(err, services.ErrReturnNotFound):
+		// Already sent from another tap
+		sb.send(tgbotapi.NewMessage(chatID, messages.InfoAlreadyDone))
+		return
+	case err != nil:
+		log.Printf("❌ Failed to submit return in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ثبت درخواست مرجوعی")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ReturnSubmitted, request.OrderID))
+	msg.ReplyMarkup = mainMenuKeyboard()
+	sb.send(msg)
+
+	customer := strings.TrimSpace(from.FirstName + " " + from.LastName)
+	if from.UserName != "" {
+		customer += " (@" + from.UserName + ")"
+	}
+	sb.notifyOwner(fmt.Sprintf(messages.ReturnOwnerNotice, request.OrderID, customer, request.Reason, len(request.Photos)))
+	// Photo file IDs only work in this bot, so the owner gets them here
+	if ownerChatID, ok := sb.ownerChatID(); ok {
+		for _, photo := range request.Photos {
+			if _, err := sb.bot.Send(tgbotapi.NewPhoto(ownerChatID, tgbotapi.FileID(photo.FileID))); err != nil {
+				log.Printf("❌ Failed to send return photo to owner of store %d: %v", sb.store.ID, err)
+			}
+		}
+	}
+}
+
+// discardReturn drops the return request the customer is writing
+func (sb *SubBot) discardReturn(chatID int64) {
+	if err := sb.returns.DiscardReturn(sb.store.ID, chatID); err != nil {
+		log.Printf("❌ Failed to discard return in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در لغو درخواست مرجوعی")
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, messages.ReturnDiscarded)
+	msg.ReplyMarkup = mainMenuKeyboard()
+	sb.send(msg)
+}
+
+// redeliverOrder sends the customer the digital items of a paid order again
+func (sb *SubBot) redeliverOrder(chatID int64, orderID uint) {
+	deliveries, err := sb.digital.OrderDeliveries(sb.store.ID, chatID, orderID)
+	switch {
+	case errors.Is(err, services.ErrNoDigitalDelivery):
+		sb.send(tgbotapi.NewMessage(chatID, messages.DigitalNothingToDeliver))
+		return
+	case errors.Is(err, services.ErrOrderNotFound):
+		sb.sendError(chatID, "سفارش یافت نشد")
+		return
+	case err != nil:
+		log.Printf("❌ Failed to get deliveries of order %d in store %d: %v", orderID, sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ارسال محصولات دیجیتال")
+		return
+	}
+
+	var sent []services.DigitalDelivery
+	for _, delivery := range deliveries {
+		ok := true
+		for _, message := range services.DigitalMessages(chatID, delivery) {
+			if _, err := sb.bot.Send(message); err != nil {
+				log.Printf("❌ Failed to deliver order %d in store %d: %v", orderID, sb.store.ID, err)
+				ok = false
+			}
+		}
+		if ok {
+			sent = append(sent, delivery)
+		}
+	}
+	if err := sb.digital.MarkDelivered(sent); err != nil {
+		log.Printf("❌ %v", err)
+	}
+}
+
+// hasDigitalItems reports whether an order, loaded with its products, has
+// digital items
+func hasDigitalItems(order *models.Order) bool {
+	for _, item := range order.OrderItems {
+		if item.Product.DigitalType != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyOwner sends the store owner a message through the store bot
+func (sb *SubBot) notifyOwner(text string) {
+	ownerChatID, ok := sb.ownerChatID()
+	if !ok {
+		return
+	}
+	if _, err := sb.bot.Send(tgbotapi.NewMessage(ownerChatID, text)); err != nil {
+		log.Printf("❌ Failed to notify owner of store %d: %v", sb.store.ID, err)
+	}
+}
+
+// ownerChatID returns the store owner's chat. The store must have been
+// loaded with its owner; a missing one is logged rather than going unnoticed.
+func (sb *SubBot) ownerChatID() (int64, bool) {
+	if sb.store.Owner.TelegramID == 0 {
+		log.Printf("⚠️ Owner of store %d is not loaded, not notifying them", sb.store.ID)
+		return 0, false
+	}
+	return sb.store.Owner.TelegramID, true
+}
+
+// callbackOrderID reads the order ID after the prefix of a button's data
+func (sb *SubBot) callbackOrderID(chatID int64, data, prefix string) (uint, bool) {
+	orderID, err := strconv.ParseUint(strings.TrimPrefix(data, prefix), 10, 32)
+	if err != nil {
+		sb.sendError(chatID, "خطا در شناسایی سفارش")
+		return 0, false
+	}
+	return uint(orderID), true
+}
+
+// returnKeyboard is shown under the return request questions; the request
+// can be sent once it has a reason
+func returnKeyboard(canSubmit bool) tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	if canSubmit {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonSubmitReturn, "return_submit"))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "return_discard"))
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+// returnStatusLabel returns the Persian name of a return request status
+func returnStatusLabel(status string) string {
+	switch status {
+	case models.ReturnApproved:
+		return messages.ReturnStatusApproved
+	case models.ReturnRejected:
+		return messages.ReturnStatusRejected
+	default:
+		return messages.ReturnStatusRequested
+	}
+}
@@ -0,0 +1,135 @@
+package bot
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DefaultUpdateWorkers is the worker pool size used when none is configured
+const DefaultUpdateWorkers = 8
+
+// updateDispatcher handles updates on a bounded pool of goroutines. Updates
+// of one chat are queued and handled strictly in order, while different
+// chats are handled in parallel, so a slow handler only delays its own chat.
+type updateDispatcher struct {
+	name   string
+	handle func(tgbotapi.Update)
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	pending  map[int64][]tgbotapi.Update // queued updates of chats being handled
+	inFlight map[int]struct{}            // update IDs dispatched but not handled yet
+	highest  int                         // highest update ID dispatched
+}
+
+func newUpdateDispatcher(name string, workers int, handle func(tgbotapi.Update)) *updateDispatcher {
+	if workers < 1 {
+		workers = DefaultUpdateWorkers
+	}
+
+	return &updateDispatcher{
+		name:     name,
+		handle:   handle,
+		slots:    make(chan struct{}, workers),
+		pending:  make(map[int64][]tgbotapi.Update),
+		inFlight: make(map[int]struct{}),
+	}
+}
+
+// Dispatch queues an update behind earlier updates of the same chat. It
+// blocks while every worker is busy with other chats.
+func (d *updateDispatcher) Dispatch(update tgbotapi.Update) {
+	key := updateChatKey(update)
+
+	d.mu.Lock()
+	d.inFlight[update.UpdateID] = struct{}{}
+	if update.UpdateID > d.highest {
+		d.highest = update.UpdateID
+	}
+	if queued, busy := d.pending[key]; busy {
+		d.pending[key] = append(queued, update)
+		d.mu.Unlock()
+		return
+	}
+	d.pending[key] = []tgbotapi.Update{}
+	d.mu.Unlock()
+
+	d.slots <- struct{}{}
+	d.wg.Add(1)
+	go d.work(key, update)
+}
+
+// Wait blocks until every dispatched update has been handled
+func (d *updateDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Handled returns the highest update ID up to which every dispatched update
+// has been handled. Chats finish out of order, so this trails the updates
+// that are still in progress.
+func (d *updateDispatcher) Handled() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	handled := d.highest
+	for id := range d.inFlight {
+		if id <= handled {
+			handled = id - 1
+		}
+	}
+	return handled
+}
+
+// work handles an update and then the rest of its chat's queue
+func (d *updateDispatcher) work(key int64, update tgbotapi.Update) {
+	defer d.wg.Done()
+	defer func() { <-d.slots }()
+
+	for {
+		d.safeHandle(update)
+
+		d.mu.Lock()
+		delete(d.inFlight, update.UpdateID)
+		queued := d.pending[key]
+		if len(queued) == 0 {
+			delete(d.pending, key)
+			d.mu.Unlock()
+			return
+		}
+		update = queued[0]
+		d.pending[key] = queued[1:]
+		d.mu.Unlock()
+	}
+}
+
+// safeHandle keeps a panicking handler from taking down the update loop
+func (d *updateDispatcher) safeHandle(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ Panic while handling update %d of %s: %v\n%s", update.UpdateID, d.name, r, debug.Stack())
+		}
+	}()
+
+	d.handle(update)
+}
+
+// updateChatKey returns the chat an update belongs to. Updates without a
+// chat, like inline queries, are ordered per user instead.
+func updateChatKey(update tgbotapi.Update) int64 {
+	// FromChat dereferences the message of a callback query, which inline
+	// messages don't have
+	if update.CallbackQuery != nil && update.CallbackQuery.Message == nil {
+		return update.CallbackQuery.From.ID
+	}
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	return 0
+}
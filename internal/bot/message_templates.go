@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// templatePreviewLength keeps the template list within one message
+const templatePreviewLength = 300
+
+// messageTemplateSession is the session data kept while waiting for the new
+// text of a message template
+type messageTemplateSession struct {
+	StoreID uint   `json:"store_id"`
+	Key     string `json:"key"`
+}
+
+// showMessageTemplates lists the messages the seller's store bot sends
+// customers, with buttons to reword them
+func (mb *MotherBot) showMessageTemplates(chatID int64) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	custom, err := mb.templates.GetStoreTemplates(store.ID)
+	if err != nil {
+		log.Printf("Error getting message templates of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString(messages.MessageTemplatesIntro)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, info := range services.MessageTemplates() {
+		marker, current := messages.MessageTemplateDefault, info.Default
+		if customText, ok := custom[info.Key]; ok {
+			marker, current = messages.MessageTemplateCustom, customText
+		}
+		if runes := []rune(current); len(runes) > templatePreviewLength {
+			current = string(runes[:templatePreviewLength]) + "…"
+		}
+		fmt.Fprintf(&text, messages.MessageTemplateLine, marker, info.Name, current)
+
+		row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✏️ "+info.Name, "tpl_edit:"+info.Key))
+		if _, ok := custom[info.Key]; ok {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData("↩️ پیش‌فرض", "tpl_reset:"+info.Key))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.send(msg)
+}
+
+// startMessageTemplateEdit asks the seller for the new text of a template
+func (mb *MotherBot) startMessageTemplateEdit(chatID int64, key string) {
+	info, ok := services.LookupMessageTemplate(key)
+	if !ok {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+	current, err := mb.templates.Get(store.ID, key)
+	if err != nil {
+		log.Printf("Error getting message template %s of store %d: %v", key, store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	session := messageTemplateSession{StoreID: store.ID, Key: key}
+	if err := mb.sessionService.SetUserState(chatID, messages.StateWaitingMessageTemplate, session); err != nil {
+		log.Printf("Error setting message template state: %v", err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.MessageTemplateAsk, info.Name, current))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"),
+		),
+	)
+	mb.send(msg)
+}
+
+// handleMessageTemplateInput saves the text the seller sent for a template
+func (mb *MotherBot) handleMessageTemplateInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	var data messageTemplateSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may reword the store's messages
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	err = mb.templates.Set(store.ID, data.Key, message.Text)
+	if errors.Is(err, services.ErrTemplateLength) {
+		// Stay in the waiting state so the seller can send it again
+		mb.send(tgbotapi.NewMessage(chatID, messages.MessageTemplateInvalid))
+		return
+	}
+	mb.sessionService.ClearUserState(chatID)
+	if err != nil {
+		log.Printf("Error saving message template %s of store %d: %v", data.Key, store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	info, _ := services.LookupMessageTemplate(data.Key)
+	mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.MessageTemplateSaved, info.Name)))
+	mb.showMessageTemplates(chatID)
+}
+
+// resetMessageTemplate brings a template back to its default text
+func (mb *MotherBot) resetMessageTemplate(chatID int64, key string) {
+	info, ok := services.LookupMessageTemplate(key)
+	if !ok {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	if err := mb.templates.Reset(store.ID, key); err != nil {
+		log.Printf("Error resetting message template %s of store %d: %v", key, store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.MessageTemplateReset, info.Name)))
+	mb.showMessageTemplates(chatID)
+}
@@ -25,8 +25,28 @@ type MotherBot struct {
         paymentService    *services.PaymentService
         subscriptionSrv   *services.SubscriptionService
         botManager        *services.BotManagerService
+        offsets           *services.UpdateOffsetService
+        idempotency       *services.IdempotencyService
+        templates         *services.MessageTemplateService
+        coupons           *services.CouponService
+        shipping          *services.ShippingService
+        returns           *services.ReturnService
+        digital           *services.DigitalService
+        webhooks          *services.WebhookService // nil when polling
+        leases            *services.LeaseService   // nil when the only instance
+        workers           int
 }
 
+// Mother bot polling. A poll ends well within the lease so a lost lease
+// stops it before another instance starts polling.
+const (
+        motherBotPollTimeout    = 10 * time.Second
+        motherBotPollRetryDelay = 3 * time.Second
+)
+
+// MotherBotWebhookKey is the webhook path of the mother bot: /tg/mother
+const MotherBotWebhookKey = "mother"
+
 func NewMotherBot(
         bot *tgbotapi.BotAPI,
         db *gorm.DB,
@@ -52,24 +72,106 @@ func NewMotherBot(
                 paymentService:    paymentService,
                 subscriptionSrv:   subscriptionSrv,
                 botManager:        botManager,
+                offsets:           services.NewUpdateOffsetService(db),
+                idempotency:       services.NewIdempotencyService(db),
+                templates:         services.NewMessageTemplateService(db),
+                coupons:           services.NewCouponService(db),
+                shipping:          services.NewShippingService(db),
+                returns:           services.NewReturnService(db, orderService),
+                digital:           services.NewDigitalService(db),
         }
 }
 
+// UseWebhooks makes Start receive updates through the webhook server instead of polling
+func (mb *MotherBot) UseWebhooks(webhooks *services.WebhookService) {
+        mb.webhooks = webhooks
+}
+
+// SetLeaseService makes Start poll only while this instance holds the
+// mother bot lease and return once it loses it
+func (mb *MotherBot) SetLeaseService(leases *services.LeaseService) {
+        mb.leases = leases
+}
+
+// SetUpdateWorkers sets how many chats are handled in parallel
+func (mb *MotherBot) SetUpdateWorkers(workers int) {
+        mb.workers = workers
+}
+
 func (mb *MotherBot) Start() {
-        u := tgbotapi.NewUpdate(0)
-        u.Timeout = 60
+        // Resume after the last handled update instead of Telegram's own offset
+        offsets := loadUpdateOffsets(mb.offsets, mb.bot.Self.ID, "mother bot")
+
+        // Chats are handled in parallel, each chat's updates in order
+        dispatcher := newUpdateDispatcher("mother bot", mb.workers, mb.handleUpdate)
+        if mb.webhooks != nil {
+                updates, err := mb.webhooks.SetWebhook(mb.bot, MotherBotWebhookKey)
+                if err != nil {
+                        log.Fatalf("❌ Failed to set mother bot webhook: %v", err)
+                }
 
-        updates := mb.bot.GetUpdatesChan(u)
-        
-        log.Println("👂 Mother Bot is listening for messages...")
+                log.Println("👂 Mother Bot is listening for messages...")
+                for update := range updates {
+                        mb.receive(dispatcher, offsets, update)
+                }
+        } else {
+                mb.poll(dispatcher, offsets)
+        }
+        dispatcher.Wait()
+        offsets.Save(dispatcher.Handled(), true)
+}
+
+// poll long-polls getUpdates from the last handled update for as long as
+// this instance holds the mother bot lease
+func (mb *MotherBot) poll(dispatcher *updateDispatcher, offsets *updateOffsets) {
+        // getUpdates is refused while a webhook is set
+        if _, err := mb.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+                log.Printf("⚠️ Failed to delete mother bot webhook: %v", err)
+        }
+
+        u := tgbotapi.NewUpdate(offsets.Next())
+        u.Timeout = int(motherBotPollTimeout.Seconds())
 
-        for update := range updates {
-                if update.Message != nil {
-                        go mb.handleMessage(update.Message) // Handle in goroutine for better performance
-                } else if update.CallbackQuery != nil {
-                        go mb.handleCallback(update.CallbackQuery)
+        log.Println("👂 Mother Bot is listening for messages...")
+        for mb.holdsLease() {
+                updates, err := mb.bot.GetUpdates(u)
+                if err != nil {
+                        log.Printf("⚠️ Failed to get mother bot updates: %v", err)
+                        time.Sleep(motherBotPollRetryDelay)
+                        continue
+                }
+                for _, update := range updates {
+                        if update.UpdateID >= u.Offset {
+                                u.Offset = update.UpdateID + 1
+                        }
+                        mb.receive(dispatcher, offsets, update)
                 }
         }
+        log.Println("🛑 Mother bot lease lost, polling stopped")
+}
+
+// holdsLease reports whether this instance may poll the mother bot for one
+// more request
+func (mb *MotherBot) holdsLease() bool {
+        return mb.leases == nil || mb.leases.HoldsFor(services.MotherBotLeaseName, motherBotPollTimeout+motherBotPollTimeout/2)
+}
+
+// receive queues an update for its chat, skipping updates that were
+// already handled before a restart
+func (mb *MotherBot) receive(dispatcher *updateDispatcher, offsets *updateOffsets, update tgbotapi.Update) {
+        if offsets.Handled(update.UpdateID) {
+                return
+        }
+        dispatcher.Dispatch(update)
+        offsets.Save(dispatcher.Handled(), false)
+}
+
+func (mb *MotherBot) handleUpdate(update tgbotapi.Update) {
+        if update.Message != nil {
+                mb.handleMessage(update.Message)
+        } else if update.CallbackQuery != nil {
+                mb.handleCallback(update.CallbackQuery)
+        }
 }
 
 func (mb *MotherBot) handleMessage(message *tgbotapi.Message) {
@@ -115,6 +217,22 @@ func (mb *MotherBot) handleMessage(message *tgbotapi.Message) {
                 mb.showAdminPanel(chatID)
         case text == "🆘 پشتیبانی":
                 mb.showSupportMenu(chatID)
+        case text == "/orders":
+                mb.showOrders(chatID)
+        case text == "/templates":
+                mb.showMessageTemplates(chatID)
+        case text == "/coupons":
+                mb.showCoupons(chatID, 0)
+        case text == "/shipping":
+                mb.showShippingMethods(chatID, 0)
+        case text == "/returns":
+                mb.showReturns(chatID, 0)
+        case text == "/variants":
+                mb.showVariantProducts(chatID, 0)
+        case text == "/digital":
+                mb.showDigitalProducts(chatID, 0)
+        case text == "/gallery":
+                mb.showGalleryProducts(chatID, 0)
         case strings.HasPrefix(text, "/store"):
                 mb.handleStoreCommand(chatID, text)
         default:
@@ -123,6 +241,38 @@ func (mb *MotherBot) handleMessage(message *tgbotapi.Message) {
         }
 }
 
+// handleConversationState routes a message sent while the user is in the middle of a flow
+func (mb *MotherBot) handleConversationState(message *tgbotapi.Message, session *models.UserSession) {
+        chatID := message.Chat.ID
+
+        if message.Text == "/cancel" {
+                mb.sessionService.ClearUserState(chatID)
+                mb.sendMainMenu(chatID)
+                return
+        }
+
+        switch session.State {
+        case messages.StateWaitingBotToken:
+                mb.handleBotTokenInput(message, session)
+        case messages.StateWaitingMessageTemplate:
+                mb.handleMessageTemplateInput(message, session)
+        case messages.StateWaitingOrderSearch, messages.StateWaitingTrackingCode, messages.StateWaitingCancelReason:
+                mb.handleOrderInput(message, session)
+        case messages.StateWaitingCouponSpec:
+                mb.handleCouponInput(message, session)
+        case messages.StateWaitingShippingSpec:
+                mb.handleShippingInput(message, session)
+        case messages.StateWaitingVariantSpec:
+                mb.handleVariantInput(message, session)
+        case messages.StateWaitingLicenseKeys:
+                mb.handleLicenseKeysInput(message, session)
+        default:
+                // Unknown or stale state, start over
+                mb.sessionService.ClearUserState(chatID)
+                mb.sendMainMenu(chatID)
+        }
+}
+
 func (mb *MotherBot) sendWelcome(chatID int64) {
         msg := tgbotapi.NewMessage(chatID, messages.WelcomeMessage)
         
@@ -142,7 +292,7 @@ func (mb *MotherBot) sendWelcome(chatID int64) {
         
         msg.ReplyMarkup = keyboard
         msg.ParseMode = "HTML"
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) sendMainMenu(chatID int64) {
@@ -197,7 +347,7 @@ func (mb *MotherBot) showRegistrationMenu(chatID int64) {
         msg := tgbotapi.NewMessage(chatID, text)
         msg.ReplyMarkup = keyboard
 
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) handleCallback(callback *tgbotapi.CallbackQuery) {
@@ -219,11 +369,22 @@ func (mb *MotherBot) handleCallback(callback *tgbotapi.CallbackQuery) {
         switch {
         case data == "register_store":
                 mb.showRegistrationMenu(chatID)
+        case data == "connect_bot":
+                mb.startBotConnection(chatID)
+        case data == "cancel_state":
+                mb.sessionService.ClearUserState(chatID)
+                mb.sendMainMenu(chatID)
         case strings.HasPrefix(data, "plan_"):
                 planType := strings.TrimPrefix(data, "plan_")
                 mb.handlePlanSelection(chatID, models.PlanType(planType))
         case data == "manage_store":
                 mb.showStoreManagement(chatID)
+        case data == "message_templates":
+                mb.showMessageTemplates(chatID)
+        case strings.HasPrefix(data, "tpl_edit:"):
+                mb.startMessageTemplateEdit(chatID, strings.TrimPrefix(data, "tpl_edit:"))
+        case strings.HasPrefix(data, "tpl_reset:"):
+                mb.resetMessageTemplate(chatID, strings.TrimPrefix(data, "tpl_reset:"))
         case data == "view_plans":
                 mb.showRegistrationMenu(chatID)
         case data == "back_main":
@@ -231,12 +392,51 @@ func (mb *MotherBot) handleCallback(callback *tgbotapi.CallbackQuery) {
         case data == "check_membership":
                 // Re-check membership when user clicks the button
                 mb.sendWelcome(chatID)
+        // Order inbox callbacks
+        case data == "view_orders":
+                mb.showOrders(chatID)
+        case strings.HasPrefix(data, "orders:"):
+                mb.handleOrderCallback(callback)
+        // Return requests
+        case data == "returns":
+                mb.showReturns(chatID, callback.Message.MessageID)
+        case strings.HasPrefix(data, "returns:"):
+                mb.handleReturnCallback(callback)
+        // Product variants
+        case data == "variants":
+                mb.showVariantProducts(chatID, callback.Message.MessageID)
+        case strings.HasPrefix(data, "variants:"):
+                mb.handleVariantCallback(callback)
+        // Digital products
+        case data == "digital":
+                mb.showDigitalProducts(chatID, callback.Message.MessageID)
+        case strings.HasPrefix(data, "digital:"):
+                mb.handleDigitalCallback(callback)
+        // Product galleries
+        case data == "gallery":
+                mb.showGalleryProducts(chatID, callback.Message.MessageID)
+        case strings.HasPrefix(data, "gallery:"):
+                mb.handleGalleryCallback(callback)
+        // Shipping methods
+        case data == "shipping":
+                mb.showShippingMethods(chatID, callback.Message.MessageID)
+        case strings.HasPrefix(data, "shipping:"):
+                mb.handleShippingCallback(callback)
+        // Discount codes and sales report
+        case data == "coupons":
+                mb.showCoupons(chatID, callback.Message.MessageID)
+        case strings.HasPrefix(data, "coupons:"):
+                mb.handleCouponCallback(callback)
+        case data == "sales_report":
+                mb.showSalesReport(chatID)
         // Seller panel callbacks
-        case data == "add_product" || data == "list_products" || data == "view_orders" || 
-                 data == "sales_report" || data == "store_settings" || data == "renew_plan" ||
+        case data == "add_product" || data == "list_products" || 
+                 data == "store_settings" || data == "renew_plan" ||
                  strings.Contains(data, "product_") || strings.Contains(data, "order_") || strings.Contains(data, "upgrade_"):
                 mb.handleSellerPanel(callback)
         // Admin panel callbacks  
+        case data == "admin_bot_health" && mb.userService.IsAdmin(chatID):
+                mb.showUnhealthyBots(chatID)
         case strings.HasPrefix(data, "admin_") || strings.Contains(data, "payment_") || 
                  strings.Contains(data, "store_") && mb.isAdmin(chatID):
                 mb.handleAdminPanel(callback)
@@ -282,7 +482,7 @@ func (mb *MotherBot) handlePlanSelection(chatID int64, planType models.PlanType)
         msg := tgbotapi.NewMessage(chatID, paymentText)
         msg.ReplyMarkup = keyboard
 
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) createFreeStore(chatID int64) {
@@ -293,14 +493,14 @@ func (mb *MotherBot) createFreeStore(chatID int64) {
         userResult := mb.db.Where("telegram_id = ?", chatID).First(&user)
         if userResult.Error != nil {
                 msg := tgbotapi.NewMessage(chatID, "❌ لطفاً ابتدا با /start شروع کنید")
-                mb.bot.Send(msg)
+                mb.send(msg)
                 return
         }
         
         result := mb.db.Where("owner_id = ?", user.ID).First(&existingStore)
         if result.Error == nil {
                 msg := tgbotapi.NewMessage(chatID, "⚠️ شما قبلاً یک فروشگاه ثبت کرده‌اید!")
-                mb.bot.Send(msg)
+                mb.send(msg)
                 return
         }
 
@@ -315,7 +515,7 @@ func (mb *MotherBot) createFreeStore(chatID int64) {
 
         if err := mb.db.Create(&store).Error; err != nil {
                 msg := tgbotapi.NewMessage(chatID, "❌ خطا در ثبت فروشگاه. لطفاً دوباره تلاش کنید.")
-                mb.bot.Send(msg)
+                mb.send(msg)
                 return
         }
 
@@ -326,11 +526,14 @@ func (mb *MotherBot) createFreeStore(chatID int64) {
 • تعداد محصولات مجاز: 10
 • مدت اعتبار: 1 ماه
 
-🤖 ربات فروشگاهی شما تا 24 ساعت آینده آماده خواهد شد.
+🤖 برای راه‌اندازی ربات فروشگاه، روی دکمه "اتصال ربات" بزنید و توکن ربات خود را از BotFather ارسال کنید.
 
 برای مدیریت فروشگاه از دکمه "پنل مدیریت" استفاده کنید.`
 
         keyboard := tgbotapi.NewInlineKeyboardMarkup(
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonConnectBot, "connect_bot"),
+                ),
                 tgbotapi.NewInlineKeyboardRow(
                         tgbotapi.NewInlineKeyboardButtonData("📊 پنل مدیریت", "manage_store"),
                 ),
@@ -342,7 +545,7 @@ func (mb *MotherBot) createFreeStore(chatID int64) {
         msg := tgbotapi.NewMessage(chatID, successText)
         msg.ReplyMarkup = keyboard
 
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) showStoreManagement(chatID int64) {
@@ -352,14 +555,14 @@ func (mb *MotherBot) showStoreManagement(chatID int64) {
         userResult := mb.db.Where("telegram_id = ?", chatID).First(&user)
         if userResult.Error != nil {
                 msg := tgbotapi.NewMessage(chatID, "❌ لطفاً ابتدا با /start شروع کنید")
-                mb.bot.Send(msg)
+                mb.send(msg)
                 return
         }
         
         result := mb.db.Where("owner_id = ? AND is_active = ?", user.ID, true).First(&store)
         if result.Error != nil {
                 msg := tgbotapi.NewMessage(chatID, "❌ شما هنوز فروشگاهی ثبت نکرده‌اید!")
-                mb.bot.Send(msg)
+                mb.send(msg)
                 return
         }
 
@@ -400,6 +603,22 @@ func (mb *MotherBot) showStoreManagement(chatID int64) {
                         tgbotapi.NewInlineKeyboardButtonData("⚙️ تنظیمات", "store_settings"),
                         tgbotapi.NewInlineKeyboardButtonData("🔄 تمدید پلن", "renew_plan"),
                 ),
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonConnectBot, "connect_bot"),
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonMessageTemplates, "message_templates"),
+                ),
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCoupons, "coupons"),
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonShipping, "shipping"),
+                ),
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonReturns, "returns"),
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonVariants, "variants"),
+                ),
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonDigital, "digital"),
+                        tgbotapi.NewInlineKeyboardButtonData(messages.ButtonGallery, "gallery"),
+                ),
                 tgbotapi.NewInlineKeyboardRow(
                         tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", "back_main"),
                 ),
@@ -408,7 +627,7 @@ func (mb *MotherBot) showStoreManagement(chatID int64) {
         msg := tgbotapi.NewMessage(chatID, managementText)
         msg.ReplyMarkup = keyboard
 
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) sendMainMenu(chatID int64) {
@@ -440,6 +659,9 @@ func (mb *MotherBot) showAdminPanel(chatID int64) {
                         tgbotapi.NewInlineKeyboardButtonData("📊 گزارش مالی", "admin_financial"),
                         tgbotapi.NewInlineKeyboardButtonData("📢 ارسال پیام", "admin_broadcast"),
                 ),
+                tgbotapi.NewInlineKeyboardRow(
+                        tgbotapi.NewInlineKeyboardButtonData("🩺 سلامت ربات‌ها", "admin_bot_health"),
+                ),
                 tgbotapi.NewInlineKeyboardRow(
                         tgbotapi.NewInlineKeyboardButtonData("🔙 بازگشت", "back_main"),
                 ),
@@ -448,7 +670,7 @@ func (mb *MotherBot) showAdminPanel(chatID int64) {
         msg := tgbotapi.NewMessage(chatID, adminText)
         msg.ReplyMarkup = keyboard
 
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
 
 func (mb *MotherBot) isAdmin(chatID int64) bool {
@@ -460,17 +682,17 @@ func (mb *MotherBot) isAdmin(chatID int64) bool {
 // Stub methods for missing handlers - in production these would be full implementations
 func (mb *MotherBot) handleStoreRegistration(callback *tgbotapi.CallbackQuery) {
         // Simplified store registration handler
-        mb.bot.Send(tgbotapi.NewMessage(callback.Message.Chat.ID, "🏪 Store registration functionality coming soon!"))
+        mb.send(tgbotapi.NewMessage(callback.Message.Chat.ID, "🏪 Store registration functionality coming soon!"))
 }
 
 func (mb *MotherBot) handleAdminPanel(callback *tgbotapi.CallbackQuery) {
         // Simplified admin panel handler
-        mb.bot.Send(tgbotapi.NewMessage(callback.Message.Chat.ID, "👨‍💼 Admin panel functionality coming soon!"))
+        mb.send(tgbotapi.NewMessage(callback.Message.Chat.ID, "👨‍💼 Admin panel functionality coming soon!"))
 }
 
 func (mb *MotherBot) handleSellerPanel(callback *tgbotapi.CallbackQuery) {
         // Simplified seller panel handler
-        mb.bot.Send(tgbotapi.NewMessage(callback.Message.Chat.ID, "📊 Seller panel functionality coming soon!"))
+        mb.send(tgbotapi.NewMessage(callback.Message.Chat.ID, "📊 Seller panel functionality coming soon!"))
 }
 
 func (mb *MotherBot) handleStoreCommand(chatID int64, command string) {
@@ -508,5 +730,5 @@ func (mb *MotherBot) showStoreStats(chatID int64) {
 💎 پلن: %s`, store.StoreName, productCount, orderCount, totalRevenue, store.PlanType)
 
         msg := tgbotapi.NewMessage(chatID, statsText)
-        mb.bot.Send(msg)
+        mb.send(msg)
 }
\ No newline at end of file
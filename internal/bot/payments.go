@@ -7,8 +7,10 @@ import (
 
 	"telegram-store-hub/internal/messages"
 	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
 )
 
 func (mb *MotherBot) handlePaymentProof(chatID int64, user *models.User, photos []tgbotapi.PhotoSize, session *models.UserSession) {
@@ -100,11 +102,11 @@ func (mb *MotherBot) notifyAdminNewPayment(payment *models.Payment, store *model
 		photoMsg := tgbotapi.NewPhoto(mb.config.AdminChatID, tgbotapi.FileURL(payment.ProofImageURL))
 		photoMsg.Caption = adminText
 		photoMsg.ReplyMarkup = keyboard
-		mb.bot.Send(photoMsg)
+		mb.send(photoMsg)
 	} else {
 		msg := tgbotapi.NewMessage(mb.config.AdminChatID, adminText)
 		msg.ReplyMarkup = keyboard
-		mb.bot.Send(msg)
+		mb.send(msg)
 	}
 }
 
@@ -122,7 +124,7 @@ func (mb *MotherBot) processPaymentApproval(paymentID uint, adminUser *models.Us
 		}
 
 		// Activate store
-		err = mb.activateStore(payment.StoreID, &payment.Store.Owner)
+		err = mb.activateStore(mb.db, payment.StoreID, &payment.Store.Owner)
 		if err != nil {
 			return err
 		}
@@ -134,7 +136,7 @@ func (mb *MotherBot) processPaymentApproval(paymentID uint, adminUser *models.Us
 
 	} else {
 		// Reject payment
-		err = mb.paymentService.RejectPayment(paymentID, adminUser.ID)
+		err = mb.paymentService.RejectPayment(paymentID, adminUser.ID, messages.PaymentRejectedNote)
 		if err != nil {
 			return err
 		}
@@ -292,34 +294,45 @@ func (mb *MotherBot) notifyAdminRenewalPayment(payment *models.Payment, store *m
 		photoMsg := tgbotapi.NewPhoto(mb.config.AdminChatID, tgbotapi.FileURL(payment.ProofImageURL))
 		photoMsg.Caption = adminText
 		photoMsg.ReplyMarkup = keyboard
-		mb.bot.Send(photoMsg)
+		mb.send(photoMsg)
 	} else {
 		msg := tgbotapi.NewMessage(mb.config.AdminChatID, adminText)
 		msg.ReplyMarkup = keyboard
-		mb.bot.Send(msg)
+		mb.send(msg)
 	}
 }
 
-func (mb *MotherBot) processRenewalApproval(paymentID uint, months int, adminUser *models.User) error {
-	payment, err := mb.paymentService.GetPaymentByID(paymentID)
+// processRenewalApproval approves a renewal payment and renews its store in
+// tx. The owner is told with notifyRenewal once it's committed.
+func (mb *MotherBot) processRenewalApproval(tx *gorm.DB, paymentID uint, months int, adminUser *models.User) (*models.Payment, error) {
+	payments := services.NewPaymentService(tx)
+	payment, err := payments.GetPaymentByID(paymentID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Approve payment
-	err = mb.paymentService.ApprovePayment(paymentID, adminUser.ID)
+	err = payments.ApprovePayment(paymentID, adminUser.ID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Renew store
-	err = mb.storeService.RenewStore(payment.StoreID, months)
+	err = services.NewStoreService(tx).RenewStore(payment.StoreID, months)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return payment, nil
+}
 
+// notifyRenewal tells the owner of a renewed store its new expiry
+func (mb *MotherBot) notifyRenewal(payment *models.Payment) {
 	// Get updated store info
-	store, _ := mb.storeService.GetStoreByID(payment.StoreID)
+	store, err := mb.storeService.GetStoreByID(payment.StoreID)
+	if err != nil {
+		log.Printf("Error loading renewed store %d: %v", payment.StoreID, err)
+		return
+	}
 
 	// Notify store owner
 	renewalMessage := fmt.Sprintf(`✅ پلن شما با موفقیت تمدید شد!
@@ -333,5 +346,4 @@ func (mb *MotherBot) processRenewalApproval(paymentID uint, months int, adminUse
 	)
 
 	mb.sendMessage(payment.Store.Owner.TelegramID, renewalMessage)
-	return nil
-}
\ No newline at end of file
+}
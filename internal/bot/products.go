@@ -2,14 +2,18 @@ package bot
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
 
 	"telegram-store-hub/internal/messages"
 	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
 )
 
 func (mb *MotherBot) handleProductName(chatID int64, user *models.User, productName string, session *models.UserSession) {
@@ -68,7 +72,12 @@ func (mb *MotherBot) handleProductPrice(chatID int64, user *models.User, priceSt
 	mb.sendMessage(chatID, fmt.Sprintf(messages.ProductPriceReceived, mb.formatPrice(int(price))))
 }
 
-func (mb *MotherBot) finalizeProduct(chatID int64, user *models.User, imageURL string, session *models.UserSession) {
+// finalizeProduct creates the product the seller described. media is the
+// product's gallery, photos and videos by their file IDs in the store's bot;
+// its first photo becomes the product's picture in the catalog. Photos sent
+// to this bot can't be used there, as file IDs only work in the bot that
+// received them: sellers upload them to the store bot with /photo.
+func (mb *MotherBot) finalizeProduct(chatID int64, user *models.User, media []models.ProductMedia, session *models.UserSession) {
 	var sessionData map[string]interface{}
 	json.Unmarshal([]byte(session.Data), &sessionData)
 
@@ -85,7 +94,7 @@ func (mb *MotherBot) finalizeProduct(chatID int64, user *models.User, imageURL s
 		productName,
 		productDescription,
 		productPrice,
-		imageURL,
+		"",
 		"general", // default category
 	)
 	
@@ -94,6 +103,12 @@ func (mb *MotherBot) finalizeProduct(chatID int64, user *models.User, imageURL s
 		return
 	}
 
+	if len(media) > 0 {
+		if err := mb.productService.SetProductMedia(product.ID, media); err != nil {
+			log.Printf("Error saving gallery of product %d: %v", product.ID, err)
+		}
+	}
+
 	// Clear session
 	mb.sessionService.ClearSession(user.TelegramID)
 
@@ -117,7 +132,7 @@ func (mb *MotherBot) finalizeProduct(chatID int64, user *models.User, imageURL s
 
 	msg := tgbotapi.NewMessage(chatID, "محصول با موفقیت اضافه شد! چه کار می‌خواهید انجام دهید؟")
 	msg.ReplyMarkup = keyboard
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 func (mb *MotherBot) handleProductEdit(chatID int64, user *models.User, productID uint) {
@@ -171,7 +186,7 @@ func (mb *MotherBot) handleProductEdit(chatID int64, user *models.User, productI
 
 	msg := tgbotapi.NewMessage(chatID, productText)
 	msg.ReplyMarkup = keyboard
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 func (mb *MotherBot) handleProductDelete(chatID int64, user *models.User, productID uint) {
@@ -191,21 +206,36 @@ func (mb *MotherBot) handleProductDelete(chatID int64, user *models.User, produc
 	confirmText := fmt.Sprintf("⚠️ آیا مطمئن هستید که محصول '%s' را حذف کنید؟\n\n⚠️ این عمل قابل بازگشت نیست!", product.Name)
 	msg := tgbotapi.NewMessage(chatID, confirmText)
 	msg.ReplyMarkup = keyboard
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
+// confirmProductDelete deletes a product of the user's store. The returned
+// error is already reported to the user.
+// confirmProductDelete deletes an owner's product once, however often the
+// confirmation is tapped
 func (mb *MotherBot) confirmProductDelete(chatID int64, user *models.User, productID uint) {
-	product, err := mb.productService.GetProductByID(productID)
-	if err != nil || product.Store.OwnerID != user.ID {
-		mb.sendMessage(chatID, messages.ErrorGeneral)
+	var storeID uint
+	var productName string
+	err := mb.idempotency.Once(fmt.Sprintf("delete_product:%d", productID), func(tx *gorm.DB) error {
+		products := services.NewProductService(tx)
+		product, err := products.GetProductByID(productID)
+		if err == nil && product.Store.OwnerID != user.ID {
+			err = errors.New("product belongs to another store")
+		}
+		if err != nil {
+			return err
+		}
+
+		storeID = product.StoreID
+		productName = product.Name
+		return products.DeleteProduct(productID)
+	})
+	if errors.Is(err, services.ErrActionDone) {
+		mb.sendMessage(chatID, messages.InfoAlreadyDone)
 		return
 	}
-
-	storeID := product.StoreID
-	productName := product.Name
-
-	err = mb.productService.DeleteProduct(productID)
 	if err != nil {
+		log.Printf("Error deleting product %d: %v", productID, err)
 		mb.sendMessage(chatID, messages.ErrorGeneral)
 		return
 	}
@@ -223,7 +253,7 @@ func (mb *MotherBot) confirmProductDelete(chatID int64, user *models.User, produ
 
 	msg := tgbotapi.NewMessage(chatID, "چه کار می‌خواهید انجام دهید؟")
 	msg.ReplyMarkup = keyboard
-	mb.bot.Send(msg)
+	mb.send(msg)
 }
 
 func (mb *MotherBot) toggleProductAvailability(chatID int64, user *models.User, productID uint) {
@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// showSalesReport shows the sales of the seller's store and how its
+// discount codes were used
+func (mb *MotherBot) showSalesReport(chatID int64) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	report, err := mb.orderService.GetStoreSalesReport(store.ID)
+	if err != nil {
+		log.Printf("Error getting sales report of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.SalesReport, store.Name,
+		services.FormatPrice(report.TotalSales),
+		services.FormatPrice(report.NetIncome),
+		report.TotalOrders,
+		report.CompletedOrders,
+		report.PendingOrders,
+		services.FormatPrice(report.MonthlySales),
+		services.FormatPrice(report.DailySales),
+		store.CommissionRate,
+		services.FormatPrice(report.CommissionPaid))
+	writeCouponStats(&text, report)
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📋 جزئیات سفارشات", "view_orders"),
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCoupons, "coupons"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+		),
+	)
+	mb.send(msg)
+}
+
+// writeCouponStats adds the redemption statistics of the store's coupons to
+// a sales report
+func writeCouponStats(text *strings.Builder, report *services.SalesReport) {
+	if len(report.Coupons) == 0 {
+		return
+	}
+	fmt.Fprintf(text, messages.SalesReportCoupons, services.FormatPrice(report.TotalDiscounts))
+	for _, coupon := range report.Coupons {
+		status := "⏸"
+		if coupon.IsActive {
+			status = "🟢"
+		}
+		fmt.Fprintf(text, messages.SalesReportCouponLine, status, coupon.Code, coupon.Uses,
+			services.FormatPrice(coupon.Discount), services.FormatPrice(coupon.Sales))
+	}
+}
@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Product search limits
+const (
+	searchResultLimit = 10 // products listed for /search
+	inlineResultLimit = 50 // results per inline answer, Telegram's maximum
+	inlineCacheTime   = 30 // seconds Telegram may reuse an inline answer
+)
+
+// startSearch searches the store for the words after /search, or asks for
+// them in a message the customer replies to
+func (sb *SubBot) startSearch(chatID int64, query string) {
+	if query = strings.TrimSpace(query); query != "" {
+		sb.showSearchResults(chatID, query)
+		return
+	}
+	msg := tgbotapi.NewMessage(chatID, messages.SearchAsk)
+	msg.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, InputFieldPlaceholder: "🔍"}
+	sb.send(msg)
+}
+
+// isSearchReply reports whether a message answers the question of
+// startSearch
+func isSearchReply(message *tgbotapi.Message) bool {
+	reply := message.ReplyToMessage
+	return reply != nil && reply.From != nil && reply.From.IsBot && reply.Text == messages.SearchAsk
+}
+
+// showSearchResults lists the store's products matching a query, each with
+// a button to its card
+func (sb *SubBot) showSearchResults(chatID int64, query string) {
+	products, err := sb.products.SearchProducts(sb.store.ID, query)
+	if err != nil {
+		log.Printf("❌ Failed to search products of store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در جستجوی محصولات")
+		return
+	}
+	if len(products) == 0 {
+		sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.SearchNoResults, query)))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.SearchResultsTitle, query)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, product := range products[:min(len(products), searchResultLimit)] {
+		fmt.Fprintf(&text, messages.CatalogLine, i+1, product.Name, services.FormatPrice(product.Price))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf(messages.ButtonCatalogProduct, product.Name),
+				fmt.Sprintf("buy_%d", product.ID),
+			),
+		))
+	}
+	if len(products) > searchResultLimit {
+		fmt.Fprintf(&text, messages.SearchMoreResults, len(products)-searchResultLimit)
+	}
+	if sb.bot.Self.UserName != "" {
+		fmt.Fprintf(&text, messages.SearchShareHint, sb.bot.Self.UserName)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.send(msg)
+}
+
+// openDeepLink handles the payload of a t.me/<bot>?start= link: buy_<product
+// ID> from a shared product opens its card, anything else the main menu
+func (sb *SubBot) openDeepLink(chatID int64, payload string) {
+	productID, err := strconv.ParseUint(strings.TrimPrefix(payload, "buy_"), 10, 32)
+	if !strings.HasPrefix(payload, "buy_") || err != nil {
+		sb.sendWelcome(chatID)
+		return
+	}
+	sb.showProductCard(chatID, uint(productID), 0)
+}
+
+// handleInlineQuery answers "@storebot <words>" typed in any chat with the
+// matching products, or the newest ones for no words, to share with a link
+// to buy them in the store bot. The offset pages through more than fit in
+// one answer.
+func (sb *SubBot) handleInlineQuery(query *tgbotapi.InlineQuery) {
+	var products []models.Product
+	var err error
+	if words := strings.TrimSpace(query.Query); words != "" {
+		products, err = sb.products.SearchProducts(sb.store.ID, words)
+	} else {
+		products, err = sb.products.GetActiveStoreProducts(sb.store.ID)
+	}
+	if err != nil {
+		log.Printf("❌ Failed to search products of store %d: %v", sb.store.ID, err)
+		return
+	}
+
+	offset, _ := strconv.Atoi(query.Offset)
+	offset = min(max(offset, 0), len(products))
+	next := ""
+	if offset+inlineResultLimit < len(products) {
+		next = strconv.Itoa(offset + inlineResultLimit)
+	}
+	results := []interface{}{}
+	for _, product := range products[offset:min(offset+inlineResultLimit, len(products))] {
+		results = append(results, sb.inlineProductResult(&product))
+	}
+
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     inlineCacheTime,
+		NextOffset:    next,
+	}
+	if _, err := sb.bot.Request(answer); err != nil {
+		log.Printf("❌ Failed to answer inline query in store %d: %v", sb.store.ID, err)
+	}
+}
+
+// inlineProductResult is a product shared inline: its photo, or a text card
+// for products without one, with a button that opens it in the store bot
+func (sb *SubBot) inlineProductResult(product *models.Product) interface{} {
+	id := strconv.FormatUint(uint64(product.ID), 10)
+	price := services.FormatPrice(product.Price)
+	text := fmt.Sprintf(messages.InlineProductCaption, product.Name, price, sb.store.Name)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL(messages.ButtonBuyProduct,
+			fmt.Sprintf("https://t.me/%s?start=buy_%d", sb.bot.Self.UserName, product.ID)),
+	))
+
+	if product.ThumbnailFileID != "" {
+		result := tgbotapi.NewInlineQueryResultCachedPhoto(id, product.ThumbnailFileID)
+		result.Title = product.Name
+		result.Description = fmt.Sprintf(messages.InlineProductDescription, price)
+		result.Caption = text
+		result.ReplyMarkup = &keyboard
+		return result
+	}
+	result := tgbotapi.NewInlineQueryResultArticle(id, product.Name, text)
+	result.Description = fmt.Sprintf(messages.InlineProductDescription, price)
+	result.ReplyMarkup = &keyboard
+	return result
+}
@@ -0,0 +1,238 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// digitalSession is the session data kept while waiting for the license
+// keys of a product
+type digitalSession struct {
+	StoreID   uint `json:"store_id"`
+	ProductID uint `json:"product_id"`
+}
+
+// showDigitalProducts lists the products of the seller's store with how
+// each one is delivered
+func (mb *MotherBot) showDigitalProducts(chatID int64, messageID int) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	products, err := mb.productService.GetStoreProducts(store.ID)
+	if err != nil {
+		log.Printf("Error listing products of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	text := fmt.Sprintf(messages.DigitalTitle, store.Name)
+	if len(products) == 0 {
+		text += messages.DigitalNoProducts
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, product := range products {
+		label := fmt.Sprintf(messages.ButtonPhysicalProduct, product.Name)
+		switch product.DigitalType {
+		case models.DigitalFile:
+			label = fmt.Sprintf(messages.ButtonFileProduct, product.Name)
+		case models.DigitalLicense:
+			label = fmt.Sprintf(messages.ButtonLicenseProduct, product.Name, product.Stock)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("digital:product:%d", product.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// handleDigitalCallback handles the digital product buttons:
+// digital:<product|file|keys|physical>:<product ID>
+func (mb *MotherBot) handleDigitalCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	product, err := mb.productService.GetProductByID(uint(id))
+	if err != nil || product.StoreID != store.ID {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	switch parts[1] {
+	case "product":
+		mb.showDigitalProduct(chatID, messageID, product)
+	case "file":
+		// File IDs only work in the bot that received the file, so the
+		// file goes to the store bot
+		if store.BotUsername == "" {
+			mb.send(tgbotapi.NewMessage(chatID, messages.DigitalNeedsBot))
+			return
+		}
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.DigitalFileInstructions, product.Name, store.BotUsername, product.ID)))
+	case "keys":
+		session := digitalSession{StoreID: store.ID, ProductID: product.ID}
+		if err := mb.sessionService.SetUserState(chatID, messages.StateWaitingLicenseKeys, session); err != nil {
+			log.Printf("Error setting license keys state: %v", err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.DigitalAskKeys, product.Name))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"),
+			),
+		)
+		mb.send(msg)
+	case "physical":
+		if err := mb.digital.MakePhysical(store.ID, product.ID); err != nil {
+			log.Printf("Error making product %d physical: %v", product.ID, err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		product.DigitalType = ""
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.DigitalMadePhysical, product.Name)))
+		mb.showDigitalProduct(chatID, messageID, product)
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+	}
+}
+
+// showDigitalProduct shows how a product is delivered, with buttons to
+// deliver it as a file or license keys or to make it physical again
+func (mb *MotherBot) showDigitalProduct(chatID int64, messageID int, product *models.Product) {
+	text := fmt.Sprintf(messages.DigitalProductTitle, product.Name, product.ID)
+	switch product.DigitalType {
+	case models.DigitalFile:
+		text += messages.DigitalFileReady
+	case models.DigitalLicense:
+		pool, err := mb.digital.KeyPoolSize(product.ID)
+		if err != nil {
+			log.Printf("Error counting license keys of product %d: %v", product.ID, err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		text += fmt.Sprintf(messages.DigitalKeysPool, pool)
+		if pool <= services.LowKeyPoolThreshold {
+			text += messages.DigitalKeysLow
+		}
+	default:
+		text += messages.DigitalPhysical
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonDigitalFile, fmt.Sprintf("digital:file:%d", product.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonDigitalKeys, fmt.Sprintf("digital:keys:%d", product.ID)),
+		),
+	}
+	if product.DigitalType != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonDigitalPhysical, fmt.Sprintf("digital:physical:%d", product.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBackToDigital, "digital"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// handleLicenseKeysInput adds the license keys the seller sent to the pool
+// of a product
+func (mb *MotherBot) handleLicenseKeysInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	var data digitalSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may change the store's products
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	// Stay in the waiting state until some keys arrive
+	added, pool, err := mb.digital.AddLicenseKeys(store.ID, data.ProductID, message.Text)
+	if errors.Is(err, services.ErrNoLicenseKeys) {
+		mb.send(tgbotapi.NewMessage(chatID, messages.DigitalKeysEmpty))
+		return
+	}
+	mb.sessionService.ClearUserState(chatID)
+	if errors.Is(err, services.ErrDigitalProductNotFound) {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding license keys to product %d: %v", data.ProductID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.DigitalKeysAdded, added, pool)))
+	if product, err := mb.productService.GetProductByID(data.ProductID); err == nil {
+		mb.showDigitalProduct(chatID, 0, product)
+	}
+}
+
+// setDigitalFile makes the document the store owner sent to the store bot,
+// captioned "/file <product ID>", the file of a digital product
+func (sb *SubBot) setDigitalFile(message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+
+	productID, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(message.Caption, "/file")), 10, 32)
+	if err != nil {
+		sb.send(tgbotapi.NewMessage(chatID, messages.DigitalFileUsage))
+		return
+	}
+	product, err := sb.digital.SetDigitalFile(sb.store.ID, uint(productID), message.Document.FileID)
+	if errors.Is(err, services.ErrDigitalProductNotFound) {
+		sb.send(tgbotapi.NewMessage(chatID, messages.DigitalProductNotFound))
+		return
+	}
+	if err != nil {
+		log.Printf("❌ Failed to set digital file in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ذخیره فایل")
+		return
+	}
+	sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.DigitalFileSaved, product.Name)))
+}
@@ -0,0 +1,207 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// galleryAlbum remembers which product an album the owner is uploading to
+// the store bot is for, as only its first item carries the caption
+type galleryAlbum struct {
+	mediaGroupID string
+	productID    uint
+}
+
+// showGalleryProducts lists the products of the seller's store to pick the
+// one whose gallery is edited
+func (mb *MotherBot) showGalleryProducts(chatID int64, messageID int) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	products, err := mb.productService.GetStoreProducts(store.ID)
+	if err != nil {
+		log.Printf("Error listing products of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	text := fmt.Sprintf(messages.GalleryTitle, store.Name)
+	if len(products) == 0 {
+		text += messages.GalleryNoProducts
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, product := range products {
+		label := product.Name
+		if product.ThumbnailFileID != "" {
+			label = fmt.Sprintf(messages.ButtonProductWithGallery, product.Name)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("gallery:product:%d", product.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// handleGalleryCallback handles the product gallery buttons:
+// gallery:<product|clear>:<product ID>
+func (mb *MotherBot) handleGalleryCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	product, err := mb.productService.GetProductByID(uint(id))
+	if err != nil || product.StoreID != store.ID {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	switch parts[1] {
+	case "product":
+		mb.showProductGallery(chatID, messageID, store, product)
+	case "clear":
+		if err := mb.productService.ClearProductMedia(store.ID, product.ID); err != nil {
+			log.Printf("Error clearing gallery of product %d: %v", product.ID, err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.GalleryCleared, product.Name)))
+		mb.showProductGallery(chatID, messageID, store, product)
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+	}
+}
+
+// showProductGallery shows how many photos and videos a product's gallery
+// has and how to add more through the store bot, whose file IDs they must be
+func (mb *MotherBot) showProductGallery(chatID int64, messageID int, store *models.Store, product *models.Product) {
+	media, err := mb.productService.GetProductMedia(product.ID)
+	if err != nil {
+		log.Printf("Error getting gallery of product %d: %v", product.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	text := fmt.Sprintf(messages.GalleryProductTitle, product.Name, product.ID, len(media), services.MaxProductMedia)
+	if store.BotUsername != "" {
+		text += fmt.Sprintf(messages.GalleryInstructions, store.BotUsername, product.ID)
+	} else {
+		text += messages.GalleryNeedsBot
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(media) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonClearGallery, fmt.Sprintf("gallery:clear:%d", product.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBackToGallery, "gallery"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// galleryUpload returns the product a photo or video the store owner sent
+// is for: the one in its "/photo <product ID>" caption or, for the rest of
+// an album, in the caption of the album's first item. It reports false for
+// media that isn't a gallery upload.
+func (sb *SubBot) galleryUpload(message *tgbotapi.Message) (uint, bool) {
+	if strings.HasPrefix(message.Caption, "/photo") {
+		productID, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(message.Caption, "/photo")), 10, 32)
+		if err != nil {
+			productID = 0
+		}
+		if message.MediaGroupID != "" {
+			sb.album = galleryAlbum{mediaGroupID: message.MediaGroupID, productID: uint(productID)}
+		}
+		return uint(productID), true
+	}
+	if message.MediaGroupID != "" && message.MediaGroupID == sb.album.mediaGroupID {
+		return sb.album.productID, true
+	}
+	return 0, false
+}
+
+// addGalleryMedia adds a photo or video the store owner sent to the end of
+// a product's gallery
+func (sb *SubBot) addGalleryMedia(message *tgbotapi.Message, productID uint) {
+	chatID := message.Chat.ID
+
+	if productID == 0 {
+		// Told once, on the album item with the caption
+		if message.Caption != "" {
+			sb.send(tgbotapi.NewMessage(chatID, messages.GalleryUsage))
+		}
+		return
+	}
+
+	mediaType, fileID := models.MediaPhoto, ""
+	if message.Video != nil {
+		mediaType, fileID = models.MediaVideo, message.Video.FileID
+	} else {
+		// The largest size of the photo
+		fileID = message.Photo[len(message.Photo)-1].FileID
+	}
+
+	count, err := sb.products.AddProductMedia(sb.store.ID, productID, mediaType, fileID)
+	switch {
+	case errors.Is(err, services.ErrProductNotFound):
+		sb.send(tgbotapi.NewMessage(chatID, messages.GalleryProductNotFound))
+	case errors.Is(err, services.ErrProductMediaLimit):
+		sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.GalleryFull, services.MaxProductMedia)))
+	case err != nil:
+		log.Printf("❌ Failed to add gallery media in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در ذخیره عکس")
+	default:
+		sb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.GalleryMediaAdded, productID, count, services.MaxProductMedia)))
+	}
+}
+
+// sendGallery sends the photos and videos of a product, as an album when
+// there are several. A gallery of just the card's photo isn't sent.
+func (sb *SubBot) sendGallery(chatID int64, product *models.Product) {
+	media, err := sb.products.GetProductMedia(product.ID)
+	if err != nil {
+		log.Printf("❌ Failed to get gallery of product %d: %v", product.ID, err)
+		return
+	}
+	if len(media) == 0 || len(media) == 1 && media[0].FileID == product.ThumbnailFileID {
+		return
+	}
+	if _, err := sb.bot.Request(services.ProductGallery(chatID, media)); err != nil {
+		log.Printf("❌ Failed to send gallery of product %d in store %d: %v", product.ID, sb.store.ID, err)
+	}
+}
@@ -0,0 +1,433 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Order inbox limits
+const (
+	orderInboxPageSize  = 8
+	orderSearchLimit    = 20
+	maxOrderInputLength = 500 // tracking codes and cancel reasons
+)
+
+// orderInboxFilters are the status filters of the order inbox; "" lists all
+var orderInboxFilters = []models.OrderStatus{
+	"",
+	models.OrderStatusPending,
+	models.OrderStatusConfirmed,
+	models.OrderStatusShipped,
+	models.OrderStatusDelivered,
+	models.OrderStatusCancelled,
+}
+
+// orderActionSession is the session data kept while waiting for a search,
+// a tracking code or a cancel reason from the seller
+type orderActionSession struct {
+	StoreID uint `json:"store_id"`
+	OrderID uint `json:"order_id,omitempty"`
+}
+
+// showOrders opens the order inbox of the seller's store
+func (mb *MotherBot) showOrders(chatID int64) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+	mb.showOrderInbox(chatID, 0, store, "", 0)
+}
+
+// handleOrderCallback handles the order inbox buttons: orders:list:<filter>:<page>,
+// orders:search and orders:<action>:<order ID>
+func (mb *MotherBot) handleOrderCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	switch {
+	case len(parts) == 2 && parts[1] == "search":
+		mb.askOrderInput(chatID, messages.StateWaitingOrderSearch, orderActionSession{StoreID: store.ID}, messages.OrderAskSearch, nil)
+		return
+	case len(parts) == 4 && parts[1] == "list":
+		filter := models.OrderStatus(parts[2])
+		if filter == "all" {
+			filter = ""
+		}
+		page, _ := strconv.Atoi(parts[3])
+		mb.showOrderInbox(chatID, messageID, store, filter, page)
+		return
+	case len(parts) != 3:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	orderID := uint(id)
+	session := orderActionSession{StoreID: store.ID, OrderID: orderID}
+
+	switch parts[1] {
+	case "view":
+		mb.showOrderDetail(chatID, messageID, store, orderID)
+	case "accept":
+		mb.changeOrderStatus(chatID, messageID, store, orderID, models.OrderStatusConfirmed, "")
+	case "reject":
+		mb.changeOrderStatus(chatID, messageID, store, orderID, models.OrderStatusCancelled, messages.OrderRejectedReason)
+	case "deliver":
+		mb.changeOrderStatus(chatID, messageID, store, orderID, models.OrderStatusDelivered, "")
+	case "ship":
+		skip := tgbotapi.NewInlineKeyboardButtonData(messages.ButtonShipNoTracking, fmt.Sprintf("orders:shipnow:%d", orderID))
+		mb.askOrderInput(chatID, messages.StateWaitingTrackingCode, session, fmt.Sprintf(messages.OrderAskTrackingCode, orderID), &skip)
+	case "shipnow":
+		mb.sessionService.ClearUserState(chatID)
+		mb.changeOrderStatus(chatID, messageID, store, orderID, models.OrderStatusShipped, "")
+	case "cancel":
+		mb.askOrderInput(chatID, messages.StateWaitingCancelReason, session, fmt.Sprintf(messages.OrderAskCancelReason, orderID), nil)
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+	}
+}
+
+// showOrderInbox shows a page of the store's orders, optionally only those
+// in one status
+func (mb *MotherBot) showOrderInbox(chatID int64, messageID int, store *models.Store, filter models.OrderStatus, page int) {
+	page = max(page, 0)
+	orders, total, err := mb.orderService.ListStoreOrders(store.ID, filter, orderInboxPageSize, page*orderInboxPageSize)
+	pages := max(int((total+orderInboxPageSize-1)/orderInboxPageSize), 1)
+	if err == nil && page >= pages {
+		// The page emptied since it was shown
+		page = pages - 1
+		orders, total, err = mb.orderService.ListStoreOrders(store.ID, filter, orderInboxPageSize, page*orderInboxPageSize)
+	}
+	if err != nil {
+		log.Printf("Error listing orders of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	filterName := messages.OrderFilterAll
+	if filter != "" {
+		filterName = services.OrderStatusLabel(filter)
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.OrderInboxTitle, store.Name, filterName)
+	writeOrderLines(&text, orders)
+	if pages > 1 {
+		fmt.Fprintf(&text, messages.OrderInboxPage, page+1, pages)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var filters []tgbotapi.InlineKeyboardButton
+	for _, status := range orderInboxFilters {
+		name, key := messages.OrderFilterAll, "all"
+		if status != "" {
+			name, key = services.OrderStatusLabel(status), string(status)
+		}
+		if status == filter {
+			name = "• " + name
+		}
+		filters = append(filters, tgbotapi.NewInlineKeyboardButtonData(name, fmt.Sprintf("orders:list:%s:0", key)))
+		if len(filters) == 3 {
+			rows = append(rows, filters)
+			filters = nil
+		}
+	}
+	if len(filters) > 0 {
+		rows = append(rows, filters)
+	}
+	rows = append(rows, orderButtons(orders)...)
+
+	key := string(filter)
+	if key == "" {
+		key = "all"
+	}
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀️", fmt.Sprintf("orders:list:%s:%d", key, page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("▶️", fmt.Sprintf("orders:list:%s:%d", key, page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderSearch, "orders:search"),
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text.String(), &keyboard)
+}
+
+// showOrderSearchResults lists the store's orders matching a search
+func (mb *MotherBot) showOrderSearchResults(chatID int64, store *models.Store, query string) {
+	orders, err := mb.orderService.SearchStoreOrders(store.ID, query, orderSearchLimit)
+	if err != nil {
+		log.Printf("Error searching orders of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.OrderSearchResults, query)
+	writeOrderLines(&text, orders)
+
+	rows := orderButtons(orders)
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderSearch, "orders:search"),
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBackToOrders, "orders:list:all:0"),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, text.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.send(msg)
+}
+
+// showOrderDetail shows an order with its items, delivery details and the
+// buttons of the status changes it allows
+func (mb *MotherBot) showOrderDetail(chatID int64, messageID int, store *models.Store, orderID uint) {
+	order, err := mb.orderService.GetStoreOrder(store.ID, orderID)
+	if errors.Is(err, services.ErrOrderNotFound) {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting order %d: %v", orderID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var items strings.Builder
+	for _, item := range order.OrderItems {
+		fmt.Fprintf(&items, messages.OrderItemLine, sellerItemName(item), item.Quantity, services.FormatPrice(item.SubTotal))
+	}
+	customer := order.CustomerName
+	if order.CustomerUsername != "" {
+		customer += " (@" + order.CustomerUsername + ")"
+	}
+	notes := order.DeliveryNotes
+	if notes == "" {
+		notes = messages.CheckoutNoNotes
+	}
+
+	text := fmt.Sprintf(messages.OrderDetail, orderStatusEmoji(order.Status), order.ID, services.OrderStatusLabel(order.Status),
+		order.CreatedAt.Format(messages.DateTimeFormat), customer, order.DeliveryPhone, orderAddress(order), notes,
+		items.String(), services.FormatPrice(order.TotalAmount))
+	if order.DiscountAmount > 0 {
+		text += fmt.Sprintf(messages.OrderDiscountLine, order.CouponCode, services.FormatPrice(order.DiscountAmount))
+	}
+	if order.ShippingMethod != "" {
+		text += fmt.Sprintf(messages.OrderShippingLine, order.ShippingMethod, shippingFeeText(order.ShippingFee))
+	}
+	if order.TrackingCode != "" {
+		text += fmt.Sprintf(messages.OrderDetailTracking, order.TrackingCode)
+	}
+
+	var actions []tgbotapi.InlineKeyboardButton
+	for _, next := range services.NextOrderStatuses(order.Status) {
+		switch next {
+		case models.OrderStatusConfirmed:
+			actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderAccept, fmt.Sprintf("orders:accept:%d", order.ID)))
+		case models.OrderStatusShipped:
+			actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderShip, fmt.Sprintf("orders:ship:%d", order.ID)))
+		case models.OrderStatusDelivered:
+			actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderDeliver, fmt.Sprintf("orders:deliver:%d", order.ID)))
+		case models.OrderStatusCancelled:
+			// A new order is turned down; an accepted one is cancelled with a reason
+			if order.Status == models.OrderStatusPending {
+				actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderReject, fmt.Sprintf("orders:reject:%d", order.ID)))
+			} else {
+				actions = append(actions, tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOrderCancel, fmt.Sprintf("orders:cancel:%d", order.ID)))
+			}
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if len(actions) > 0 {
+		rows = append(rows, actions)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBackToOrders, "orders:list:all:0"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// changeOrderStatus moves an order of the store to a new status for the
+// seller and shows it again. The customer is told by the order service.
+func (mb *MotherBot) changeOrderStatus(chatID int64, messageID int, store *models.Store, orderID uint, status models.OrderStatus, note string) {
+	if _, err := mb.orderService.GetStoreOrder(store.ID, orderID); err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: chatID}
+	_, err := mb.orderService.UpdateOrderStatus(orderID, status, seller, note)
+	var transitionErr *services.InvalidTransitionError
+	var stockErr *services.OutOfStockError
+	switch {
+	case errors.As(err, &transitionErr):
+		// Changed from another device or by the system in the meantime
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.OrderStatusOutdated, orderID, services.OrderStatusLabel(transitionErr.From))))
+	case errors.As(err, &stockErr):
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.OrderConfirmOutOfStock, stockErr.Product, stockErr.Available)))
+	case err != nil:
+		log.Printf("Error changing status of order %d: %v", orderID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	mb.showOrderDetail(chatID, messageID, store, orderID)
+}
+
+// askOrderInput waits for the seller to type a search, a tracking code or a
+// cancel reason
+func (mb *MotherBot) askOrderInput(chatID int64, state string, session orderActionSession, text string, extra *tgbotapi.InlineKeyboardButton) {
+	if err := mb.sessionService.SetUserState(chatID, state, session); err != nil {
+		log.Printf("Error setting order state: %v", err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"))
+	if extra != nil {
+		row = append([]tgbotapi.InlineKeyboardButton{*extra}, row...)
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	mb.send(msg)
+}
+
+// handleOrderInput takes the search, tracking code or cancel reason the
+// seller was asked for
+func (mb *MotherBot) handleOrderInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	var data orderActionSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may manage the store's orders
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	// Stay in the waiting state until the answer is usable
+	text := strings.TrimSpace(message.Text)
+	if text == "" {
+		mb.send(tgbotapi.NewMessage(chatID, messages.OrderTextOnly))
+		return
+	}
+	if utf8.RuneCountInString(text) > maxOrderInputLength {
+		mb.send(tgbotapi.NewMessage(chatID, messages.OrderTextTooLong))
+		return
+	}
+	mb.sessionService.ClearUserState(chatID)
+
+	switch session.State {
+	case messages.StateWaitingOrderSearch:
+		mb.showOrderSearchResults(chatID, store, text)
+	case messages.StateWaitingTrackingCode:
+		mb.changeOrderStatus(chatID, 0, store, data.OrderID, models.OrderStatusShipped, text)
+	case messages.StateWaitingCancelReason:
+		mb.changeOrderStatus(chatID, 0, store, data.OrderID, models.OrderStatusCancelled, text)
+	}
+}
+
+// sendOrEdit replaces the message the pressed button belongs to, or sends
+// a new one when there is none
+func (mb *MotherBot) sendOrEdit(chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text)
+		if keyboard != nil {
+			msg.ReplyMarkup = *keyboard
+		}
+		mb.send(msg)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = keyboard
+	if _, err := mb.bot.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("❌ Failed to edit message in chat %d: %v", chatID, err)
+	}
+}
+
+// writeOrderLines writes one summary line per order
+func writeOrderLines(text *strings.Builder, orders []models.Order) {
+	if len(orders) == 0 {
+		text.WriteString(messages.OrderInboxEmpty)
+		return
+	}
+	for _, order := range orders {
+		fmt.Fprintf(text, messages.OrderInboxLine, orderStatusEmoji(order.Status), order.ID, order.CustomerName,
+			services.FormatPrice(order.TotalAmount), order.CreatedAt.Format(messages.DateFormat))
+	}
+}
+
+// orderButtons returns two buttons per row that open the orders
+func orderButtons(orders []models.Order) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, order := range orders {
+		button := tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s #%d", orderStatusEmoji(order.Status), order.ID), fmt.Sprintf("orders:view:%d", order.ID))
+		if i%2 == 0 {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+		} else {
+			rows[len(rows)-1] = append(rows[len(rows)-1], button)
+		}
+	}
+	return rows
+}
+
+// orderStatusEmoji returns the emoji shown next to an order status
+func orderStatusEmoji(status models.OrderStatus) string {
+	switch status {
+	case models.OrderStatusPending:
+		return "⏳"
+	case models.OrderStatusConfirmed:
+		return "✅"
+	case models.OrderStatusShipped:
+		return "🚚"
+	case models.OrderStatusDelivered:
+		return "📦"
+	case models.OrderStatusCancelled:
+		return "❌"
+	case models.OrderStatusRefunded:
+		return "💸"
+	case models.OrderStatusExpired:
+		return "⌛"
+	default:
+		return "❓"
+	}
+}
@@ -0,0 +1,184 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// returnInboxLimit is how many return requests the seller sees at once
+const returnInboxLimit = 20
+
+// showReturns lists the return requests of the seller's store, the ones
+// waiting for a decision first
+func (mb *MotherBot) showReturns(chatID int64, messageID int) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	requests, err := mb.returns.ListReturns(store.ID, returnInboxLimit)
+	if err != nil {
+		log.Printf("Error listing returns of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.ReturnsTitle, store.Name)
+	if len(requests) == 0 {
+		text.WriteString(messages.ReturnsEmpty)
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, request := range requests {
+		fmt.Fprintf(&text, messages.ReturnLine, returnStatusEmoji(request.Status), request.ID, request.OrderID,
+			request.Order.CustomerName, services.FormatPrice(request.Order.TotalAmount))
+		button := tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s #%d", returnStatusEmoji(request.Status), request.ID), fmt.Sprintf("returns:view:%d", request.ID))
+		if i%2 == 0 {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+		} else {
+			rows[len(rows)-1] = append(rows[len(rows)-1], button)
+		}
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text.String(), &keyboard)
+}
+
+// handleReturnCallback handles the return request buttons:
+// returns:<view|approve|reject>:<request ID>
+func (mb *MotherBot) handleReturnCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	requestID := uint(id)
+
+	switch parts[1] {
+	case "view":
+		mb.showReturnDetail(chatID, messageID, store, requestID)
+		return
+	case "approve":
+		seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: chatID}
+		refund, err := mb.returns.ApproveReturn(store.ID, requestID, seller)
+		if err == nil {
+			mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ReturnApproved, services.FormatPrice(refund.Amount))))
+		}
+		mb.showReturnDecision(chatID, messageID, store, requestID, err)
+	case "reject":
+		_, err := mb.returns.RejectReturn(store.ID, requestID)
+		if err == nil {
+			mb.send(tgbotapi.NewMessage(chatID, messages.ReturnRejected))
+		}
+		mb.showReturnDecision(chatID, messageID, store, requestID, err)
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+	}
+}
+
+// showReturnDecision reports how deciding a return request went and shows
+// the request again
+func (mb *MotherBot) showReturnDecision(chatID int64, messageID int, store *models.Store, requestID uint, err error) {
+	var transitionErr *services.InvalidTransitionError
+	switch {
+	case errors.Is(err, services.ErrReturnNotFound):
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	case errors.Is(err, services.ErrReturnNotPending):
+		// Decided from another device in the meantime
+		mb.send(tgbotapi.NewMessage(chatID, messages.ReturnAlreadyDecided))
+	case errors.As(err, &transitionErr):
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.OrderStatusOutdated, transitionErr.OrderID, services.OrderStatusLabel(transitionErr.From))))
+	case err != nil:
+		log.Printf("Error deciding return request %d: %v", requestID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+	mb.showReturnDetail(chatID, messageID, store, requestID)
+}
+
+// showReturnDetail shows a return request with its order and, while it
+// waits for a decision, the buttons to approve or reject it
+func (mb *MotherBot) showReturnDetail(chatID int64, messageID int, store *models.Store, requestID uint) {
+	request, err := mb.returns.GetReturn(store.ID, requestID)
+	if errors.Is(err, services.ErrReturnNotFound) {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting return request %d: %v", requestID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	order := request.Order
+	var items strings.Builder
+	for _, item := range order.OrderItems {
+		fmt.Fprintf(&items, messages.OrderItemLine, sellerItemName(item), item.Quantity, services.FormatPrice(item.SubTotal))
+	}
+	customer := order.CustomerName
+	if order.CustomerUsername != "" {
+		customer += " (@" + order.CustomerUsername + ")"
+	}
+
+	text := fmt.Sprintf(messages.ReturnDetail, request.ID, returnStatusLabel(request.Status), order.ID,
+		services.FormatPrice(order.TotalAmount), request.CreatedAt.Format(messages.DateTimeFormat), customer,
+		items.String(), request.Reason, len(request.Photos))
+	if len(request.Photos) > 0 {
+		text += messages.ReturnPhotosNote
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if request.Status == models.ReturnRequested {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonReturnApprove, fmt.Sprintf("returns:approve:%d", request.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonReturnReject, fmt.Sprintf("returns:reject:%d", request.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBackToReturns, "returns"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// returnStatusEmoji returns the emoji shown next to a return request status
+func returnStatusEmoji(status string) string {
+	switch status {
+	case models.ReturnApproved:
+		return "✅"
+	case models.ReturnRejected:
+		return "❌"
+	default:
+		return "⏳"
+	}
+}
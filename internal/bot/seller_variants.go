@@ -0,0 +1,222 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// variantSession is the session data kept while waiting for the variant
+// list of a product
+type variantSession struct {
+	StoreID   uint `json:"store_id"`
+	ProductID uint `json:"product_id"`
+}
+
+// showVariantProducts lists the products of the seller's store to pick the
+// one whose variants are edited
+func (mb *MotherBot) showVariantProducts(chatID int64, messageID int) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	products, err := mb.productService.GetStoreProducts(store.ID)
+	if err != nil {
+		log.Printf("Error listing products of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	text := fmt.Sprintf(messages.VariantsTitle, store.Name)
+	if len(products) == 0 {
+		text += messages.VariantsNoProducts
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, product := range products {
+		label := product.Name
+		if services.HasVariants(&product) {
+			label = fmt.Sprintf(messages.ButtonProductWithVariants, product.Name, product.VariantAxes)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("variants:product:%d", product.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text, &keyboard)
+}
+
+// handleVariantCallback handles the product variant buttons:
+// variants:<product|edit|clear>:<product ID>
+func (mb *MotherBot) handleVariantCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) != 3 {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	product, err := mb.productService.GetProductByID(uint(id))
+	if err != nil || product.StoreID != store.ID {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	switch parts[1] {
+	case "product":
+		mb.showProductVariants(chatID, messageID, product)
+	case "edit":
+		session := variantSession{StoreID: store.ID, ProductID: product.ID}
+		if err := mb.sessionService.SetUserState(chatID, messages.StateWaitingVariantSpec, session); err != nil {
+			log.Printf("Error setting variant state: %v", err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.VariantsAskSpec, product.Name))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"),
+			),
+		)
+		mb.send(msg)
+	case "clear":
+		if err := mb.productService.SaveVariants(product.ID, "", nil); err != nil {
+			log.Printf("Error clearing variants of product %d: %v", product.ID, err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		product.VariantAxes = ""
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.VariantsCleared, product.Name)))
+		mb.showProductVariants(chatID, messageID, product)
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+	}
+}
+
+// showProductVariants shows the variants of a product with buttons to
+// replace or remove them
+func (mb *MotherBot) showProductVariants(chatID int64, messageID int, product *models.Product) {
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.VariantsProductTitle, product.Name)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if services.HasVariants(product) {
+		variants, err := mb.productService.GetVariants(product.ID)
+		if err != nil {
+			log.Printf("Error getting variants of product %d: %v", product.ID, err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		for _, variant := range variants {
+			fmt.Fprintf(&text, messages.VariantLine, describeVariant(product, &variant))
+		}
+		fmt.Fprintf(&text, messages.VariantsCurrentSpec, services.FormatVariantSpec(product, variants))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonEditVariants, fmt.Sprintf("variants:edit:%d", product.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonClearVariants, fmt.Sprintf("variants:clear:%d", product.ID)),
+		))
+	} else {
+		text.WriteString(messages.VariantsNone)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.ButtonAddVariants, fmt.Sprintf("variants:edit:%d", product.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBackToVariants, "variants"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text.String(), &keyboard)
+}
+
+// handleVariantInput replaces the variants of a product with the list the
+// seller sent
+func (mb *MotherBot) handleVariantInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	var data variantSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may change the store's products
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+	product, err := mb.productService.GetProductByID(data.ProductID)
+	if err != nil || product.StoreID != store.ID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	// Stay in the waiting state until the list is usable
+	axes, variants, err := services.ParseVariantSpec(message.Text)
+	var specErr *services.VariantSpecError
+	if errors.As(err, &specErr) {
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.VariantSpecInvalid, specErr.Part)))
+		return
+	}
+	mb.sessionService.ClearUserState(chatID)
+	if err == nil {
+		err = mb.productService.SaveVariants(product.ID, axes, variants)
+	}
+	if err != nil {
+		log.Printf("Error saving variants of product %d: %v", product.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.VariantsSaved, len(variants), product.Name)))
+	if product, err = mb.productService.GetProductByID(product.ID); err == nil {
+		mb.showProductVariants(chatID, 0, product)
+	}
+}
+
+// describeVariant sums up a variant: its options, SKU, price and stock
+func describeVariant(product *models.Product, variant *models.ProductVariant) string {
+	parts := []string{services.VariantName(variant)}
+	if variant.SKU != "" {
+		parts = append(parts, variant.SKU)
+	}
+	price := product.Price
+	if variant.Price > 0 {
+		price = variant.Price
+	}
+	parts = append(parts, fmt.Sprintf(messages.VariantPricePart, services.FormatPrice(price)))
+	if product.TrackStock {
+		parts = append(parts, fmt.Sprintf(messages.VariantStockPart, variant.Stock))
+	}
+	return strings.Join(parts, " — ")
+}
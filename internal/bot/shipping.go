@@ -0,0 +1,207 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/services"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// shippingSession is the session data kept while waiting for the
+// description of a new shipping method
+type shippingSession struct {
+	StoreID uint `json:"store_id"`
+}
+
+// showShippingMethods lists the shipping methods of the seller's store with
+// buttons to add one, turn them on and off and delete them
+func (mb *MotherBot) showShippingMethods(chatID int64, messageID int) {
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	methods, err := mb.shipping.ListMethods(store.ID, false)
+	if err != nil {
+		log.Printf("Error listing shipping methods of store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	var text strings.Builder
+	fmt.Fprintf(&text, messages.ShippingTitle, store.Name)
+	if len(methods) == 0 {
+		text.WriteString(messages.ShippingEmpty)
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, method := range methods {
+		status, label := "⏸", messages.ButtonShippingEnable
+		if method.IsActive {
+			status, label = "🟢", messages.ButtonShippingDisable
+		}
+		fmt.Fprintf(&text, messages.ShippingLine, status, method.Name, shippingKindLabel(method.Kind), describeShippingMethod(&method))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(label, method.Name), fmt.Sprintf("shipping:toggle:%d", method.ID)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(messages.ButtonShippingDelete, method.Name), fmt.Sprintf("shipping:delete:%d", method.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonNewShipping, "shipping:new"),
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonBack, "manage_store"),
+	))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	mb.sendOrEdit(chatID, messageID, text.String(), &keyboard)
+}
+
+// handleShippingCallback handles the shipping method buttons: shipping:new,
+// shipping:toggle:<method ID> and shipping:delete:<method ID>
+func (mb *MotherBot) handleShippingCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoStore))
+		return
+	}
+
+	parts := strings.Split(callback.Data, ":")
+	if len(parts) == 2 && parts[1] == "new" {
+		if err := mb.sessionService.SetUserState(chatID, messages.StateWaitingShippingSpec, shippingSession{StoreID: store.ID}); err != nil {
+			log.Printf("Error setting shipping state: %v", err)
+			mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+			return
+		}
+		msg := tgbotapi.NewMessage(chatID, messages.ShippingAskSpec)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(messages.ButtonCancel, "cancel_state"),
+			),
+		)
+		mb.send(msg)
+		return
+	}
+	if len(parts) != 3 {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	method, err := mb.shipping.GetMethod(store.ID, uint(id))
+	if errors.Is(err, services.ErrShippingMethodNotFound) {
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting shipping method %d: %v", id, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	switch parts[1] {
+	case "toggle":
+		err = mb.shipping.SetMethodActive(store.ID, method.ID, !method.IsActive)
+	case "delete":
+		err = mb.shipping.DeleteMethod(store.ID, method.ID)
+		if err == nil {
+			mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ShippingDeleted, method.Name)))
+		}
+	default:
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating shipping method %d: %v", method.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+	mb.showShippingMethods(chatID, messageID)
+}
+
+// handleShippingInput creates the shipping method the seller described
+func (mb *MotherBot) handleShippingInput(message *tgbotapi.Message, session *models.UserSession) {
+	chatID := message.Chat.ID
+
+	var data shippingSession
+	if err := json.Unmarshal([]byte(session.Data), &data); err != nil || data.StoreID == 0 {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorInvalidCommand))
+		return
+	}
+
+	// Only the owner may change how the store ships
+	store, err := mb.getOwnerStore(chatID)
+	if err != nil || store.ID != data.StoreID {
+		mb.sessionService.ClearUserState(chatID)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	// Stay in the waiting state until the description is usable
+	method, err := services.ParseShippingSpec(message.Text)
+	if err == nil {
+		method.StoreID = store.ID
+		err = mb.shipping.CreateMethod(method)
+	}
+	var specErr *services.ShippingSpecError
+	if errors.As(err, &specErr) {
+		mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ShippingSpecInvalid, specErr.Part)))
+		return
+	}
+	mb.sessionService.ClearUserState(chatID)
+	if err != nil {
+		log.Printf("Error creating shipping method for store %d: %v", store.ID, err)
+		mb.send(tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError))
+		return
+	}
+
+	mb.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ShippingCreated, method.Name)))
+	mb.showShippingMethods(chatID, 0)
+}
+
+// describeShippingMethod sums up the fees of a shipping method
+func describeShippingMethod(method *models.ShippingMethod) string {
+	var parts []string
+	switch method.RateType {
+	case models.ShippingRateRegion:
+		for _, rate := range method.Rates {
+			parts = append(parts, fmt.Sprintf(messages.ShippingRegionRate, rate.Region, shippingFeeText(rate.Fee)))
+		}
+		parts = append(parts, fmt.Sprintf(messages.ShippingOtherRegions, shippingFeeText(method.BaseFee)))
+	case models.ShippingRateWeight:
+		parts = append(parts, fmt.Sprintf(messages.ShippingWeightRate,
+			shippingFeeText(method.BaseFee), fmt.Sprintf(messages.ShippingFeeAmount, services.FormatPrice(method.PerKgFee))))
+	default:
+		parts = append(parts, shippingFeeText(method.BaseFee))
+	}
+	if method.FreeOver > 0 {
+		parts = append(parts, fmt.Sprintf(messages.ShippingFreeOver, services.FormatPrice(method.FreeOver)))
+	}
+	return strings.Join(parts, " — ")
+}
+
+// shippingKindLabel returns the name of a kind of shipping method
+func shippingKindLabel(kind string) string {
+	switch kind {
+	case models.ShippingPost:
+		return messages.ShippingKindPost
+	case models.ShippingPickup:
+		return messages.ShippingKindPickup
+	default:
+		return messages.ShippingKindCourier
+	}
+}
@@ -1,55 +1,256 @@
 package bot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"telegram-store-hub/internal/messages"
 	"telegram-store-hub/internal/models"
 	"telegram-store-hub/internal/services"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"gorm.io/gorm"
 )
 
+// Polling tuning for store bots
+const (
+	subBotPollTimeout     = 60 // seconds, long polling
+	subBotPollRetryDelay  = 3 * time.Second
+	subBotMaxPollFailures = 5
+)
+
 type SubBot struct {
 	bot           *tgbotapi.BotAPI
 	db            *gorm.DB
 	store         *models.Store
-	storeManager  *services.StoreManagerService
-	subscription  *services.SubscriptionService
+	products      *services.ProductService
+	carts         *services.CartService
+	orders        *services.OrderService
+	returns       *services.ReturnService
+	digital       *services.DigitalService
+	offsets       *services.UpdateOffsetService
+	idempotency   *services.IdempotencyService
+
+	webhooks *services.WebhookService // nil when polling
+	workers  int
+	album    galleryAlbum // the owner's last gallery upload; only the owner's chat uses it
+	onUpdate func()
+	stop     chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+}
+
+// cancelableClient ties every Bot API request to the sub-bot's lifetime so a
+// pending long poll returns as soon as the bot is stopped
+type cancelableClient struct {
+	ctx    context.Context
+	client *http.Client
+}
+
+func (c *cancelableClient) Do(req *http.Request) (*http.Response, error) {
+	return c.client.Do(req.WithContext(c.ctx))
 }
 
 func NewSubBot(token string, db *gorm.DB, store *models.Store) (*SubBot, error) {
-	bot, err := tgbotapi.NewBotAPI(token)
+	ctx, cancel := context.WithCancel(context.Background())
+	bot, err := tgbotapi.NewBotAPIWithClient(token, services.BotAPIEndpoint(), &cancelableClient{ctx: ctx, client: &http.Client{}})
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	bot.Debug = false
-	log.Printf("🤖 Sub-bot for store %s (%s) is ready", store.StoreName, bot.Self.UserName)
+	services.UseSendQueue(bot)
+	log.Printf("🤖 Sub-bot for store %s (%s) is ready", store.Name, bot.Self.UserName)
 
+	orders := services.NewOrderService(db)
 	return &SubBot{
 		bot:           bot,
 		db:            db,
 		store:         store,
-		storeManager:  services.NewStoreManagerService(db),
-		subscription:  services.NewSubscriptionService(db),
+		products:      services.NewProductService(db),
+		carts:         services.NewCartService(db),
+		orders:        orders,
+		returns:       services.NewReturnService(db, orders),
+		digital:       services.NewDigitalService(db),
+		offsets:       services.NewUpdateOffsetService(db),
+		idempotency:   services.NewIdempotencyService(db),
+		stop:          make(chan struct{}),
+		cancel:        cancel,
 	}, nil
 }
 
+// NewStoreBotFactory returns the factory the bot manager uses to run store
+// bots. With a nil webhook service the bots fall back to long polling;
+// workers is the number of chats each bot handles in parallel.
+func NewStoreBotFactory(db *gorm.DB, webhooks *services.WebhookService, workers int) services.StoreBotFactory {
+	return func(store *models.Store, onUpdate func()) (services.StoreBotRunner, error) {
+		subBot, err := NewSubBot(store.BotToken, db, store)
+		if err != nil {
+			return nil, err
+		}
+		subBot.webhooks = webhooks
+		subBot.workers = workers
+		subBot.OnUpdate(onUpdate)
+		return subBot, nil
+	}
+}
+
+// OnUpdate registers a hook called for every update the bot receives
+func (sb *SubBot) OnUpdate(fn func()) {
+	sb.onUpdate = fn
+}
+
 func (sb *SubBot) Start() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	if err := sb.Run(); err != nil {
+		log.Printf("❌ Sub-bot for store %d stopped: %v", sb.store.ID, err)
+	}
+}
+
+// Run receives updates until Stop is called, through the webhook server when
+// one is set and by long polling otherwise. It returns nil after a clean stop
+// and an error when updates can't be received, e.g. a revoked token.
+func (sb *SubBot) Run() error {
+	name := fmt.Sprintf("store %d", sb.store.ID)
+	dispatcher := newUpdateDispatcher(name, sb.workers, sb.handleUpdate)
+	offsets := loadUpdateOffsets(sb.offsets, sb.bot.Self.ID, name)
+	defer func() {
+		// Let handlers that are already running finish before reporting the
+		// stop, and record how far they got
+		dispatcher.Wait()
+		offsets.Save(dispatcher.Handled(), true)
+	}()
+
+	if sb.webhooks != nil {
+		return sb.runWebhook(dispatcher, offsets)
+	}
+	return sb.runPolling(dispatcher, offsets)
+}
+
+// runWebhook registers the store's webhook and handles updates posted to it
+func (sb *SubBot) runWebhook(dispatcher *updateDispatcher, offsets *updateOffsets) error {
+	// Stop doesn't cancel requests in webhook mode so the cleanup below can run
+	defer sb.cancel()
+
+	key := strconv.FormatUint(uint64(sb.store.ID), 10)
+	updates, err := sb.webhooks.SetWebhook(sb.bot, key)
+	if err != nil {
+		return err
+	}
+	defer sb.webhooks.RemoveWebhook(sb.bot, key, updates)
+
+	for {
+		select {
+		case <-sb.stop:
+			return nil
+		case update := <-updates:
+			sb.receive(dispatcher, offsets, update)
+		}
+	}
+}
 
-	updates := sb.bot.GetUpdatesChan(u)
+// runPolling long-polls getUpdates from the last handled update, confirming
+// each batch with the next call
+func (sb *SubBot) runPolling(dispatcher *updateDispatcher, offsets *updateOffsets) error {
+	// getUpdates is refused while a webhook is set, e.g. after running in webhook mode
+	if _, err := sb.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil && !sb.stopped() {
+		log.Printf("⚠️ Failed to delete webhook of store %d: %v", sb.store.ID, err)
+	}
+
+	u := tgbotapi.NewUpdate(offsets.Next())
+	u.Timeout = subBotPollTimeout
 
-	for update := range updates {
-		if update.Message != nil {
-			sb.handleMessage(update.Message)
-		} else if update.CallbackQuery != nil {
-			sb.handleCallback(update.CallbackQuery)
+	failures := 0
+	for {
+		if sb.stopped() {
+			return nil
 		}
+
+		updates, err := sb.bot.GetUpdates(u)
+		if err != nil {
+			if sb.stopped() {
+				return nil
+			}
+
+			var apiErr *tgbotapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+				return fmt.Errorf("bot token was rejected: %w", err)
+			}
+
+			failures++
+			if failures >= subBotMaxPollFailures {
+				return fmt.Errorf("getUpdates failed %d times in a row: %w", failures, err)
+			}
+
+			select {
+			case <-sb.stop:
+				return nil
+			case <-time.After(subBotPollRetryDelay):
+			}
+			continue
+		}
+		failures = 0
+
+		for _, update := range updates {
+			// Leave the rest of the batch unconfirmed so it's redelivered on the next start
+			if sb.stopped() {
+				return nil
+			}
+			if update.UpdateID >= u.Offset {
+				u.Offset = update.UpdateID + 1
+			}
+			sb.receive(dispatcher, offsets, update)
+		}
+		offsets.Save(dispatcher.Handled(), false)
+	}
+}
+
+// Stop ends Run and, when polling, aborts the pending request. It is safe to
+// call more than once.
+func (sb *SubBot) Stop() {
+	sb.stopOnce.Do(func() {
+		close(sb.stop)
+		if sb.webhooks == nil {
+			sb.cancel()
+		}
+	})
+}
+
+func (sb *SubBot) stopped() bool {
+	select {
+	case <-sb.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// receive records an incoming update and queues it for its chat, skipping
+// updates that were already handled before a restart
+func (sb *SubBot) receive(dispatcher *updateDispatcher, offsets *updateOffsets, update tgbotapi.Update) {
+	if offsets.Handled(update.UpdateID) {
+		return
+	}
+	if sb.onUpdate != nil {
+		sb.onUpdate()
+	}
+	dispatcher.Dispatch(update)
+	offsets.Save(dispatcher.Handled(), false)
+}
+
+func (sb *SubBot) handleUpdate(update tgbotapi.Update) {
+	if update.Message != nil {
+		sb.handleMessage(update.Message)
+	} else if update.CallbackQuery != nil {
+		sb.handleCallback(update.CallbackQuery)
+	} else if update.InlineQuery != nil {
+		sb.handleInlineQuery(update.InlineQuery)
 	}
 }
 
@@ -57,17 +258,66 @@ func (sb *SubBot) handleMessage(message *tgbotapi.Message) {
 	chatID := message.Chat.ID
 	text := message.Text
 
+	// The owner uploads the files of digital products here, as file IDs only
+	// work in the bot that received them
+	if message.Document != nil && chatID == sb.store.Owner.TelegramID && strings.HasPrefix(message.Caption, "/file") {
+		sb.setDigitalFile(message)
+		return
+	}
+	// Likewise for the photos and videos of product galleries
+	if (len(message.Photo) > 0 || message.Video != nil) && chatID == sb.store.Owner.TelegramID {
+		if productID, ok := sb.galleryUpload(message); ok {
+			sb.addGalleryMedia(message, productID)
+			return
+		}
+	}
+
+	// A customer checking out is answering its questions; a command leaves
+	// the checkout and is handled as usual
+	if step, err := sb.carts.CheckoutStep(sb.store.ID, chatID); err != nil {
+		log.Printf("❌ Failed to get checkout step in store %d: %v", sb.store.ID, err)
+	} else if step != "" {
+		if !message.IsCommand() {
+			sb.handleCheckoutAnswer(message, step)
+			return
+		}
+		if err := sb.carts.CancelCheckout(sb.store.ID, chatID); err != nil {
+			log.Printf("❌ Failed to cancel checkout in store %d: %v", sb.store.ID, err)
+		}
+	}
+
+	// Likewise for a customer writing a return request
+	if draft, err := sb.returns.DraftReturn(sb.store.ID, chatID); err != nil {
+		log.Printf("❌ Failed to get draft return in store %d: %v", sb.store.ID, err)
+	} else if draft != nil {
+		if !message.IsCommand() {
+			sb.handleReturnAnswer(message, draft)
+			return
+		}
+		if err := sb.returns.DiscardReturn(sb.store.ID, chatID); err != nil {
+			log.Printf("❌ Failed to discard return in store %d: %v", sb.store.ID, err)
+		}
+	}
+
 	switch {
 	case text == "/start":
 		sb.sendWelcome(chatID)
+	case message.Command() == "start":
+		sb.openDeepLink(chatID, message.CommandArguments())
+	case message.Command() == "search":
+		sb.startSearch(chatID, message.CommandArguments())
+	case isSearchReply(message):
+		sb.showSearchResults(chatID, text)
 	case text == "/products" || text == "🛍 محصولات":
-		sb.showProducts(chatID)
+		sb.showProducts(chatID, 0)
 	case text == "/cart" || text == "🛒 سبد خرید":
-		sb.showCart(chatID)
+		sb.showCart(chatID, 0)
 	case text == "/orders" || text == "📋 سفارش‌های من":
 		sb.showUserOrders(chatID)
 	case text == "/contact" || text == "📞 تماس با ما":
 		sb.showContact(chatID)
+	case text == "/about":
+		sb.showAbout(chatID)
 	default:
 		sb.sendMainMenu(chatID)
 	}
@@ -78,10 +328,18 @@ func (sb *SubBot) sendWelcome(chatID int64) {
 	if welcomeText == "" {
 		welcomeText = fmt.Sprintf(`🌟 به فروشگاه %s خوش آمدید! 🌟
 
-برای مشاهده محصولات و خرید از دکمه‌های زیر استفاده کنید:`, sb.store.StoreName)
+برای مشاهده محصولات و خرید از دکمه‌های زیر استفاده کنید:`, sb.store.Name)
 	}
 
-	keyboard := tgbotapi.NewReplyKeyboard(
+	msg := tgbotapi.NewMessage(chatID, welcomeText)
+	msg.ReplyMarkup = mainMenuKeyboard()
+
+	sb.send(msg)
+}
+
+// mainMenuKeyboard is the reply keyboard of the store bot's main menu
+func mainMenuKeyboard() tgbotapi.ReplyKeyboardMarkup {
+	return tgbotapi.NewReplyKeyboard(
 		tgbotapi.NewKeyboardButtonRow(
 			tgbotapi.NewKeyboardButton("🛍 محصولات"),
 			tgbotapi.NewKeyboardButton("🛒 سبد خرید"),
@@ -91,96 +349,127 @@ func (sb *SubBot) sendWelcome(chatID int64) {
 			tgbotapi.NewKeyboardButton("📞 تماس با ما"),
 		),
 	)
-
-	msg := tgbotapi.NewMessage(chatID, welcomeText)
-	msg.ReplyMarkup = keyboard
-
-	sb.bot.Send(msg)
 }
 
-func (sb *SubBot) showProducts(chatID int64) {
-	products, err := sb.storeManager.GetProducts(sb.store.ID)
+// showCart shows the customer's cart with buttons to change it, as a new
+// message or by editing messageID in place
+func (sb *SubBot) showCart(chatID int64, messageID int) {
+	cart, err := sb.carts.GetCart(sb.store.ID, chatID)
 	if err != nil {
-		sb.sendError(chatID, "خطا در دریافت محصولات")
+		log.Printf("❌ Failed to get cart in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت سبد خرید")
 		return
 	}
 
-	if len(products) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "❌ هیچ محصولی در حال حاضر موجود نیست.")
-		sb.bot.Send(msg)
+	if len(cart.Items) == 0 {
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🛍 مشاهده محصولات", "show_products"),
+			),
+		)
+		sb.sendOrEdit(chatID, messageID, messages.CartEmpty, &keyboard)
 		return
 	}
 
-	text := fmt.Sprintf("🛍 محصولات فروشگاه %s:\n\n", sb.store.StoreName)
-
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-	for i, product := range products {
-		if product.IsActive {
-			text += fmt.Sprintf("%d. %s\n💰 قیمت: %,.0f تومان\n📝 %s\n\n",
-				i+1, product.Name, product.Price, product.Description)
+	text := messages.CartTitle
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, item := range cart.Items {
+		// Variant items have their own buttons: vcart_* and var_<variant ID>
+		prefix, id, card := "cart", item.ProductID, fmt.Sprintf("buy_%d", item.ProductID)
+		if item.VariantID != nil {
+			prefix, id, card = "vcart", *item.VariantID, fmt.Sprintf("var_%d", *item.VariantID)
+		}
 
-			keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(
-					fmt.Sprintf("🛒 خرید %s", product.Name),
-					fmt.Sprintf("buy_%d", product.ID),
-				),
+		name := services.CartItemName(item)
+		if !services.CartItemAvailable(item) {
+			if item.Product.Name == "" {
+				name = fmt.Sprintf("محصول #%d", item.ProductID)
+			}
+			text += fmt.Sprintf(messages.CartUnavailableItemLine, i+1, name)
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("❌ حذف "+name, fmt.Sprintf("%s_del_%d", prefix, id)),
 			))
+			continue
 		}
-	}
 
-	msg := tgbotapi.NewMessage(chatID, text)
-	if len(keyboard) > 0 {
-		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+		price := services.CartItemPrice(item)
+		text += fmt.Sprintf(messages.CartItemLine, i+1, name, item.Quantity,
+			services.FormatPrice(price), services.FormatPrice(price*int64(item.Quantity)))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➖", fmt.Sprintf("%s_dec_%d", prefix, id)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s (%d)", name, item.Quantity), card),
+			tgbotapi.NewInlineKeyboardButtonData("➕", fmt.Sprintf("%s_inc_%d", prefix, id)),
+		))
 	}
+	text += fmt.Sprintf(messages.CartTotalLine, services.FormatPrice(services.CartTotal(cart)))
 
-	sb.bot.Send(msg)
-}
-
-func (sb *SubBot) showCart(chatID int64) {
-	// For now, we'll show a simple cart message
-	// In a full implementation, you'd store cart items in a session or database
-	text := `🛒 سبد خرید شما
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🗑 خالی کردن سبد", "cart_clear"),
+		tgbotapi.NewInlineKeyboardButtonData("✅ ثبت سفارش", "confirm_order"),
+	))
 
-برای افزودن محصول به سبد خرید، از بخش محصولات استفاده کنید.
-
-💡 راهنما: پس از انتخاب محصولات، می‌توانید سفارش خود را نهایی کنید.`
-
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🛍 مشاهده محصولات", "show_products"),
-		),
-	)
-
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ReplyMarkup = keyboard
-
-	sb.bot.Send(msg)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.sendOrEdit(chatID, messageID, text, &keyboard)
 }
 
 func (sb *SubBot) showUserOrders(chatID int64) {
 	// Get user's orders
 	var orders []models.Order
-	err := sb.db.Preload("Products").Where("store_id = ? AND customer_id = ?", sb.store.ID, chatID).Find(&orders).Error
+	err := sb.db.Preload("OrderItems.Product").Where("store_id = ? AND customer_telegram_id = ?", sb.store.ID, chatID).
+		Order("created_at DESC").Limit(customerOrdersLimit).Find(&orders).Error
 	if err != nil {
 		sb.sendError(chatID, "خطا در دریافت سفارش‌ها")
 		return
 	}
+	returns, err := sb.returns.CustomerReturns(sb.store.ID, chatID)
+	if err != nil {
+		log.Printf("❌ Failed to get returns in store %d: %v", sb.store.ID, err)
+		sb.sendError(chatID, "خطا در دریافت سفارش‌ها")
+		return
+	}
 
 	if len(orders) == 0 {
 		msg := tgbotapi.NewMessage(chatID, "📋 شما هنوز سفارشی ثبت نکرده‌اید.")
-		sb.bot.Send(msg)
+		sb.send(msg)
 		return
 	}
 
+	// Pending orders can be cancelled and delivered ones returned; the
+	// digital items of paid orders can be received again
 	text := "📋 سفارش‌های شما:\n\n"
+	var rows [][]tgbotapi.InlineKeyboardButton
 	for i, order := range orders {
-		statusEmoji := sb.getStatusEmoji(order.Status)
-		text += fmt.Sprintf("%d. سفارش #%d\n%s وضعیت: %s\n💰 مبلغ: %,.0f تومان\n📅 تاریخ: %s\n\n",
-			i+1, order.ID, statusEmoji, order.Status, order.TotalAmount, order.CreatedAt.Format("2006/01/02"))
+		statusEmoji := orderStatusEmoji(order.Status)
+		text += fmt.Sprintf("%d. سفارش #%d\n%s وضعیت: %s\n💰 مبلغ: %s تومان\n📅 تاریخ: %s\n",
+			i+1, order.ID, statusEmoji, services.OrderStatusLabel(order.Status), services.FormatPrice(order.TotalAmount), order.CreatedAt.Format("2006/01/02"))
+		returnStatus, returned := returns[order.ID]
+		if returned {
+			text += fmt.Sprintf(messages.CustomerOrderReturnLine, returnStatusLabel(returnStatus))
+		}
+		text += "\n"
+
+		switch {
+		case order.Status == models.OrderStatusPending:
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(messages.ButtonCancelOrder, order.ID), fmt.Sprintf("order_cancel_%d", order.ID)),
+			))
+		case order.Status == models.OrderStatusDelivered && !returned:
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(messages.ButtonReturnOrder, order.ID), fmt.Sprintf("order_return_%d", order.ID)),
+			))
+		}
+		if services.DigitalDelivered(&order) && hasDigitalItems(&order) {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(messages.ButtonRedeliverOrder, order.ID), fmt.Sprintf("order_files_%d", order.ID)),
+			))
+		}
 	}
 
 	msg := tgbotapi.NewMessage(chatID, text)
-	sb.bot.Send(msg)
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	sb.send(msg)
 }
 
 func (sb *SubBot) showContact(chatID int64) {
@@ -192,17 +481,32 @@ func (sb *SubBot) showContact(chatID int64) {
 ⏰ ساعت کاری: 9 صبح تا 21 شب
 📱 پاسخگویی: حداکثر 2 ساعت
 
-💬 برای ارسال پیام، کافی است متن خود را بنویسید.`, sb.store.StoreName)
+💬 برای ارسال پیام، کافی است متن خود را بنویسید.`, sb.store.Name)
 
 	msg := tgbotapi.NewMessage(chatID, contactText)
-	sb.bot.Send(msg)
+	sb.send(msg)
+}
+
+func (sb *SubBot) showAbout(chatID int64) {
+	description := sb.store.Description
+	if description == "" {
+		description = "فروشگاه هنوز توضیحی ثبت نکرده است."
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("ℹ️ درباره فروشگاه %s\n\n%s", sb.store.Name, description))
+	sb.send(msg)
 }
 
 func (sb *SubBot) handleCallback(callback *tgbotapi.CallbackQuery) {
 	chatID := callback.Message.Chat.ID
 	data := callback.Data
 
-	// Answer callback query
+	// Cart buttons answer with the outcome, the others right away
+	if strings.HasPrefix(data, "card_") || strings.HasPrefix(data, "cart_") ||
+		strings.HasPrefix(data, "vcard_") || strings.HasPrefix(data, "vcart_") {
+		sb.bot.Request(tgbotapi.NewCallback(callback.ID, sb.handleCartCallback(callback)))
+		return
+	}
 	sb.bot.Request(tgbotapi.NewCallback(callback.ID, ""))
 
 	switch {
@@ -213,73 +517,388 @@ func (sb *SubBot) handleCallback(callback *tgbotapi.CallbackQuery) {
 			sb.sendError(chatID, "خطا در شناسایی محصول")
 			return
 		}
-		sb.handleProductPurchase(chatID, uint(productID))
+		sb.showProductCard(chatID, uint(productID), 0)
+	case strings.HasPrefix(data, "var_"):
+		variantID, err := strconv.ParseUint(strings.TrimPrefix(data, "var_"), 10, 32)
+		if err != nil {
+			sb.sendError(chatID, "خطا در شناسایی محصول")
+			return
+		}
+		sb.showVariantCard(chatID, uint(variantID), 0)
 	case data == "show_products":
-		sb.showProducts(chatID)
+		sb.showProducts(chatID, menuMessageID(callback))
+	case strings.HasPrefix(data, "catalog_"):
+		sb.showCatalogCallback(chatID, callback.Message.MessageID, data)
+	case data == "show_cart":
+		sb.showCart(chatID, menuMessageID(callback))
 	case data == "confirm_order":
-		sb.handleOrderConfirmation(chatID)
+		sb.startCheckout(chatID)
+	case data == "checkout_confirm":
+		// A double tap on the same button must not place the order twice
+		sb.placeOrder(chatID, callbackActionKey(fmt.Sprintf("store:%d", sb.store.ID), callback), callback.From)
+	case data == "checkout_cancel":
+		sb.cancelCheckout(chatID)
+	case strings.HasPrefix(data, "ship_"):
+		methodID, err := strconv.ParseUint(strings.TrimPrefix(data, "ship_"), 10, 32)
+		if err != nil {
+			sb.sendError(chatID, "خطا در شناسایی روش ارسال")
+			return
+		}
+		sb.chooseShippingMethod(chatID, uint(methodID))
+	case data == "checkout_coupon":
+		sb.startCouponEntry(chatID)
+	case data == "checkout_coupon_remove":
+		sb.removeCoupon(chatID)
+	case strings.HasPrefix(data, "order_cancel_yes_"):
+		if orderID, ok := sb.callbackOrderID(chatID, data, "order_cancel_yes_"); ok {
+			sb.cancelOrder(chatID, orderID)
+		}
+	case strings.HasPrefix(data, "order_cancel_"):
+		if orderID, ok := sb.callbackOrderID(chatID, data, "order_cancel_"); ok {
+			sb.askCancelOrder(chatID, orderID)
+		}
+	case strings.HasPrefix(data, "order_return_"):
+		if orderID, ok := sb.callbackOrderID(chatID, data, "order_return_"); ok {
+			sb.startReturn(chatID, orderID)
+		}
+	case strings.HasPrefix(data, "order_files_"):
+		if orderID, ok := sb.callbackOrderID(chatID, data, "order_files_"); ok {
+			sb.redeliverOrder(chatID, orderID)
+		}
+	case data == "return_submit":
+		sb.submitReturn(chatID, callback.From)
+	case data == "return_discard":
+		sb.discardReturn(chatID)
+	case data == "show_orders":
+		sb.showUserOrders(chatID)
 	}
 }
 
-func (sb *SubBot) handleProductPurchase(chatID int64, productID uint) {
-	var product models.Product
-	err := sb.db.First(&product, productID).Error
+// callbackActionKey identifies a tap on a state-changing button by the message
+// the button is on: tapping it again is a repeat, while the same button on a
+// new message is a new action
+func callbackActionKey(scope string, callback *tgbotapi.CallbackQuery) string {
+	return fmt.Sprintf("%s:%d:%d:%s", scope, callback.Message.Chat.ID, callback.Message.MessageID, callback.Data)
+}
+
+// once runs a state-changing action unless it already succeeded for key, in
+// which case the customer is told so. It reports whether the action ran and
+// succeeded, after which the customer can be told the outcome.
+func (sb *SubBot) once(chatID int64, key string, action func(tx *gorm.DB) error) bool {
+	err := sb.idempotency.Once(key, action)
+	switch {
+	case errors.Is(err, services.ErrActionDone):
+		sb.send(tgbotapi.NewMessage(chatID, messages.InfoAlreadyDone))
+	case err != nil:
+		log.Printf("❌ Action %s of store %d failed: %v", key, sb.store.ID, err)
+		sb.sendError(chatID, "خطا در انجام عملیات")
+	}
+	return err == nil
+}
+
+// handleCartCallback handles the cart buttons on product cards (card_*) and
+// in the cart (cart_*), and their variant counterparts (vcard_*, vcart_*),
+// and redraws the message they are on. It returns the text to answer the
+// callback with.
+func (sb *SubBot) handleCartCallback(callback *tgbotapi.CallbackQuery) string {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+
+	if callback.Data == "cart_clear" {
+		if err := sb.carts.Clear(sb.store.ID, chatID); err != nil {
+			log.Printf("❌ Failed to clear cart in store %d: %v", sb.store.ID, err)
+			return messages.CartError
+		}
+		sb.showCart(chatID, messageID)
+		return messages.CartCleared
+	}
+
+	// card_add_12 -> card_add, 12; the ID is a variant's for vcard_*/vcart_*
+	cut := strings.LastIndex(callback.Data, "_")
+	action := callback.Data[:max(cut, 0)]
+	id, err := strconv.ParseUint(callback.Data[cut+1:], 10, 32)
 	if err != nil {
+		return messages.CartError
+	}
+
+	answer := ""
+	switch action {
+	case "card_add", "cart_inc":
+		_, err = sb.carts.AddItem(sb.store.ID, chatID, uint(id))
+		answer = messages.CartItemAdded
+	case "card_dec", "cart_dec":
+		_, err = sb.carts.RemoveOne(sb.store.ID, chatID, uint(id))
+	case "cart_del":
+		err = sb.carts.RemoveItem(sb.store.ID, chatID, uint(id))
+	case "vcard_add", "vcart_inc":
+		_, err = sb.carts.AddVariant(sb.store.ID, chatID, uint(id))
+		answer = messages.CartItemAdded
+	case "vcard_dec", "vcart_dec":
+		_, err = sb.carts.RemoveOneVariant(sb.store.ID, chatID, uint(id))
+	case "vcart_del":
+		err = sb.carts.RemoveVariant(sb.store.ID, chatID, uint(id))
+	case "card_show", "vcard_show":
+		// Moving between a product's card and its variants' in place
+	default:
+		return ""
+	}
+
+	switch {
+	case errors.Is(err, services.ErrProductUnavailable):
+		answer = messages.CartProductUnavailable
+	case errors.Is(err, services.ErrCartQuantityLimit):
+		answer = messages.CartQuantityLimit
+	case errors.Is(err, services.ErrVariantRequired):
+		// The product got variants since the card was shown
+		answer = messages.CartChooseVariant
+	case err != nil:
+		log.Printf("❌ Failed to update cart in store %d: %v", sb.store.ID, err)
+		return messages.CartError
+	}
+
+	switch {
+	case strings.HasPrefix(action, "card_"):
+		sb.showProductCard(chatID, uint(id), messageID)
+	case strings.HasPrefix(action, "vcard_"):
+		sb.showVariantCard(chatID, uint(id), messageID)
+	default:
+		sb.showCart(chatID, messageID)
+	}
+	return answer
+}
+
+// showProductCard shows a product with buttons to put it in the cart, as a
+// new message or by editing messageID in place
+func (sb *SubBot) showProductCard(chatID int64, productID uint, messageID int) {
+	product, err := sb.products.GetProductByID(productID)
+	if err != nil || product.StoreID != sb.store.ID || !product.IsAvailable {
 		sb.sendError(chatID, "محصول یافت نشد")
 		return
 	}
 
-	purchaseText := fmt.Sprintf(`🛒 خرید محصول
+	quantity, err := sb.carts.ItemQuantity(sb.store.ID, chatID, productID)
+	if err != nil {
+		log.Printf("❌ Failed to get cart quantity in store %d: %v", sb.store.ID, err)
+	}
 
-📦 محصول: %s
-💰 قیمت: %,.0f تومان
-📝 توضیحات: %s
+	// The gallery goes above a new card; cards edited in place keep theirs
+	if messageID == 0 {
+		sb.sendGallery(chatID, product)
+	}
 
-آیا مایل به خرید این محصول هستید؟`, product.Name, product.Price, product.Description)
+	text := fmt.Sprintf(messages.ProductCard, product.Name, services.FormatPrice(product.Price), product.Description)
+	if services.HasVariants(product) {
+		sb.showVariantChoice(chatID, product, quantity, text, messageID)
+		return
+	}
+	soldOut := false
+	if product.TrackStock {
+		available, err := sb.products.AvailableStock(productID)
+		if err != nil {
+			log.Printf("❌ Failed to get stock of product %d: %v", productID, err)
+		}
+		if available > 0 {
+			text += fmt.Sprintf(messages.ProductCardStock, available)
+		} else {
+			soldOut = true
+		}
+	}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ تایید خرید", fmt.Sprintf("confirm_buy_%d", productID)),
-			tgbotapi.NewInlineKeyboardButtonData("❌ انصراف", "cancel_buy"),
-		),
-	)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	switch {
+	case quantity > 0:
+		text += fmt.Sprintf(messages.ProductCardInCart, quantity)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➖", fmt.Sprintf("card_dec_%d", productID)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🛒 %d", quantity), "show_cart"),
+			tgbotapi.NewInlineKeyboardButtonData("➕", fmt.Sprintf("card_add_%d", productID)),
+		))
+	case !soldOut:
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ افزودن به سبد خرید", fmt.Sprintf("card_add_%d", productID)),
+		))
+	}
+	if soldOut {
+		text += messages.ProductCardSoldOut
+	}
 
-	msg := tgbotapi.NewMessage(chatID, purchaseText)
-	msg.ReplyMarkup = keyboard
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🛒 مشاهده سبد خرید", "show_cart"),
+		tgbotapi.NewInlineKeyboardButtonData("🛍 محصولات", "show_products"),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.sendCard(chatID, messageID, product.ThumbnailFileID, text, &keyboard)
+}
+
+// showVariantChoice finishes the card of a product sold by variant with a
+// button for each of its variants
+func (sb *SubBot) showVariantChoice(chatID int64, product *models.Product, quantity int, text string, messageID int) {
+	variants, err := sb.products.GetVariants(product.ID)
+	if err != nil {
+		log.Printf("❌ Failed to get variants of product %d: %v", product.ID, err)
+		sb.sendError(chatID, "خطا در دریافت محصول")
+		return
+	}
+
+	if quantity > 0 {
+		text += fmt.Sprintf(messages.ProductCardInCart, quantity)
+	}
+	text += fmt.Sprintf(messages.ProductCardChooseVariant, strings.ReplaceAll(product.VariantAxes, "/", " / "))
 
-	sb.bot.Send(msg)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, variant := range variants {
+		label := services.VariantName(&variant)
+		if variant.Price > 0 {
+			label += " — " + services.FormatPrice(variant.Price)
+		}
+		if product.TrackStock && variant.Stock <= 0 {
+			label = "❌ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("vcard_show_%d", variant.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🛒 مشاهده سبد خرید", "show_cart"),
+		tgbotapi.NewInlineKeyboardButtonData("🛍 محصولات", "show_products"),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.sendCard(chatID, messageID, product.ThumbnailFileID, text, &keyboard)
 }
 
-func (sb *SubBot) handleOrderConfirmation(chatID int64) {
-	// This is a simplified order confirmation
-	// In a real implementation, you'd handle payment processing
-	
-	confirmText := `✅ سفارش شما با موفقیت ثبت شد!
+// showVariantCard shows a product variant with buttons to put it in the
+// cart, as a new message or by editing messageID in place
+func (sb *SubBot) showVariantCard(chatID int64, variantID uint, messageID int) {
+	variant, err := sb.products.GetVariant(variantID)
+	if err != nil || !variant.IsAvailable {
+		sb.sendError(chatID, "محصول یافت نشد")
+		return
+	}
+	product, err := sb.products.GetProductByID(variant.ProductID)
+	if err != nil || product.StoreID != sb.store.ID || !product.IsAvailable {
+		sb.sendError(chatID, "محصول یافت نشد")
+		return
+	}
+
+	quantity, err := sb.carts.VariantQuantity(sb.store.ID, chatID, variantID)
+	if err != nil {
+		log.Printf("❌ Failed to get cart quantity in store %d: %v", sb.store.ID, err)
+	}
+
+	price := product.Price
+	if variant.Price > 0 {
+		price = variant.Price
+	}
+	text := fmt.Sprintf(messages.ProductCard, product.Name, services.FormatPrice(price), product.Description)
+	text += fmt.Sprintf(messages.ProductCardVariant, services.VariantName(variant))
+	soldOut := false
+	if product.TrackStock {
+		available, err := sb.products.AvailableVariantStock(variantID)
+		if err != nil {
+			log.Printf("❌ Failed to get stock of variant %d: %v", variantID, err)
+		}
+		if available > 0 {
+			text += fmt.Sprintf(messages.ProductCardStock, available)
+		} else {
+			soldOut = true
+		}
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	switch {
+	case quantity > 0:
+		text += fmt.Sprintf(messages.ProductCardInCart, quantity)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➖", fmt.Sprintf("vcard_dec_%d", variantID)),
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🛒 %d", quantity), "show_cart"),
+			tgbotapi.NewInlineKeyboardButtonData("➕", fmt.Sprintf("vcard_add_%d", variantID)),
+		))
+	case !soldOut:
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ افزودن به سبد خرید", fmt.Sprintf("vcard_add_%d", variantID)),
+		))
+	}
+	if soldOut {
+		text += messages.ProductCardSoldOut
+	}
 
-📋 شماره سفارش: #` + fmt.Sprintf("%d", chatID) + `
-⏰ زمان تحویل: 1-2 روز کاری
-📞 برای پیگیری با پشتیبانی تماس بگیرید.
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.ButtonOtherVariants, fmt.Sprintf("card_show_%d", product.ID)),
+		tgbotapi.NewInlineKeyboardButtonData("🛒 مشاهده سبد خرید", "show_cart"),
+	))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	sb.sendCard(chatID, messageID, product.ThumbnailFileID, text, &keyboard)
+}
 
-🙏 از خرید شما متشکریم!`
+// send sends a message, logging when it can't be sent
+func (sb *SubBot) send(c tgbotapi.Chattable) {
+	if _, err := sb.bot.Send(c); err != nil {
+		log.Printf("❌ Failed to send message in store %d: %v", sb.store.ID, err)
+	}
+}
 
-	msg := tgbotapi.NewMessage(chatID, confirmText)
-	sb.bot.Send(msg)
+// sendOrEdit sends a new message, or edits messageID in place when it is set
+func (sb *SubBot) sendOrEdit(chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text)
+		if keyboard != nil {
+			msg.ReplyMarkup = *keyboard
+		}
+		sb.send(msg)
+		return
+	}
 
-	// Notify store owner
-	sb.notifyStoreOwner(chatID)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = keyboard
+	if _, err := sb.bot.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("❌ Failed to edit message in store %d: %v", sb.store.ID, err)
+	}
 }
 
-func (sb *SubBot) notifyStoreOwner(customerID int64) {
-	notificationText := fmt.Sprintf(`🔔 سفارش جدید!
+// sendCard sends a product card, as a photo captioned with the text when the
+// product has a picture, or edits messageID in place
+func (sb *SubBot) sendCard(chatID int64, messageID int, photo, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	if photo == "" {
+		sb.sendOrEdit(chatID, messageID, text, keyboard)
+		return
+	}
 
-🏪 فروشگاه: %s
-👤 مشتری: %d
-📅 زمان: الان
+	text = truncateCaption(text)
+	if messageID == 0 {
+		msg := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(photo))
+		msg.Caption = text
+		if keyboard != nil {
+			msg.ReplyMarkup = *keyboard
+		}
+		sb.send(msg)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageCaption(chatID, messageID, text)
+	edit.ReplyMarkup = keyboard
+	if _, err := sb.bot.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("❌ Failed to edit card in store %d: %v", sb.store.ID, err)
+	}
+}
 
-برای مشاهده جزئیات به پنل مدیریت مراجعه کنید.`, sb.store.StoreName, customerID)
+// menuMessageID returns the message a button is on for a menu to replace it
+// in place, or 0 for a new message when it is a photo card, whose caption
+// can't become a menu
+func menuMessageID(callback *tgbotapi.CallbackQuery) int {
+	if len(callback.Message.Photo) > 0 {
+		return 0
+	}
+	return callback.Message.MessageID
+}
 
-	msg := tgbotapi.NewMessage(sb.store.OwnerChatID, notificationText)
-	sb.bot.Send(msg)
+// truncateCaption shortens a text to fit a photo caption
+func truncateCaption(text string) string {
+	const maxCaption = 1024 // characters
+	runes := []rune(text)
+	if len(runes) <= maxCaption {
+		return text
+	}
+	return string(runes[:maxCaption-1]) + "…"
 }
 
 func (sb *SubBot) sendMainMenu(chatID int64) {
@@ -288,22 +907,5 @@ func (sb *SubBot) sendMainMenu(chatID int64) {
 
 func (sb *SubBot) sendError(chatID int64, errorMsg string) {
 	msg := tgbotapi.NewMessage(chatID, "❌ "+errorMsg)
-	sb.bot.Send(msg)
-}
-
-func (sb *SubBot) getStatusEmoji(status string) string {
-	switch status {
-	case "pending":
-		return "⏳"
-	case "confirmed":
-		return "✅"
-	case "shipped":
-		return "🚚"
-	case "delivered":
-		return "📋"
-	case "cancelled":
-		return "❌"
-	default:
-		return "❓"
-	}
-}
\ No newline at end of file
+	sb.send(msg)
+}
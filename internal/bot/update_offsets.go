@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"log"
+	"telegram-store-hub/internal/services"
+	"time"
+)
+
+// offsetSaveInterval limits how often a bot's handled offset is written
+const offsetSaveInterval = 2 * time.Second
+
+// updateOffsets keeps a bot's persisted update offset in step with what its
+// dispatcher has handled. It is used from the bot's receive loop only.
+type updateOffsets struct {
+	service *services.UpdateOffsetService
+	botID   int64
+	name    string
+	saved   int
+	savedAt time.Time
+}
+
+// loadUpdateOffsets reads the last handled update of a bot. When that fails
+// the bot starts from Telegram's own offset, as it did before.
+func loadUpdateOffsets(service *services.UpdateOffsetService, botID int64, name string) *updateOffsets {
+	last, err := service.GetLastUpdateID(botID)
+	if err != nil {
+		log.Printf("⚠️ Failed to load update offset of %s: %v", name, err)
+	}
+
+	return &updateOffsets{
+		service: service,
+		botID:   botID,
+		name:    name,
+		saved:   last,
+		savedAt: time.Now(),
+	}
+}
+
+// Next returns the offset to ask getUpdates for
+func (o *updateOffsets) Next() int {
+	if o.saved == 0 {
+		return 0
+	}
+	return o.saved + 1
+}
+
+// Handled reports whether an update was handled before, e.g. one Telegram
+// delivers again after a restart or a webhook retry
+func (o *updateOffsets) Handled(updateID int) bool {
+	return updateID <= o.saved
+}
+
+// Save persists handled as the last handled update, at most every
+// offsetSaveInterval unless force is set
+func (o *updateOffsets) Save(handled int, force bool) {
+	if handled <= o.saved {
+		return
+	}
+	if !force && time.Since(o.savedAt) < offsetSaveInterval {
+		return
+	}
+
+	if err := o.service.SaveLastUpdateID(o.botID, handled); err != nil {
+		log.Printf("⚠️ Failed to save update offset of %s: %v", o.name, err)
+		return
+	}
+	o.saved = handled
+	o.savedAt = time.Now()
+}
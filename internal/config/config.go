@@ -4,15 +4,39 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
 
+// Update delivery modes
+const (
+	UpdateModePolling = "polling"
+	UpdateModeWebhook = "webhook"
+)
+
 // Config holds all configuration for the application
 type Config struct {
 	// Bot Configuration
-	MotherBotToken    string `json:"mother_bot_token"`
+	MotherBotToken    string `json:"-"`
 	Debug             bool   `json:"debug"`
+	BotAPIEndpoint    string `json:"bot_api_endpoint"` // e.g. a self-hosted Bot API server; empty for Telegram
+	
+	// Update delivery: "polling" (default) or "webhook"
+	UpdateMode        string `json:"update_mode"`
+	WebhookBaseURL    string `json:"webhook_base_url"`
+	WebhookListenAddr string `json:"webhook_listen_addr"`
+	WebhookSecret     string `json:"-"`
+	UpdateWorkers     int    `json:"update_workers"` // chats handled in parallel per bot
+	
+	// Name of this instance when several share the store bots and elect a
+	// leader; defaults to the host name and process ID
+	InstanceID        string `json:"instance_id"`
+	
+	// Master keys for encrypting store bot tokens at rest (base64, 32 bytes).
+	// Old keys are only used to decrypt while rotating to a new key.
+	TokenEncryptionKey     string   `json:"-"`
+	TokenEncryptionOldKeys []string `json:"-"`
 	
 	// Database Configuration
 	DatabaseURL       string `json:"database_url"`
@@ -78,6 +102,24 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
 	}
 	
+	// Token encryption key, inline or from a file such as a mounted secret
+	cfg.TokenEncryptionKey = os.Getenv("TOKEN_ENCRYPTION_KEY")
+	if keyFile := os.Getenv("TOKEN_ENCRYPTION_KEY_FILE"); keyFile != "" && cfg.TokenEncryptionKey == "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TOKEN_ENCRYPTION_KEY_FILE: %v", err)
+		}
+		cfg.TokenEncryptionKey = strings.TrimSpace(string(key))
+	}
+	if cfg.TokenEncryptionKey == "" {
+		return nil, fmt.Errorf("TOKEN_ENCRYPTION_KEY or TOKEN_ENCRYPTION_KEY_FILE is required")
+	}
+	for _, key := range strings.Split(os.Getenv("TOKEN_ENCRYPTION_OLD_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			cfg.TokenEncryptionOldKeys = append(cfg.TokenEncryptionOldKeys, key)
+		}
+	}
+	
 	// Admin chat ID
 	adminChatIDStr := os.Getenv("ADMIN_CHAT_ID")
 	if adminChatIDStr == "" {
@@ -96,6 +138,29 @@ func LoadConfig() (*Config, error) {
 	cfg.PaymentCardNumber = getEnv("PAYMENT_CARD_NUMBER", "1234-5678-9012-3456")
 	cfg.PaymentCardHolder = getEnv("PAYMENT_CARD_HOLDER", "فروشگاه CodeRoot")
 	
+	cfg.BotAPIEndpoint = os.Getenv("BOT_API_ENDPOINT")
+	
+	// Update delivery mode
+	cfg.UpdateMode = getEnv("UPDATE_MODE", UpdateModePolling)
+	cfg.WebhookBaseURL = os.Getenv("WEBHOOK_BASE_URL")
+	cfg.WebhookListenAddr = getEnv("WEBHOOK_LISTEN_ADDR", ":8443")
+	cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+	cfg.UpdateWorkers = getEnvInt("UPDATE_WORKERS", 8)
+	cfg.InstanceID = getEnv("INSTANCE_ID", defaultInstanceID())
+	
+	switch cfg.UpdateMode {
+	case UpdateModePolling:
+	case UpdateModeWebhook:
+		if cfg.WebhookBaseURL == "" || cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("WEBHOOK_BASE_URL and WEBHOOK_SECRET are required when UPDATE_MODE=webhook")
+		}
+	default:
+		return nil, fmt.Errorf("invalid UPDATE_MODE %q: use %q or %q", cfg.UpdateMode, UpdateModePolling, UpdateModeWebhook)
+	}
+	if cfg.UpdateWorkers < 1 {
+		return nil, fmt.Errorf("UPDATE_WORKERS must be at least 1")
+	}
+	
 	// Override pricing if environment variables are set
 	if price := getEnvInt64("FREE_PLAN_PRICE", -1); price != -1 {
 		cfg.FreePlanPrice = price
@@ -157,6 +222,15 @@ func getEnvBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// defaultInstanceID names an instance after its host and process
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
 // GetPlanLimit returns the product limit for a given plan
 func (c *Config) GetPlanLimit(planType string) int {
 	switch planType {
@@ -202,4 +276,9 @@ func (c *Config) GetPlanCommission(planType string) int {
 // IsChannelJoinRequired returns true if channel join is required
 func (c *Config) IsChannelJoinRequired() bool {
 	return c.RequiredChannelID != "" && c.RequiredChannelUsername != ""
-}
\ No newline at end of file
+}
+
+// UseWebhooks returns true if bots receive updates through the webhook server
+func (c *Config) UseWebhooks() bool {
+	return c.UpdateMode == UpdateModeWebhook
+}
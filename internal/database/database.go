@@ -50,10 +50,29 @@ func Migrate(db *gorm.DB) error {
 		&models.User{},
 		&models.Store{},
 		&models.Product{},
+		&models.ProductVariant{},
+		&models.ProductMedia{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.LicenseKey{},
+		&models.OrderStatusEvent{},
+		&models.Cart{},
+		&models.CartItem{},
+		&models.StockReservation{},
+		&models.MessageTemplate{},
+		&models.Coupon{},
+		&models.CouponRedemption{},
+		&models.ShippingMethod{},
+		&models.ShippingRate{},
+		&models.ReturnRequest{},
+		&models.ReturnPhoto{},
+		&models.Refund{},
 		&models.Payment{},
 		&models.UserSession{},
+		&models.BotUpdateOffset{},
+		&models.ProcessedAction{},
+		&models.BotHealthCheck{},
+		&models.Lease{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
@@ -64,6 +83,12 @@ func Migrate(db *gorm.DB) error {
 		return fmt.Errorf("failed to create indexes: %w", err)
 	}
 
+	// Orders used to be finished as "completed"; it is "delivered" now
+	if err := db.Model(&models.Order{}).Where("status = ?", "completed").
+		Update("status", models.OrderStatusDelivered).Error; err != nil {
+		return fmt.Errorf("failed to migrate order statuses: %w", err)
+	}
+
 	log.Println("Database migrations completed successfully")
 	return nil
 }
@@ -90,6 +115,20 @@ func createIndexes(db *gorm.DB) error {
 		return err
 	}
 
+	// A bot can only serve one store
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_stores_bot_id_unique ON stores(bot_id) WHERE bot_id <> 0 AND deleted_at IS NULL").Error; err != nil {
+		return err
+	}
+
+	// A cart holds each product and each of its variants once. The index
+	// used to cover the product only.
+	if err := db.Exec("DROP INDEX IF EXISTS idx_cart_items_cart_product").Error; err != nil {
+		return err
+	}
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_product_variant ON cart_items(cart_id, product_id, COALESCE(variant_id, 0))").Error; err != nil {
+		return err
+	}
+
 	// Index on product store_id for faster product queries
 	if err := db.Exec("CREATE INDEX IF NOT EXISTS idx_products_store_id ON products(store_id)").Error; err != nil {
 		return err
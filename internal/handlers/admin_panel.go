@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"telegram-store-hub/internal/messages"
 	"telegram-store-hub/internal/models"
 	"telegram-store-hub/internal/services"
 	"time"
@@ -18,6 +21,7 @@ type AdminPanelHandler struct {
 	storeManager *services.StoreManagerService
 	botManager   *services.BotManagerService
 	subscription *services.SubscriptionService
+	idempotency  *services.IdempotencyService
 }
 
 func NewAdminPanelHandler(bot *tgbotapi.BotAPI, db *gorm.DB) *AdminPanelHandler {
@@ -27,6 +31,14 @@ func NewAdminPanelHandler(bot *tgbotapi.BotAPI, db *gorm.DB) *AdminPanelHandler
 		storeManager: services.NewStoreManagerService(db),
 		botManager:   services.NewBotManagerService(db),
 		subscription: services.NewSubscriptionService(db),
+		idempotency:  services.NewIdempotencyService(db),
+	}
+}
+
+// send sends a message, logging when it can't be sent
+func (aph *AdminPanelHandler) send(c tgbotapi.Chattable) {
+	if _, err := aph.bot.Send(c); err != nil {
+		log.Printf("Error sending message: %v", err)
 	}
 }
 
@@ -34,13 +46,13 @@ func (aph *AdminPanelHandler) HandleStoreManagement(chatID int64) {
 	stores, err := aph.storeManager.GetAllStores()
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در دریافت فروشگاه‌ها.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
 	if len(stores) == 0 {
 		msg := tgbotapi.NewMessage(chatID, "🏪 هیچ فروشگاهی ثبت نشده است.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
@@ -88,7 +100,7 @@ func (aph *AdminPanelHandler) HandleStoreManagement(chatID int64) {
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
 
 func (aph *AdminPanelHandler) HandlePaymentManagement(chatID int64) {
@@ -96,13 +108,13 @@ func (aph *AdminPanelHandler) HandlePaymentManagement(chatID int64) {
 	err := aph.db.Preload("Store").Order("created_at desc").Limit(20).Find(&payments).Error
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در دریافت پرداخت‌ها.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
 	if len(payments) == 0 {
 		msg := tgbotapi.NewMessage(chatID, "💰 هیچ پرداختی ثبت نشده است.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
@@ -143,7 +155,7 @@ func (aph *AdminPanelHandler) HandlePaymentManagement(chatID int64) {
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
 
 func (aph *AdminPanelHandler) HandleFinancialReport(chatID int64) {
@@ -225,7 +237,7 @@ func (aph *AdminPanelHandler) HandleFinancialReport(chatID int64) {
 
 	msg := tgbotapi.NewMessage(chatID, reportText)
 	msg.ReplyMarkup = keyboard
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
 
 func (aph *AdminPanelHandler) HandleBroadcastMessage(chatID int64) {
@@ -248,7 +260,7 @@ func (aph *AdminPanelHandler) HandleBroadcastMessage(chatID int64) {
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
 
 func (aph *AdminPanelHandler) HandleStoreDetails(chatID int64, storeID uint) {
@@ -256,7 +268,7 @@ func (aph *AdminPanelHandler) HandleStoreDetails(chatID int64, storeID uint) {
 	err := aph.db.Preload("Products").Preload("Orders").First(&store, storeID).Error
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاه یافت نشد.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
@@ -325,8 +337,8 @@ func (aph *AdminPanelHandler) HandleStoreDetails(chatID int64, storeID uint) {
 		}(),
 		store.BotUsername,
 		func() string {
-			isRunning, _ := aph.botManager.GetBotStatus(store.ID)
-			if isRunning {
+			status, _ := aph.botManager.GetBotStatus(store.ID)
+			if status == services.BotStatusActive {
 				return "✅ در حال اجرا"
 			}
 			return "❌ متوقف"
@@ -353,7 +365,7 @@ func (aph *AdminPanelHandler) HandleStoreDetails(chatID int64, storeID uint) {
 
 	msg := tgbotapi.NewMessage(chatID, detailsText)
 	msg.ReplyMarkup = keyboard
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
 
 func (aph *AdminPanelHandler) HandleCallback(callback *tgbotapi.CallbackQuery) {
@@ -397,7 +409,7 @@ func (aph *AdminPanelHandler) handlePaymentApproval(chatID int64, paymentIDStr,
 	paymentID, err := strconv.ParseUint(paymentIDStr, 10, 32)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در شناسایی پرداخت.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
@@ -406,26 +418,42 @@ func (aph *AdminPanelHandler) handlePaymentApproval(chatID int64, paymentIDStr,
 	err = aph.db.Preload("Store").First(&payment, paymentID).Error
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ پرداخت یافت نشد.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
-	err = aph.db.Model(&payment).Update("status", status).Error
+	// Approving and rejecting share one key: a double tap, or a second admin
+	// deciding the same payment, has no effect
+	var failure string
+	err = aph.idempotency.Once(fmt.Sprintf("payment:%d", paymentID), func(tx *gorm.DB) error {
+		if err := tx.Model(&payment).Update("status", status).Error; err != nil {
+			failure = "❌ خطا در بروزرسانی وضعیت پرداخت."
+			return err
+		}
+
+		// If approved, extend subscription
+		if status == "approved" {
+			if err := services.NewStoreManagerService(tx).ExtendStorePlan(payment.StoreID, 1); err != nil {
+				failure = "❌ خطا در تمدید اشتراک."
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, services.ErrActionDone) {
+		aph.send(tgbotapi.NewMessage(chatID, messages.InfoAlreadyDone))
+		return
+	}
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "❌ خطا در بروزرسانی وضعیت پرداخت.")
-		aph.bot.Send(msg)
+		if failure == "" {
+			failure = "❌ خطا در بروزرسانی وضعیت پرداخت."
+		}
+		msg := tgbotapi.NewMessage(chatID, failure)
+		aph.send(msg)
 		return
 	}
 
-	// If approved, extend subscription
 	if status == "approved" {
-		err = aph.subscription.ExtendSubscription(payment.StoreID, payment.PlanType, 1)
-		if err != nil {
-			msg := tgbotapi.NewMessage(chatID, "⚠️ پرداخت تایید شد اما خطا در تمدید اشتراک رخ داد.")
-			aph.bot.Send(msg)
-			return
-		}
-
 		// Notify store owner
 		notificationText := fmt.Sprintf(`✅ پرداخت شما تایید شد!
 
@@ -436,7 +464,7 @@ func (aph *AdminPanelHandler) handlePaymentApproval(chatID int64, paymentIDStr,
 فروشگاه شما فعال است و می‌توانید از تمامی امکانات استفاده کنید.`, payment.PlanType, time.Now().AddDate(0, 1, 0).Format("2006/01/02"))
 
 		ownerMsg := tgbotapi.NewMessage(payment.Store.OwnerChatID, notificationText)
-		aph.bot.Send(ownerMsg)
+		aph.send(ownerMsg)
 	} else {
 		// Notify store owner of rejection
 		notificationText := `❌ پرداخت شما تایید نشد.
@@ -444,7 +472,7 @@ func (aph *AdminPanelHandler) handlePaymentApproval(chatID int64, paymentIDStr,
 لطفاً با پشتیبانی تماس بگیرید تا مشکل بررسی شود.`
 
 		ownerMsg := tgbotapi.NewMessage(payment.Store.OwnerChatID, notificationText)
-		aph.bot.Send(ownerMsg)
+		aph.send(ownerMsg)
 	}
 
 	statusText := "تایید"
@@ -453,14 +481,14 @@ func (aph *AdminPanelHandler) handlePaymentApproval(chatID int64, paymentIDStr,
 	}
 
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ پرداخت با موفقیت %s شد.", statusText))
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
 
 func (aph *AdminPanelHandler) handleStoreActivation(chatID int64, storeIDStr string, activate bool) {
 	storeID, err := strconv.ParseUint(storeIDStr, 10, 32)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در شناسایی فروشگاه.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
@@ -468,14 +496,14 @@ func (aph *AdminPanelHandler) handleStoreActivation(chatID int64, storeIDStr str
 	err = aph.db.First(&store, storeID).Error
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاه یافت نشد.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
 	err = aph.db.Model(&store).Update("is_active", activate).Error
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در بروزرسانی وضعیت فروشگاه.")
-		aph.bot.Send(msg)
+		aph.send(msg)
 		return
 	}
 
@@ -498,8 +526,8 @@ func (aph *AdminPanelHandler) handleStoreActivation(chatID int64, storeIDStr str
 برای اطلاعات بیشتر با پشتیبانی تماس بگیرید.`, store.StoreName, statusText)
 
 	ownerMsg := tgbotapi.NewMessage(store.OwnerChatID, notificationText)
-	aph.bot.Send(ownerMsg)
+	aph.send(ownerMsg)
 
 	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ فروشگاه با موفقیت %s شد.", statusText))
-	aph.bot.Send(msg)
+	aph.send(msg)
 }
\ No newline at end of file
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"strings"
+	"telegram-store-hub/internal/messages"
 	"telegram-store-hub/internal/models"
 	"telegram-store-hub/internal/services"
 
@@ -17,15 +20,29 @@ type SellerPanelHandler struct {
 	storeManager *services.StoreManagerService
 	botManager   *services.BotManagerService
 	subscription *services.SubscriptionService
+	orders       *services.OrderService
+	idempotency  *services.IdempotencyService
 }
 
 func NewSellerPanelHandler(bot *tgbotapi.BotAPI, db *gorm.DB) *SellerPanelHandler {
+	orders := services.NewOrderService(db)
+	orders.SetNotifier(services.NewOrderNotifier(db))
+
 	return &SellerPanelHandler{
 		bot:          bot,
 		db:           db,
 		storeManager: services.NewStoreManagerService(db),
 		botManager:   services.NewBotManagerService(db),
 		subscription: services.NewSubscriptionService(db),
+		orders:       orders,
+		idempotency:  services.NewIdempotencyService(db),
+	}
+}
+
+// send sends a message, logging when it can't be sent
+func (sph *SellerPanelHandler) send(c tgbotapi.Chattable) {
+	if _, err := sph.bot.Send(c); err != nil {
+		log.Printf("Error sending message: %v", err)
 	}
 }
 
@@ -33,7 +50,7 @@ func (sph *SellerPanelHandler) HandleAddProduct(chatID int64) {
 	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ ابتدا باید فروشگاه خود را ثبت کنید.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -41,7 +58,7 @@ func (sph *SellerPanelHandler) HandleAddProduct(chatID int64) {
 	canAdd, err := sph.subscription.CanAddProduct(store.ID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در بررسی محدودیت محصولات.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -49,7 +66,7 @@ func (sph *SellerPanelHandler) HandleAddProduct(chatID int64) {
 		limits := sph.subscription.GetPlanLimits(store.PlanType)
 		msg := tgbotapi.NewMessage(chatID, 
 			fmt.Sprintf("❌ شما به حداکثر تعداد محصولات مجاز (%d) رسیده‌اید. برای افزودن محصول بیشتر، پلن خود را ارتقا دهید.", limits.MaxProducts))
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -65,7 +82,7 @@ func (sph *SellerPanelHandler) HandleAddProduct(chatID int64) {
 ⚠️ توجه: قیمت را به تومان وارد کنید.`
 
 	msg := tgbotapi.NewMessage(chatID, text)
-	sph.bot.Send(msg)
+	sph.send(msg)
 
 	// Set user state to adding product (in a real implementation, you'd store this in a session)
 }
@@ -74,14 +91,14 @@ func (sph *SellerPanelHandler) HandleProductList(chatID int64) {
 	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاهی یافت نشد.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	products, err := sph.storeManager.GetProducts(store.ID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در دریافت محصولات.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -100,7 +117,7 @@ func (sph *SellerPanelHandler) HandleProductList(chatID int64) {
 
 		msg := tgbotapi.NewMessage(chatID, text)
 		msg.ReplyMarkup = keyboard
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -130,27 +147,27 @@ func (sph *SellerPanelHandler) HandleProductList(chatID int64) {
 	if len(keyboard) > 0 {
 		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
 	}
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) HandleOrdersList(chatID int64) {
 	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاهی یافت نشد.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	orders, err := sph.storeManager.GetOrders(store.ID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در دریافت سفارش‌ها.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	if len(orders) == 0 {
 		msg := tgbotapi.NewMessage(chatID, "🛒 هیچ سفارشی یافت نشد.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -175,21 +192,21 @@ func (sph *SellerPanelHandler) HandleOrdersList(chatID int64) {
 	if len(keyboard) > 0 {
 		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
 	}
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) HandleSalesReport(chatID int64) {
 	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاهی یافت نشد.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	stats, err := sph.storeManager.GetStoreStats(store.ID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در دریافت گزارش.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -221,22 +238,22 @@ func (sph *SellerPanelHandler) HandleSalesReport(chatID int64) {
 	)
 
 	msg := tgbotapi.NewMessage(chatID, reportText)
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) HandleStoreSettings(chatID int64) {
 	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاهی یافت نشد.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	limits := sph.subscription.GetPlanLimits(store.PlanType)
 	botStatus := "❌ غیرفعال"
 	if store.BotToken != "" {
-		isRunning, _ := sph.botManager.GetBotStatus(store.ID)
-		if isRunning {
+		status, _ := sph.botManager.GetBotStatus(store.ID)
+		if status == services.BotStatusActive {
 			botStatus = "✅ فعال"
 		} else {
 			botStatus = "⏸ متوقف"
@@ -302,14 +319,14 @@ func (sph *SellerPanelHandler) HandleStoreSettings(chatID int64) {
 
 	msg := tgbotapi.NewMessage(chatID, settingsText)
 	msg.ReplyMarkup = keyboard
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) HandleRenewPlan(chatID int64) {
 	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاهی یافت نشد.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
@@ -358,7 +375,7 @@ func (sph *SellerPanelHandler) HandleRenewPlan(chatID int64) {
 
 	msg := tgbotapi.NewMessage(chatID, renewText)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) HandleCallback(callback *tgbotapi.CallbackQuery) {
@@ -398,45 +415,80 @@ func (sph *SellerPanelHandler) HandleCallback(callback *tgbotapi.CallbackQuery)
 
 func (sph *SellerPanelHandler) handleEditProduct(chatID int64, productIDStr string) {
 	msg := tgbotapi.NewMessage(chatID, "✏️ ویرایش محصول - این قابلیت به زودی اضافه می‌شود.")
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) handleDeleteProduct(chatID int64, productIDStr string) {
 	productID, err := strconv.ParseUint(productIDStr, 10, 32)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در شناسایی محصول.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
-	err = sph.storeManager.DeleteProduct(uint(productID))
+	err = sph.idempotency.Once(fmt.Sprintf("delete_product:%d", productID), func(tx *gorm.DB) error {
+		return services.NewProductService(tx).DeleteProduct(uint(productID))
+	})
+	if errors.Is(err, services.ErrActionDone) {
+		sph.send(tgbotapi.NewMessage(chatID, messages.InfoAlreadyDone))
+		return
+	}
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در حذف محصول.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	msg := tgbotapi.NewMessage(chatID, "✅ محصول با موفقیت حذف شد.")
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) handleConfirmOrder(chatID int64, orderIDStr string) {
 	orderID, err := strconv.ParseUint(orderIDStr, 10, 32)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در شناسایی سفارش.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
-	err = sph.storeManager.UpdateOrderStatus(uint(orderID), "confirmed")
+	// Confirming commits stock and delivers digital items: only the
+	// store's own orders may be confirmed
+	store, err := sph.storeManager.GetStoreByOwner(chatID)
 	if err != nil {
+		sph.send(tgbotapi.NewMessage(chatID, "❌ ابتدا باید فروشگاه خود را ثبت کنید."))
+		return
+	}
+	if _, err := sph.orders.GetStoreOrder(store.ID, uint(orderID)); err != nil {
+		sph.send(tgbotapi.NewMessage(chatID, messages.ErrorNoPermission))
+		return
+	}
+
+	// The order is locked while it changes, so a repeated tap fails as a
+	// transition from confirmed. The customer is told after the commit.
+	seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: chatID}
+	_, err = sph.orders.UpdateOrderStatus(uint(orderID), models.OrderStatusConfirmed, seller, "")
+	var transitionErr *services.InvalidTransitionError
+	var stockErr *services.OutOfStockError
+	switch {
+	case errors.As(err, &transitionErr) && transitionErr.From == models.OrderStatusConfirmed:
+		sph.send(tgbotapi.NewMessage(chatID, messages.InfoAlreadyDone))
+		return
+	case transitionErr != nil:
+		// Cancelled or moved on from another device or by the system
+		sph.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.OrderStatusOutdated, orderID, services.OrderStatusLabel(transitionErr.From))))
+		return
+	case errors.As(err, &stockErr):
+		sph.send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.OrderConfirmOutOfStock, stockErr.Product, stockErr.Available)))
+		return
+	case err != nil:
+		log.Printf("Error confirming order %d: %v", orderID, err)
 		msg := tgbotapi.NewMessage(chatID, "❌ خطا در تایید سفارش.")
-		sph.bot.Send(msg)
+		sph.send(msg)
 		return
 	}
 
 	msg := tgbotapi.NewMessage(chatID, "✅ سفارش تایید شد و به مشتری اطلاع داده شد.")
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
 func (sph *SellerPanelHandler) handlePlanUpgrade(chatID int64, planType models.PlanType) {
@@ -465,10 +517,10 @@ func (sph *SellerPanelHandler) handlePlanUpgrade(chatID int64, planType models.P
 
 	msg := tgbotapi.NewMessage(chatID, upgradeText)
 	msg.ReplyMarkup = keyboard
-	sph.bot.Send(msg)
+	sph.send(msg)
 }
 
-func (sph *SellerPanelHandler) getStatusEmoji(status string) string {
+func (sph *SellerPanelHandler) getStatusEmoji(status models.OrderStatus) string {
 	switch status {
 	case "pending":
 		return "⏳"
@@ -28,6 +28,7 @@ const (
 	ErrorNoPermission   = "❌ شما مجوز دسترسی به این بخش را ندارید."
 	ErrorInvalidCommand = "❌ دستور نامعتبر. لطفاً از منوی اصلی استفاده کنید."
 	ErrorDatabaseError  = "❌ خطا در اتصال به پایگاه داده. لطفاً بعداً تلاش کنید."
+	InfoAlreadyDone     = "ℹ️ این عملیات قبلاً انجام شده است."
 
 	// Success messages
 	SuccessStoreCreated = `🎉 تبریک! فروشگاه شما با موفقیت ثبت شد!
@@ -37,7 +38,7 @@ const (
 • تعداد محصولات مجاز: 10
 • مدت اعتبار: 1 ماه
 
-🤖 ربات فروشگاهی شما تا 24 ساعت آینده آماده خواهد شد.
+🤖 برای راه‌اندازی ربات فروشگاه، روی دکمه "اتصال ربات" بزنید و توکن ربات خود را از BotFather ارسال کنید.
 
 برای مدیریت فروشگاه از دکمه "پنل مدیریت" استفاده کنید.`
 
@@ -96,6 +97,10 @@ const (
 
 مبلغ قابل پرداخت: %s تومان`
 
+	// PaymentRejectedNote is kept in the notes of a payment an admin rejected
+	// from its button, which has no room for a reason
+	PaymentRejectedNote = "رد شده توسط ادمین"
+
 	// User states for conversation flow
 	StateWaitingStoreName        = "waiting_store_name"
 	StateWaitingStoreDescription = "waiting_store_description"
@@ -103,6 +108,15 @@ const (
 	StateWaitingProductName      = "waiting_product_name"
 	StateWaitingProductPrice     = "waiting_product_price"
 	StateWaitingProductImage     = "waiting_product_image"
+	StateWaitingBotToken         = "waiting_bot_token"
+	StateWaitingMessageTemplate  = "waiting_message_template"
+	StateWaitingTrackingCode     = "waiting_tracking_code"
+	StateWaitingCancelReason     = "waiting_cancel_reason"
+	StateWaitingOrderSearch      = "waiting_order_search"
+	StateWaitingCouponSpec       = "waiting_coupon_spec"
+	StateWaitingShippingSpec     = "waiting_shipping_spec"
+	StateWaitingVariantSpec      = "waiting_variant_spec"
+	StateWaitingLicenseKeys      = "waiting_license_keys"
 
 	// Button texts
 	ButtonRegisterStore    = "🏪 ثبت فروشگاه"
@@ -118,6 +132,489 @@ const (
 	ButtonVIPPlan          = "👑 پلن VIP"
 	ButtonPaymentComplete  = "✅ پرداخت کردم"
 	ButtonCancel           = "❌ انصراف"
+	ButtonConnectBot       = "🤖 اتصال ربات"
+	ButtonMessageTemplates = "✏️ پیام‌های مشتری"
+	ButtonCoupons          = "🎟 کدهای تخفیف"
+	ButtonShipping         = "🚚 روش‌های ارسال"
+	ButtonReturns          = "↩️ مرجوعی‌ها"
+	ButtonVariants         = "🎨 تنوع محصولات"
+	ButtonDigital          = "📥 محصولات دیجیتال"
+	ButtonGallery          = "🖼 گالری محصولات"
+
+	// Bot connection messages
+	BotTokenInstructions = `🤖 اتصال ربات فروشگاه
+
+۱. در @BotFather دستور /newbot را بفرستید و یک ربات جدید بسازید.
+۲. توکنی را که BotFather می‌دهد کپی کنید.
+۳. توکن را همین‌جا ارسال کنید.
+
+💡 برای اینکه مشتریان بتوانند محصولات را در هر چتی با نوشتن نام ربات جستجو و به دوستانشان معرفی کنند، در @BotFather حالت inline را با دستور /setinline برای ربات فعال کنید.
+
+🔐 پیام حاوی توکن پس از بررسی از گفتگو حذف می‌شود.`
+
+	BotTokenRotationNote = `
+
+♻️ ربات فعلی: @%s
+اگر توکن را در BotFather باطل کرده‌اید، توکن جدید را ارسال کنید. محصولات، سفارش‌ها و مشتریان فروشگاه حفظ می‌شوند.`
+
+	ErrorBotTokenMalformed = "❌ این متن شبیه توکن ربات نیست. توکن باید به شکل 123456789:ABC... باشد. دوباره ارسال کنید یا /cancel بزنید."
+	ErrorBotTokenRejected  = "❌ تلگرام این توکن را نپذیرفت. اگر توکن را باطل کرده‌اید، توکن جدید را از BotFather بگیرید."
+	ErrorBotTokenInUse     = "⚠️ این ربات قبلاً به فروشگاه دیگری متصل شده است. لطفاً برای این فروشگاه یک ربات جدید بسازید."
+	ErrorBotTokenCheck     = "❌ بررسی توکن با خطا مواجه شد. لطفاً چند دقیقه دیگر دوباره تلاش کنید."
+
+	SuccessBotConnected = `✅ ربات @%s به فروشگاه %s متصل شد!
+
+🔗 لینک ربات: https://t.me/%s
+
+اکنون مشتریان می‌توانند از طریق این ربات محصولات شما را مشاهده و خریداری کنند.`
+
+	// Bot health messages
+	BotUnhealthyOwnerAlert = `⚠️ ربات فروشگاه %s (@%s) با مشکل مواجه شده است:
+
+%s
+
+وضعیت ربات مرتب بررسی می‌شود و پس از رفع مشکل به شما اطلاع می‌دهیم.`
+
+	BotTokenRevokedHint = "🔑 به نظر می‌رسد توکن ربات در BotFather باطل شده است. از «مدیریت فروشگاه» توکن جدید را ثبت کنید."
+
+	BotUnhealthyAdminAlert = `🩺 ربات ناسالم
+
+🏪 فروشگاه: %s (ID: %d)
+🤖 ربات: @%s
+
+%s`
+
+	BotRecoveredOwnerNotice = "✅ مشکل ربات فروشگاه %s (@%s) برطرف شد و ربات دوباره به‌درستی کار می‌کند."
+
+	BotHealthAllHealthy = "✅ همه ربات‌های فروشگاه‌ها سالم هستند."
+
+	// Store bot catalog
+	CatalogTitle          = "🛍 دسته‌بندی محصولات فروشگاه %s:"
+	CatalogEmpty          = "❌ هیچ محصولی در حال حاضر موجود نیست."
+	CatalogPageTitle      = "🛍 %s\n\n"
+	CatalogLine           = "%d. %s — %s تومان\n"
+	CatalogPage           = "\nصفحه %d از %d"
+	CatalogUncategorized  = "سایر محصولات"
+	ButtonCatalogCategory = "%s (%d)"
+	ButtonCatalogProduct  = "🛒 %s"
+	ButtonCategories      = "🗂 دسته‌بندی‌ها"
+
+	// Store bot product search and inline sharing
+	SearchAsk                = "🔍 نام یا بخشی از توضیحات محصول مورد نظر را بنویسید:"
+	SearchResultsTitle       = "🔍 نتایج جستجوی «%s»:\n\n"
+	SearchNoResults          = "❌ محصولی برای «%s» پیدا نشد."
+	SearchMoreResults        = "\nو %d محصول دیگر؛ برای نتیجه دقیق‌تر عبارت کامل‌تری جستجو کنید."
+	SearchShareHint          = "\n\n💡 برای معرفی محصولات به دوستان، در هر چتی @%s و نام محصول را بنویسید."
+	InlineProductCaption     = "🛍 %s\n💰 %s تومان\n🏪 %s"
+	InlineProductDescription = "💰 %s تومان"
+	ButtonBuyProduct         = "🛒 خرید از فروشگاه"
+
+	// Store bot product card and cart messages
+	ProductCard = `📦 %s
+
+💰 قیمت: %s تومان
+📝 %s`
+
+	ProductCardInCart        = "\n\n🛒 در سبد خرید شما: %d عدد"
+	ProductCardStock         = "\n📦 موجودی: %d عدد"
+	ProductCardSoldOut       = "\n\n❌ این محصول فعلاً تمام شده است"
+	ProductCardVariant       = "\n🎨 %s"
+	ProductCardChooseVariant = "\n\n👇 لطفاً %s مورد نظر را انتخاب کنید:"
+	ButtonOtherVariants      = "🎨 سایر انتخاب‌ها"
+
+	CartEmpty = `🛒 سبد خرید شما خالی است.
+
+برای افزودن محصول به سبد خرید، از بخش محصولات استفاده کنید.`
+
+	CartTitle               = "🛒 سبد خرید شما\n\n"
+	CartItemLine            = "%d. %s\n   %d × %s = %s تومان\n"
+	CartUnavailableItemLine = "%d. %s\n   ❌ ناموجود، در جمع کل حساب نمی‌شود\n"
+	CartTotalLine           = "\n💰 جمع کل: %s تومان"
+
+	CartItemAdded          = "✅ به سبد خرید اضافه شد"
+	CartCleared            = "🗑 سبد خرید خالی شد"
+	CartProductUnavailable = "❌ این محصول در حال حاضر موجود نیست"
+	CartQuantityLimit      = "⚠️ بیش از این تعداد از این محصول موجود نیست"
+	CartChooseVariant      = "👇 لطفاً ابتدا یکی از انتخاب‌های محصول را انتخاب کنید"
+	CartError              = "❌ خطا در بروزرسانی سبد خرید"
+
+	// Store bot checkout messages
+	CheckoutAskShipping = "🚚 روش ارسال سفارش را انتخاب کنید:"
+	CheckoutAskCity     = "🏙 نام شهر یا استان خود را بنویسید:"
+	CheckoutAskAddress  = "📍 لطفاً آدرس کامل تحویل سفارش را بنویسید:"
+	CheckoutAskPhone    = "📱 شماره تماس خود را با دکمه زیر ارسال کنید یا آن را بنویسید:"
+	CheckoutAskNotes    = "📝 اگر توضیحی برای سفارش دارید بنویسید، در غیر این صورت «بدون توضیحات» را بزنید:"
+
+	CheckoutTextOnly       = "❌ لطفاً پاسخ را به صورت متن بفرستید."
+	CheckoutInvalidPhone   = "❌ شماره تماس معتبر نیست. لطفاً با دکمه زیر ارسال کنید یا به شکل 09123456789 بنویسید."
+	CheckoutForeignContact = "❌ لطفاً شماره تماس خودتان را با دکمه زیر ارسال کنید."
+
+	CheckoutReview = `🧾 بررسی سفارش
+
+%s
+📍 آدرس: %s
+📱 تلفن: %s
+📝 توضیحات: %s
+
+در صورت صحت اطلاعات، سفارش را تایید کنید.`
+
+	CheckoutCancelled  = "❌ ثبت سفارش لغو شد. سبد خرید شما حفظ شده است."
+	CheckoutCartEmpty  = "🛒 سبد خرید شما خالی است یا محصولات آن دیگر موجود نیستند."
+	CheckoutNotActive  = "ℹ️ این سفارش قبلاً ثبت یا لغو شده است."
+	CheckoutOutOfStock = "⚠️ از «%s» فقط %d عدد موجود است. لطفاً سبد خرید خود را اصلاح کنید."
+	CheckoutNoNotes    = "—"
+
+	CheckoutAskCoupon     = "🎟 کد تخفیف خود را ارسال کنید:"
+	CheckoutCouponApplied = "✅ کد تخفیف %s اعمال شد: %s تومان تخفیف"
+	CheckoutCouponDropped = "⚠️ کد تخفیف %s دیگر قابل استفاده نیست و از سفارش حذف شد.\n%s"
+	CheckoutDiscountLine  = "🎟 تخفیف (%s): %s- تومان\n"
+	CheckoutPayableLine   = "💳 مبلغ قابل پرداخت: %s تومان\n"
+	CouponNotFound        = "❌ این کد تخفیف معتبر نیست."
+	CouponInactive        = "❌ این کد تخفیف فعال نیست یا مهلت استفاده از آن تمام شده است."
+	CouponUsedUp          = "❌ ظرفیت استفاده از این کد تخفیف تمام شده است."
+	CouponCustomerLimit   = "❌ شما قبلاً از این کد تخفیف استفاده کرده‌اید."
+	CouponMinOrder        = "❌ این کد تخفیف برای خریدهای از %s تومان به بالا است."
+	CouponNotApplicable   = "❌ این کد تخفیف شامل محصولات سبد خرید شما نمی‌شود."
+
+	CheckoutShippingLine        = "🚚 %s: %s\n"
+	CheckoutShippingUnavailable = "⚠️ روش ارسال انتخاب‌شده دیگر در دسترس نیست. لطفاً دوباره انتخاب کنید."
+	CheckoutPickupAddress       = "تحویل حضوری"
+	ShippingFeeFree             = "رایگان"
+	ShippingFeeAmount           = "%s تومان"
+	ShippingFeeByRegion         = "بر اساس شهر"
+
+	OrderPlacedCustomer = `✅ سفارش شما با موفقیت ثبت شد!
+
+📋 شماره سفارش: #%d
+💰 مبلغ: %s تومان
+
+فروشگاه به‌زودی سفارش شما را بررسی می‌کند.
+🙏 از خرید شما متشکریم!`
+
+	OrderOwnerSummary = `🔔 سفارش جدید #%d
+
+🏪 فروشگاه: %s
+👤 مشتری: %s
+📱 تلفن: %s
+📍 آدرس: %s
+📝 توضیحات: %s
+
+%s
+💰 جمع کل: %s تومان
+💼 کارمزد پلتفرم: %s تومان`
+
+	OrderItemLine = "• %s × %d = %s تومان\n"
+
+	OrderDiscountLine = "\n🎟 کد تخفیف %s: %s تومان تخفیف"
+	OrderShippingLine = "\n🚚 ارسال با %s: %s"
+
+	// Order statuses as customers and sellers see them
+	OrderStatusPendingLabel   = "در انتظار تایید"
+	OrderStatusConfirmedLabel = "تایید شده"
+	OrderStatusShippedLabel   = "ارسال شده"
+	OrderStatusDeliveredLabel = "تحویل داده شده"
+	OrderStatusCancelledLabel = "لغو شده"
+	OrderStatusRefundedLabel  = "مسترد شده"
+	OrderStatusExpiredLabel   = "منقضی شده"
+
+	// Default order status notices sent to customers through the store bot.
+	// Stores can replace them; {order_id}, {status}, {store}, {customer},
+	// {total}, {tracking_code} and {reason} are filled in.
+	OrderConfirmedTemplate = "✅ سفارش #{order_id} شما در فروشگاه {store} تایید شد و در حال آماده‌سازی است."
+	OrderShippedTemplate   = "🚚 سفارش #{order_id} شما از فروشگاه {store} ارسال شد."
+	OrderDeliveredTemplate = "📦 سفارش #{order_id} شما تحویل داده شد. از خرید شما متشکریم!"
+	OrderCancelledTemplate = "❌ سفارش #{order_id} شما در فروشگاه {store} لغو شد."
+	OrderRefundedTemplate  = "💸 مبلغ سفارش #{order_id} شما ({total} تومان) بازگردانده شد."
+	OrderExpiredTemplate   = "⌛ سفارش #{order_id} شما چون به موقع تایید نشد لغو شد."
+
+	// Appended to a notice whose template doesn't place them itself
+	OrderNoticeTrackingLine = "\n📮 کد رهگیری: %s"
+	OrderNoticeReasonLine   = "\n📝 دلیل: %s"
+
+	// Store bot order cancellation and returns
+	CustomerOrderReturnLine   = "↩️ مرجوعی: %s\n"
+	CustomerCancelConfirm     = "❓ سفارش #%d لغو شود؟"
+	CustomerOrderCancelled    = "✅ سفارش #%d لغو شد."
+	CustomerCancelTooLate     = "ℹ️ سفارش #%d «%s» است و دیگر قابل لغو نیست. برای لغو با فروشگاه تماس بگیرید."
+	CustomerCancelOwnerNotice = "❌ مشتری سفارش #%d را لغو کرد."
+	ReturnAskReason           = "↩️ درخواست مرجوعی سفارش #%d\n\nلطفاً دلیل مرجوع کردن سفارش را بنویسید."
+	ReturnAskPhotos           = "📷 در صورت تمایل تا %d عکس از کالا بفرستید، سپس درخواست را ارسال کنید."
+	ReturnPhotoAdded          = "✅ عکس %d از %d دریافت شد."
+	ReturnPhotoLimit          = "⚠️ حداکثر %d عکس می‌توانید بفرستید."
+	ReturnReasonTooLong       = "❌ دلیل خیلی طولانی است. لطفاً کوتاه‌تر بنویسید."
+	ReturnTextOrPhoto         = "❌ لطفاً دلیل را به صورت متن یا عکس کالا را بفرستید."
+	ReturnNeedsReason         = "❌ پیش از ارسال درخواست، دلیل مرجوع کردن را بنویسید."
+	ReturnSubmitted           = "✅ درخواست مرجوعی سفارش #%d ثبت شد. نتیجه بررسی فروشگاه به شما اطلاع داده می‌شود."
+	ReturnDiscarded           = "درخواست مرجوعی لغو شد."
+	ReturnNotAllowed          = "ℹ️ فقط سفارش‌های تحویل‌شده قابل مرجوع کردن هستند."
+	ReturnExists              = "ℹ️ برای این سفارش قبلاً درخواست مرجوعی ثبت شده است."
+	ReturnOwnerNotice         = `↩️ درخواست مرجوعی جدید برای سفارش #%d
+
+👤 مشتری: %s
+📝 دلیل: %s
+📷 عکس‌ها: %d
+
+برای بررسی، دستور /returns را در ربات اصلی بفرستید.`
+	ReturnApprovedNote   = "درخواست مرجوعی شما تایید شد"
+	ReturnRejectedNotice = "❌ درخواست مرجوعی سفارش #%d شما در فروشگاه %s پذیرفته نشد."
+
+	ReturnStatusRequested = "در انتظار بررسی"
+	ReturnStatusApproved  = "تایید شده"
+	ReturnStatusRejected  = "رد شده"
+
+	ButtonCancelOrder   = "❌ لغو سفارش #%d"
+	ButtonReturnOrder   = "↩️ مرجوعی سفارش #%d"
+	ButtonConfirmCancel = "✅ بله، لغو شود"
+	ButtonKeepOrder     = "🔙 خیر"
+	ButtonSubmitReturn  = "📨 ارسال درخواست"
+
+	// Store bot delivery of digital products
+	DigitalFileCaption      = "📥 %s — سفارش #%d"
+	DigitalKeysMessage      = "🔑 %s — سفارش #%d\n\n%s"
+	DigitalNothingToDeliver = "ℹ️ این سفارش محصول دیجیتالی برای دریافت ندارد."
+	DigitalFileSaved        = "✅ فایل محصول «%s» ذخیره شد و پس از تایید هر سفارش خودکار برای مشتری ارسال می‌شود."
+	DigitalFileUsage        = "❌ فایل را با توضیح /file و شماره محصول بفرستید، مثلاً: /file 12"
+	DigitalProductNotFound  = "❌ محصولی با این شماره در فروشگاه شما نیست."
+	DigitalLowKeyPool       = "⚠️ کلیدهای لایسنس محصول «%s» رو به اتمام است: %d کلید باقی مانده. از بخش محصولات دیجیتال در پنل مدیریت کلید اضافه کنید."
+	ButtonRedeliverOrder    = "📥 دریافت مجدد سفارش #%d"
+
+	// Store bot uploads of product galleries
+	GalleryMediaAdded      = "✅ به گالری محصول #%d اضافه شد (%d از %d)."
+	GalleryFull            = "❌ گالری این محصول پر است؛ حداکثر %d عکس و ویدیو."
+	GalleryUsage           = "❌ عکس یا ویدیو را با توضیح /photo و شماره محصول بفرستید، مثلاً: /photo 12"
+	GalleryProductNotFound = "❌ محصولی با این شماره در فروشگاه شما نیست."
+
+	// Seller order management in the mother bot
+	OrderInboxTitle        = "🛒 سفارش‌های فروشگاه %s — %s\n\n"
+	OrderInboxEmpty        = "هیچ سفارشی یافت نشد."
+	OrderInboxLine         = "%s #%d — %s — %s تومان — %s\n"
+	OrderInboxPage         = "\nصفحه %d از %d"
+	OrderFilterAll         = "همه"
+	OrderSearchResults     = "🔍 نتایج جستجوی «%s»:\n\n"
+	OrderAskSearch         = "🔍 شماره سفارش (مثلاً 125) یا نام کاربری مشتری (مثلاً @ali) را ارسال کنید."
+	OrderAskTrackingCode   = "📮 کد رهگیری مرسوله سفارش #%d را ارسال کنید، یا بدون کد رهگیری ثبت کنید."
+	OrderAskCancelReason   = "📝 دلیل لغو سفارش #%d را بنویسید. این دلیل برای مشتری ارسال می‌شود."
+	OrderTextOnly          = "❌ لطفاً پاسخ را به صورت متن بفرستید."
+	OrderTextTooLong       = "❌ متن خیلی طولانی است. لطفاً کوتاه‌تر بنویسید."
+	OrderStatusOutdated    = "ℹ️ سفارش #%d اکنون «%s» است و این تغییر ممکن نیست."
+	OrderConfirmOutOfStock = "⚠️ موجودی «%s» برای تایید این سفارش کافی نیست (%d عدد موجود)."
+	OrderRejectedReason    = "سفارش توسط فروشگاه پذیرفته نشد"
+
+	OrderDetail = `%s سفارش #%d
+وضعیت: %s
+📅 تاریخ: %s
+
+👤 مشتری: %s
+📱 تلفن: %s
+📍 آدرس: %s
+📝 توضیحات: %s
+
+%s
+💰 جمع کل: %s تومان`
+	OrderDetailTracking = "\n📮 کد رهگیری: %s"
+
+	ButtonOrderAccept    = "✅ پذیرش"
+	ButtonOrderReject    = "❌ رد سفارش"
+	ButtonOrderShip      = "🚚 ارسال"
+	ButtonOrderDeliver   = "📦 تحویل شد"
+	ButtonOrderCancel    = "🚫 لغو سفارش"
+	ButtonOrderSearch    = "🔍 جستجو"
+	ButtonShipNoTracking = "بدون کد رهگیری"
+	ButtonBackToOrders   = "🔙 سفارش‌ها"
+
+	// Return requests in the mother bot
+	ReturnsTitle = "↩️ درخواست‌های مرجوعی فروشگاه %s\n\n"
+	ReturnsEmpty = "هنوز درخواست مرجوعی‌ای ثبت نشده است."
+	ReturnLine   = "%s #%d — سفارش #%d — %s — %s تومان\n"
+	ReturnDetail = `↩️ درخواست مرجوعی #%d — %s
+📋 سفارش #%d — %s تومان
+📅 تاریخ درخواست: %s
+👤 مشتری: %s
+
+%s
+📝 دلیل: %s
+📷 عکس‌ها: %d`
+	ReturnPhotosNote     = "\n(عکس‌ها در ربات فروشگاه برای شما ارسال شده‌اند)"
+	ReturnApproved       = "✅ مرجوعی تایید شد و کالاها به موجودی برگشتند. %s تومان باید به مشتری بازگردانده شود."
+	ReturnRejected       = "درخواست مرجوعی رد شد و به مشتری اطلاع داده شد."
+	ReturnAlreadyDecided = "ℹ️ این درخواست قبلاً بررسی شده است."
+
+	ButtonReturnApprove = "✅ تایید و بازپرداخت"
+	ButtonReturnReject  = "❌ رد درخواست"
+	ButtonBackToReturns = "🔙 مرجوعی‌ها"
+
+	// Store message template settings in the mother bot
+	MessageTemplatesIntro = `✏️ پیام‌های فروشگاه
+
+این پیام‌ها هنگام تغییر وضعیت سفارش از طریق ربات فروشگاه برای مشتری ارسال می‌شوند. در متن می‌توانید از این عبارت‌ها استفاده کنید:
+{order_id} شماره سفارش، {status} وضعیت، {store} نام فروشگاه، {customer} نام مشتری، {total} مبلغ، {tracking_code} کد رهگیری، {reason} دلیل لغو
+
+`
+	MessageTemplateLine    = "%s %s:\n%s\n\n"
+	MessageTemplateCustom  = "✏️"
+	MessageTemplateDefault = "▫️"
+	MessageTemplateAsk     = "متن جدید پیام «%s» را ارسال کنید.\n\nمتن فعلی:\n%s"
+	MessageTemplateSaved   = "✅ پیام «%s» ذخیره شد."
+	MessageTemplateReset   = "✅ پیام «%s» به متن پیش‌فرض برگشت."
+	MessageTemplateInvalid = "❌ متن پیام باید بین ۱ تا ۱۰۰۰ حرف باشد."
+
+	// Store discount codes in the mother bot
+	CouponsTitle  = "🎟 کدهای تخفیف فروشگاه %s\n\n"
+	CouponsEmpty  = "هنوز کد تخفیفی نساخته‌اید.\n"
+	CouponLine    = "%s %s — %s\n"
+	CouponAskSpec = `🎟 کد تخفیف جدید را در یک خط به این شکل بفرستید:
+
+کد مقدار [min=حداقل خرید] [uses=تعداد کل] [per=تعداد برای هر مشتری] [from=تاریخ شروع] [to=تاریخ پایان] [product=شناسه محصول] [category=دسته]
+
+مقدار درصدی مثل 20% یا مبلغی به تومان مثل 50000 است. تاریخ‌ها میلادی و به شکل 2026-10-31 هستند و دسته باید آخر بیاید.
+
+مثال:
+SUMMER20 20% min=200000 uses=100 per=1 to=2026-10-31`
+	CouponSpecInvalid = "❌ بخش «%s» قابل فهم نیست. لطفاً دوباره طبق راهنما بفرستید."
+	CouponExists      = "❌ کد %s قبلاً ساخته شده است. کد دیگری انتخاب کنید."
+	CouponCreated     = "✅ کد تخفیف %s ساخته شد."
+
+	CouponPercentValue    = "%d٪"
+	CouponFixedValue      = "%s تومان"
+	CouponMinOrderPart    = "حداقل خرید %s تومان"
+	CouponUsesPart        = "%d از %d استفاده"
+	CouponUsedPart        = "%d استفاده"
+	CouponPerCustomerPart = "%d بار برای هر مشتری"
+	CouponStartsPart      = "از %s"
+	CouponEndsPart        = "تا %s"
+	CouponProductPart     = "فقط محصول #%d"
+	CouponCategoryPart    = "فقط دسته %s"
+
+	ButtonNewCoupon      = "➕ کد تخفیف جدید"
+	ButtonCouponEnable   = "▶️ فعال‌سازی %s"
+	ButtonCouponDisable  = "⏸ غیرفعال‌سازی %s"
+	ButtonCheckoutCoupon = "🎟 کد تخفیف"
+	ButtonRemoveCoupon   = "حذف کد تخفیف"
+
+	// Digital products in the mother bot
+	DigitalTitle            = "📥 محصولات دیجیتال فروشگاه %s\n\nمحصولات دیجیتال پس از تایید سفارش خودکار توسط ربات فروشگاه تحویل داده می‌شوند.\n"
+	DigitalNoProducts       = "\nهنوز محصولی ثبت نکرده‌اید.\n"
+	DigitalProductTitle     = "📥 محصول «%s» (#%d)\n\n"
+	DigitalPhysical         = "این محصول فیزیکی است و به صورت دستی ارسال می‌شود."
+	DigitalFileReady        = "📎 پس از تایید سفارش، فایل محصول برای مشتری ارسال می‌شود."
+	DigitalKeysPool         = "🔑 پس از تایید سفارش، یک کلید لایسنس برای مشتری ارسال می‌شود.\nکلیدهای باقی‌مانده: %d"
+	DigitalKeysLow          = "\n⚠️ کلیدها رو به اتمام است؛ کلیدهای جدید اضافه کنید."
+	DigitalFileInstructions = `📎 فایل محصول «%s» را به صورت فایل به ربات فروشگاه @%s بفرستید و در توضیح آن بنویسید:
+
+/file %d
+
+فایل باید در خود ربات فروشگاه ارسال شود تا ربات بتواند آن را برای مشتریان بفرستد.`
+	DigitalNeedsBot     = "❌ ابتدا ربات فروشگاه را متصل کنید."
+	DigitalAskKeys      = "🔑 کلیدهای لایسنس محصول «%s» را بفرستید، هر کلید در یک خط. کلیدهای تکراری نادیده گرفته می‌شوند."
+	DigitalKeysAdded    = "✅ %d کلید اضافه شد. کلیدهای باقی‌مانده: %d"
+	DigitalKeysEmpty    = "❌ کلیدی پیدا نشد. هر کلید را در یک خط بفرستید."
+	DigitalMadePhysical = "📦 محصول «%s» دوباره فیزیکی شد."
+
+	ButtonPhysicalProduct = "📦 %s"
+	ButtonFileProduct     = "📎 %s"
+	ButtonLicenseProduct  = "🔑 %s (%d)"
+	ButtonDigitalFile     = "📎 تحویل فایل"
+	ButtonDigitalKeys     = "🔑 افزودن کلید لایسنس"
+	ButtonDigitalPhysical = "📦 محصول فیزیکی"
+	ButtonBackToDigital   = "🔙 محصولات دیجیتال"
+
+	// Product galleries in the mother bot
+	GalleryTitle        = "🖼 گالری محصولات فروشگاه %s\n\nعکس‌ها و ویدیوهای هر محصول در ربات فروشگاه به صورت آلبوم نمایش داده می‌شوند و اولین عکس، تصویر محصول در فهرست محصولات است.\n"
+	GalleryNoProducts   = "\nهنوز محصولی ثبت نکرده‌اید.\n"
+	GalleryProductTitle = "🖼 گالری محصول «%s» (#%d)\n\n%d از %d عکس و ویدیو\n\n"
+	GalleryInstructions = `عکس‌ها و ویدیوهای کوتاه محصول را به ربات فروشگاه @%s بفرستید و در توضیح آن‌ها بنویسید:
+
+/photo %d
+
+برای ارسال چند عکس با هم، نوشتن توضیح برای اولین عکس آلبوم کافی است.`
+	GalleryNeedsBot = "برای افزودن عکس، ابتدا ربات فروشگاه را متصل کنید."
+	GalleryCleared  = "🗑 گالری محصول «%s» پاک شد."
+
+	ButtonProductWithGallery = "🖼 %s"
+	ButtonClearGallery       = "🗑 پاک کردن گالری"
+	ButtonBackToGallery      = "🔙 گالری محصولات"
+
+	// Store shipping methods in the mother bot
+	ShippingTitle   = "🚚 روش‌های ارسال فروشگاه %s\n\n"
+	ShippingEmpty   = "هنوز روش ارسالی تعریف نکرده‌اید؛ مشتریان بدون انتخاب روش و هزینه ارسال سفارش می‌دهند.\n"
+	ShippingLine    = "%s %s (%s) — %s\n"
+	ShippingAskSpec = `🚚 روش ارسال جدید را به این شکل بفرستید:
+
+خط اول: نام روش ارسال
+خط دوم: kind=نوع rate=نرخ [fee=هزینه] [perkg=هزینه هر کیلو] [free=ارسال رایگان از]
+خطوط بعد (فقط برای rate=region): شهر یا استان=هزینه
+
+نوع: courier (پیک)، post (پست) یا pickup (تحویل حضوری)
+نرخ: flat (ثابت)، region (بر اساس شهر؛ fee هزینه سایر مناطق است) یا weight (بر اساس وزن؛ fee هزینه پایه است)
+
+مثال:
+پیک تهران
+kind=courier rate=region fee=60000 free=1000000
+تهران=40000
+کرج=50000`
+	ShippingSpecInvalid = "❌ بخش «%s» قابل فهم نیست. لطفاً دوباره طبق راهنما بفرستید."
+	ShippingCreated     = "✅ روش ارسال «%s» اضافه شد."
+	ShippingDeleted     = "🗑 روش ارسال «%s» حذف شد."
+
+	ShippingKindCourier  = "پیک"
+	ShippingKindPost     = "پست"
+	ShippingKindPickup   = "تحویل حضوری"
+	ShippingRegionRate   = "%s: %s"
+	ShippingOtherRegions = "سایر مناطق: %s"
+	ShippingWeightRate   = "%s + %s برای هر کیلوگرم"
+	ShippingFreeOver     = "رایگان برای خرید از %s تومان"
+
+	ButtonNewShipping     = "➕ روش ارسال جدید"
+	ButtonShippingEnable  = "▶️ %s"
+	ButtonShippingDisable = "⏸ %s"
+	ButtonShippingDelete  = "🗑 %s"
+
+	// Product variants in the mother bot
+	VariantsTitle        = "🎨 تنوع محصولات فروشگاه %s\n\nمحصولی را که می‌خواهید انتخاب‌هایش (مثل سایز و رنگ) را تعیین کنید انتخاب کنید:\n"
+	VariantsNoProducts   = "\nهنوز محصولی ثبت نکرده‌اید.\n"
+	VariantsProductTitle = "🎨 انتخاب‌های محصول «%s»\n\n"
+	VariantsNone         = "این محصول انتخابی ندارد و با یک قیمت و موجودی فروخته می‌شود.\n"
+	VariantLine          = "• %s\n"
+	VariantsCurrentSpec  = "\nبرای ویرایش، این متن را کپی کنید، تغییر دهید و بفرستید:\n\n%s"
+	VariantStockPart     = "موجودی %d"
+	VariantPricePart     = "%s تومان"
+	VariantsAskSpec      = `🎨 انتخاب‌های محصول «%s» را به این شکل بفرستید:
+
+خط اول: نام ویژگی‌ها، جدا شده با /
+خطوط بعد: یک انتخاب در هر خط، مقدار هر ویژگی جدا شده با / و در صورت نیاز [sku=کد کالا] [price=قیمت] [stock=موجودی]
+
+انتخاب بدون قیمت با قیمت خود محصول فروخته می‌شود. انتخاب‌هایی که در فهرست نباشند از فروش خارج می‌شوند.
+
+مثال:
+سایز/رنگ
+M/قرمز sku=TS-M-R stock=5
+L/قرمز price=130000 stock=2`
+	VariantSpecInvalid = "❌ بخش «%s» قابل فهم نیست. لطفاً دوباره طبق راهنما بفرستید."
+	VariantsSaved      = "✅ %d انتخاب برای محصول «%s» ذخیره شد."
+	VariantsCleared    = "🗑 انتخاب‌های محصول «%s» حذف شد."
+
+	ButtonProductWithVariants = "%s (%s)"
+	ButtonAddVariants         = "➕ تعریف انتخاب‌ها"
+	ButtonEditVariants        = "✏️ ویرایش انتخاب‌ها"
+	ButtonClearVariants       = "🗑 حذف انتخاب‌ها"
+	ButtonBackToVariants      = "🔙 محصولات"
+
+	// Store sales report in the mother bot
+	SalesReport = `📊 گزارش فروش فروشگاه "%s"
+
+💰 فروش کل: %s تومان
+💳 درآمد خالص: %s تومان
+📋 تعداد سفارشات: %d
+✅ سفارشات تکمیل شده: %d
+⏳ سفارشات در حال انجام: %d
+
+📈 فروش این ماه: %s تومان
+📅 فروش امروز: %s تومان
+
+💵 کارمزد پلن: %d%%
+🏦 کارمزد پرداختی: %s تومان`
+	SalesReportCoupons    = "\n\n🎟 کدهای تخفیف (مجموع تخفیف: %s تومان):\n"
+	SalesReportCouponLine = "%s %s: %d استفاده — %s تومان تخفیف — %s تومان فروش\n"
 
 	// Help and support messages
 	SupportMessage = `🆘 پشتیبانی
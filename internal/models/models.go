@@ -3,6 +3,9 @@ package models
 import (
         "time"
         "gorm.io/gorm"
+
+        // Registers the "secret" serializer that encrypts bot tokens at rest
+        _ "telegram-store-hub/internal/secrets"
 )
 
 // PlanType represents the subscription plan type
@@ -14,6 +17,75 @@ const (
         PlanVIP  PlanType = "vip"
 )
 
+// OrderStatus is a state in the order lifecycle
+type OrderStatus string
+
+const (
+        OrderStatusPending   OrderStatus = "pending"
+        OrderStatusConfirmed OrderStatus = "confirmed"
+        OrderStatusShipped   OrderStatus = "shipped"
+        OrderStatusDelivered OrderStatus = "delivered"
+        OrderStatusCancelled OrderStatus = "cancelled"
+        OrderStatusRefunded  OrderStatus = "refunded"
+        OrderStatusExpired   OrderStatus = "expired"
+)
+
+// Payment statuses of an order
+const (
+        PaymentStatusPending  = "pending"
+        PaymentStatusPaid     = "paid"
+        PaymentStatusFailed   = "failed"
+        PaymentStatusRefunded = "refunded"
+)
+
+// Who changed an order's status
+const (
+        OrderActorCustomer = "customer"
+        OrderActorSeller   = "seller"
+        OrderActorAdmin    = "admin"
+        OrderActorSystem   = "system"
+)
+
+// Coupon discount types
+const (
+        CouponPercent = "percent" // Value is a percentage of the eligible items
+        CouponFixed   = "fixed"   // Value is an amount off the eligible items
+)
+
+// Shipping method kinds
+const (
+        ShippingCourier = "courier"
+        ShippingPost    = "post"
+        ShippingPickup  = "pickup" // the customer picks the order up, no address
+)
+
+// Shipping rate types
+const (
+        ShippingRateFlat   = "flat"   // BaseFee for every order
+        ShippingRateRegion = "region" // the fee of the customer's city or province, else BaseFee
+        ShippingRateWeight = "weight" // BaseFee plus PerKgFee for each started kilogram
+)
+
+// Return request statuses
+const (
+        ReturnDraft     = "draft"     // the customer is still writing the reason and sending photos
+        ReturnRequested = "requested" // waiting for the seller
+        ReturnApproved  = "approved"
+        ReturnRejected  = "rejected"
+)
+
+// Kinds of digital product
+const (
+        DigitalFile    = "file"    // a document sent by the store bot
+        DigitalLicense = "license" // a key taken from the product's pool
+)
+
+// Kinds of product gallery media
+const (
+        MediaPhoto = "photo"
+        MediaVideo = "video"
+)
+
 // User represents a telegram user
 type User struct {
         ID        uint           `gorm:"primarykey" json:"id"`
@@ -43,8 +115,11 @@ type Store struct {
         
         Name        string `json:"name"`
         Description string `json:"description"`
-        BotToken    string `json:"bot_token"`
+        BotToken    string `gorm:"serializer:secret" json:"-"` // encrypted at rest, never serialized
+        BotID       int64  `gorm:"index" json:"bot_id"` // Telegram user ID of the store bot
         BotUsername string `json:"bot_username"`
+        BotStatus   string `json:"bot_status"` // "creating", "active", "inactive", "error"
+        BotProfileHash string `json:"-"` // profile last sent to Telegram (commands, descriptions)
         
         // Subscription details
         PlanType        PlanType  `json:"plan_type"` // "free", "pro", "vip"
@@ -78,6 +153,10 @@ type Product struct {
         ImageURL    string `json:"image_url"`
         IsAvailable bool   `gorm:"default:true" json:"is_available"`
         
+        // file_id of the first photo of the gallery in the store's bot, the
+        // product's picture in the catalog
+        ThumbnailFileID string `json:"thumbnail_file_id"`
+        
         // Product organization
         Category string `json:"category"`
         Tags     string `json:"tags"` // JSON array as string
@@ -86,8 +165,51 @@ type Product struct {
         Stock       int  `json:"stock"`
         TrackStock  bool `gorm:"default:false" json:"track_stock"`
         
+        // Shipping weight in grams, for weight-based shipping rates
+        Weight int `gorm:"default:0" json:"weight"`
+        
+        // Option names of the product's variants joined by "/", e.g.
+        // "Size/Color". A product with variants is sold by variant, and its
+        // Stock is the sum of theirs.
+        VariantAxes string `json:"variant_axes"`
+        
+        // Digital products are delivered by the store bot once the order is
+        // confirmed. DigitalType is empty for physical products.
+        DigitalType   string `json:"digital_type"`
+        DigitalFileID string `json:"digital_file_id"` // file_id of the document in the store's bot
+        
         // Relationships
-        OrderItems []OrderItem `gorm:"foreignKey:ProductID" json:"order_items,omitempty"`
+        OrderItems []OrderItem       `gorm:"foreignKey:ProductID" json:"order_items,omitempty"`
+        Variants   []ProductVariant `gorm:"foreignKey:ProductID" json:"variants,omitempty"`
+        Media      []ProductMedia   `gorm:"foreignKey:ProductID" json:"media,omitempty"`
+}
+
+// ProductMedia is a photo or short video of a product's gallery, by its
+// file ID in the store's bot. The gallery is shown in Position order.
+type ProductMedia struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        
+        ProductID uint   `gorm:"index" json:"product_id"`
+        Position  int    `json:"position"`
+        Type      string `json:"type"` // MediaPhoto or MediaVideo
+        FileID    string `json:"file_id"`
+}
+
+// ProductVariant is one combination of a product's options, such as a size
+// and a color, with its own SKU, stock and optionally price
+type ProductVariant struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        ProductID uint   `gorm:"uniqueIndex:idx_product_variants_product_options" json:"product_id"`
+        Options   string `gorm:"uniqueIndex:idx_product_variants_product_options" json:"options"` // its value on each axis joined by "/", e.g. "M/Red"
+        
+        SKU         string `json:"sku"`
+        Price       int64  `json:"price"` // 0 for the product's price
+        Stock       int    `json:"stock"` // used when the product tracks its stock
+        IsAvailable bool   `gorm:"default:true" json:"is_available"`
 }
 
 // Order represents a customer order
@@ -105,22 +227,52 @@ type Order struct {
         CustomerName       string `json:"customer_name"`
         CustomerUsername   string `json:"customer_username"`
         
-        // Order details
-        TotalAmount    int64  `json:"total_amount"`
-        Status         string `json:"status"` // "pending", "confirmed", "shipped", "delivered", "cancelled"
-        PaymentMethod  string `json:"payment_method"`
-        PaymentStatus  string `json:"payment_status"` // "pending", "paid", "failed"
+        // Order details; TotalAmount is the items after discount plus shipping
+        TotalAmount    int64       `json:"total_amount"`
+        Status         OrderStatus `json:"status"`
+        PaymentMethod  string      `json:"payment_method"`
+        PaymentStatus  string      `json:"payment_status"` // "pending", "paid", "failed", "refunded"
         
         // Delivery info
         DeliveryAddress string `json:"delivery_address"`
         DeliveryPhone   string `json:"delivery_phone"`
         DeliveryNotes   string `json:"delivery_notes"`
+        TrackingCode    string `json:"tracking_code"`
+        DeliveryCity    string `json:"delivery_city"`
+        
+        // Shipping method chosen at checkout and its fee
+        ShippingMethodID *uint  `json:"shipping_method_id,omitempty"`
+        ShippingMethod   string `json:"shipping_method"` // name at the time of the order
+        ShippingFee      int64  `json:"shipping_fee"`
+        
+        // Discount code used at checkout; TotalAmount is after the discount
+        CouponCode     string `json:"coupon_code"`
+        DiscountAmount int64  `json:"discount_amount"`
         
         // Commission
         CommissionAmount int64 `json:"commission_amount"`
         
+        // Whether the ordered units were taken off the stock
+        StockCommitted bool `gorm:"default:false" json:"stock_committed"`
+        
         // Relationships
-        OrderItems []OrderItem `gorm:"foreignKey:OrderID" json:"order_items,omitempty"`
+        OrderItems   []OrderItem        `gorm:"foreignKey:OrderID" json:"order_items,omitempty"`
+        StatusEvents []OrderStatusEvent `gorm:"foreignKey:OrderID" json:"status_events,omitempty"`
+}
+
+// OrderStatusEvent records one change of an order's status: who made it,
+// when, and the note that came with it, such as a cancel reason
+type OrderStatusEvent struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        
+        OrderID    uint        `gorm:"index" json:"order_id"`
+        FromStatus OrderStatus `json:"from_status"`
+        ToStatus   OrderStatus `json:"to_status"`
+        
+        ActorType       string `json:"actor_type"` // "customer", "seller", "admin", "system"
+        ActorTelegramID int64  `json:"actor_telegram_id"` // 0 for the system
+        Note            string `gorm:"type:text" json:"note"`
 }
 
 // OrderItem represents individual items in an order
@@ -135,9 +287,212 @@ type OrderItem struct {
         ProductID uint    `json:"product_id"`
         Product   Product `gorm:"foreignKey:ProductID" json:"product"`
         
+        // Variant ordered, with its options and SKU at the time of the order
+        VariantID   *uint  `json:"variant_id,omitempty"`
+        VariantName string `json:"variant_name"`
+        SKU         string `json:"sku"`
+        
         Quantity  int   `json:"quantity"`
         UnitPrice int64 `json:"unit_price"`
         SubTotal  int64 `json:"sub_total"`
+        
+        DeliveredAt *time.Time `json:"delivered_at,omitempty"` // when a digital item was sent to the customer
+}
+
+// LicenseKey is one key in the pool of a digital product. It belongs to
+// the order item it was delivered with, and stays with it for
+// re-delivery.
+type LicenseKey struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        
+        ProductID   uint   `gorm:"uniqueIndex:idx_license_keys_product_key" json:"product_id"`
+        Key         string `gorm:"uniqueIndex:idx_license_keys_product_key" json:"key"`
+        OrderItemID *uint  `gorm:"index" json:"order_item_id,omitempty"` // nil while the key is in the pool
+}
+
+// Cart is a customer's shopping cart in a store bot. There is one cart per
+// store and customer, kept until the customer checks out or empties it.
+type Cart struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        StoreID            uint  `gorm:"uniqueIndex:idx_carts_store_customer" json:"store_id"`
+        Store              Store `gorm:"foreignKey:StoreID" json:"store"`
+        CustomerTelegramID int64 `gorm:"uniqueIndex:idx_carts_store_customer" json:"customer_telegram_id"`
+        
+        // Checkout in progress: the step waiting for the customer's answer
+        // and the delivery details entered so far
+        CheckoutStep     string `json:"checkout_step"` // "", "shipping", "city", "address", "phone", "notes", "confirm", "coupon"
+        ShippingMethodID *uint  `json:"shipping_method_id,omitempty"`
+        DeliveryCity     string `json:"delivery_city"`
+        DeliveryAddress  string `json:"delivery_address"`
+        DeliveryPhone    string `json:"delivery_phone"`
+        DeliveryNotes    string `json:"delivery_notes"`
+        CouponCode       string `json:"coupon_code"`
+        
+        // Relationships
+        Items []CartItem `gorm:"foreignKey:CartID" json:"items,omitempty"`
+}
+
+// CartItem is a product, or a variant of one, in a cart. A cart holds each
+// product and variant once (see idx_cart_items_cart_product_variant).
+type CartItem struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        CartID    uint            `gorm:"index" json:"cart_id"`
+        ProductID uint            `json:"product_id"`
+        Product   Product         `gorm:"foreignKey:ProductID" json:"product"`
+        VariantID *uint           `json:"variant_id,omitempty"`
+        Variant   *ProductVariant `gorm:"foreignKey:VariantID" json:"variant,omitempty"`
+        
+        Quantity int `json:"quantity"`
+}
+
+// StockReservation holds units of a product for an order from checkout until
+// the order is confirmed or paid, when they are taken off the stock, or until
+// the order is cancelled or the reservation expires
+type StockReservation struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        
+        OrderID   uint      `gorm:"index" json:"order_id"`
+        ProductID uint      `gorm:"index" json:"product_id"`
+        VariantID *uint     `gorm:"index" json:"variant_id,omitempty"`
+        Quantity  int       `json:"quantity"`
+        ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+}
+
+// MessageTemplate is a store's own wording of a message its bot sends, such
+// as the notice of an order status change
+type MessageTemplate struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        StoreID uint   `gorm:"uniqueIndex:idx_message_templates_store_key" json:"store_id"`
+        Key     string `gorm:"uniqueIndex:idx_message_templates_store_key" json:"key"` // e.g. "order_shipped"
+        Text    string `gorm:"type:text" json:"text"`
+}
+
+// Coupon is a discount code of a store. A coupon limited to a product or a
+// category only discounts the items of that product or category.
+type Coupon struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        StoreID uint   `gorm:"uniqueIndex:idx_coupons_store_code" json:"store_id"`
+        Code    string `gorm:"uniqueIndex:idx_coupons_store_code" json:"code"` // upper case
+        
+        Type     string `json:"type"`  // "percent", "fixed"
+        Value    int64  `json:"value"` // percent, or amount in toman
+        MinOrder int64  `json:"min_order"`
+        
+        // Usage limits, 0 for unlimited
+        MaxUses            int `json:"max_uses"`
+        MaxUsesPerCustomer int `json:"max_uses_per_customer"`
+        UsedCount          int `gorm:"default:0" json:"used_count"`
+        
+        StartsAt *time.Time `json:"starts_at,omitempty"`
+        EndsAt   *time.Time `json:"ends_at,omitempty"`
+        
+        // Optional scope
+        ProductID *uint  `json:"product_id,omitempty"`
+        Category  string `json:"category"`
+        
+        IsActive bool `gorm:"default:true" json:"is_active"`
+}
+
+// CouponRedemption is the use of a coupon by an order. It is removed when
+// the order is cancelled or expires, which gives the use back.
+type CouponRedemption struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        
+        CouponID           uint  `gorm:"index" json:"coupon_id"`
+        OrderID            uint  `gorm:"uniqueIndex" json:"order_id"`
+        CustomerTelegramID int64 `gorm:"index" json:"customer_telegram_id"`
+        Discount           int64 `json:"discount"`
+}
+
+// ShippingMethod is a way a store delivers orders, with the rule its fee is
+// computed by
+type ShippingMethod struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        StoreID uint   `gorm:"index" json:"store_id"`
+        Name    string `json:"name"`
+        Kind    string `json:"kind"` // "courier", "post", "pickup"
+        
+        RateType string `json:"rate_type"` // "flat", "region", "weight"
+        BaseFee  int64  `json:"base_fee"`
+        PerKgFee int64  `json:"per_kg_fee"`
+        FreeOver int64  `json:"free_over"` // free for item subtotals of at least this, 0 for never
+        
+        IsActive bool `gorm:"default:true" json:"is_active"`
+        
+        // Relationships
+        Rates []ShippingRate `gorm:"foreignKey:ShippingMethodID" json:"rates,omitempty"`
+}
+
+// ShippingRate is the fee of a region-based shipping method for a city or
+// province
+type ShippingRate struct {
+        ID uint `gorm:"primarykey" json:"id"`
+        
+        ShippingMethodID uint   `gorm:"index" json:"shipping_method_id"`
+        Region           string `json:"region"`
+        Fee              int64  `json:"fee"`
+}
+
+// ReturnRequest is a customer's request to send back a delivered order.
+// An approved return refunds the order and puts its items back in stock.
+type ReturnRequest struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        StoreID            uint  `gorm:"index" json:"store_id"`
+        OrderID            uint  `gorm:"uniqueIndex" json:"order_id"`
+        Order              Order `gorm:"foreignKey:OrderID" json:"order"`
+        CustomerTelegramID int64 `gorm:"index" json:"customer_telegram_id"`
+        
+        Reason     string     `gorm:"type:text" json:"reason"`
+        Status     string     `gorm:"default:draft" json:"status"` // "draft", "requested", "approved", "rejected"
+        ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+        
+        // Relationships
+        Photos []ReturnPhoto `gorm:"foreignKey:ReturnRequestID" json:"photos,omitempty"`
+}
+
+// ReturnPhoto is a photo the customer sent with a return request, by its
+// file ID in the store bot
+type ReturnPhoto struct {
+        ID uint `gorm:"primarykey" json:"id"`
+        
+        ReturnRequestID uint   `gorm:"index" json:"return_request_id"`
+        FileID          string `json:"file_id"`
+}
+
+// Refund is the money a store owes a customer for an approved return
+type Refund struct {
+        ID        uint      `gorm:"primarykey" json:"id"`
+        CreatedAt time.Time `json:"created_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+        
+        StoreID            uint  `gorm:"index" json:"store_id"`
+        OrderID            uint  `gorm:"uniqueIndex" json:"order_id"`
+        ReturnRequestID    uint  `gorm:"index" json:"return_request_id"`
+        CustomerTelegramID int64 `json:"customer_telegram_id"`
+        
+        Amount int64  `json:"amount"`
+        Status string `gorm:"default:pending" json:"status"` // "pending" until the store pays it back, then "paid"
 }
 
 // Payment represents payment records
@@ -169,4 +524,43 @@ type UserSession struct {
         State      string            `json:"state"`
         Data       string            `json:"data"` // JSON data for current operation
         UpdatedAt  time.Time         `json:"updated_at"`
-}
\ No newline at end of file
+}
+
+// BotUpdateOffset records the last update a bot has finished handling, keyed
+// by the bot's Telegram ID so it survives token rotation
+type BotUpdateOffset struct {
+        BotID        int64     `gorm:"primarykey;autoIncrement:false" json:"bot_id"`
+        LastUpdateID int       `json:"last_update_id"`
+        UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ProcessedAction marks a state-changing action, such as approving a payment,
+// as done so that repeating it has no effect
+type ProcessedAction struct {
+        Key       string    `gorm:"primarykey;size:191" json:"key"`
+        CreatedAt time.Time `json:"created_at"`
+}
+
+// BotHealthCheck is the latest health probe of a store bot
+type BotHealthCheck struct {
+        StoreID        uint       `gorm:"primarykey;autoIncrement:false" json:"store_id"`
+        Store          Store      `gorm:"foreignKey:StoreID" json:"store,omitempty"`
+        Healthy        bool       `gorm:"index" json:"healthy"`
+        Problems       string     `gorm:"type:text" json:"problems"` // one problem per line
+        PendingUpdates int        `json:"pending_updates"`
+        ErrorRate      float64    `json:"error_rate"`
+        LastUpdateAt   *time.Time `json:"last_update_at"`
+        CheckedAt      time.Time  `json:"checked_at"`
+        UnhealthySince *time.Time `json:"unhealthy_since"`
+        AlertedAt      *time.Time `json:"alerted_at"` // owner and admin were alerted, cleared on recovery
+}
+
+// Lease is a named lock held by one instance until it expires. Instances
+// renew their leases with a heartbeat, so the leases of a dead instance
+// expire and are taken over by the others.
+type Lease struct {
+        Name      string    `gorm:"primarykey;size:191" json:"name"`
+        Holder    string    `gorm:"size:191;index" json:"holder"`
+        ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+        UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,247 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Encrypted values look like v1:<key id>:<wrapped data key>:<ciphertext>.
+// Every value has its own random data key, sealed with the master key, so
+// only the master key has to be kept secret and rotated.
+const (
+	envelopeVersion = "v1"
+	keySize         = 32 // AES-256
+)
+
+var (
+	// ErrNoKeyring is returned when a secret is read or written before SetKeyring
+	ErrNoKeyring = errors.New("no encryption keyring configured")
+	// ErrUnknownKey is returned for values sealed with a master key that isn't loaded
+	ErrUnknownKey = errors.New("value was encrypted with an unknown master key")
+	// ErrMalformed is returned for values that look encrypted but can't be parsed
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+// Keyring encrypts with its primary master key and decrypts with any of its keys
+type Keyring struct {
+	primary *masterKey
+	keys    map[string]*masterKey
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKeyring creates a keyring that encrypts with primary. Previous keys are
+// only used to decrypt, so values can be read while they are being rotated.
+func NewKeyring(primary []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]*masterKey)}
+
+	for i, raw := range append([][]byte{primary}, previous...) {
+		key, err := newMasterKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			k.primary = key
+		}
+		if _, ok := k.keys[key.id]; !ok {
+			k.keys[key.id] = key
+		}
+	}
+
+	return k, nil
+}
+
+// LoadKeyring creates a keyring from base64 encoded master keys
+func LoadKeyring(primary string, previous []string) (*Keyring, error) {
+	primaryKey, err := ParseKey(primary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+
+	var previousKeys [][]byte
+	for i, encoded := range previous {
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous master key %d: %w", i+1, err)
+		}
+		previousKeys = append(previousKeys, key)
+	}
+
+	return NewKeyring(primaryKey, previousKeys...)
+}
+
+// ParseKey decodes a base64 master key, as printed by `openssl rand -base64 32`
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+func newMasterKey(raw []byte) (*masterKey, error) {
+	if len(raw) != keySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", keySize, len(raw))
+	}
+
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(raw)
+	return &masterKey{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// PrimaryKeyID identifies the key new values are encrypted with
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary.id
+}
+
+// Encrypt seals plaintext under a fresh data key. An empty string stays
+// empty so "no token" remains queryable.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	// The key ID is authenticated with the wrapped data key so an envelope
+	// can't be relabelled to another master key
+	wrapped, err := seal(k.primary.aead, dataKey, []byte(k.primary.id))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		envelopeVersion,
+		k.primary.id,
+		base64.RawURLEncoding.EncodeToString(wrapped),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+	}, ":"), nil
+}
+
+// Decrypt opens a value produced by Encrypt. Values that were stored before
+// encryption was introduced are returned unchanged.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return "", ErrMalformed
+	}
+
+	key, ok := k.keys[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("%w (key id %s)", ErrUnknownKey, parts[1])
+	}
+
+	wrapped, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(key.aead, wrapped, []byte(key.id))
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// NeedsRotation reports whether a stored value is plaintext or encrypted
+// with a key other than the primary one
+func (k *Keyring) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	if !IsEncrypted(value) {
+		return true
+	}
+	parts := strings.SplitN(value, ":", 3)
+	return len(parts) < 3 || parts[1] != k.primary.id
+}
+
+// IsEncrypted reports whether value is an envelope produced by Encrypt.
+// Telegram bot tokens start with the bot's numeric ID, so they never match.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, envelopeVersion+":")
+}
+
+// seal encrypts with a random nonce prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// current is the keyring used by the gorm serializer
+var current atomic.Pointer[Keyring]
+
+// SetKeyring sets the keyring used to encrypt and decrypt model fields
+func SetKeyring(k *Keyring) {
+	current.Store(k)
+}
+
+// CurrentKeyring returns the keyring set by SetKeyring, or nil
+func CurrentKeyring() *Keyring {
+	return current.Load()
+}
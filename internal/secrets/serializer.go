@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/schema"
+)
+
+// SerializerName is the gorm serializer that encrypts a string field at rest:
+//
+//	BotToken string `gorm:"serializer:secret"`
+const SerializerName = "secret"
+
+func init() {
+	schema.RegisterSerializer(SerializerName, Serializer{})
+}
+
+// Serializer encrypts string fields on save and decrypts them on load with
+// the keyring set by SetKeyring
+type Serializer struct{}
+
+// Scan implements schema.SerializerInterface
+func (Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("failed to scan %s: unexpected type %T", field.Name, dbValue)
+	}
+
+	plaintext, err := decrypt(stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt %s: %w", field.Name, err)
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(plaintext)
+	return nil
+}
+
+// Value implements schema.SerializerValuerInterface
+func (Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	plaintext, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a string to be encrypted", field.Name)
+	}
+	return encrypt(plaintext)
+}
+
+// Sealed encrypts a value passed to Updates with a map, which doesn't go
+// through the field's serializer:
+//
+//	db.Model(&store).Updates(map[string]interface{}{"bot_token": secrets.Sealed(token)})
+func Sealed(plaintext string) driver.Valuer {
+	return sealedValue(plaintext)
+}
+
+type sealedValue string
+
+func (v sealedValue) Value() (driver.Value, error) {
+	return encrypt(string(v))
+}
+
+func encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	keyring := CurrentKeyring()
+	if keyring == nil {
+		return "", ErrNoKeyring
+	}
+	return keyring.Encrypt(plaintext)
+}
+
+func decrypt(stored string) (string, error) {
+	if !IsEncrypted(stored) {
+		return stored, nil
+	}
+	keyring := CurrentKeyring()
+	if keyring == nil {
+		return "", ErrNoKeyring
+	}
+	return keyring.Decrypt(stored)
+}
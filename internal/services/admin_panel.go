@@ -54,11 +54,18 @@ func NewAdminPanelService(
 	}
 }
 
+// send sends a message, logging when it can't be sent
+func (a *AdminPanelService) send(c tgbotapi.Chattable) {
+	if _, err := a.bot.Send(c); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
+
 // ShowAdminPanel displays the main admin panel
 func (a *AdminPanelService) ShowAdminPanel(chatID int64) {
 	if !a.isAdmin(chatID) {
 		msg := tgbotapi.NewMessage(chatID, "❌ شما دسترسی ادمین ندارید.")
-		a.bot.Send(msg)
+		a.send(msg)
 		return
 	}
 
@@ -67,7 +74,7 @@ func (a *AdminPanelService) ShowAdminPanel(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting system stats: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		a.bot.Send(msg)
+		a.send(msg)
 		return
 	}
 
@@ -122,7 +129,7 @@ func (a *AdminPanelService) ShowAdminPanel(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	a.bot.Send(msg)
+	a.send(msg)
 }
 
 // ShowUserManagement displays user management panel
@@ -137,7 +144,7 @@ func (a *AdminPanelService) ShowUserManagement(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting recent users: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		a.bot.Send(msg)
+		a.send(msg)
 		return
 	}
 
@@ -176,7 +183,7 @@ func (a *AdminPanelService) ShowUserManagement(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	a.bot.Send(msg)
+	a.send(msg)
 }
 
 // ShowStoreManagement displays store management panel
@@ -191,7 +198,7 @@ func (a *AdminPanelService) ShowStoreManagement(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting recent stores: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		a.bot.Send(msg)
+		a.send(msg)
 		return
 	}
 
@@ -240,7 +247,7 @@ func (a *AdminPanelService) ShowStoreManagement(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	a.bot.Send(msg)
+	a.send(msg)
 }
 
 // ShowDetailedReports displays detailed system reports
@@ -254,7 +261,7 @@ func (a *AdminPanelService) ShowDetailedReports(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting detailed stats: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		a.bot.Send(msg)
+		a.send(msg)
 		return
 	}
 
@@ -309,7 +316,7 @@ func (a *AdminPanelService) ShowDetailedReports(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	a.bot.Send(msg)
+	a.send(msg)
 }
 
 // ShowSystemSettings displays system settings
@@ -355,7 +362,7 @@ func (a *AdminPanelService) ShowSystemSettings(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	a.bot.Send(msg)
+	a.send(msg)
 }
 
 // HandleBroadcastMessage handles broadcast message sending
@@ -373,7 +380,7 @@ func (a *AdminPanelService) HandleBroadcastMessage(chatID int64) {
 	// Set user state for broadcast message
 	// Note: You'll need to implement this in session service
 	msg := tgbotapi.NewMessage(chatID, text)
-	a.bot.Send(msg)
+	a.send(msg)
 }
 
 // PerformSystemCleanup performs system cleanup operations
@@ -383,7 +390,7 @@ func (a *AdminPanelService) PerformSystemCleanup(chatID int64) {
 	}
 
 	msg := tgbotapi.NewMessage(chatID, "🧹 در حال انجام نظافت سیستم...")
-	a.bot.Send(msg)
+	a.send(msg)
 
 	// Perform cleanup operations
 	cleanupResults := make(map[string]int)
@@ -421,7 +428,7 @@ func (a *AdminPanelService) PerformSystemCleanup(chatID int64) {
 
 	resultMsg := tgbotapi.NewMessage(chatID, resultText)
 	resultMsg.ReplyMarkup = keyboard
-	a.bot.Send(resultMsg)
+	a.send(resultMsg)
 }
 
 // Helper methods
@@ -518,7 +525,7 @@ func (a *AdminPanelService) getDetailedStats() (map[string]int64, error) {
 	a.db.Model(&models.Order{}).Where("status = ?", models.OrderStatusPending).Count(&stats["pending_orders"])
 
 	// Completed orders
-	a.db.Model(&models.Order{}).Where("status = ?", models.OrderStatusCompleted).Count(&stats["completed_orders"])
+	a.db.Model(&models.Order{}).Where("status = ?", models.OrderStatusDelivered).Count(&stats["completed_orders"])
 
 	// Total orders for completion rate
 	a.db.Model(&models.Order{}).Count(&stats["total_orders"])
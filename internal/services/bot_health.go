@@ -0,0 +1,302 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// Health monitoring tuning
+const (
+	botHealthCheckEvery    = 5 * time.Minute
+	botHealthTimeout       = 15 * time.Second
+	botStuckAfter          = 10 * time.Minute // updates pending but none handled for this long
+	botPendingUpdatesLimit = 500
+	botWebhookErrorWindow  = 15 * time.Minute // webhook errors older than this are history
+	botErrorRateLimit      = 0.5
+	botErrorRateMinCalls   = 20 // fewer calls than this say nothing about the error rate
+	botRealertAfter        = 24 * time.Hour
+)
+
+// BotHealth is the result of probing a store bot
+type BotHealth struct {
+	StoreID        uint      `json:"store_id"`
+	Healthy        bool      `json:"healthy"`
+	Problems       []string  `json:"problems,omitempty"`
+	PendingUpdates int       `json:"pending_updates"`
+	ErrorRate      float64   `json:"error_rate"`
+	LastUpdate     time.Time `json:"last_update"`
+	TokenRevoked   bool      `json:"token_revoked"`
+}
+
+// SetAdminChatID sets the chat that is alerted about failing store bots
+func (b *BotManagerService) SetAdminChatID(chatID int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.adminChatID = chatID
+}
+
+// CheckBotHealth probes a store bot: the token must still be accepted,
+// Telegram must be able to deliver updates, and when this process runs the
+// bot its loop must keep up and its API calls must mostly succeed
+func (b *BotManagerService) CheckBotHealth(store *models.Store) BotHealth {
+	health := BotHealth{StoreID: store.ID}
+	api := directBotAPI(store.BotToken, botHealthTimeout)
+
+	if _, err := api.GetMe(); err != nil {
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+			health.TokenRevoked = true
+			health.Problems = append(health.Problems, "توکن ربات توسط تلگرام پذیرفته نمی‌شود")
+		} else {
+			health.Problems = append(health.Problems, fmt.Sprintf("ربات به getMe پاسخ نمی‌دهد: %v", err))
+		}
+	}
+
+	// A revoked token fails every other call the same way
+	if !health.TokenRevoked {
+		if info, err := api.GetWebhookInfo(); err != nil {
+			health.Problems = append(health.Problems, fmt.Sprintf("وضعیت تحویل پیام‌ها دریافت نشد: %v", err))
+		} else {
+			health.PendingUpdates = info.PendingUpdateCount
+			if info.LastErrorDate > 0 && time.Since(time.Unix(int64(info.LastErrorDate), 0)) < botWebhookErrorWindow {
+				health.Problems = append(health.Problems, fmt.Sprintf("خطای وبهوک: %s", info.LastErrorMessage))
+			}
+		}
+	}
+
+	if runtime, ok := b.GetBotRuntime(store.ID); ok {
+		health.LastUpdate = runtime.LastUpdate
+
+		switch runtime.State {
+		case BotRunStateErroring:
+			health.Problems = append(health.Problems, fmt.Sprintf("دریافت پیام‌ها متوقف شده است: %s", runtime.LastError))
+		case BotRunStateRunning:
+			idleSince := runtime.LastUpdate
+			if idleSince.Before(runtime.StartedAt) {
+				idleSince = runtime.StartedAt
+			}
+			if idle := time.Since(idleSince); health.PendingUpdates > 0 && idle > botStuckAfter {
+				health.Problems = append(health.Problems, stuckProblem(health.PendingUpdates, idle))
+			}
+		}
+	} else if store.BotID != 0 {
+		// Another instance runs the bot; it saves its offset as it handles updates
+		var offset models.BotUpdateOffset
+		if err := b.db.Where("bot_id = ?", store.BotID).First(&offset).Error; err == nil {
+			health.LastUpdate = offset.UpdatedAt
+			if idle := time.Since(offset.UpdatedAt); health.PendingUpdates > 0 && idle > botStuckAfter {
+				health.Problems = append(health.Problems, stuckProblem(health.PendingUpdates, idle))
+			}
+		}
+	}
+
+	if health.PendingUpdates > botPendingUpdatesLimit {
+		health.Problems = append(health.Problems, fmt.Sprintf("%d پیام در صف تلگرام مانده است", health.PendingUpdates))
+	}
+
+	rate, calls := APIErrorRate(store.BotToken)
+	health.ErrorRate = rate
+	if calls >= botErrorRateMinCalls && rate >= botErrorRateLimit {
+		health.Problems = append(health.Problems, fmt.Sprintf("%.0f%% از %d درخواست اخیر به تلگرام ناموفق بوده است", rate*100, calls))
+	}
+
+	health.Healthy = len(health.Problems) == 0
+	return health
+}
+
+// stuckProblem describes a bot that stopped handling its pending updates
+func stuckProblem(pending int, idle time.Duration) string {
+	return fmt.Sprintf("%d پیام در انتظار است ولی %d دقیقه است پیامی پردازش نشده", pending, int(idle.Minutes()))
+}
+
+// MonitorBots probes the store bots, records the results and moves failing
+// bots to the error status. The owner and the platform admin are alerted once
+// per incident, and again only if it is still going on a day later.
+func (b *BotManagerService) MonitorBots() {
+	b.mu.Lock()
+	supervising := b.newStoreBot != nil
+	sharded := b.leases != nil
+	storeIDs := make([]uint, 0, len(b.running))
+	for storeID := range b.running {
+		storeIDs = append(storeIDs, storeID)
+	}
+	b.mu.Unlock()
+
+	// A lone supervising process checks the bots it runs; the leader of
+	// sharded instances checks every bot that should be running
+	query := b.db.Preload("Owner").Where("bot_token <> ''")
+	if supervising && !sharded {
+		if len(storeIDs) == 0 {
+			return
+		}
+		query = query.Where("id IN ?", storeIDs)
+	} else {
+		query = query.Where("bot_status IN ?", []BotStatus{BotStatusActive, BotStatusError})
+	}
+
+	var stores []models.Store
+	if err := query.Find(&stores).Error; err != nil {
+		log.Printf("Error getting store bots to monitor: %v", err)
+		return
+	}
+
+	unhealthy := 0
+	for i := range stores {
+		health := b.CheckBotHealth(&stores[i])
+		if !health.Healthy {
+			unhealthy++
+		}
+		if err := b.recordBotHealth(&stores[i], health); err != nil {
+			log.Printf("Failed to record health of store %d: %v", stores[i].ID, err)
+		}
+	}
+
+	log.Printf("Checked %d store bots, %d unhealthy", len(stores), unhealthy)
+}
+
+// StartBotMonitoringRoutine starts a routine to monitor bots periodically.
+// With sharded instances only the leader monitors.
+func (b *BotManagerService) StartBotMonitoringRoutine() {
+	go func() {
+		ticker := time.NewTicker(botHealthCheckEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			b.mu.Lock()
+			leases := b.leases
+			b.mu.Unlock()
+
+			if leases.IsLeader() {
+				b.MonitorBots()
+			}
+		}
+	}()
+
+	log.Println("Bot monitoring routine started")
+}
+
+// GetUnhealthyBots returns the latest failed checks, longest failing first
+func (b *BotManagerService) GetUnhealthyBots() ([]models.BotHealthCheck, error) {
+	var checks []models.BotHealthCheck
+	if err := b.db.Preload("Store").Where("healthy = ?", false).
+		Order("unhealthy_since ASC").Find(&checks).Error; err != nil {
+		return nil, fmt.Errorf("failed to get unhealthy bots: %w", err)
+	}
+	return checks, nil
+}
+
+// recordBotHealth saves a check and updates the bot's status and alerts
+func (b *BotManagerService) recordBotHealth(store *models.Store, health BotHealth) error {
+	b.setBotProblems(store.ID, health.Problems)
+
+	var check models.BotHealthCheck
+	if err := b.db.Where("store_id = ?", store.ID).First(&check).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	now := time.Now()
+	check.StoreID = store.ID
+	check.Healthy = health.Healthy
+	check.Problems = strings.Join(health.Problems, "\n")
+	check.PendingUpdates = health.PendingUpdates
+	check.ErrorRate = health.ErrorRate
+	check.CheckedAt = now
+	if !health.LastUpdate.IsZero() {
+		lastUpdate := health.LastUpdate
+		check.LastUpdateAt = &lastUpdate
+	}
+
+	if health.Healthy {
+		if store.BotStatus == string(BotStatusError) {
+			b.saveBotStatus(store.ID, BotStatusActive)
+		}
+		if check.AlertedAt != nil {
+			b.notifyBotRecovered(store)
+		}
+		check.UnhealthySince = nil
+		check.AlertedAt = nil
+	} else {
+		b.saveBotStatus(store.ID, BotStatusError)
+		if check.UnhealthySince == nil {
+			check.UnhealthySince = &now
+		}
+		if check.AlertedAt == nil || now.Sub(*check.AlertedAt) >= botRealertAfter {
+			if b.alertBotUnhealthy(store, health) {
+				check.AlertedAt = &now
+			}
+		}
+	}
+
+	return b.db.Omit("Store").Save(&check).Error
+}
+
+// setBotProblems hands the latest problems to the supervised bot, if any
+func (b *BotManagerService) setBotProblems(storeID uint, problems []string) {
+	b.mu.Lock()
+	sb, ok := b.running[storeID]
+	b.mu.Unlock()
+
+	if ok {
+		sb.setProblems(problems)
+	}
+}
+
+// alertBotUnhealthy tells the store owner and the platform admin about a
+// failing bot. It returns false when nobody could be told, so the alert is
+// tried again on the next check.
+func (b *BotManagerService) alertBotUnhealthy(store *models.Store, health BotHealth) bool {
+	if b.bot == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	adminChatID := b.adminChatID
+	b.mu.Unlock()
+
+	problems := "• " + strings.Join(health.Problems, "\n• ")
+	sent := false
+
+	if store.Owner.TelegramID != 0 {
+		text := fmt.Sprintf(messages.BotUnhealthyOwnerAlert, store.Name, store.BotUsername, problems)
+		if health.TokenRevoked {
+			text += "\n\n" + messages.BotTokenRevokedHint
+		}
+		if _, err := b.bot.Send(tgbotapi.NewMessage(store.Owner.TelegramID, text)); err != nil {
+			log.Printf("Failed to alert owner of store %d: %v", store.ID, err)
+		} else {
+			sent = true
+		}
+	}
+
+	if adminChatID != 0 {
+		text := fmt.Sprintf(messages.BotUnhealthyAdminAlert, store.Name, store.ID, store.BotUsername, problems)
+		if _, err := b.bot.Send(tgbotapi.NewMessage(adminChatID, text)); err != nil {
+			log.Printf("Failed to alert admin about store %d: %v", store.ID, err)
+		} else {
+			sent = true
+		}
+	}
+
+	return sent
+}
+
+// notifyBotRecovered tells the owner that an alerted problem is gone
+func (b *BotManagerService) notifyBotRecovered(store *models.Store) {
+	if b.bot == nil || store.Owner.TelegramID == 0 {
+		return
+	}
+
+	text := fmt.Sprintf(messages.BotRecoveredOwnerNotice, store.Name, store.BotUsername)
+	if _, err := b.bot.Send(tgbotapi.NewMessage(store.Owner.TelegramID, text)); err != nil {
+		log.Printf("Failed to notify owner of store %d about recovery: %v", store.ID, err)
+	}
+}
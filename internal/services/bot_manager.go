@@ -1,9 +1,15 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/secrets"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -14,6 +20,14 @@ import (
 type BotManagerService struct {
 	bot *tgbotapi.BotAPI
 	db  *gorm.DB
+
+	// Supervisor state for the running store bots (see bot_supervisor.go)
+	mu            sync.Mutex
+	newStoreBot   StoreBotFactory
+	running       map[uint]*supervisedBot
+	reconcileStop chan struct{}
+	adminChatID   int64         // alerted about failing bots (see bot_health.go)
+	leases        *LeaseService // shards bots across instances when set
 }
 
 // SubBotConfig contains configuration for creating sub-bots
@@ -38,12 +52,42 @@ const (
 // NewBotManagerService creates a new bot manager service
 func NewBotManagerService(bot *tgbotapi.BotAPI, db *gorm.DB) *BotManagerService {
 	return &BotManagerService{
-		bot: bot,
-		db:  db,
+		bot:     bot,
+		db:      db,
+		running: make(map[uint]*supervisedBot),
 	}
 }
 
-// CreateSubBot creates a new sub-bot for a store
+// Bot token errors returned by ConnectBotToken
+var (
+	ErrBotTokenMalformed = errors.New("bot token is malformed")
+	ErrBotTokenRejected  = errors.New("bot token was rejected by Telegram")
+	ErrBotTokenInUse     = errors.New("bot is already connected to another store")
+)
+
+// botTokenCheckTimeout bounds the live getMe check of a pasted token
+const botTokenCheckTimeout = 15 * time.Second
+
+// botAPIEndpoint is where bots reach the Bot API: Telegram by default, a
+// self-hosted Bot API server, or a fake one in tests
+var botAPIEndpoint atomic.Value
+
+// SetBotAPIEndpoint points every bot built from now on at another Bot API
+// server. endpoint has the form of tgbotapi.APIEndpoint.
+func SetBotAPIEndpoint(endpoint string) {
+	botAPIEndpoint.Store(endpoint)
+}
+
+// BotAPIEndpoint returns the Bot API endpoint bots are built with
+func BotAPIEndpoint() string {
+	if endpoint, ok := botAPIEndpoint.Load().(string); ok && endpoint != "" {
+		return endpoint
+	}
+	return tgbotapi.APIEndpoint
+}
+
+// CreateSubBot starts bot onboarding for a store. The store is marked as
+// waiting for its bot and the owner is asked to send a token from BotFather.
 func (b *BotManagerService) CreateSubBot(storeID uint) error {
 	// Get store information
 	var store models.Store
@@ -51,9 +95,14 @@ func (b *BotManagerService) CreateSubBot(storeID uint) error {
 		return fmt.Errorf("failed to get store: %w", err)
 	}
 
-	log.Printf("Creating sub-bot for store: %s (ID: %d)", store.Name, store.ID)
+	if store.BotID != 0 {
+		log.Printf("Store %d already has bot @%s", store.ID, store.BotUsername)
+		return nil
+	}
+
+	log.Printf("Waiting for bot token for store: %s (ID: %d)", store.Name, store.ID)
 
-	// Update store status to indicate bot creation is in progress
+	// Update store status to indicate the bot is being set up
 	if err := b.db.Model(&store).Updates(map[string]interface{}{
 		"bot_status": BotStatusCreating,
 		"updated_at": time.Now(),
@@ -61,192 +110,117 @@ func (b *BotManagerService) CreateSubBot(storeID uint) error {
 		return fmt.Errorf("failed to update store status: %w", err)
 	}
 
-	// Start async bot creation process
-	go b.createSubBotAsync(&store)
+	if b.bot != nil {
+		b.notifyBotTokenRequired(store.Owner.TelegramID, &store)
+	}
 
 	return nil
 }
 
-// createSubBotAsync creates sub-bot asynchronously
-func (b *BotManagerService) createSubBotAsync(store *models.Store) {
-	// Generate unique bot username
-	botUsername := b.generateBotUsername(store.Name, store.ID)
-	
-	// Simulate bot creation process (in real implementation, this would involve BotFather API)
-	log.Printf("Simulating bot creation for store: %s", store.Name)
-	time.Sleep(2 * time.Second) // Simulate creation delay
-
-	// Generate fake bot token for demonstration
-	botToken := b.generateFakeBotToken(store.ID)
-
-	// Create bot configuration
-	config := &SubBotConfig{
-		StoreID:     store.ID,
-		StoreName:   store.Name,
-		Description: store.Description,
-		PlanType:    string(store.PlanType),
-		OwnerID:     store.OwnerID,
-	}
-
-	// Update store with bot information
-	updates := map[string]interface{}{
-		"bot_username": botUsername,
-		"bot_token":    botToken, // In real implementation, encrypt this
-		"bot_status":   BotStatusActive,
-		"updated_at":   time.Now(),
+// VerifyBotToken checks a token live with getMe and returns the bot account
+func (b *BotManagerService) VerifyBotToken(token string) (*tgbotapi.User, error) {
+	token = strings.TrimSpace(token)
+	if !IsValidBotToken(token) {
+		return nil, ErrBotTokenMalformed
 	}
 
-	if err := b.db.Model(store).Updates(updates).Error; err != nil {
-		log.Printf("Failed to update store with bot info: %v", err)
-		b.handleBotCreationError(store.ID, err)
-		return
+	api, err := tgbotapi.NewBotAPIWithClient(token, BotAPIEndpoint(), &http.Client{Timeout: botTokenCheckTimeout})
+	if err != nil {
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && (apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusNotFound) {
+			return nil, ErrBotTokenRejected
+		}
+		return nil, fmt.Errorf("failed to verify bot token: %w", err)
 	}
 
-	// Initialize bot settings
-	if err := b.initializeBotSettings(store.ID, config); err != nil {
-		log.Printf("Failed to initialize bot settings: %v", err)
-		b.handleBotCreationError(store.ID, err)
-		return
+	if !api.Self.IsBot {
+		return nil, ErrBotTokenRejected
 	}
 
-	// Send notification to store owner
-	b.notifyBotCreated(store.Owner.TelegramID, store, botUsername)
-
-	log.Printf("Sub-bot created successfully for store %s: @%s", store.Name, botUsername)
+	return &api.Self, nil
 }
 
-// generateBotUsername generates a unique bot username
-func (b *BotManagerService) generateBotUsername(storeName string, storeID uint) string {
-	// Clean store name for username (remove spaces, special chars)
-	cleanName := b.cleanStringForUsername(storeName)
-	
-	// Ensure uniqueness by adding store ID
-	return fmt.Sprintf("%s_%d_bot", cleanName, storeID)
-}
-
-// generateFakeBotToken generates a fake bot token for demonstration
-func (b *BotManagerService) generateFakeBotToken(storeID uint) string {
-	// In real implementation, this would be obtained from BotFather
-	return fmt.Sprintf("fake_token_%d_%d", storeID, time.Now().Unix())
-}
+// ConnectBotToken verifies a token and binds its bot to a store. It is used
+// for the first connection as well as for swapping in a new token after a
+// revoke: the store keeps its ID, so products, orders, carts and sessions
+// stay attached to it.
+func (b *BotManagerService) ConnectBotToken(storeID uint, token string) (*models.Store, error) {
+	token = strings.TrimSpace(token)
 
-// cleanStringForUsername cleans a string to make it suitable for username
-func (b *BotManagerService) cleanStringForUsername(s string) string {
-	// Replace spaces and special characters with underscores
-	result := ""
-	for _, char := range s {
-		if (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') {
-			result += string(char)
-		} else if char == ' ' || char == '-' {
-			result += "_"
-		}
-	}
-	
-	// Limit length to 20 characters
-	if len(result) > 20 {
-		result = result[:20]
+	botUser, err := b.VerifyBotToken(token)
+	if err != nil {
+		return nil, err
 	}
-	
-	return result
-}
 
-// initializeBotSettings initializes settings for the new bot
-func (b *BotManagerService) initializeBotSettings(storeID uint, config *SubBotConfig) error {
-	// In a real implementation, this would:
-	// 1. Set bot description and about text
-	// 2. Configure bot commands
-	// 3. Set up webhooks
-	// 4. Initialize bot database tables
-	// 5. Configure payment settings
-	// 6. Set up welcome messages based on plan type
-
-	log.Printf("Initializing bot settings for store %d", storeID)
-
-	// Simulate initialization delay
-	time.Sleep(1 * time.Second)
-
-	// Here you would configure the bot based on the plan type
-	switch config.PlanType {
-	case "free":
-		// Configure free plan features
-		log.Printf("Configuring free plan features for store %d", storeID)
-	case "pro":
-		// Configure pro plan features (welcome message, etc.)
-		log.Printf("Configuring pro plan features for store %d", storeID)
-	case "vip":
-		// Configure VIP plan features (custom features, etc.)
-		log.Printf("Configuring VIP plan features for store %d", storeID)
-	}
+	var store models.Store
+	err = b.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&store, storeID).Error; err != nil {
+			return fmt.Errorf("failed to get store: %w", err)
+		}
 
-	return nil
-}
+		// The bot ID survives a revoke, so it also catches old tokens of the
+		// same bot; tokens themselves are encrypted and can't be compared
+		var taken int64
+		if err := tx.Model(&models.Store{}).
+			Where("id <> ? AND bot_id = ?", storeID, botUser.ID).
+			Count(&taken).Error; err != nil {
+			return fmt.Errorf("failed to check bot token: %w", err)
+		}
+		if taken > 0 {
+			return ErrBotTokenInUse
+		}
 
-// handleBotCreationError handles errors during bot creation
-func (b *BotManagerService) handleBotCreationError(storeID uint, err error) {
-	log.Printf("Bot creation failed for store %d: %v", storeID, err)
+		updates := map[string]interface{}{
+			"bot_token":    secrets.Sealed(token),
+			"bot_id":       botUser.ID,
+			"bot_username": botUser.UserName,
+			"updated_at":   time.Now(),
+			// A new bot starts without our commands and descriptions
+			"bot_profile_hash": "",
+		}
+		// Connecting a token doesn't undo an admin deactivation
+		if BotStatus(store.BotStatus) != BotStatusInactive {
+			updates["bot_status"] = BotStatusActive
+		}
 
-	// Update store status to error
-	b.db.Model(&models.Store{}).Where("id = ?", storeID).Updates(map[string]interface{}{
-		"bot_status": BotStatusError,
-		"updated_at": time.Now(),
+		return tx.Model(&store).Updates(updates).Error
 	})
-
-	// Notify store owner about the error
-	var store models.Store
-	if err := b.db.Preload("Owner").First(&store, storeID).Error; err == nil {
-		b.notifyBotCreationError(store.Owner.TelegramID, &store, err)
+	if err != nil {
+		return nil, err
 	}
-}
-
-// notifyBotCreated sends notification when bot is successfully created
-func (b *BotManagerService) notifyBotCreated(ownerTelegramID int64, store *models.Store, botUsername string) {
-	text := fmt.Sprintf(`🤖 ربات فروشگاه شما آماده شد!
-
-🏪 فروشگاه: %s
-🤖 ربات: @%s
 
-✅ ربات شما با موفقیت ایجاد و پیکربندی شد!
-
-🔗 لینک ربات: https://t.me/%s
-
-اکنون مشتریان می‌توانند از طریق این ربات محصولات شما را مشاهده و خریداری کنند.
-
-💡 نکته: ربات فعلاً در حالت آزمایشی است. برای فعال‌سازی کامل، با پشتیبانی تماس بگیرید.`,
-		store.Name,
-		botUsername,
-		botUsername)
+	if store.BotID != 0 && store.BotID != botUser.ID {
+		log.Printf("Store %d switched bots: %d -> @%s", store.ID, store.BotID, botUser.UserName)
+	}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonURL("🔗 باز کردن ربات", fmt.Sprintf("https://t.me/%s", botUsername)),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🏪 پنل مدیریت", "manage_store"),
-		),
-	)
+	// Restart the update loop on the new token
+	b.StopSubBot(store.ID)
+	if b.newStoreBot != nil {
+		if err := b.StartSubBot(store.ID); err != nil {
+			log.Printf("Bot connected for store %d but not started: %v", store.ID, err)
+		}
+	}
 
-	msg := tgbotapi.NewMessage(ownerTelegramID, text)
-	msg.ReplyMarkup = keyboard
+	if err := b.db.Preload("Owner").First(&store, storeID).Error; err != nil {
+		return nil, fmt.Errorf("failed to reload store: %w", err)
+	}
 
-	b.bot.Send(msg)
+	log.Printf("Bot @%s connected to store %s (ID: %d)", store.BotUsername, store.Name, store.ID)
+	return &store, nil
 }
 
-// notifyBotCreationError sends notification when bot creation fails
-func (b *BotManagerService) notifyBotCreationError(ownerTelegramID int64, store *models.Store, err error) {
-	text := fmt.Sprintf(`❌ خطا در ایجاد ربات فروشگاه
+// notifyBotTokenRequired asks the store owner to connect a bot
+func (b *BotManagerService) notifyBotTokenRequired(ownerTelegramID int64, store *models.Store) {
+	text := fmt.Sprintf(`🤖 اتصال ربات فروشگاه
 
 🏪 فروشگاه: %s
 
-متأسفانه در ایجاد ربات فروشگاه شما خطایی رخ داده است.
-
-🔄 ما در حال بررسی و رفع مشکل هستیم.
-
-💬 در صورت ادامه مشکل، با پشتیبانی تماس بگیرید.`,
+برای راه‌اندازی ربات فروشگاه، یک ربات جدید در @BotFather بسازید و توکن آن را برای ما ارسال کنید.`,
 		store.Name)
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🔄 تلاش مجدد", "retry_bot_creation"),
+			tgbotapi.NewInlineKeyboardButtonData("🤖 اتصال ربات", "connect_bot"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("💬 پشتیبانی", "support"),
@@ -256,7 +230,9 @@ func (b *BotManagerService) notifyBotCreationError(ownerTelegramID int64, store
 	msg := tgbotapi.NewMessage(ownerTelegramID, text)
 	msg.ReplyMarkup = keyboard
 
-	b.bot.Send(msg)
+	if _, err := b.bot.Send(msg); err != nil {
+		log.Printf("⚠️ Failed to ask the owner of store %d for a bot token: %v", store.ID, err)
+	}
 }
 
 // RetryBotCreation retries bot creation for a store
@@ -275,6 +251,8 @@ func (b *BotManagerService) DeactivateBot(storeID uint) error {
 		return fmt.Errorf("failed to deactivate bot: %w", err)
 	}
 
+	b.StopSubBot(storeID)
+
 	log.Printf("Bot deactivated for store %d", storeID)
 	return nil
 }
@@ -289,18 +267,56 @@ func (b *BotManagerService) ReactivateBot(storeID uint) error {
 		return fmt.Errorf("failed to reactivate bot: %w", err)
 	}
 
+	if b.newStoreBot != nil {
+		if err := b.StartSubBot(storeID); err != nil {
+			log.Printf("Bot for store %d reactivated but not started: %v", storeID, err)
+		}
+	}
+
 	log.Printf("Bot reactivated for store %d", storeID)
 	return nil
 }
 
-// GetBotStatus returns the status of a store's bot
+// GetBotStatus returns the status of a store's bot. When this process
+// supervises store bots, a bot only counts as active while its update loop is
+// actually running and passed its last health check; otherwise the status
+// recorded by the supervisor is used.
 func (b *BotManagerService) GetBotStatus(storeID uint) (BotStatus, error) {
+	b.mu.Lock()
+	supervising := b.newStoreBot != nil
+	sharded := b.leases != nil
+	b.mu.Unlock()
+
+	if runtime, ok := b.GetBotRuntime(storeID); ok {
+		switch runtime.State {
+		case BotRunStateRunning:
+			if len(runtime.Problems) > 0 {
+				return BotStatusError, nil
+			}
+			return BotStatusActive, nil
+		case BotRunStateErroring:
+			return BotStatusError, nil
+		}
+	}
+
 	var store models.Store
-	if err := b.db.Select("bot_status").First(&store, storeID).Error; err != nil {
+	if err := b.db.Select("id", "bot_status").First(&store, storeID).Error; err != nil {
 		return "", fmt.Errorf("failed to get store: %w", err)
 	}
 
-	return BotStatus(store.BotStatus), nil
+	// Without supervision here, or with bots sharded across instances, the
+	// status saved by whichever instance runs the bot is the best we know
+	status := BotStatus(store.BotStatus)
+	if !supervising || sharded {
+		return status, nil
+	}
+
+	switch status {
+	case BotStatusCreating, BotStatusError:
+		return status, nil
+	}
+
+	return BotStatusInactive, nil
 }
 
 // GetStoreBots returns all bots with their status
@@ -313,61 +329,20 @@ func (b *BotManagerService) GetStoreBots() ([]models.Store, error) {
 	return stores, nil
 }
 
-// UpdateBotConfiguration updates bot configuration based on plan changes
+// UpdateBotConfiguration reconfigures a store's bot for a new plan right
+// away. Plan changes made elsewhere are picked up by the supervisor's next
+// reconcile.
 func (b *BotManagerService) UpdateBotConfiguration(storeID uint, newPlanType string) error {
-	log.Printf("Updating bot configuration for store %d to plan %s", storeID, newPlanType)
-
-	// In a real implementation, this would:
-	// 1. Update bot features based on new plan
-	// 2. Enable/disable certain commands
-	// 3. Update welcome messages
-	// 4. Modify payment settings
-	// 5. Update commission rates
-
-	// Simulate configuration update
-	time.Sleep(500 * time.Millisecond)
-
-	log.Printf("Bot configuration updated for store %d", storeID)
-	return nil
-}
-
-// MonitorBots monitors all bots and ensures they're running properly
-func (b *BotManagerService) MonitorBots() {
-	log.Println("Starting bot monitoring routine...")
-
-	// Get all active bots
-	var stores []models.Store
-	if err := b.db.Where("bot_status = ?", BotStatusActive).Find(&stores).Error; err != nil {
-		log.Printf("Error getting active bots: %v", err)
-		return
+	var store models.Store
+	if err := b.db.First(&store, storeID).Error; err != nil {
+		return fmt.Errorf("failed to get store: %w", err)
 	}
-
-	for _, store := range stores {
-		// In a real implementation, this would:
-		// 1. Check if bot is responding
-		// 2. Verify bot configuration
-		// 3. Check for errors or issues
-		// 4. Update bot status if needed
-
-		log.Printf("Monitoring bot for store %s (@%s)", store.Name, store.BotUsername)
+	if store.BotToken == "" {
+		return nil
+	}
+	if newPlanType != "" {
+		store.PlanType = models.PlanType(newPlanType)
 	}
 
-	log.Printf("Monitored %d active bots", len(stores))
+	return b.SyncBotProfile(&store, true)
 }
-
-// StartBotMonitoringRoutine starts a routine to monitor bots periodically
-func (b *BotManagerService) StartBotMonitoringRoutine() {
-	go func() {
-		ticker := time.NewTicker(30 * time.Minute) // Monitor every 30 minutes
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-ticker.C:
-				b.MonitorBots()
-			}
-		}
-	}()
-
-	log.Println("Bot monitoring routine started")
-}
\ No newline at end of file
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Bot API limits for the profile texts, in characters
+const (
+	botDescriptionLimit      = 512
+	botShortDescriptionLimit = 120
+	botProfileTimeout        = 15 * time.Second
+)
+
+// Commands every store bot offers, and the ones unlocked by higher plans
+var (
+	freePlanCommands = []tgbotapi.BotCommand{
+		{Command: "start", Description: "🏠 منوی اصلی"},
+		{Command: "products", Description: "🛍 مشاهده محصولات"},
+		{Command: "search", Description: "🔍 جستجوی محصولات"},
+		{Command: "cart", Description: "🛒 سبد خرید"},
+		{Command: "orders", Description: "📋 سفارش‌های من"},
+	}
+	proPlanCommands = []tgbotapi.BotCommand{
+		{Command: "contact", Description: "📞 تماس با فروشگاه"},
+	}
+	vipPlanCommands = []tgbotapi.BotCommand{
+		{Command: "about", Description: "ℹ️ درباره فروشگاه"},
+	}
+)
+
+// freePlanCredit is appended to the description of free plan bots
+const freePlanCredit = "\n\n🤖 ساخته شده با CodeRoot"
+
+// BotProfile is what a store bot shows in Telegram besides its messages
+type BotProfile struct {
+	Commands         []tgbotapi.BotCommand `json:"commands"`
+	Description      string                `json:"description"`
+	ShortDescription string                `json:"short_description"`
+	MenuButton       string                `json:"menu_button"` // "commands" or "default"
+}
+
+// StoreBotProfile returns the profile a store's bot should have for its plan
+func StoreBotProfile(store *models.Store) BotProfile {
+	commands := append([]tgbotapi.BotCommand{}, freePlanCommands...)
+	switch store.PlanType {
+	case models.PlanPro:
+		commands = append(commands, proPlanCommands...)
+	case models.PlanVIP:
+		commands = append(commands, proPlanCommands...)
+		commands = append(commands, vipPlanCommands...)
+	}
+
+	description := strings.TrimSpace(store.Description)
+	if description == "" {
+		description = fmt.Sprintf("🌟 به فروشگاه %s خوش آمدید!", store.Name)
+	}
+	if store.PlanType == models.PlanFree || store.PlanType == "" {
+		description = truncateRunes(description, botDescriptionLimit-len([]rune(freePlanCredit))) + freePlanCredit
+	}
+
+	return BotProfile{
+		Commands:         commands,
+		Description:      truncateRunes(description, botDescriptionLimit),
+		ShortDescription: truncateRunes(fmt.Sprintf("🏪 %s", store.Name), botShortDescriptionLimit),
+		MenuButton:       "commands",
+	}
+}
+
+// Hash fingerprints the profile so unchanged profiles aren't sent again
+func (p BotProfile) Hash() string {
+	data, _ := json.Marshal(p)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// apply sends the profile to Telegram
+func (p BotProfile) apply(api *tgbotapi.BotAPI) error {
+	if _, err := api.Request(tgbotapi.NewSetMyCommands(p.Commands...)); err != nil {
+		return fmt.Errorf("setMyCommands: %w", err)
+	}
+	if _, err := api.MakeRequest("setMyDescription", tgbotapi.Params{"description": p.Description}); err != nil {
+		return fmt.Errorf("setMyDescription: %w", err)
+	}
+	if _, err := api.MakeRequest("setMyShortDescription", tgbotapi.Params{"short_description": p.ShortDescription}); err != nil {
+		return fmt.Errorf("setMyShortDescription: %w", err)
+	}
+
+	params := tgbotapi.Params{}
+	if err := params.AddInterface("menu_button", map[string]string{"type": p.MenuButton}); err != nil {
+		return err
+	}
+	if _, err := api.MakeRequest("setChatMenuButton", params); err != nil {
+		return fmt.Errorf("setChatMenuButton: %w", err)
+	}
+	return nil
+}
+
+// SyncBotProfile configures a store bot's commands, descriptions and menu
+// button for the store's plan. Unless force is set, nothing is sent when the
+// bot already has the current profile.
+func (b *BotManagerService) SyncBotProfile(store *models.Store, force bool) error {
+	profile := StoreBotProfile(store)
+	hash := profile.Hash()
+	if !force && hash == store.BotProfileHash {
+		return nil
+	}
+
+	api := directBotAPI(store.BotToken, botProfileTimeout)
+	if err := profile.apply(api); err != nil {
+		return fmt.Errorf("failed to configure bot of store %d: %w", store.ID, err)
+	}
+
+	if err := b.db.Model(&models.Store{}).Where("id = ?", store.ID).
+		UpdateColumn("bot_profile_hash", hash).Error; err != nil {
+		return fmt.Errorf("failed to save bot profile of store %d: %w", store.ID, err)
+	}
+	store.BotProfileHash = hash
+
+	log.Printf("Bot profile of store %d synced for plan %s", store.ID, store.PlanType)
+	return nil
+}
+
+// syncBotProfile is SyncBotProfile for the supervisor, where a failure must
+// not keep the bot from running; it is retried on the next reconcile
+func (b *BotManagerService) syncBotProfile(store *models.Store) {
+	if err := b.SyncBotProfile(store, false); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+}
+
+// truncateRunes shortens s to at most limit characters
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+// directBotAPI returns a client for a store bot's token without the getMe
+// round trip of tgbotapi.NewBotAPI, for calls made outside the bot's own loop
+func directBotAPI(token string, timeout time.Duration) *tgbotapi.BotAPI {
+	api := &tgbotapi.BotAPI{
+		Token:  token,
+		Client: &http.Client{Timeout: timeout},
+		Buffer: 100,
+	}
+	api.SetAPIEndpoint(BotAPIEndpoint())
+	return api
+}
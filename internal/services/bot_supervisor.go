@@ -0,0 +1,588 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"telegram-store-hub/internal/models"
+	"time"
+)
+
+// Supervisor tuning
+const (
+	supervisorMinBackoff     = 2 * time.Second
+	supervisorMaxBackoff     = 5 * time.Minute
+	supervisorHealthyRun     = 10 * time.Minute // a run this long resets the backoff
+	supervisorReconcileEvery = 1 * time.Minute
+	supervisorShardedEvery   = 15 * time.Second // bots of a dead instance are picked up this fast
+	supervisorStopTimeout    = 15 * time.Second
+)
+
+// botTokenPattern matches the "<bot id>:<secret>" format issued by BotFather
+var botTokenPattern = regexp.MustCompile(`^\d+:[A-Za-z0-9_-]{30,}$`)
+
+// BotRunState describes what a store bot's update loop is doing right now
+type BotRunState string
+
+const (
+	BotRunStateRunning  BotRunState = "running"
+	BotRunStateStopped  BotRunState = "stopped"
+	BotRunStateErroring BotRunState = "erroring"
+)
+
+// BotRuntime is a snapshot of a supervised store bot
+type BotRuntime struct {
+	StoreID    uint        `json:"store_id"`
+	State      BotRunState `json:"state"`
+	StartedAt  time.Time   `json:"started_at"`
+	LastUpdate time.Time   `json:"last_update"`
+	LastError  string      `json:"last_error,omitempty"`
+	Restarts   int         `json:"restarts"`
+	Problems   []string    `json:"problems,omitempty"` // from the last health check
+}
+
+// StoreBotRunner is a store bot update loop. Run blocks until the loop ends;
+// it returns nil after Stop and an error when the loop can't continue.
+type StoreBotRunner interface {
+	Run() error
+	Stop()
+}
+
+// StoreBotFactory builds the runner for a store. onUpdate must be called for
+// every update the runner receives so the supervisor can track liveness.
+type StoreBotFactory func(store *models.Store, onUpdate func()) (StoreBotRunner, error)
+
+// supervisedBot is the supervisor's bookkeeping for one store bot
+type supervisedBot struct {
+	storeID  uint
+	token    string
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu      sync.Mutex
+	runner  StoreBotRunner
+	runtime BotRuntime
+}
+
+// SetStoreBotFactory sets how store bots are built. It must be called before
+// StartAllBots or StartSubBot.
+func (b *BotManagerService) SetStoreBotFactory(factory StoreBotFactory) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.newStoreBot = factory
+}
+
+// SetLeaseService shards store bots across instances: a bot is only run by
+// the instance holding its lease, and each instance takes about its share of
+// the bots. It must be called before StartAllBots.
+func (b *BotManagerService) SetLeaseService(leases *LeaseService) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.leases = leases
+}
+
+// IsValidBotToken reports whether a token has the shape of a Telegram bot token
+func IsValidBotToken(token string) bool {
+	return botTokenPattern.MatchString(token)
+}
+
+// StartAllBots starts an update loop for every eligible store and keeps the
+// set of running bots in sync with the database until StopAllBots is called
+func (b *BotManagerService) StartAllBots() error {
+	b.mu.Lock()
+	if b.newStoreBot == nil {
+		b.mu.Unlock()
+		return errors.New("store bot factory is not configured")
+	}
+	if b.reconcileStop != nil {
+		b.mu.Unlock()
+		return nil
+	}
+	stop := make(chan struct{})
+	b.reconcileStop = stop
+	b.mu.Unlock()
+
+	err := b.reconcileBots()
+	go b.reconcileLoop(stop)
+
+	return err
+}
+
+// StopAllBots stops every running store bot and waits for the loops to exit
+func (b *BotManagerService) StopAllBots() {
+	b.mu.Lock()
+	if b.reconcileStop != nil {
+		close(b.reconcileStop)
+		b.reconcileStop = nil
+	}
+	bots := make([]*supervisedBot, 0, len(b.running))
+	for storeID, sb := range b.running {
+		bots = append(bots, sb)
+		delete(b.running, storeID)
+	}
+	b.mu.Unlock()
+
+	for _, sb := range bots {
+		sb.shutdown()
+	}
+
+	deadline := time.After(supervisorStopTimeout)
+	for _, sb := range bots {
+		select {
+		case <-sb.done:
+		case <-deadline:
+			log.Printf("Timed out waiting for store bots to stop")
+			return
+		}
+	}
+
+	log.Printf("Stopped %d store bots", len(bots))
+}
+
+// StartSubBot starts the update loop for a single store
+func (b *BotManagerService) StartSubBot(storeID uint) error {
+	var store models.Store
+	if err := b.db.First(&store, storeID).Error; err != nil {
+		return fmt.Errorf("failed to get store: %w", err)
+	}
+
+	if reason := b.ineligibleReason(&store); reason != "" {
+		return fmt.Errorf("store %d can't run a bot: %s", storeID, reason)
+	}
+
+	// When another instance holds the lease, that instance runs the bot
+	b.mu.Lock()
+	leases := b.leases
+	b.mu.Unlock()
+	if leases != nil {
+		ok, err := leases.Acquire(storeBotLeaseName(storeID))
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	return b.startSupervisor(&store)
+}
+
+// StopSubBot stops the update loop for a single store, if it is running
+func (b *BotManagerService) StopSubBot(storeID uint) {
+	b.mu.Lock()
+	sb, ok := b.running[storeID]
+	if ok {
+		delete(b.running, storeID)
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	sb.shutdown()
+	select {
+	case <-sb.done:
+	case <-time.After(supervisorStopTimeout):
+		log.Printf("Timed out waiting for bot of store %d to stop", storeID)
+	}
+}
+
+// GetBotRuntime returns the runtime state of a store's bot. ok is false when
+// the bot is not supervised by this process.
+func (b *BotManagerService) GetBotRuntime(storeID uint) (BotRuntime, bool) {
+	b.mu.Lock()
+	sb, ok := b.running[storeID]
+	b.mu.Unlock()
+
+	if !ok {
+		return BotRuntime{StoreID: storeID, State: BotRunStateStopped}, false
+	}
+
+	return sb.snapshot(), true
+}
+
+// ineligibleReason explains why a store must not run a bot, or returns ""
+func (b *BotManagerService) ineligibleReason(store *models.Store) string {
+	switch {
+	case !store.IsActive:
+		return "store is inactive"
+	case !store.ExpiresAt.After(time.Now()):
+		return "subscription expired"
+	case !IsValidBotToken(store.BotToken):
+		return "bot token is missing or malformed"
+	case BotStatus(store.BotStatus) == BotStatusInactive:
+		return "bot is deactivated"
+	}
+	return ""
+}
+
+// startSupervisor launches the supervise loop for a store unless one is already running
+func (b *BotManagerService) startSupervisor(store *models.Store) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.newStoreBot == nil {
+		return errors.New("store bot factory is not configured")
+	}
+	if _, ok := b.running[store.ID]; ok {
+		return nil
+	}
+
+	sb := &supervisedBot{
+		storeID: store.ID,
+		token:   store.BotToken,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		runtime: BotRuntime{StoreID: store.ID, State: BotRunStateStopped},
+	}
+	b.running[store.ID] = sb
+
+	go b.supervise(sb)
+
+	return nil
+}
+
+// supervise runs a store bot, restarting it with exponential backoff until it
+// is stopped or the store is no longer eligible
+func (b *BotManagerService) supervise(sb *supervisedBot) {
+	defer close(sb.done)
+	defer sb.setState(BotRunStateStopped, nil)
+	defer b.releaseBotLease(sb.storeID)
+
+	backoff := supervisorMinBackoff
+	for {
+		// The bot tells the owner about orders and takes their uploads
+		var store models.Store
+		if err := b.db.Preload("Owner").First(&store, sb.storeID).Error; err != nil {
+			log.Printf("Stopping bot of store %d: %v", sb.storeID, err)
+			b.forget(sb)
+			return
+		}
+		if reason := b.ineligibleReason(&store); reason != "" || store.BotToken != sb.token {
+			if reason == "" {
+				reason = "bot token changed"
+			}
+			log.Printf("Stopping bot of store %d: %s", sb.storeID, reason)
+			b.forget(sb)
+			return
+		}
+
+		b.syncBotProfile(&store)
+
+		startedAt := time.Now()
+		err := b.runOnce(sb, &store)
+		if sb.stopping() {
+			return
+		}
+
+		if time.Since(startedAt) >= supervisorHealthyRun {
+			backoff = supervisorMinBackoff
+		}
+		sb.recordFailure(err)
+		b.saveBotStatus(sb.storeID, BotStatusError)
+		log.Printf("Bot of store %d failed, restarting in %s: %v", sb.storeID, backoff, err)
+
+		select {
+		case <-sb.stop:
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > supervisorMaxBackoff {
+			backoff = supervisorMaxBackoff
+		}
+	}
+}
+
+// runOnce builds and runs the store bot a single time
+func (b *BotManagerService) runOnce(sb *supervisedBot, store *models.Store) (err error) {
+	b.mu.Lock()
+	factory := b.newStoreBot
+	b.mu.Unlock()
+
+	runner, err := factory(store, sb.touch)
+	if err != nil {
+		return fmt.Errorf("failed to create bot: %w", err)
+	}
+	if !sb.setRunner(runner) {
+		runner.Stop()
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			runner.Stop()
+			err = fmt.Errorf("panic in update loop: %v", r)
+		}
+		sb.setRunner(nil)
+	}()
+
+	sb.setState(BotRunStateRunning, nil)
+	b.saveBotStatus(sb.storeID, BotStatusActive)
+	if err := runner.Run(); err != nil {
+		return err
+	}
+	if !sb.stopping() {
+		return errors.New("update loop exited unexpectedly")
+	}
+
+	return nil
+}
+
+// forget removes a bot from the running set if it is still registered
+func (b *BotManagerService) forget(sb *supervisedBot) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.running[sb.storeID] == sb {
+		delete(b.running, sb.storeID)
+	}
+}
+
+// saveBotStatus records the supervisor's view of a bot for other processes
+func (b *BotManagerService) saveBotStatus(storeID uint, status BotStatus) {
+	if err := b.db.Model(&models.Store{}).Where("id = ? AND bot_status IS DISTINCT FROM ?", storeID, BotStatusInactive).
+		Updates(map[string]interface{}{
+			"bot_status": status,
+			"updated_at": time.Now(),
+		}).Error; err != nil {
+		log.Printf("Failed to save bot status for store %d: %v", storeID, err)
+	}
+}
+
+// reconcileLoop periodically applies store changes to the running bots
+func (b *BotManagerService) reconcileLoop(stop chan struct{}) {
+	b.mu.Lock()
+	every := supervisorReconcileEvery
+	if b.leases != nil {
+		every = supervisorShardedEvery
+	}
+	b.mu.Unlock()
+
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := b.reconcileBots(); err != nil {
+				log.Printf("Error reconciling store bots: %v", err)
+			}
+		}
+	}
+}
+
+// reconcileBots starts bots for newly eligible stores, stops bots whose
+// store was deactivated, expired, deleted or given a new token, and keeps the
+// profiles of running bots in line with their store. When sharded, only the
+// bots this instance holds leases for are kept.
+func (b *BotManagerService) reconcileBots() error {
+	var stores []models.Store
+	if err := b.db.Preload("Owner").Where("is_active = ? AND expires_at > ? AND bot_token <> ''", true, time.Now()).
+		Find(&stores).Error; err != nil {
+		return fmt.Errorf("failed to get stores: %w", err)
+	}
+
+	wanted := make(map[uint]*models.Store, len(stores))
+	for i := range stores {
+		if b.ineligibleReason(&stores[i]) == "" {
+			wanted[stores[i].ID] = &stores[i]
+		}
+	}
+
+	b.mu.Lock()
+	leases := b.leases
+	b.mu.Unlock()
+	if leases != nil {
+		wanted = b.claimBots(leases, wanted)
+	}
+
+	b.mu.Lock()
+	var stale []uint
+	for storeID, sb := range b.running {
+		if store, ok := wanted[storeID]; !ok || store.BotToken != sb.token {
+			stale = append(stale, storeID)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, storeID := range stale {
+		b.StopSubBot(storeID)
+	}
+
+	started := 0
+	for _, store := range wanted {
+		if _, ok := b.GetBotRuntime(store.ID); ok {
+			// Running bots follow plan, name and description changes
+			b.syncBotProfile(store)
+			continue
+		}
+		// A bot restarted for a new token released its lease when it stopped
+		if leases != nil && !b.holdBotLease(leases, store.ID) {
+			continue
+		}
+		if err := b.startSupervisor(store); err != nil {
+			return err
+		}
+		started++
+	}
+
+	if started > 0 || len(stale) > 0 {
+		log.Printf("Store bots reconciled: %d started, %d stopped", started, len(stale))
+	}
+
+	return nil
+}
+
+// claimBots narrows wanted down to the bots this instance should run: the
+// ones it holds leases for plus free ones, up to an even share of all bots.
+// Bots over the share are left out so they are stopped and released for the
+// instances that have fewer.
+func (b *BotManagerService) claimBots(leases *LeaseService, wanted map[uint]*models.Store) map[uint]*models.Store {
+	instances, err := leases.LiveInstances()
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	if instances < 1 {
+		instances = 1
+	}
+	share := (len(wanted) + instances - 1) / instances
+
+	storeIDs := make([]uint, 0, len(wanted))
+	for storeID := range wanted {
+		storeIDs = append(storeIDs, storeID)
+	}
+	sort.Slice(storeIDs, func(i, j int) bool { return storeIDs[i] < storeIDs[j] })
+
+	// Keep the running bots first so bots don't move without need
+	mine := make(map[uint]*models.Store, share)
+	var free []uint
+	for _, storeID := range storeIDs {
+		if _, running := b.GetBotRuntime(storeID); !running {
+			free = append(free, storeID)
+			continue
+		}
+		if len(mine) < share && b.holdBotLease(leases, storeID) {
+			mine[storeID] = wanted[storeID]
+		}
+	}
+
+	for _, storeID := range free {
+		if len(mine) >= share {
+			break
+		}
+		if b.holdBotLease(leases, storeID) {
+			mine[storeID] = wanted[storeID]
+		}
+	}
+
+	return mine
+}
+
+// holdBotLease makes sure this instance holds a store bot's lease
+func (b *BotManagerService) holdBotLease(leases *LeaseService, storeID uint) bool {
+	name := storeBotLeaseName(storeID)
+	if leases.Holds(name) {
+		return true
+	}
+
+	ok, err := leases.Acquire(name)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	return ok
+}
+
+// releaseBotLease lets another instance take over a stopped store bot
+func (b *BotManagerService) releaseBotLease(storeID uint) {
+	b.mu.Lock()
+	leases := b.leases
+	b.mu.Unlock()
+
+	if leases != nil {
+		leases.Release(storeBotLeaseName(storeID))
+	}
+}
+
+// shutdown signals the supervise loop and the current runner to stop
+func (sb *supervisedBot) shutdown() {
+	sb.stopOnce.Do(func() { close(sb.stop) })
+
+	sb.mu.Lock()
+	runner := sb.runner
+	sb.mu.Unlock()
+
+	if runner != nil {
+		runner.Stop()
+	}
+}
+
+// stopping reports whether shutdown has been requested
+func (sb *supervisedBot) stopping() bool {
+	select {
+	case <-sb.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// setRunner records the current runner. It refuses a new runner once
+// shutdown has been requested so a late start can't outlive the stop.
+func (sb *supervisedBot) setRunner(runner StoreBotRunner) bool {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	if runner != nil && sb.stopping() {
+		return false
+	}
+	sb.runner = runner
+	return true
+}
+
+func (sb *supervisedBot) setState(state BotRunState, err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.runtime.State = state
+	if state == BotRunStateRunning {
+		sb.runtime.StartedAt = time.Now()
+	}
+	if err != nil {
+		sb.runtime.LastError = err.Error()
+	}
+}
+
+func (sb *supervisedBot) recordFailure(err error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	sb.runtime.State = BotRunStateErroring
+	sb.runtime.Restarts++
+	if err != nil {
+		sb.runtime.LastError = err.Error()
+	}
+}
+
+// touch is handed to the runner and called for every received update
+func (sb *supervisedBot) touch() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.runtime.LastUpdate = time.Now()
+}
+
+func (sb *supervisedBot) setProblems(problems []string) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.runtime.Problems = problems
+}
+
+func (sb *supervisedBot) snapshot() BotRuntime {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.runtime
+}
@@ -0,0 +1,579 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"telegram-store-hub/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxCartQuantity caps how many units of one product a cart can hold
+const maxCartQuantity = 99
+
+// Cart errors
+var (
+	ErrProductUnavailable = errors.New("product is not available")
+	ErrCartQuantityLimit  = errors.New("cart quantity limit reached")
+	ErrCartEmpty          = errors.New("cart has no available products")
+	ErrCheckoutNotReady   = errors.New("checkout is not waiting for confirmation")
+	ErrVariantRequired    = errors.New("product is sold by variant")
+)
+
+// Checkout steps, in the order the customer goes through them. Stores
+// without shipping methods start at the address; the city is only asked for
+// region-based rates, and the address not for pickup.
+const (
+	CheckoutStepShipping = "shipping"
+	CheckoutStepCity     = "city"
+	CheckoutStepAddress  = "address"
+	CheckoutStepPhone    = "phone"
+	CheckoutStepNotes    = "notes"
+	CheckoutStepConfirm  = "confirm"
+	CheckoutStepCoupon   = "coupon" // from the review, while a discount code is typed
+)
+
+// checkoutSteps maps a step to the cart column its answer is saved in and
+// the step that follows it
+var checkoutSteps = map[string]struct{ column, next string }{
+	CheckoutStepCity:    {"delivery_city", CheckoutStepAddress},
+	CheckoutStepAddress: {"delivery_address", CheckoutStepPhone},
+	CheckoutStepPhone:   {"delivery_phone", CheckoutStepNotes},
+	CheckoutStepNotes:   {"delivery_notes", CheckoutStepConfirm},
+}
+
+// CartService keeps the shopping carts of store bot customers in the
+// database, so they survive bot restarts and move between instances
+type CartService struct {
+	db       *gorm.DB
+	products *ProductService
+	coupons  *CouponService
+	shipping *ShippingService
+}
+
+// NewCartService creates a new cart service
+func NewCartService(db *gorm.DB) *CartService {
+	return &CartService{
+		db:       db,
+		products: NewProductService(db),
+		coupons:  NewCouponService(db),
+		shipping: NewShippingService(db),
+	}
+}
+
+// GetCart returns the customer's cart in a store with its products. A
+// customer without a cart gets an empty one, which is not saved.
+func (s *CartService) GetCart(storeID uint, customerID int64) (*models.Cart, error) {
+	var cart models.Cart
+	err := s.db.Preload("Items", func(db *gorm.DB) *gorm.DB {
+		return db.Order("cart_items.created_at ASC")
+	}).Preload("Items.Product").Preload("Items.Variant").
+		Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID).
+		First(&cart).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.Cart{StoreID: storeID, CustomerTelegramID: customerID}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cart: %w", err)
+	}
+	return &cart, nil
+}
+
+// AddItem puts one more unit of a product in the customer's cart and returns
+// the new quantity. Only available products of the store can be added, and
+// no more units than are in stock and not reserved by pending orders when
+// the product tracks its stock. Products with variants are added with
+// AddVariant; for them AddItem returns ErrVariantRequired.
+func (s *CartService) AddItem(storeID uint, customerID int64, productID uint) (int, error) {
+	product, err := s.cartProduct(storeID, productID)
+	if err != nil {
+		return 0, err
+	}
+	if HasVariants(product) {
+		return 0, ErrVariantRequired
+	}
+	return s.addItem(storeID, customerID, product, nil)
+}
+
+// AddVariant puts one more unit of a product variant in the customer's cart
+// and returns the new quantity, with the same limits as AddItem
+func (s *CartService) AddVariant(storeID uint, customerID int64, variantID uint) (int, error) {
+	variant, err := s.products.GetVariant(variantID)
+	if errors.Is(err, ErrVariantNotFound) {
+		return 0, ErrProductUnavailable
+	}
+	if err != nil {
+		return 0, err
+	}
+	product, err := s.cartProduct(storeID, variant.ProductID)
+	if err != nil {
+		return 0, err
+	}
+	if !variant.IsAvailable || (product.TrackStock && variant.Stock <= 0) {
+		return 0, ErrProductUnavailable
+	}
+	return s.addItem(storeID, customerID, product, variant)
+}
+
+// addItem adds one unit of a product, or of one of its variants, to the
+// customer's cart
+func (s *CartService) addItem(storeID uint, customerID int64, product *models.Product, variant *models.ProductVariant) (int, error) {
+	quantity := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		cart, err := s.ensureCart(tx, storeID, customerID)
+		if err != nil {
+			return err
+		}
+
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("cart_id = ? AND product_id = ?", cart.ID, product.ID)
+		if variant != nil {
+			query = query.Where("variant_id = ?", variant.ID)
+		} else {
+			query = query.Where("variant_id IS NULL")
+		}
+		var item models.CartItem
+		err = query.First(&item).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		quantity = item.Quantity + 1
+		if quantity > maxCartQuantity {
+			return ErrCartQuantityLimit
+		}
+		if product.TrackStock {
+			available, err := availableStock(tx, product.ID)
+			if err == nil && variant != nil {
+				available, err = availableVariantStock(tx, variant.ID)
+			}
+			if err != nil {
+				return err
+			}
+			if quantity > available {
+				return ErrCartQuantityLimit
+			}
+		}
+
+		if item.ID == 0 {
+			item = models.CartItem{CartID: cart.ID, ProductID: product.ID, Quantity: quantity}
+			if variant != nil {
+				item.VariantID = &variant.ID
+			}
+			return tx.Create(&item).Error
+		}
+		return tx.Model(&item).Update("quantity", quantity).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrCartQuantityLimit) {
+			return quantity - 1, err
+		}
+		return 0, fmt.Errorf("failed to add product to cart: %w", err)
+	}
+	return quantity, nil
+}
+
+// RemoveOne takes one unit of a product out of the customer's cart and
+// returns the quantity left; the item is removed when none is left
+func (s *CartService) RemoveOne(storeID uint, customerID int64, productID uint) (int, error) {
+	return s.removeOne(storeID, customerID, "cart_items.product_id = ? AND cart_items.variant_id IS NULL", productID)
+}
+
+// RemoveOneVariant takes one unit of a product variant out of the
+// customer's cart, like RemoveOne
+func (s *CartService) RemoveOneVariant(storeID uint, customerID int64, variantID uint) (int, error) {
+	return s.removeOne(storeID, customerID, "cart_items.variant_id = ?", variantID)
+}
+
+// removeOne takes one unit out of the cart item matching the condition
+func (s *CartService) removeOne(storeID uint, customerID int64, condition string, id uint) (int, error) {
+	quantity := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var item models.CartItem
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Joins("JOIN carts ON carts.id = cart_items.cart_id").
+			Where("carts.store_id = ? AND carts.customer_telegram_id = ?", storeID, customerID).
+			Where(condition, id).
+			First(&item).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		quantity = item.Quantity - 1
+		if quantity <= 0 {
+			quantity = 0
+			return tx.Delete(&item).Error
+		}
+		return tx.Model(&item).Update("quantity", quantity).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove product from cart: %w", err)
+	}
+	return quantity, nil
+}
+
+// RemoveItem takes a product out of the customer's cart altogether; its
+// variants are taken out with RemoveVariant
+func (s *CartService) RemoveItem(storeID uint, customerID int64, productID uint) error {
+	err := s.db.Where("product_id = ? AND variant_id IS NULL AND cart_id IN (?)", productID,
+		s.db.Model(&models.Cart{}).Select("id").Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID)).
+		Delete(&models.CartItem{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to remove product from cart: %w", err)
+	}
+	return nil
+}
+
+// RemoveVariant takes a product variant out of the customer's cart
+// altogether
+func (s *CartService) RemoveVariant(storeID uint, customerID int64, variantID uint) error {
+	err := s.db.Where("variant_id = ? AND cart_id IN (?)", variantID,
+		s.db.Model(&models.Cart{}).Select("id").Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID)).
+		Delete(&models.CartItem{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to remove variant from cart: %w", err)
+	}
+	return nil
+}
+
+// Clear empties the customer's cart
+func (s *CartService) Clear(storeID uint, customerID int64) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		carts := tx.Model(&models.Cart{}).Select("id").Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID)
+		if err := tx.Where("cart_id IN (?)", carts).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID).Delete(&models.Cart{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clear cart: %w", err)
+	}
+	return nil
+}
+
+// ItemQuantity returns how many units of a product, of any variant, are in
+// the customer's cart
+func (s *CartService) ItemQuantity(storeID uint, customerID int64, productID uint) (int, error) {
+	var quantity int
+	err := s.db.Model(&models.CartItem{}).
+		Joins("JOIN carts ON carts.id = cart_items.cart_id").
+		Where("carts.store_id = ? AND carts.customer_telegram_id = ? AND cart_items.product_id = ?", storeID, customerID, productID).
+		Select("COALESCE(SUM(cart_items.quantity), 0)").Scan(&quantity).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to get cart quantity: %w", err)
+	}
+	return quantity, nil
+}
+
+// VariantQuantity returns how many units of a product variant are in the
+// customer's cart
+func (s *CartService) VariantQuantity(storeID uint, customerID int64, variantID uint) (int, error) {
+	var quantity int
+	err := s.db.Model(&models.CartItem{}).
+		Joins("JOIN carts ON carts.id = cart_items.cart_id").
+		Where("carts.store_id = ? AND carts.customer_telegram_id = ? AND cart_items.variant_id = ?", storeID, customerID, variantID).
+		Select("COALESCE(SUM(cart_items.quantity), 0)").Scan(&quantity).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to get cart quantity: %w", err)
+	}
+	return quantity, nil
+}
+
+// StartCheckout starts collecting the delivery details for the customer's
+// cart, with the choice of a shipping method when the store offers any. It
+// returns ErrCartEmpty when nothing in the cart can be bought.
+func (s *CartService) StartCheckout(storeID uint, customerID int64) error {
+	cart, err := s.GetCart(storeID, customerID)
+	if err != nil {
+		return err
+	}
+	if !hasAvailableItems(cart) {
+		return ErrCartEmpty
+	}
+
+	var methods int64
+	if err := s.db.Model(&models.ShippingMethod{}).Where("store_id = ? AND is_active = ?", storeID, true).
+		Count(&methods).Error; err != nil {
+		return fmt.Errorf("failed to count shipping methods: %w", err)
+	}
+	first := CheckoutStepAddress
+	if methods > 0 {
+		first = CheckoutStepShipping
+	}
+
+	err = s.db.Model(&models.Cart{}).Where("id = ?", cart.ID).Updates(map[string]interface{}{
+		"checkout_step":      first,
+		"shipping_method_id": nil,
+		"delivery_city":      "",
+		"delivery_address":   "",
+		"delivery_phone":     "",
+		"delivery_notes":     "",
+		"coupon_code":        "",
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to start checkout: %w", err)
+	}
+	return nil
+}
+
+// CheckoutStep returns the checkout step waiting for the customer's answer,
+// or "" when the customer isn't checking out
+func (s *CartService) CheckoutStep(storeID uint, customerID int64) (string, error) {
+	var steps []string
+	err := s.db.Model(&models.Cart{}).
+		Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID).
+		Pluck("checkout_step", &steps).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to get checkout step: %w", err)
+	}
+	if len(steps) == 0 {
+		return "", nil
+	}
+	return steps[0], nil
+}
+
+// SaveCheckoutAnswer saves the customer's answer to the current checkout
+// step and moves on. It returns the next step.
+func (s *CartService) SaveCheckoutAnswer(storeID uint, customerID int64, step, answer string) (string, error) {
+	current, ok := checkoutSteps[step]
+	if !ok {
+		return "", fmt.Errorf("checkout step %q takes no answer", step)
+	}
+
+	result := s.db.Model(&models.Cart{}).
+		Where("store_id = ? AND customer_telegram_id = ? AND checkout_step = ?", storeID, customerID, step).
+		Updates(map[string]interface{}{
+			current.column:  answer,
+			"checkout_step": current.next,
+		})
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to save checkout answer: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrCheckoutNotReady
+	}
+	return current.next, nil
+}
+
+// CancelCheckout stops the checkout; the cart is kept
+func (s *CartService) CancelCheckout(storeID uint, customerID int64) error {
+	err := s.db.Model(&models.Cart{}).
+		Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID).
+		Update("checkout_step", "").Error
+	if err != nil {
+		return fmt.Errorf("failed to cancel checkout: %w", err)
+	}
+	return nil
+}
+
+// ShippingMethods returns the shipping methods a customer can choose from
+func (s *CartService) ShippingMethods(storeID uint) ([]models.ShippingMethod, error) {
+	return s.shipping.ListMethods(storeID, true)
+}
+
+// ChooseShippingMethod saves the shipping method the customer picked and
+// moves on to the city, the address or the phone, as the method needs. It
+// returns the next step, or ErrShippingUnavailable for a method the store
+// no longer offers.
+func (s *CartService) ChooseShippingMethod(storeID uint, customerID int64, methodID uint) (string, error) {
+	method, err := loadShippingMethod(s.db, storeID, methodID)
+	if err != nil {
+		return "", err
+	}
+	next := CheckoutStepAddress
+	switch {
+	case !NeedsAddress(method):
+		next = CheckoutStepPhone
+	case method.RateType == models.ShippingRateRegion:
+		next = CheckoutStepCity
+	}
+
+	result := s.db.Model(&models.Cart{}).
+		Where("store_id = ? AND customer_telegram_id = ? AND checkout_step = ?", storeID, customerID, CheckoutStepShipping).
+		Updates(map[string]interface{}{
+			"shipping_method_id": method.ID,
+			"checkout_step":      next,
+		})
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to choose shipping method: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return "", ErrCheckoutNotReady
+	}
+	return next, nil
+}
+
+// CartShipping returns the shipping method of the checkout and its fee for
+// the cart, nil without one, or ErrShippingUnavailable when the store
+// withdrew it
+func (s *CartService) CartShipping(cart *models.Cart) (*models.ShippingMethod, int64, error) {
+	if cart.ShippingMethodID == nil {
+		return nil, 0, nil
+	}
+	method, err := loadShippingMethod(s.db, cart.StoreID, *cart.ShippingMethodID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return method, ShippingFee(method, cart.DeliveryCity, cart.Items), nil
+}
+
+// StartCouponEntry waits for the customer to type a discount code at the
+// checkout review
+func (s *CartService) StartCouponEntry(storeID uint, customerID int64) error {
+	return s.setCheckoutStep(storeID, customerID, CheckoutStepConfirm, CheckoutStepCoupon)
+}
+
+// ApplyCoupon checks a discount code against the customer's cart, keeps it
+// for the order and goes back to the review. It returns the discount, or
+// one of the coupon errors when the code can't be used.
+func (s *CartService) ApplyCoupon(storeID uint, customerID int64, code string) (int64, error) {
+	cart, err := s.GetCart(storeID, customerID)
+	if err != nil {
+		return 0, err
+	}
+	if cart.CheckoutStep != CheckoutStepCoupon && cart.CheckoutStep != CheckoutStepConfirm {
+		return 0, ErrCheckoutNotReady
+	}
+	coupon, discount, err := s.coupons.CheckCoupon(storeID, customerID, code, cart.Items)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.Model(&models.Cart{}).Where("id = ?", cart.ID).Updates(map[string]interface{}{
+		"coupon_code":   coupon.Code,
+		"checkout_step": CheckoutStepConfirm,
+	}).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to apply coupon: %w", err)
+	}
+	return discount, nil
+}
+
+// RemoveCoupon drops the discount code of the checkout and goes back to the
+// review
+func (s *CartService) RemoveCoupon(storeID uint, customerID int64) error {
+	result := s.db.Model(&models.Cart{}).
+		Where("store_id = ? AND customer_telegram_id = ? AND checkout_step IN ?", storeID, customerID,
+			[]string{CheckoutStepConfirm, CheckoutStepCoupon}).
+		Updates(map[string]interface{}{
+			"coupon_code":   "",
+			"checkout_step": CheckoutStepConfirm,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove coupon: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCheckoutNotReady
+	}
+	return nil
+}
+
+// CartDiscount returns the discount the cart's code gives, 0 without a
+// code, or one of the coupon errors when the code can no longer be used
+func (s *CartService) CartDiscount(cart *models.Cart) (int64, error) {
+	if cart.CouponCode == "" {
+		return 0, nil
+	}
+	_, discount, err := s.coupons.CheckCoupon(cart.StoreID, cart.CustomerTelegramID, cart.CouponCode, cart.Items)
+	return discount, err
+}
+
+// setCheckoutStep moves the checkout from one step to another
+func (s *CartService) setCheckoutStep(storeID uint, customerID int64, from, to string) error {
+	result := s.db.Model(&models.Cart{}).
+		Where("store_id = ? AND customer_telegram_id = ? AND checkout_step = ?", storeID, customerID, from).
+		Update("checkout_step", to)
+	if result.Error != nil {
+		return fmt.Errorf("failed to change checkout step: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCheckoutNotReady
+	}
+	return nil
+}
+
+// CartItemAvailable reports whether a cart item can still be bought: its
+// product and variant weren't deleted, made unavailable or sold out since it
+// was added, and the product didn't get variants meanwhile
+func CartItemAvailable(item models.CartItem) bool {
+	product := item.Product
+	if product.ID == 0 || !product.IsAvailable || (product.TrackStock && product.Stock <= 0) {
+		return false
+	}
+	if item.VariantID == nil {
+		return !HasVariants(&product)
+	}
+	variant := item.Variant
+	return variant != nil && variant.IsAvailable && (!product.TrackStock || variant.Stock > 0)
+}
+
+// CartItemPrice is the unit price of a cart item: its variant's price, when
+// the variant has one, or the product's
+func CartItemPrice(item models.CartItem) int64 {
+	if item.Variant != nil && item.Variant.Price > 0 {
+		return item.Variant.Price
+	}
+	return item.Product.Price
+}
+
+// CartItemName is the name of a cart item's product, with its variant
+func CartItemName(item models.CartItem) string {
+	if item.Variant != nil {
+		return variantTitle(item.Product.Name, item.Variant)
+	}
+	return item.Product.Name
+}
+
+// CartTotal is the price of the available items in a cart
+func CartTotal(cart *models.Cart) int64 {
+	var total int64
+	for _, item := range cart.Items {
+		if CartItemAvailable(item) {
+			total += CartItemPrice(item) * int64(item.Quantity)
+		}
+	}
+	return total
+}
+
+// hasAvailableItems reports whether anything in the cart can be bought
+func hasAvailableItems(cart *models.Cart) bool {
+	for _, item := range cart.Items {
+		if CartItemAvailable(item) {
+			return true
+		}
+	}
+	return false
+}
+
+// cartProduct loads a product that may go in a cart of the store
+func (s *CartService) cartProduct(storeID, productID uint) (*models.Product, error) {
+	product, err := s.products.GetProductByID(productID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProductUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	if product.StoreID != storeID || !product.IsAvailable || (product.TrackStock && product.Stock <= 0) {
+		return nil, ErrProductUnavailable
+	}
+	return product, nil
+}
+
+// ensureCart returns the customer's cart, creating it if needed
+func (s *CartService) ensureCart(tx *gorm.DB, storeID uint, customerID int64) (*models.Cart, error) {
+	cart := models.Cart{StoreID: storeID, CustomerTelegramID: customerID}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Omit("Store").Create(&cart).Error; err != nil {
+		return nil, err
+	}
+	if cart.ID != 0 {
+		return &cart, nil
+	}
+	if err := tx.Where("store_id = ? AND customer_telegram_id = ?", storeID, customerID).First(&cart).Error; err != nil {
+		return nil, err
+	}
+	return &cart, nil
+}
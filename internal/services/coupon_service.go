@@ -0,0 +1,427 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Coupon code length limits
+const (
+	minCouponCodeLength = 3
+	maxCouponCodeLength = 32
+)
+
+// couponDateFormat is how sellers write the start and end dates of a coupon
+const couponDateFormat = "2006-01-02"
+
+// Coupon errors. ErrCouponNotFound and the errors after it tell why a
+// customer can't use a code; see IsCouponError.
+var (
+	ErrCouponExists        = errors.New("coupon code already exists")
+	ErrCouponNotFound      = errors.New("coupon not found")
+	ErrCouponInactive      = errors.New("coupon is disabled, not started or ended")
+	ErrCouponUsedUp        = errors.New("coupon has no uses left")
+	ErrCouponCustomerLimit = errors.New("customer has no uses of the coupon left")
+	ErrCouponNotApplicable = errors.New("coupon applies to no item of the cart")
+)
+
+// CouponMinOrderError is returned when a cart is below the minimum order of
+// a coupon
+type CouponMinOrderError struct {
+	MinOrder int64
+}
+
+func (e *CouponMinOrderError) Error() string {
+	return fmt.Sprintf("coupon needs an order of at least %d", e.MinOrder)
+}
+
+// CouponSpecError is returned for a coupon description the seller mistyped;
+// Part is the word that couldn't be understood
+type CouponSpecError struct {
+	Part string
+}
+
+func (e *CouponSpecError) Error() string {
+	return fmt.Sprintf("invalid coupon description near %q", e.Part)
+}
+
+// IsCouponError reports whether err tells why a customer can't use a coupon
+func IsCouponError(err error) bool {
+	var minErr *CouponMinOrderError
+	return errors.Is(err, ErrCouponNotFound) || errors.Is(err, ErrCouponInactive) ||
+		errors.Is(err, ErrCouponUsedUp) || errors.Is(err, ErrCouponCustomerLimit) ||
+		errors.Is(err, ErrCouponNotApplicable) || errors.As(err, &minErr)
+}
+
+// CouponStats is how much a coupon was used. Uses of cancelled, refunded
+// and expired orders don't count.
+type CouponStats struct {
+	Code     string
+	IsActive bool
+	Uses     int64
+	Discount int64
+	Sales    int64 // total of the orders that used the coupon, after discount
+}
+
+// CouponService manages the discount codes of stores
+type CouponService struct {
+	db *gorm.DB
+}
+
+// NewCouponService creates a new coupon service
+func NewCouponService(db *gorm.DB) *CouponService {
+	return &CouponService{db: db}
+}
+
+// NormalizeCouponCode returns a code the way it is stored: trimmed and in
+// upper case, so customers can type it in any case
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ParseCouponSpec reads a coupon from the one-line description sellers send:
+//
+//	CODE VALUE [min=AMOUNT] [uses=N] [per=N] [from=YYYY-MM-DD] [to=YYYY-MM-DD] [product=ID] [category=NAME]
+//
+// VALUE is a percentage such as 20% or an amount in toman. The category
+// takes the rest of the line, so it must come last.
+func ParseCouponSpec(spec string) (*models.Coupon, error) {
+	spec = latinDigits(strings.TrimSpace(spec))
+	if i := strings.Index(spec, "category="); i >= 0 {
+		category := strings.TrimSpace(spec[i+len("category="):])
+		if category == "" {
+			return nil, &CouponSpecError{Part: "category="}
+		}
+		coupon, err := ParseCouponSpec(spec[:i])
+		if err != nil {
+			return nil, err
+		}
+		coupon.Category = category
+		return coupon, nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return nil, &CouponSpecError{Part: spec}
+	}
+	coupon := &models.Coupon{Code: NormalizeCouponCode(fields[0]), IsActive: true}
+
+	value := fields[1]
+	coupon.Type = models.CouponFixed
+	if trimmed := strings.TrimRight(value, "%٪"); trimmed != value {
+		coupon.Type, value = models.CouponPercent, trimmed
+	}
+	amount, err := strconv.ParseInt(strings.ReplaceAll(value, ",", ""), 10, 64)
+	if err != nil {
+		return nil, &CouponSpecError{Part: fields[1]}
+	}
+	coupon.Value = amount
+
+	for _, field := range fields[2:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || value == "" {
+			return nil, &CouponSpecError{Part: field}
+		}
+		switch key {
+		case "min", "uses", "per", "product":
+			n, err := strconv.ParseInt(strings.ReplaceAll(value, ",", ""), 10, 64)
+			if err != nil || n < 0 {
+				return nil, &CouponSpecError{Part: field}
+			}
+			switch key {
+			case "min":
+				coupon.MinOrder = n
+			case "uses":
+				coupon.MaxUses = int(n)
+			case "per":
+				coupon.MaxUsesPerCustomer = int(n)
+			case "product":
+				productID := uint(n)
+				coupon.ProductID = &productID
+			}
+		case "from", "to":
+			day, err := time.ParseInLocation(couponDateFormat, value, time.Local)
+			if err != nil {
+				return nil, &CouponSpecError{Part: field}
+			}
+			if key == "from" {
+				coupon.StartsAt = &day
+			} else {
+				// The coupon works through the end day
+				end := day.AddDate(0, 0, 1)
+				coupon.EndsAt = &end
+			}
+		default:
+			return nil, &CouponSpecError{Part: field}
+		}
+	}
+	return coupon, nil
+}
+
+// CreateCoupon checks a new coupon of a store and saves it. It returns a
+// *CouponSpecError for invalid values and ErrCouponExists when the store
+// already has the code.
+func (s *CouponService) CreateCoupon(coupon *models.Coupon) error {
+	coupon.Code = NormalizeCouponCode(coupon.Code)
+	if err := validateCoupon(coupon); err != nil {
+		return err
+	}
+	if coupon.ProductID != nil {
+		var count int64
+		if err := s.db.Model(&models.Product{}).Where("id = ? AND store_id = ?", *coupon.ProductID, coupon.StoreID).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check coupon product: %w", err)
+		}
+		if count == 0 {
+			return &CouponSpecError{Part: fmt.Sprintf("product=%d", *coupon.ProductID)}
+		}
+	}
+
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(coupon)
+	if result.Error != nil {
+		return fmt.Errorf("failed to create coupon: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCouponExists
+	}
+	return nil
+}
+
+// ListCoupons returns the coupons of a store, newest first
+func (s *CouponService) ListCoupons(storeID uint) ([]models.Coupon, error) {
+	var coupons []models.Coupon
+	if err := s.db.Where("store_id = ?", storeID).Order("created_at DESC").Find(&coupons).Error; err != nil {
+		return nil, fmt.Errorf("failed to list coupons: %w", err)
+	}
+	return coupons, nil
+}
+
+// GetCoupon returns a coupon of a store by its code
+func (s *CouponService) GetCoupon(storeID uint, code string) (*models.Coupon, error) {
+	var coupon models.Coupon
+	err := s.db.Where("store_id = ? AND code = ?", storeID, NormalizeCouponCode(code)).First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coupon: %w", err)
+	}
+	return &coupon, nil
+}
+
+// SetCouponActive enables or disables a coupon of a store
+func (s *CouponService) SetCouponActive(storeID, couponID uint, active bool) error {
+	result := s.db.Model(&models.Coupon{}).Where("id = ? AND store_id = ?", couponID, storeID).Update("is_active", active)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update coupon: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCouponNotFound
+	}
+	return nil
+}
+
+// CheckCoupon returns the coupon of a code and the discount it gives the
+// customer on the available items of the cart, or why it can't be used
+func (s *CouponService) CheckCoupon(storeID uint, customerID int64, code string, items []models.CartItem) (*models.Coupon, int64, error) {
+	coupon, err := s.GetCoupon(storeID, code)
+	if err != nil {
+		return nil, 0, err
+	}
+	discount, err := checkCoupon(s.db, coupon, customerID, items, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	return coupon, discount, nil
+}
+
+// RedemptionStats returns how much each coupon of a store was used, the
+// most used first
+func (s *CouponService) RedemptionStats(storeID uint) ([]CouponStats, error) {
+	var stats []CouponStats
+	err := s.db.Model(&models.Coupon{}).
+		Select(`coupons.code, coupons.is_active, COUNT(coupon_redemptions.id) AS uses,
+			COALESCE(SUM(coupon_redemptions.discount), 0) AS discount, COALESCE(SUM(orders.total_amount), 0) AS sales`).
+		Joins("LEFT JOIN coupon_redemptions ON coupon_redemptions.coupon_id = coupons.id").
+		Joins("LEFT JOIN orders ON orders.id = coupon_redemptions.order_id").
+		Where("coupons.store_id = ?", storeID).
+		Group("coupons.id, coupons.code, coupons.is_active").
+		Order("uses DESC, coupons.code ASC").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coupon stats: %w", err)
+	}
+	return stats, nil
+}
+
+// CouponDiscount is the discount a coupon gives on the available items of a
+// cart that are in its scope
+func CouponDiscount(coupon *models.Coupon, items []models.CartItem) int64 {
+	var eligible int64
+	for _, item := range items {
+		if CartItemAvailable(item) && couponCovers(coupon, item.Product) {
+			eligible += CartItemPrice(item) * int64(item.Quantity)
+		}
+	}
+
+	discount := coupon.Value
+	if coupon.Type == models.CouponPercent {
+		discount = eligible * coupon.Value / 100
+	}
+	return min(discount, eligible)
+}
+
+// couponCovers reports whether a product is in the scope of a coupon
+func couponCovers(coupon *models.Coupon, product models.Product) bool {
+	if coupon.ProductID != nil && product.ID != *coupon.ProductID {
+		return false
+	}
+	if coupon.Category != "" && !strings.EqualFold(strings.TrimSpace(product.Category), coupon.Category) {
+		return false
+	}
+	return true
+}
+
+// checkCoupon returns the discount a coupon gives a customer on the items,
+// or why it can't be used
+func checkCoupon(db *gorm.DB, coupon *models.Coupon, customerID int64, items []models.CartItem, now time.Time) (int64, error) {
+	if !coupon.IsActive || (coupon.StartsAt != nil && now.Before(*coupon.StartsAt)) ||
+		(coupon.EndsAt != nil && !now.Before(*coupon.EndsAt)) {
+		return 0, ErrCouponInactive
+	}
+	if coupon.MaxUses > 0 && coupon.UsedCount >= coupon.MaxUses {
+		return 0, ErrCouponUsedUp
+	}
+	if coupon.MaxUsesPerCustomer > 0 {
+		var uses int64
+		if err := db.Model(&models.CouponRedemption{}).
+			Where("coupon_id = ? AND customer_telegram_id = ?", coupon.ID, customerID).
+			Count(&uses).Error; err != nil {
+			return 0, fmt.Errorf("failed to count coupon uses: %w", err)
+		}
+		if uses >= int64(coupon.MaxUsesPerCustomer) {
+			return 0, ErrCouponCustomerLimit
+		}
+	}
+
+	var subtotal int64
+	for _, item := range items {
+		if CartItemAvailable(item) {
+			subtotal += CartItemPrice(item) * int64(item.Quantity)
+		}
+	}
+	if subtotal < coupon.MinOrder {
+		return 0, &CouponMinOrderError{MinOrder: coupon.MinOrder}
+	}
+
+	discount := CouponDiscount(coupon, items)
+	if discount <= 0 {
+		return 0, ErrCouponNotApplicable
+	}
+	return discount, nil
+}
+
+// redeemCoupon uses a coupon of the store for an order and returns its
+// discount. The coupon is locked so that its usage limits hold when
+// customers check out at the same time.
+func redeemCoupon(tx *gorm.DB, storeID uint, code string, orderID uint, customerID int64, items []models.CartItem) (*models.Coupon, int64, error) {
+	var coupon models.Coupon
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("store_id = ? AND code = ?", storeID, NormalizeCouponCode(code)).
+		First(&coupon).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, 0, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	discount, err := checkCoupon(tx, &coupon, customerID, items, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	redemption := models.CouponRedemption{
+		CouponID:           coupon.ID,
+		OrderID:            orderID,
+		CustomerTelegramID: customerID,
+		Discount:           discount,
+	}
+	if err := tx.Create(&redemption).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := tx.Model(&coupon).UpdateColumn("used_count", gorm.Expr("used_count + 1")).Error; err != nil {
+		return nil, 0, err
+	}
+	return &coupon, discount, nil
+}
+
+// releaseCoupon gives back the coupon use of an order that won't go through
+func releaseCoupon(tx *gorm.DB, orderID uint) error {
+	var redemption models.CouponRedemption
+	err := tx.Where("order_id = ?", orderID).First(&redemption).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Delete(&redemption).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Coupon{}).Where("id = ? AND used_count > 0", redemption.CouponID).
+		UpdateColumn("used_count", gorm.Expr("used_count - 1")).Error
+}
+
+// validateCoupon checks the values of a new coupon
+func validateCoupon(coupon *models.Coupon) error {
+	if n := len(coupon.Code); n < minCouponCodeLength || n > maxCouponCodeLength {
+		return &CouponSpecError{Part: coupon.Code}
+	}
+	for _, r := range coupon.Code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return &CouponSpecError{Part: coupon.Code}
+		}
+	}
+
+	switch coupon.Type {
+	case models.CouponPercent:
+		if coupon.Value < 1 || coupon.Value > 100 {
+			return &CouponSpecError{Part: fmt.Sprintf("%d%%", coupon.Value)}
+		}
+	case models.CouponFixed:
+		if coupon.Value < 1 {
+			return &CouponSpecError{Part: strconv.FormatInt(coupon.Value, 10)}
+		}
+	default:
+		return &CouponSpecError{Part: coupon.Type}
+	}
+
+	if coupon.MinOrder < 0 || coupon.MaxUses < 0 || coupon.MaxUsesPerCustomer < 0 {
+		return &CouponSpecError{Part: coupon.Code}
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.StartsAt.Before(*coupon.EndsAt) {
+		return &CouponSpecError{Part: "to=" + coupon.EndsAt.AddDate(0, 0, -1).Format(couponDateFormat)}
+	}
+	return nil
+}
+
+// latinDigits replaces Persian and Arabic digits with Latin ones
+func latinDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '۰' && r <= '۹':
+			return '0' + (r - '۰')
+		case r >= '٠' && r <= '٩':
+			return '0' + (r - '٠')
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,297 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LowKeyPoolThreshold is the number of license keys left in a product's
+// pool at which the seller is warned
+const LowKeyPoolThreshold = 3
+
+// Digital product errors
+var (
+	ErrDigitalProductNotFound = errors.New("product not found in store")
+	ErrNoLicenseKeys          = errors.New("no license keys given")
+	ErrNoDigitalDelivery      = errors.New("order has nothing to deliver")
+)
+
+// DigitalDelivery is what the customer receives for a digital order item:
+// the product's file or the license keys taken for the item
+type DigitalDelivery struct {
+	Item   models.OrderItem // with its product
+	FileID string
+	Keys   []string
+}
+
+// DigitalService manages the digital products of stores: their files and
+// license key pools, and what their orders deliver
+type DigitalService struct {
+	db *gorm.DB
+}
+
+// NewDigitalService creates a new digital product service
+func NewDigitalService(db *gorm.DB) *DigitalService {
+	return &DigitalService{db: db}
+}
+
+// SetDigitalFile makes a product of the store a file the store bot sends
+// once the order is confirmed. The file ID must come from the store's bot;
+// files are not counted as stock.
+func (s *DigitalService) SetDigitalFile(storeID, productID uint, fileID string) (*models.Product, error) {
+	product, err := s.storeProduct(s.db, storeID, productID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Model(product).Updates(map[string]interface{}{
+		"digital_type":    models.DigitalFile,
+		"digital_file_id": fileID,
+		"track_stock":     false,
+	}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to set digital file: %w", err)
+	}
+	return product, nil
+}
+
+// AddLicenseKeys adds keys, one per line, to the pool of a product of the
+// store and makes it a license product. Keys already in the pool are
+// skipped. The product's stock becomes the number of keys in its pool. It
+// returns how many keys were added and how many the pool has.
+func (s *DigitalService) AddLicenseKeys(storeID, productID uint, keys string) (int, int, error) {
+	var lines []string
+	for _, line := range strings.Split(keys, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return 0, 0, ErrNoLicenseKeys
+	}
+
+	added := 0
+	var pool int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		product, err := s.storeProduct(tx.Clauses(clause.Locking{Strength: "UPDATE"}), storeID, productID)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.LicenseKey{ProductID: product.ID, Key: line})
+			if result.Error != nil {
+				return result.Error
+			}
+			added += int(result.RowsAffected)
+		}
+		if pool, err = keyPoolSize(tx, product.ID); err != nil {
+			return err
+		}
+		return tx.Model(product).Updates(map[string]interface{}{
+			"digital_type":    models.DigitalLicense,
+			"digital_file_id": "",
+			"track_stock":     true,
+			"stock":           pool,
+		}).Error
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to add license keys: %w", err)
+	}
+	return added, int(pool), nil
+}
+
+// MakePhysical turns a digital product of the store back into a physical
+// one. Keys left in its pool are kept for when it becomes digital again.
+func (s *DigitalService) MakePhysical(storeID, productID uint) error {
+	product, err := s.storeProduct(s.db, storeID, productID)
+	if err != nil {
+		return err
+	}
+	err = s.db.Model(product).Updates(map[string]interface{}{
+		"digital_type":    "",
+		"digital_file_id": "",
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to make product physical: %w", err)
+	}
+	return nil
+}
+
+// KeyPoolSize returns how many license keys of a product are left to sell
+func (s *DigitalService) KeyPoolSize(productID uint) (int, error) {
+	pool, err := keyPoolSize(s.db, productID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count license keys: %w", err)
+	}
+	return int(pool), nil
+}
+
+// OrderDeliveries returns what a confirmed order of the customer delivers,
+// for the customer to receive it again. Orders of other stores or
+// customers are reported as ErrOrderNotFound, and orders neither confirmed
+// nor paid yet or without digital items as ErrNoDigitalDelivery.
+func (s *DigitalService) OrderDeliveries(storeID uint, customerTelegramID int64, orderID uint) ([]DigitalDelivery, error) {
+	var order models.Order
+	err := s.db.Where("id = ? AND store_id = ? AND customer_telegram_id = ?", orderID, storeID, customerTelegramID).
+		First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if !DigitalDelivered(&order) {
+		return nil, ErrNoDigitalDelivery
+	}
+
+	deliveries, err := orderDeliveries(s.db, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, ErrNoDigitalDelivery
+	}
+	return deliveries, nil
+}
+
+// MarkDelivered records that the digital items of an order were sent
+func (s *DigitalService) MarkDelivered(deliveries []DigitalDelivery) error {
+	return markDelivered(s.db, deliveries)
+}
+
+// DigitalDelivered reports whether the digital items of an order were
+// delivered: it was confirmed, or paid while still pending, and it wasn't
+// taken back
+func DigitalDelivered(order *models.Order) bool {
+	switch order.Status {
+	case models.OrderStatusConfirmed, models.OrderStatusShipped, models.OrderStatusDelivered:
+		return true
+	case models.OrderStatusPending:
+		return order.PaymentStatus == models.PaymentStatusPaid
+	}
+	return false
+}
+
+// DigitalMessages returns the messages that deliver a digital order item to
+// a chat
+func DigitalMessages(chatID int64, delivery DigitalDelivery) []tgbotapi.Chattable {
+	name := OrderItemName(delivery.Item)
+	if delivery.FileID != "" {
+		document := tgbotapi.NewDocument(chatID, tgbotapi.FileID(delivery.FileID))
+		document.Caption = fmt.Sprintf(messages.DigitalFileCaption, name, delivery.Item.OrderID)
+		return []tgbotapi.Chattable{document}
+	}
+	text := fmt.Sprintf(messages.DigitalKeysMessage, name, delivery.Item.OrderID, strings.Join(delivery.Keys, "\n"))
+	return []tgbotapi.Chattable{tgbotapi.NewMessage(chatID, text)}
+}
+
+// assignLicenseKeys takes keys from the pools of an order's license
+// products for the items that have none yet. The order's stock must have
+// been committed, which keeps the pools and the stock in step; a pool that
+// ran short anyway is reported as an *OutOfStockError.
+func assignLicenseKeys(tx *gorm.DB, orderID uint) error {
+	var items []models.OrderItem
+	err := tx.Preload("Product").
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.order_id = ? AND products.digital_type = ?", orderID, models.DigitalLicense).
+		Where("NOT EXISTS (SELECT 1 FROM license_keys WHERE license_keys.order_item_id = order_items.id)").
+		Order("order_items.id ASC").Find(&items).Error
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var keys []models.LicenseKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ? AND order_item_id IS NULL", item.ProductID).
+			Order("id ASC").Limit(item.Quantity).Find(&keys).Error
+		if err != nil {
+			return err
+		}
+		if len(keys) < item.Quantity {
+			return &OutOfStockError{ProductID: item.ProductID, Product: item.Product.Name, Available: len(keys)}
+		}
+		ids := make([]uint, len(keys))
+		for i, key := range keys {
+			ids[i] = key.ID
+		}
+		if err := tx.Model(&models.LicenseKey{}).Where("id IN ?", ids).Update("order_item_id", item.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// orderDeliveries returns what the digital items of an order deliver
+func orderDeliveries(db *gorm.DB, orderID uint) ([]DigitalDelivery, error) {
+	var items []models.OrderItem
+	err := db.Preload("Product").
+		Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.order_id = ? AND products.digital_type <> ?", orderID, "").
+		Order("order_items.id ASC").Find(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digital items: %w", err)
+	}
+
+	var deliveries []DigitalDelivery
+	for _, item := range items {
+		delivery := DigitalDelivery{Item: item}
+		switch item.Product.DigitalType {
+		case models.DigitalFile:
+			delivery.FileID = item.Product.DigitalFileID
+		case models.DigitalLicense:
+			if err := db.Model(&models.LicenseKey{}).Where("order_item_id = ?", item.ID).
+				Order("id ASC").Pluck("key", &delivery.Keys).Error; err != nil {
+				return nil, fmt.Errorf("failed to get license keys: %w", err)
+			}
+		}
+		if delivery.FileID == "" && len(delivery.Keys) == 0 {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// markDelivered records when digital order items were sent
+func markDelivered(db *gorm.DB, deliveries []DigitalDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(deliveries))
+	for i, delivery := range deliveries {
+		ids[i] = delivery.Item.ID
+	}
+	if err := db.Model(&models.OrderItem{}).Where("id IN ?", ids).Update("delivered_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to mark digital items delivered: %w", err)
+	}
+	return nil
+}
+
+// keyPoolSize counts the license keys of a product not delivered yet
+func keyPoolSize(db *gorm.DB, productID uint) (int64, error) {
+	var pool int64
+	err := db.Model(&models.LicenseKey{}).Where("product_id = ? AND order_item_id IS NULL", productID).Count(&pool).Error
+	return pool, err
+}
+
+// storeProduct loads a product of the store
+func (s *DigitalService) storeProduct(db *gorm.DB, storeID, productID uint) (*models.Product, error) {
+	var product models.Product
+	err := db.Where("id = ? AND store_id = ?", productID, storeID).First(&product).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDigitalProductNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product: %w", err)
+	}
+	return &product, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Processed actions are kept long enough for the buttons of a decision to
+// stop being tapped; the actions themselves check the state they change.
+const (
+	processedActionTTL   = 30 * 24 * time.Hour
+	processedActionEvery = 6 * time.Hour
+)
+
+// ErrActionDone is returned by Once when the action has already succeeded
+var ErrActionDone = errors.New("action was already done")
+
+// IdempotencyService makes state-changing actions, like approving a payment
+// from a button, take effect only once however often they are triggered
+type IdempotencyService struct {
+	db     *gorm.DB
+	leases *LeaseService // only the leader cleans up when set
+}
+
+// NewIdempotencyService creates a new idempotency service
+func NewIdempotencyService(db *gorm.DB) *IdempotencyService {
+	return &IdempotencyService{db: db}
+}
+
+// SetLeaseService makes only the leader instance clean up processed actions
+func (s *IdempotencyService) SetLeaseService(leases *LeaseService) {
+	s.leases = leases
+}
+
+// Once runs action unless an action with the same key has already succeeded,
+// in which case it returns ErrActionDone. The key is claimed in the
+// transaction passed to action, which only commits when action returns nil:
+// the action's writes must go through it so they take effect with the key,
+// and a failed action can be retried. A concurrent duplicate waits for the
+// first one to finish, so the action must not wait on anything else, like
+// sending messages; those belong after Once returns.
+func (s *IdempotencyService) Once(key string, action func(tx *gorm.DB) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.ProcessedAction{Key: key})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrActionDone
+		}
+		return action(tx)
+	})
+}
+
+// CleanupProcessedActions forgets the actions processed before the TTL
+func (s *IdempotencyService) CleanupProcessedActions() error {
+	result := s.db.Where("created_at < ?", time.Now().Add(-processedActionTTL)).Delete(&models.ProcessedAction{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to clean up processed actions: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("Cleaned up %d processed actions", result.RowsAffected)
+	}
+	return nil
+}
+
+// StartCleanup cleans up processed actions periodically. With sharded
+// instances only the leader does.
+func (s *IdempotencyService) StartCleanup() {
+	go func() {
+		ticker := time.NewTicker(processedActionEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !s.leases.IsLeader() {
+				continue
+			}
+			if err := s.CleanupProcessedActions(); err != nil {
+				log.Printf("⚠️ %v", err)
+			}
+		}
+	}()
+}
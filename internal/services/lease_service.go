@@ -0,0 +1,238 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Lease timing. A lease outlives two missed heartbeats, so a slow database
+// doesn't make bots hop between instances.
+const (
+	leaseTTL            = 30 * time.Second
+	leaseHeartbeatEvery = 10 * time.Second
+)
+
+// Lease names
+const (
+	// MotherBotLeaseName is held by the instance polling the mother bot
+	MotherBotLeaseName = "mother_bot"
+
+	leaderLeaseName     = "leader"
+	instanceLeasePrefix = "instance:"
+	storeBotLeasePrefix = "store_bot:"
+)
+
+// LeaseService lets several instances of the platform share the work. Every
+// instance holds an instance lease while it is alive, one of them holds the
+// leader lease and runs the singleton jobs, and each store bot is run by the
+// instance holding its lease. Leases live in Postgres and are kept by a
+// heartbeat; the leases of a dead instance expire and are taken over.
+type LeaseService struct {
+	db     *gorm.DB
+	holder string
+
+	mu     sync.Mutex
+	held   map[string]time.Time // lease name -> when it expires at the latest
+	leader bool
+	stop   chan struct{}
+}
+
+// NewLeaseService creates a lease service for the instance named holder
+func NewLeaseService(db *gorm.DB, holder string) *LeaseService {
+	return &LeaseService{
+		db:     db,
+		holder: holder,
+		held:   make(map[string]time.Time),
+	}
+}
+
+// Holder returns the name of this instance
+func (s *LeaseService) Holder() string {
+	return s.holder
+}
+
+// Start registers the instance, runs the first leader election and keeps
+// the leases renewed in the background until Stop is called
+func (s *LeaseService) Start() {
+	s.mu.Lock()
+	if s.stop != nil {
+		s.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	s.stop = stop
+	s.mu.Unlock()
+
+	s.heartbeat()
+
+	go func() {
+		ticker := time.NewTicker(leaseHeartbeatEvery)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.heartbeat()
+			}
+		}
+	}()
+
+	log.Printf("Lease heartbeat started for instance %s", s.holder)
+}
+
+// Stop ends the heartbeat and releases every lease of the instance so the
+// other instances can take over right away
+func (s *LeaseService) Stop() {
+	s.mu.Lock()
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
+	s.held = make(map[string]time.Time)
+	s.leader = false
+	s.mu.Unlock()
+
+	if err := s.db.Exec("DELETE FROM leases WHERE holder = ?", s.holder).Error; err != nil {
+		log.Printf("Failed to release leases of instance %s: %v", s.holder, err)
+	}
+}
+
+// IsLeader reports whether this instance runs the singleton jobs. Without a
+// lease service there is only one instance, which is always the leader.
+func (s *LeaseService) IsLeader() bool {
+	if s == nil {
+		return true
+	}
+	return s.Holds(leaderLeaseName)
+}
+
+// Holds reports whether this instance still holds a lease
+func (s *LeaseService) Holds(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.held[name])
+}
+
+// HoldsFor reports whether this instance holds a lease for at least d more,
+// so work taking up to d ends before another instance may take it over
+func (s *LeaseService) HoldsFor(name string, d time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Add(d).Before(s.held[name])
+}
+
+// Acquire takes a lease that is free or expired, or renews it when this
+// instance already holds it. It returns false when another instance holds it.
+func (s *LeaseService) Acquire(name string) (bool, error) {
+	start := time.Now()
+	result := s.db.Exec(`INSERT INTO leases (name, holder, expires_at, updated_at)
+		VALUES (?, ?, NOW() + ?::interval, NOW())
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at, updated_at = EXCLUDED.updated_at
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires_at < NOW()`,
+		name, s.holder, leaseInterval())
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to acquire lease %s: %w", name, result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	s.mu.Lock()
+	s.held[name] = start.Add(leaseTTL)
+	s.mu.Unlock()
+	return true, nil
+}
+
+// Release gives up a lease, if this instance holds it
+func (s *LeaseService) Release(name string) {
+	s.mu.Lock()
+	delete(s.held, name)
+	s.mu.Unlock()
+
+	if err := s.db.Exec("DELETE FROM leases WHERE name = ? AND holder = ?", name, s.holder).Error; err != nil {
+		log.Printf("Failed to release lease %s: %v", name, err)
+	}
+}
+
+// Await blocks until this instance holds the lease
+func (s *LeaseService) Await(name string) {
+	for {
+		ok, err := s.Acquire(name)
+		if err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+		if ok {
+			return
+		}
+		time.Sleep(leaseHeartbeatEvery)
+	}
+}
+
+// LiveInstances counts the instances whose heartbeat is current
+func (s *LeaseService) LiveInstances() (int, error) {
+	var count int64
+	if err := s.db.Table("leases").Where("name LIKE ? AND expires_at > NOW()", instanceLeasePrefix+"%").
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count instances: %w", err)
+	}
+	return int(count), nil
+}
+
+// heartbeat keeps the instance registered, tries for leadership and renews
+// the other leases. Leases that could not be renewed lapse locally once they
+// may have expired, so their work stops before another instance takes over.
+func (s *LeaseService) heartbeat() {
+	if _, err := s.Acquire(instanceLeasePrefix + s.holder); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	if _, err := s.Acquire(leaderLeaseName); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+
+	start := time.Now()
+	var names []string
+	if err := s.db.Raw(`UPDATE leases SET expires_at = NOW() + ?::interval, updated_at = NOW()
+		WHERE holder = ? AND expires_at > NOW() RETURNING name`, leaseInterval(), s.holder).
+		Scan(&names).Error; err != nil {
+		log.Printf("⚠️ Failed to renew leases of instance %s: %v", s.holder, err)
+	} else {
+		s.mu.Lock()
+		for _, name := range names {
+			s.held[name] = start.Add(leaseTTL)
+		}
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	for name, expires := range s.held {
+		if !start.Before(expires) {
+			delete(s.held, name)
+		}
+	}
+	leader := start.Before(s.held[leaderLeaseName])
+	changed := leader != s.leader
+	s.leader = leader
+	s.mu.Unlock()
+
+	if changed && leader {
+		log.Printf("👑 Instance %s is now the leader", s.holder)
+	} else if changed {
+		log.Printf("Instance %s is no longer the leader", s.holder)
+	}
+}
+
+// leaseInterval is leaseTTL as a Postgres interval
+func leaseInterval() string {
+	return fmt.Sprintf("%d milliseconds", leaseTTL.Milliseconds())
+}
+
+// storeBotLeaseName is the lease an instance must hold to run a store bot
+func storeBotLeaseName(storeID uint) string {
+	return fmt.Sprintf("%s%d", storeBotLeasePrefix, storeID)
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxTemplateLength keeps a filled-in template well under Telegram's
+// 4096 character limit
+const maxTemplateLength = 1000
+
+// Message template errors
+var (
+	ErrUnknownTemplate = errors.New("unknown message template")
+	ErrTemplateLength  = errors.New("message template is empty or too long")
+)
+
+// MessageTemplateInfo describes a message a store can reword
+type MessageTemplateInfo struct {
+	Key     string
+	Name    string
+	Default string
+}
+
+// messageTemplates are the messages stores can reword, in the order sellers
+// see them
+var messageTemplates = []MessageTemplateInfo{
+	{OrderStatusTemplateKey(models.OrderStatusConfirmed), messages.OrderStatusConfirmedLabel, messages.OrderConfirmedTemplate},
+	{OrderStatusTemplateKey(models.OrderStatusShipped), messages.OrderStatusShippedLabel, messages.OrderShippedTemplate},
+	{OrderStatusTemplateKey(models.OrderStatusDelivered), messages.OrderStatusDeliveredLabel, messages.OrderDeliveredTemplate},
+	{OrderStatusTemplateKey(models.OrderStatusCancelled), messages.OrderStatusCancelledLabel, messages.OrderCancelledTemplate},
+	{OrderStatusTemplateKey(models.OrderStatusRefunded), messages.OrderStatusRefundedLabel, messages.OrderRefundedTemplate},
+	{OrderStatusTemplateKey(models.OrderStatusExpired), messages.OrderStatusExpiredLabel, messages.OrderExpiredTemplate},
+}
+
+// OrderStatusTemplateKey is the template key of the notice for an order
+// status, e.g. "order_shipped"
+func OrderStatusTemplateKey(status models.OrderStatus) string {
+	return "order_" + string(status)
+}
+
+// MessageTemplates returns the messages stores can reword
+func MessageTemplates() []MessageTemplateInfo {
+	return append([]MessageTemplateInfo(nil), messageTemplates...)
+}
+
+// LookupMessageTemplate returns the description of a template key
+func LookupMessageTemplate(key string) (MessageTemplateInfo, bool) {
+	for _, info := range messageTemplates {
+		if info.Key == key {
+			return info, true
+		}
+	}
+	return MessageTemplateInfo{}, false
+}
+
+// RenderTemplate fills the {placeholders} of a template
+func RenderTemplate(text string, values map[string]string) string {
+	pairs := make([]string, 0, 2*len(values))
+	for name, value := range values {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// MessageTemplateService keeps the stores' own wording of their bot messages
+type MessageTemplateService struct {
+	db *gorm.DB
+}
+
+// NewMessageTemplateService creates a new message template service
+func NewMessageTemplateService(db *gorm.DB) *MessageTemplateService {
+	return &MessageTemplateService{db: db}
+}
+
+// Get returns the store's text for a template, or the default text when the
+// store didn't reword it
+func (s *MessageTemplateService) Get(storeID uint, key string) (string, error) {
+	info, ok := LookupMessageTemplate(key)
+	if !ok {
+		return "", ErrUnknownTemplate
+	}
+
+	var texts []string
+	if err := s.db.Model(&models.MessageTemplate{}).
+		Where("store_id = ? AND key = ?", storeID, key).
+		Pluck("text", &texts).Error; err != nil {
+		return "", fmt.Errorf("failed to get message template: %w", err)
+	}
+	if len(texts) == 0 {
+		return info.Default, nil
+	}
+	return texts[0], nil
+}
+
+// GetStoreTemplates returns the templates a store reworded, by key
+func (s *MessageTemplateService) GetStoreTemplates(storeID uint) (map[string]string, error) {
+	var templates []models.MessageTemplate
+	if err := s.db.Where("store_id = ?", storeID).Find(&templates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get message templates: %w", err)
+	}
+
+	texts := make(map[string]string, len(templates))
+	for _, template := range templates {
+		texts[template.Key] = template.Text
+	}
+	return texts, nil
+}
+
+// Set saves the store's own text for a template
+func (s *MessageTemplateService) Set(storeID uint, key, text string) error {
+	if _, ok := LookupMessageTemplate(key); !ok {
+		return ErrUnknownTemplate
+	}
+	text = strings.TrimSpace(text)
+	if text == "" || utf8.RuneCountInString(text) > maxTemplateLength {
+		return ErrTemplateLength
+	}
+
+	template := models.MessageTemplate{StoreID: storeID, Key: key, Text: text}
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "store_id"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"text", "updated_at"}),
+	}).Create(&template).Error
+	if err != nil {
+		return fmt.Errorf("failed to save message template: %w", err)
+	}
+	return nil
+}
+
+// Reset brings a template of the store back to its default text
+func (s *MessageTemplateService) Reset(storeID uint, key string) error {
+	err := s.db.Where("store_id = ? AND key = ?", storeID, key).Delete(&models.MessageTemplate{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to reset message template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+)
+
+// notifierRequestTimeout bounds a status notice sent through a store bot
+const notifierRequestTimeout = 30 * time.Second
+
+// OrderNotifier tells customers about the status changes of their orders
+// and delivers their digital items. The notices go out through the store's
+// own bot, in the chat the customer ordered in, with the store's wording
+// when it has one.
+type OrderNotifier struct {
+	templates *MessageTemplateService
+
+	mu   sync.Mutex
+	bots map[string]*tgbotapi.BotAPI // by token
+}
+
+// NewOrderNotifier creates a new order notifier
+func NewOrderNotifier(db *gorm.DB) *OrderNotifier {
+	return &OrderNotifier{
+		templates: NewMessageTemplateService(db),
+		bots:      make(map[string]*tgbotapi.BotAPI),
+	}
+}
+
+// NotifyStatusChange sends the customer the notice of a status change. The
+// order must come with its store. Stores without a bot are skipped.
+func (n *OrderNotifier) NotifyStatusChange(order *models.Order, event *models.OrderStatusEvent) error {
+	if order.Store.BotToken == "" || order.CustomerTelegramID == 0 {
+		return nil
+	}
+
+	text, err := n.StatusNotice(order, event)
+	if err != nil {
+		return err
+	}
+	return n.NotifyCustomer(order, text)
+}
+
+// NotifyCustomer sends the customer of an order a message through the
+// store's bot. The order must come with its store.
+func (n *OrderNotifier) NotifyCustomer(order *models.Order, text string) error {
+	if order.Store.BotToken == "" || order.CustomerTelegramID == 0 {
+		return nil
+	}
+	if err := n.send(order, tgbotapi.NewMessage(order.CustomerTelegramID, text)); err != nil {
+		return fmt.Errorf("failed to notify customer of order %d: %w", order.ID, err)
+	}
+	return nil
+}
+
+// NotifyOwner sends the owner of an order's store a message through the
+// store's bot. The order must come with its store.
+func (n *OrderNotifier) NotifyOwner(order *models.Order, ownerTelegramID int64, text string) error {
+	if order.Store.BotToken == "" || ownerTelegramID == 0 {
+		return nil
+	}
+	if err := n.send(order, tgbotapi.NewMessage(ownerTelegramID, text)); err != nil {
+		return fmt.Errorf("failed to notify owner of store %d: %w", order.StoreID, err)
+	}
+	return nil
+}
+
+// DeliverDigital sends the customer of an order its digital items through
+// the store's bot, whose files they are. It returns the deliveries that
+// were sent. The order must come with its store.
+func (n *OrderNotifier) DeliverDigital(order *models.Order, deliveries []DigitalDelivery) ([]DigitalDelivery, error) {
+	if order.Store.BotToken == "" || order.CustomerTelegramID == 0 {
+		return nil, nil
+	}
+	var sent []DigitalDelivery
+	for _, delivery := range deliveries {
+		for _, message := range DigitalMessages(order.CustomerTelegramID, delivery) {
+			if err := n.send(order, message); err != nil {
+				return sent, fmt.Errorf("failed to deliver order %d: %w", order.ID, err)
+			}
+		}
+		sent = append(sent, delivery)
+	}
+	return sent, nil
+}
+
+// send sends a message through the bot of an order's store
+func (n *OrderNotifier) send(order *models.Order, message tgbotapi.Chattable) error {
+	bot, err := n.storeBot(order.Store.BotToken)
+	if err != nil {
+		return fmt.Errorf("failed to reach bot of store %d: %w", order.StoreID, err)
+	}
+	if _, err := bot.Send(message); err != nil {
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusUnauthorized {
+			n.forget(order.Store.BotToken)
+		}
+		return err
+	}
+	return nil
+}
+
+// StatusNotice returns the text of the notice of a status change. The
+// tracking code and the reason are added below the store's text when its
+// template doesn't place them itself.
+func (n *OrderNotifier) StatusNotice(order *models.Order, event *models.OrderStatusEvent) (string, error) {
+	template, err := n.templates.Get(order.StoreID, OrderStatusTemplateKey(event.ToStatus))
+	if err != nil {
+		return "", err
+	}
+
+	reason := ""
+	if event.ToStatus == models.OrderStatusCancelled || event.ToStatus == models.OrderStatusRefunded {
+		reason = event.Note
+	}
+
+	text := RenderTemplate(template, map[string]string{
+		"order_id":      strconv.FormatUint(uint64(order.ID), 10),
+		"status":        OrderStatusLabel(event.ToStatus),
+		"store":         order.Store.Name,
+		"customer":      order.CustomerName,
+		"total":         FormatPrice(order.TotalAmount),
+		"tracking_code": order.TrackingCode,
+		"reason":        reason,
+	})
+	if order.TrackingCode != "" && event.ToStatus == models.OrderStatusShipped && !strings.Contains(template, "{tracking_code}") {
+		text += fmt.Sprintf(messages.OrderNoticeTrackingLine, order.TrackingCode)
+	}
+	if reason != "" && !strings.Contains(template, "{reason}") {
+		text += fmt.Sprintf(messages.OrderNoticeReasonLine, reason)
+	}
+	return text, nil
+}
+
+// storeBot returns a Bot API client for a store bot token. It shares the
+// send queue of the token with the store bot itself.
+func (n *OrderNotifier) storeBot(token string) (*tgbotapi.BotAPI, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if bot, ok := n.bots[token]; ok {
+		return bot, nil
+	}
+	bot, err := tgbotapi.NewBotAPIWithClient(token, BotAPIEndpoint(), &http.Client{Timeout: notifierRequestTimeout})
+	if err != nil {
+		return nil, err
+	}
+	UseSendQueue(bot)
+	n.bots[token] = bot
+	return bot, nil
+}
+
+// forget drops the client of a token Telegram no longer accepts
+func (n *OrderNotifier) forget(token string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.bots, token)
+}
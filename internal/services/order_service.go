@@ -1,21 +1,172 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"telegram-store-hub/internal/messages"
 	"telegram-store-hub/internal/models"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OrderService struct {
-	db *gorm.DB
+	db       *gorm.DB
+	leases   *LeaseService  // only the leader expires reservations when set
+	notifier *OrderNotifier // tells customers about status changes when set
 }
 
 func NewOrderService(db *gorm.DB) *OrderService {
 	return &OrderService{db: db}
 }
 
-// CreateOrder creates a new order
+// WithTx returns an order service working in a transaction. It doesn't tell
+// customers about status changes, which must wait for the commit.
+func (s *OrderService) WithTx(tx *gorm.DB) *OrderService {
+	return &OrderService{db: tx}
+}
+
+// SetLeaseService makes only the leader instance expire stock reservations
+func (s *OrderService) SetLeaseService(leases *LeaseService) {
+	s.leases = leases
+}
+
+// SetNotifier makes status changes send the customer a notice through the
+// store's bot
+func (s *OrderService) SetNotifier(notifier *OrderNotifier) {
+	s.notifier = notifier
+}
+
+// CreateOrderFromCart turns a customer's cart whose checkout is waiting for
+// confirmation into an order, in one transaction: the order, its items, the
+// delivery details and the commission are saved, the stock of the products
+// is reserved, the cart's discount code is used, the shipping fee is added
+// and the cart is emptied. Products that became unavailable are left out;
+// ErrCartEmpty is returned when nothing is left, an *OutOfStockError when a
+// product doesn't have enough stock, ErrShippingUnavailable when the store
+// withdrew the shipping method, and one of the coupon errors when the code
+// can no longer be used (see IsCouponError).
+func (s *OrderService) CreateOrderFromCart(storeID uint, customerTelegramID int64, customerName, customerUsername string) (*models.Order, error) {
+	var orderID uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var cart models.Cart
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("store_id = ? AND customer_telegram_id = ?", storeID, customerTelegramID).
+			First(&cart).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCartEmpty
+			}
+			return err
+		}
+		// The review can be confirmed while a discount code is being typed
+		if cart.CheckoutStep != CheckoutStepConfirm && cart.CheckoutStep != CheckoutStepCoupon {
+			return ErrCheckoutNotReady
+		}
+		if err := tx.Preload("Product").Preload("Variant").Where("cart_id = ?", cart.ID).Order("created_at ASC").Find(&cart.Items).Error; err != nil {
+			return err
+		}
+
+		orders := s.WithTx(tx)
+		order, err := orders.CreateOrder(storeID, customerTelegramID, customerName, customerUsername)
+		if err != nil {
+			return err
+		}
+
+		var items []models.CartItem
+		for _, item := range cart.Items {
+			if !CartItemAvailable(item) {
+				continue
+			}
+			orderItem := models.OrderItem{
+				OrderID:   order.ID,
+				ProductID: item.ProductID,
+				VariantID: item.VariantID,
+				Quantity:  item.Quantity,
+				UnitPrice: CartItemPrice(item),
+			}
+			// The variant is kept by name too, in case the seller changes it
+			if item.Variant != nil {
+				orderItem.VariantName = VariantName(item.Variant)
+				orderItem.SKU = item.Variant.SKU
+			}
+			if err := orders.saveOrderItem(&orderItem); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		if len(items) == 0 {
+			return ErrCartEmpty
+		}
+		if err := reserveStock(tx, order.ID, items); err != nil {
+			return err
+		}
+		if cart.CouponCode != "" {
+			coupon, discount, err := redeemCoupon(tx, storeID, cart.CouponCode, order.ID, customerTelegramID, items)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+				"coupon_code":     coupon.Code,
+				"discount_amount": discount,
+			}).Error; err != nil {
+				return err
+			}
+			if err := orders.UpdateOrderTotal(order.ID); err != nil {
+				return err
+			}
+		}
+		if cart.ShippingMethodID != nil {
+			method, err := loadShippingMethod(tx, storeID, *cart.ShippingMethodID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&models.Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+				"shipping_method_id": method.ID,
+				"shipping_method":    method.Name,
+				"shipping_fee":       ShippingFee(method, cart.DeliveryCity, items),
+				"delivery_city":      cart.DeliveryCity,
+			}).Error; err != nil {
+				return err
+			}
+			if err := orders.UpdateOrderTotal(order.ID); err != nil {
+				return err
+			}
+		}
+
+		if err := orders.UpdateOrderDeliveryInfo(order.ID, cart.DeliveryAddress, cart.DeliveryPhone, cart.DeliveryNotes); err != nil {
+			return err
+		}
+		if err := orders.CalculateCommission(order.ID); err != nil {
+			return err
+		}
+
+		if err := tx.Where("cart_id = ?", cart.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&cart).Error; err != nil {
+			return err
+		}
+
+		orderID = order.ID
+		return nil
+	})
+	if err != nil {
+		var stockErr *OutOfStockError
+		if errors.Is(err, ErrCartEmpty) || errors.Is(err, ErrCheckoutNotReady) || errors.As(err, &stockErr) ||
+			errors.Is(err, ErrShippingUnavailable) || IsCouponError(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to create order from cart: %w", err)
+	}
+
+	return s.GetOrderByID(orderID)
+}
+
+// CreateOrder creates a new pending order and starts its status history
 func (s *OrderService) CreateOrder(storeID uint, customerTelegramID int64, customerName, customerUsername string) (*models.Order, error) {
 	order := models.Order{
 		StoreID:            storeID,
@@ -23,11 +174,19 @@ func (s *OrderService) CreateOrder(storeID uint, customerTelegramID int64, custo
 		CustomerName:       customerName,
 		CustomerUsername:   customerUsername,
 		TotalAmount:        0,
-		Status:             "pending",
-		PaymentStatus:      "pending",
+		Status:             models.OrderStatusPending,
+		PaymentStatus:      models.PaymentStatusPending,
 	}
 	
-	if err := s.db.Create(&order).Error; err != nil {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		customer := OrderActor{Type: models.OrderActorCustomer, TelegramID: customerTelegramID}
+		_, err := recordStatusEvent(tx, order.ID, "", order.Status, customer, "")
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
 	
@@ -36,23 +195,27 @@ func (s *OrderService) CreateOrder(storeID uint, customerTelegramID int64, custo
 
 // AddOrderItem adds an item to an order
 func (s *OrderService) AddOrderItem(orderID, productID uint, quantity int, unitPrice int64) error {
-	orderItem := models.OrderItem{
+	return s.saveOrderItem(&models.OrderItem{
 		OrderID:   orderID,
 		ProductID: productID,
 		Quantity:  quantity,
 		UnitPrice: unitPrice,
-		SubTotal:  unitPrice * int64(quantity),
-	}
-	
-	if err := s.db.Create(&orderItem).Error; err != nil {
+	})
+}
+
+// saveOrderItem saves an item of an order with its subtotal
+func (s *OrderService) saveOrderItem(orderItem *models.OrderItem) error {
+	orderItem.SubTotal = orderItem.UnitPrice * int64(orderItem.Quantity)
+	if err := s.db.Create(orderItem).Error; err != nil {
 		return err
 	}
 	
 	// Update order total
-	return s.UpdateOrderTotal(orderID)
+	return s.UpdateOrderTotal(orderItem.OrderID)
 }
 
-// UpdateOrderTotal recalculates and updates order total
+// UpdateOrderTotal recalculates and updates order total: the items less
+// the order's discount, plus its shipping fee
 func (s *OrderService) UpdateOrderTotal(orderID uint) error {
 	var total int64
 	s.db.Table("order_items").
@@ -60,6 +223,16 @@ func (s *OrderService) UpdateOrderTotal(orderID uint) error {
 		Select("COALESCE(SUM(sub_total), 0)").
 		Row().Scan(&total)
 	
+	var adjustments struct {
+		DiscountAmount int64
+		ShippingFee    int64
+	}
+	if err := s.db.Model(&models.Order{}).Select("discount_amount, shipping_fee").
+		Where("id = ?", orderID).Scan(&adjustments).Error; err != nil {
+		return err
+	}
+	total = max(total-adjustments.DiscountAmount, 0) + adjustments.ShippingFee
+	
 	return s.db.Model(&models.Order{}).Where("id = ?", orderID).Update("total_amount", total).Error
 }
 
@@ -83,8 +256,54 @@ func (s *OrderService) GetStoreOrders(storeID uint, limit, offset int) ([]models
 	return orders, err
 }
 
+// ListStoreOrders returns a page of a store's orders, newest first, and how
+// many there are. An empty status lists the orders in every status.
+func (s *OrderService) ListStoreOrders(storeID uint, status models.OrderStatus, limit, offset int) ([]models.Order, int64, error) {
+	query := s.db.Model(&models.Order{}).Where("store_id = ?", storeID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var orders []models.Order
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&orders).Error
+	return orders, total, err
+}
+
+// GetStoreOrder gets an order of a store with its items. Orders of other
+// stores are reported as ErrOrderNotFound.
+func (s *OrderService) GetStoreOrder(storeID, orderID uint) (*models.Order, error) {
+	order, err := s.GetOrderByID(orderID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && order.StoreID != storeID) {
+		return nil, ErrOrderNotFound
+	}
+	return order, err
+}
+
+// SearchStoreOrders finds a store's orders by order number, with or without
+// a leading #, or by the start of the customer's username
+func (s *OrderService) SearchStoreOrders(storeID uint, query string, limit int) ([]models.Order, error) {
+	query = strings.TrimSpace(query)
+	var orders []models.Order
+	if id, err := strconv.ParseUint(strings.TrimPrefix(query, "#"), 10, 32); err == nil {
+		err := s.db.Where("store_id = ? AND id = ?", storeID, id).Find(&orders).Error
+		return orders, err
+	}
+
+	username := strings.TrimPrefix(query, "@")
+	if username == "" {
+		return nil, nil
+	}
+	err := s.db.Where("store_id = ? AND customer_username ILIKE ?", storeID, escapeLike(username)+"%").
+		Order("created_at DESC, id DESC").Limit(limit).Find(&orders).Error
+	return orders, err
+}
+
 // GetOrdersByStatus gets orders by status
-func (s *OrderService) GetOrdersByStatus(storeID uint, status string) ([]models.Order, error) {
+func (s *OrderService) GetOrdersByStatus(storeID uint, status models.OrderStatus) ([]models.Order, error) {
 	var orders []models.Order
 	err := s.db.Where("store_id = ? AND status = ?", storeID, status).
 		Preload("OrderItems").
@@ -94,35 +313,261 @@ func (s *OrderService) GetOrdersByStatus(storeID uint, status string) ([]models.
 	return orders, err
 }
 
-// UpdateOrderStatus updates order status
-func (s *OrderService) UpdateOrderStatus(orderID uint, status string) error {
-	updates := map[string]interface{}{
-		"status":     status,
-		"updated_at": time.Now(),
+// UpdateOrderStatus moves an order to a new status and records the change
+// in its history. Changes the lifecycle doesn't allow are rejected with an
+// *InvalidTransitionError. Confirming an order takes its reserved stock off
+// the products; cancelling, refunding or expiring it releases the
+// reservations and gives back its coupon use, and puts the units back on
+// sale if they never left the store. For shipped orders the note is the
+// tracking code, if any. The customer is told about the change when a
+// notifier is set, and gets the digital items of a confirmed order.
+func (s *OrderService) UpdateOrderStatus(orderID uint, status models.OrderStatus, actor OrderActor, note string) (*models.Order, error) {
+	return s.updateStatus(orderID, status, actor, note, nil)
+}
+
+// updateStatus is UpdateOrderStatus with a check run on the locked order
+// before the change, in the same transaction. An error from check aborts
+// the change.
+func (s *OrderService) updateStatus(orderID uint, status models.OrderStatus, actor OrderActor, note string, check func(tx *gorm.DB, order *models.Order) error) (*models.Order, error) {
+	var event *models.OrderStatusEvent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, orderID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrOrderNotFound
+			}
+			return err
+		}
+		if !CanTransition(order.Status, status) {
+			return &InvalidTransitionError{OrderID: orderID, From: order.Status, To: status}
+		}
+		if check != nil {
+			if err := check(tx, &order); err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{
+			"status":     status,
+			"updated_at": time.Now(),
+		}
+		switch status {
+		case models.OrderStatusConfirmed:
+			if err := commitStockReservations(tx, orderID); err != nil {
+				return err
+			}
+			if err := assignLicenseKeys(tx, orderID); err != nil {
+				return err
+			}
+		case models.OrderStatusShipped:
+			updates["tracking_code"] = strings.TrimSpace(note)
+		case models.OrderStatusDelivered:
+			// Orders not paid online are paid on delivery
+			updates["payment_status"] = models.PaymentStatusPaid
+		case models.OrderStatusCancelled, models.OrderStatusRefunded, models.OrderStatusExpired:
+			if err := releaseStockReservations(tx, orderID); err != nil {
+				return err
+			}
+			if err := releaseCoupon(tx, orderID); err != nil {
+				return err
+			}
+			if order.StockCommitted && order.Status != models.OrderStatusShipped && order.Status != models.OrderStatusDelivered {
+				if err := restockOrder(tx, orderID); err != nil {
+					return err
+				}
+			}
+			if status == models.OrderStatusRefunded && order.PaymentStatus == models.PaymentStatusPaid {
+				updates["payment_status"] = models.PaymentStatusRefunded
+			}
+		}
+
+		if err := tx.Model(&models.Order{}).Where("id = ?", orderID).Updates(updates).Error; err != nil {
+			return err
+		}
+		var err error
+		event, err = recordStatusEvent(tx, orderID, order.Status, status, actor, note)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update status of order %d: %w", orderID, err)
 	}
-	
-	// If completed, mark payment as paid
-	if status == "completed" {
-		updates["payment_status"] = "paid"
+
+	order, err := s.GetOrderByID(orderID)
+	if err != nil {
+		return nil, err
 	}
-	
-	return s.db.Model(&models.Order{}).Where("id = ?", orderID).Updates(updates).Error
+	if s.notifier != nil {
+		if err := s.notifier.NotifyStatusChange(order, event); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+	if status == models.OrderStatusConfirmed {
+		s.deliverDigital(order)
+	}
+	return order, nil
 }
 
-// UpdateOrderPaymentStatus updates payment status
+// deliverDigital sends the customer the digital items of a paid order that
+// weren't sent yet, through the store's bot, and warns the store owner of
+// license key pools running low. It needs a notifier.
+func (s *OrderService) deliverDigital(order *models.Order) {
+	if s.notifier == nil {
+		return
+	}
+	deliveries, err := orderDeliveries(s.db, order.ID)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+		return
+	}
+	var pending []DigitalDelivery
+	for _, delivery := range deliveries {
+		if delivery.Item.DeliveredAt == nil {
+			pending = append(pending, delivery)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	// The customer can get what wasn't sent from the order history
+	sent, err := s.notifier.DeliverDigital(order, pending)
+	if err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+	if err := markDelivered(s.db, sent); err != nil {
+		log.Printf("⚠️ %v", err)
+	}
+
+	var owners []int64
+	if err := s.db.Model(&models.User{}).Where("id = ?", order.Store.OwnerID).Pluck("telegram_id", &owners).Error; err != nil || len(owners) == 0 {
+		return
+	}
+	for _, delivery := range pending {
+		if delivery.Item.Product.DigitalType != models.DigitalLicense {
+			continue
+		}
+		pool, err := keyPoolSize(s.db, delivery.Item.ProductID)
+		if err != nil || pool > LowKeyPoolThreshold {
+			continue
+		}
+		text := fmt.Sprintf(messages.DigitalLowKeyPool, delivery.Item.Product.Name, pool)
+		if err := s.notifier.NotifyOwner(order, owners[0], text); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+}
+
+// ShipOrder marks an order as shipped with its tracking code, which may be
+// empty
+func (s *OrderService) ShipOrder(orderID uint, actor OrderActor, trackingCode string) (*models.Order, error) {
+	return s.UpdateOrderStatus(orderID, models.OrderStatusShipped, actor, trackingCode)
+}
+
+
+// UpdateOrderPaymentStatus updates payment status. A paid order's reserved
+// stock is taken off the products and its digital items are delivered.
 func (s *OrderService) UpdateOrderPaymentStatus(orderID uint, paymentStatus string) error {
-	return s.db.Model(&models.Order{}).Where("id = ?", orderID).Update("payment_status", paymentStatus).Error
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if paymentStatus == models.PaymentStatusPaid {
+			if err := commitStockReservations(tx, orderID); err != nil {
+				return err
+			}
+			if err := assignLicenseKeys(tx, orderID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Order{}).Where("id = ?", orderID).Update("payment_status", paymentStatus).Error
+	})
+	if err != nil || paymentStatus != models.PaymentStatusPaid || s.notifier == nil {
+		return err
+	}
+
+	order, err := s.GetOrderByID(orderID)
+	if err != nil {
+		return err
+	}
+	s.deliverDigital(order)
+	return nil
 }
 
-// CancelOrder cancels an order
-func (s *OrderService) CancelOrder(orderID uint, reason string) error {
-	updates := map[string]interface{}{
-		"status":         "cancelled",
-		"delivery_notes": reason,
-		"updated_at":     time.Now(),
+// CancelOrder cancels an order; the reason is kept in its status history
+func (s *OrderService) CancelOrder(orderID uint, actor OrderActor, reason string) (*models.Order, error) {
+	return s.UpdateOrderStatus(orderID, models.OrderStatusCancelled, actor, reason)
+}
+
+// CancelCustomerOrder cancels an order for the customer who placed it.
+// Customers may only cancel orders the store hasn't accepted yet; other
+// orders are refused with an *InvalidTransitionError, and orders of other
+// stores or customers are reported as ErrOrderNotFound.
+func (s *OrderService) CancelCustomerOrder(storeID uint, customerTelegramID int64, orderID uint) (*models.Order, error) {
+	customer := OrderActor{Type: models.OrderActorCustomer, TelegramID: customerTelegramID}
+	return s.updateStatus(orderID, models.OrderStatusCancelled, customer, "", func(tx *gorm.DB, order *models.Order) error {
+		if order.StoreID != storeID || order.CustomerTelegramID != customerTelegramID {
+			return ErrOrderNotFound
+		}
+		if order.Status != models.OrderStatusPending {
+			return &InvalidTransitionError{OrderID: orderID, From: order.Status, To: models.OrderStatusCancelled}
+		}
+		return nil
+	})
+}
+
+// GetOrderStatusHistory returns the status changes of an order, oldest first
+func (s *OrderService) GetOrderStatusHistory(orderID uint) ([]models.OrderStatusEvent, error) {
+	var events []models.OrderStatusEvent
+	err := s.db.Where("order_id = ?", orderID).Order("created_at ASC, id ASC").Find(&events).Error
+	return events, err
+}
+
+// ExpireStockReservations expires the pending orders whose reservations ran
+// out, which puts their stock back on sale. It returns those orders.
+func (s *OrderService) ExpireStockReservations() ([]uint, error) {
+	var pending []uint
+	expired := s.db.Model(&models.StockReservation{}).Select("order_id").Where("expires_at <= ?", time.Now())
+	if err := s.db.Model(&models.Order{}).Where("status = ? AND id IN (?)", models.OrderStatusPending, expired).
+		Order("id ASC").Pluck("id", &pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to expire stock reservations: %w", err)
 	}
-	
-	return s.db.Model(&models.Order{}).Where("id = ?", orderID).Updates(updates).Error
+
+	var orderIDs []uint
+	for _, orderID := range pending {
+		_, err := s.UpdateOrderStatus(orderID, models.OrderStatusExpired, SystemActor, "")
+		var transitionErr *InvalidTransitionError
+		if errors.As(err, &transitionErr) {
+			// Confirmed or cancelled in the meantime
+			continue
+		}
+		if err != nil {
+			return orderIDs, fmt.Errorf("failed to expire stock reservations: %w", err)
+		}
+		orderIDs = append(orderIDs, orderID)
+	}
+	return orderIDs, nil
+}
+
+// StartReservationExpiry expires stock reservations periodically. With
+// sharded instances only the leader does.
+func (s *OrderService) StartReservationExpiry() {
+	go func() {
+		ticker := time.NewTicker(reservationExpiryEvery)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !s.leases.IsLeader() {
+				continue
+			}
+			orderIDs, err := s.ExpireStockReservations()
+			if err != nil {
+				log.Printf("⚠️ %v", err)
+				continue
+			}
+			if len(orderIDs) > 0 {
+				log.Printf("Expired stock reservations of %d orders", len(orderIDs))
+			}
+		}
+	}()
+
+	log.Println("Stock reservation expiry started")
 }
 
 // GetCustomerOrders gets orders for a specific customer
@@ -152,13 +597,13 @@ func (s *OrderService) GetOrderStats(storeID uint, days int) (map[string]interfa
 	
 	// Get order counts
 	s.db.Model(&models.Order{}).Where("store_id = ? AND created_at >= ?", storeID, startDate).Count(&stats.TotalOrders)
-	s.db.Model(&models.Order{}).Where("store_id = ? AND status = 'completed' AND created_at >= ?", storeID, startDate).Count(&stats.CompletedOrders)
+	s.db.Model(&models.Order{}).Where("store_id = ? AND status = 'delivered' AND created_at >= ?", storeID, startDate).Count(&stats.CompletedOrders)
 	s.db.Model(&models.Order{}).Where("store_id = ? AND status = 'pending' AND created_at >= ?", storeID, startDate).Count(&stats.PendingOrders)
 	s.db.Model(&models.Order{}).Where("store_id = ? AND status = 'cancelled' AND created_at >= ?", storeID, startDate).Count(&stats.CancelledOrders)
 	
 	// Get total revenue
 	s.db.Table("orders").
-		Where("store_id = ? AND status = 'completed' AND created_at >= ?", storeID, startDate).
+		Where("store_id = ? AND status = 'delivered' AND created_at >= ?", storeID, startDate).
 		Select("COALESCE(SUM(total_amount), 0)").
 		Row().Scan(&stats.TotalRevenue)
 	
@@ -183,7 +628,7 @@ func (s *OrderService) GetDailySales(storeID uint, days int) ([]map[string]inter
 	
 	err := s.db.Table("orders").
 		Select("DATE(created_at) as date, COUNT(*) as order_count, COALESCE(SUM(total_amount), 0) as total_revenue").
-		Where("store_id = ? AND status = 'completed' AND created_at >= ?", storeID, startDate).
+		Where("store_id = ? AND status = 'delivered' AND created_at >= ?", storeID, startDate).
 		Group("DATE(created_at)").
 		Order("date ASC").
 		Scan(&results).Error
@@ -205,6 +650,55 @@ func (s *OrderService) GetDailySales(storeID uint, days int) ([]map[string]inter
 	return data, nil
 }
 
+// SalesReport sums up the sales of a store. Sales are the totals of the
+// delivered orders, after their discounts.
+type SalesReport struct {
+	TotalSales      int64
+	NetIncome       int64 // sales less the platform commission
+	TotalOrders     int64
+	CompletedOrders int64
+	PendingOrders   int64 // not yet delivered nor ended
+	MonthlySales    int64
+	DailySales      int64
+	CommissionPaid  int64
+	TotalDiscounts  int64 // given by the coupons of orders still going through
+	Coupons         []CouponStats `gorm:"-"`
+}
+
+// GetStoreSalesReport returns the sales report of a store with the
+// redemption statistics of its coupons
+func (s *OrderService) GetStoreSalesReport(storeID uint) (*SalesReport, error) {
+	var report SalesReport
+	now := time.Now()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	inProgress := []models.OrderStatus{models.OrderStatusPending, models.OrderStatusConfirmed, models.OrderStatusShipped}
+
+	err := s.db.Model(&models.Order{}).Where("store_id = ?", storeID).Select(`COUNT(*) AS total_orders,
+		COUNT(*) FILTER (WHERE status = ?) AS completed_orders,
+		COUNT(*) FILTER (WHERE status IN ?) AS pending_orders,
+		COALESCE(SUM(total_amount) FILTER (WHERE status = ?), 0) AS total_sales,
+		COALESCE(SUM(commission_amount) FILTER (WHERE status = ?), 0) AS commission_paid,
+		COALESCE(SUM(total_amount) FILTER (WHERE status = ? AND created_at >= ?), 0) AS monthly_sales,
+		COALESCE(SUM(total_amount) FILTER (WHERE status = ? AND created_at >= ?), 0) AS daily_sales`,
+		models.OrderStatusDelivered, inProgress, models.OrderStatusDelivered, models.OrderStatusDelivered,
+		models.OrderStatusDelivered, monthStart, models.OrderStatusDelivered, dayStart).
+		Scan(&report).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sales report: %w", err)
+	}
+	report.NetIncome = report.TotalSales - report.CommissionPaid
+
+	report.Coupons, err = NewCouponService(s.db).RedemptionStats(storeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, coupon := range report.Coupons {
+		report.TotalDiscounts += coupon.Discount
+	}
+	return &report, nil
+}
+
 // UpdateOrderDeliveryInfo updates delivery information
 func (s *OrderService) UpdateOrderDeliveryInfo(orderID uint, address, phone, notes string) error {
 	updates := map[string]interface{}{
@@ -217,14 +711,36 @@ func (s *OrderService) UpdateOrderDeliveryInfo(orderID uint, address, phone, not
 	return s.db.Model(&models.Order{}).Where("id = ?", orderID).Updates(updates).Error
 }
 
-// CalculateCommission calculates commission for an order
+// CalculateCommission calculates commission for an order. The shipping fee
+// isn't a sale and is left out.
 func (s *OrderService) CalculateCommission(orderID uint) error {
 	var order models.Order
 	if err := s.db.Preload("Store").First(&order, orderID).Error; err != nil {
 		return err
 	}
 	
-	commissionAmount := order.TotalAmount * int64(order.Store.CommissionRate) / 100
+	commissionAmount := (order.TotalAmount - order.ShippingFee) * int64(order.Store.CommissionRate) / 100
 	
 	return s.db.Model(&models.Order{}).Where("id = ?", orderID).Update("commission_amount", commissionAmount).Error
-}
\ No newline at end of file
+}
+
+// recordStatusEvent adds a status change to an order's history
+func recordStatusEvent(tx *gorm.DB, orderID uint, from, to models.OrderStatus, actor OrderActor, note string) (*models.OrderStatusEvent, error) {
+	event := models.OrderStatusEvent{
+		OrderID:         orderID,
+		FromStatus:      from,
+		ToStatus:        to,
+		ActorType:       actor.Type,
+		ActorTelegramID: actor.TelegramID,
+		Note:            note,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
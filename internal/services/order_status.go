@@ -0,0 +1,79 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+)
+
+// ErrOrderNotFound is returned when the order of a status change doesn't exist
+var ErrOrderNotFound = errors.New("order not found")
+
+// orderTransitions lists the statuses each status can move to. Cancelled,
+// refunded and expired orders are final.
+var orderTransitions = map[models.OrderStatus][]models.OrderStatus{
+	models.OrderStatusPending:   {models.OrderStatusConfirmed, models.OrderStatusCancelled, models.OrderStatusExpired},
+	models.OrderStatusConfirmed: {models.OrderStatusShipped, models.OrderStatusCancelled, models.OrderStatusRefunded},
+	models.OrderStatusShipped:   {models.OrderStatusDelivered, models.OrderStatusRefunded},
+	models.OrderStatusDelivered: {models.OrderStatusRefunded},
+}
+
+// InvalidTransitionError is returned when an order can't move from its
+// status to the requested one
+type InvalidTransitionError struct {
+	OrderID uint
+	From    models.OrderStatus
+	To      models.OrderStatus
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("order %d can't go from %s to %s", e.OrderID, e.From, e.To)
+}
+
+// OrderActor is who changes an order's status
+type OrderActor struct {
+	Type       string // models.OrderActorCustomer, OrderActorSeller, ...
+	TelegramID int64
+}
+
+// SystemActor makes the status changes nobody asked for, like expiry
+var SystemActor = OrderActor{Type: models.OrderActorSystem}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to models.OrderStatus) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOrderStatuses returns the statuses an order in the given status can
+// move to
+func NextOrderStatuses(from models.OrderStatus) []models.OrderStatus {
+	return append([]models.OrderStatus(nil), orderTransitions[from]...)
+}
+
+// OrderStatusLabel returns the Persian name of an order status
+func OrderStatusLabel(status models.OrderStatus) string {
+	switch status {
+	case models.OrderStatusPending:
+		return messages.OrderStatusPendingLabel
+	case models.OrderStatusConfirmed:
+		return messages.OrderStatusConfirmedLabel
+	case models.OrderStatusShipped:
+		return messages.OrderStatusShippedLabel
+	case models.OrderStatusDelivered:
+		return messages.OrderStatusDeliveredLabel
+	case models.OrderStatusCancelled:
+		return messages.OrderStatusCancelledLabel
+	case models.OrderStatusRefunded:
+		return messages.OrderStatusRefundedLabel
+	case models.OrderStatusExpired:
+		return messages.OrderStatusExpiredLabel
+	default:
+		return string(status)
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"telegram-store-hub/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxProductMedia is the most photos and videos a product's gallery holds,
+// as many as Telegram sends in one media group
+const MaxProductMedia = 10
+
+// Product gallery errors
+var (
+	ErrProductNotFound   = errors.New("product not found in store")
+	ErrProductMediaLimit = errors.New("product gallery is full")
+)
+
+// AddProductMedia adds a photo or video to the end of the gallery of a
+// product of the store. The file ID must come from the store's bot. It
+// returns how many the gallery has.
+func (s *ProductService) AddProductMedia(storeID, productID uint, mediaType, fileID string) (int, error) {
+	var count int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND store_id = ?", productID, storeID).First(&product).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.ProductMedia{}).Where("product_id = ?", productID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxProductMedia {
+			return ErrProductMediaLimit
+		}
+		media := models.ProductMedia{ProductID: productID, Position: int(count), Type: mediaType, FileID: fileID}
+		if err := tx.Create(&media).Error; err != nil {
+			return err
+		}
+		count++
+		return refreshThumbnail(tx, productID)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to add product media: %w", err)
+	}
+	return int(count), nil
+}
+
+// SetProductMedia replaces the gallery of a product with media, in order
+func (s *ProductService) SetProductMedia(productID uint, media []models.ProductMedia) error {
+	if len(media) > MaxProductMedia {
+		return ErrProductMediaLimit
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", productID).Delete(&models.ProductMedia{}).Error; err != nil {
+			return err
+		}
+		for i, item := range media {
+			item.ID = 0
+			item.ProductID = productID
+			item.Position = i
+			if err := tx.Create(&item).Error; err != nil {
+				return err
+			}
+		}
+		return refreshThumbnail(tx, productID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save product media: %w", err)
+	}
+	return nil
+}
+
+// ClearProductMedia removes the whole gallery of a product of the store
+func (s *ProductService) ClearProductMedia(storeID, productID uint) error {
+	var product models.Product
+	err := s.db.Where("id = ? AND store_id = ?", productID, storeID).First(&product).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get product: %w", err)
+	}
+	return s.SetProductMedia(productID, nil)
+}
+
+// GetProductMedia returns the gallery of a product, in order
+func (s *ProductService) GetProductMedia(productID uint) ([]models.ProductMedia, error) {
+	var media []models.ProductMedia
+	err := s.db.Where("product_id = ?", productID).Order("position ASC, id ASC").Find(&media).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product media: %w", err)
+	}
+	return media, nil
+}
+
+// ProductGallery returns the message that shows a product's gallery in a
+// chat: a media group, or a single photo or video as media groups need two.
+// Send it with Request, as a media group answers with several messages.
+func ProductGallery(chatID int64, media []models.ProductMedia) tgbotapi.Chattable {
+	if len(media) == 1 {
+		if media[0].Type == models.MediaVideo {
+			return tgbotapi.NewVideo(chatID, tgbotapi.FileID(media[0].FileID))
+		}
+		return tgbotapi.NewPhoto(chatID, tgbotapi.FileID(media[0].FileID))
+	}
+
+	files := make([]interface{}, len(media))
+	for i, item := range media {
+		if item.Type == models.MediaVideo {
+			files[i] = tgbotapi.NewInputMediaVideo(tgbotapi.FileID(item.FileID))
+		} else {
+			files[i] = tgbotapi.NewInputMediaPhoto(tgbotapi.FileID(item.FileID))
+		}
+	}
+	return tgbotapi.NewMediaGroup(chatID, files)
+}
+
+// refreshThumbnail makes the first photo of a product's gallery its
+// catalog picture
+func refreshThumbnail(tx *gorm.DB, productID uint) error {
+	var thumbnail []string
+	err := tx.Model(&models.ProductMedia{}).
+		Where("product_id = ? AND type = ?", productID, models.MediaPhoto).
+		Order("position ASC, id ASC").Limit(1).Pluck("file_id", &thumbnail).Error
+	if err != nil {
+		return err
+	}
+	fileID := ""
+	if len(thumbnail) > 0 {
+		fileID = thumbnail[0]
+	}
+	return tx.Model(&models.Product{}).Where("id = ?", productID).Update("thumbnail_file_id", fileID).Error
+}
@@ -48,10 +48,11 @@ func (s *ProductService) GetStoreProducts(storeID uint) ([]models.Product, error
 	return products, err
 }
 
-// GetActiveStoreProducts gets only active products for a store
+// GetActiveStoreProducts gets the products of a store customers can buy:
+// available ones that are not sold out
 func (s *ProductService) GetActiveStoreProducts(storeID uint) ([]models.Product, error) {
 	var products []models.Product
-	err := s.db.Where("store_id = ? AND is_available = ?", storeID, true).Order("created_at DESC").Find(&products).Error
+	err := s.db.Scopes(inStock).Where("store_id = ? AND is_available = ?", storeID, true).Order("created_at DESC").Find(&products).Error
 	return products, err
 }
 
@@ -84,7 +85,13 @@ func (s *ProductService) UpdateProductStock(productID uint, stock int) error {
 	}).Error
 }
 
-// CheckProductStock checks if product has enough stock
+// UpdateProductWeight sets a product's shipping weight in grams
+func (s *ProductService) UpdateProductWeight(productID uint, grams int) error {
+	return s.db.Model(&models.Product{}).Where("id = ?", productID).Update("weight", grams).Error
+}
+
+// CheckProductStock checks if product has enough stock that isn't reserved
+// by pending orders
 func (s *ProductService) CheckProductStock(productID uint, quantity int) (bool, error) {
 	var product models.Product
 	if err := s.db.First(&product, productID).Error; err != nil {
@@ -95,22 +102,49 @@ func (s *ProductService) CheckProductStock(productID uint, quantity int) (bool,
 		return true, nil // No stock tracking
 	}
 	
-	return product.Stock >= quantity, nil
+	available, err := availableStock(s.db, productID)
+	if err != nil {
+		return false, err
+	}
+	return available >= quantity, nil
+}
+
+// AvailableStock returns the stock of a product that isn't reserved by
+// pending orders
+func (s *ProductService) AvailableStock(productID uint) (int, error) {
+	return availableStock(s.db, productID)
 }
 
 // GetProductsByCategory gets products by category
 func (s *ProductService) GetProductsByCategory(storeID uint, category string) ([]models.Product, error) {
 	var products []models.Product
-	err := s.db.Where("store_id = ? AND category = ? AND is_available = ?", storeID, category, true).
+	err := s.db.Scopes(inStock).Where("store_id = ? AND category = ? AND is_available = ?", storeID, category, true).
 		Order("created_at DESC").Find(&products).Error
 	return products, err
 }
 
+// CategoryCount is a product category with how many products it has
+type CategoryCount struct {
+	Category string
+	Products int
+}
+
+// GetStoreCategories returns the categories of the products customers can
+// buy in a store, by name, with how many products each has
+func (s *ProductService) GetStoreCategories(storeID uint) ([]CategoryCount, error) {
+	var categories []CategoryCount
+	err := s.db.Model(&models.Product{}).Scopes(inStock).
+		Select("category, COUNT(*) AS products").
+		Where("store_id = ? AND is_available = ?", storeID, true).
+		Group("category").Order("category ASC").Scan(&categories).Error
+	return categories, err
+}
+
 // SearchProducts searches products by name or description
 func (s *ProductService) SearchProducts(storeID uint, query string) ([]models.Product, error) {
 	var products []models.Product
 	searchQuery := "%" + query + "%"
-	err := s.db.Where("store_id = ? AND (name ILIKE ? OR description ILIKE ?) AND is_available = ?", 
+	err := s.db.Scopes(inStock).Where("store_id = ? AND (name ILIKE ? OR description ILIKE ?) AND is_available = ?", 
 		storeID, searchQuery, searchQuery, true).
 		Order("created_at DESC").Find(&products).Error
 	return products, err
@@ -123,7 +157,7 @@ func (s *ProductService) GetTopSellingProducts(storeID uint, limit int) ([]model
 		Select("products.*, COALESCE(SUM(order_items.quantity), 0) as total_sold").
 		Joins("LEFT JOIN order_items ON products.id = order_items.product_id").
 		Joins("LEFT JOIN orders ON order_items.order_id = orders.id").
-		Where("products.store_id = ? AND (orders.status = 'completed' OR orders.status IS NULL)", storeID).
+		Where("products.store_id = ? AND (orders.status = 'delivered' OR orders.status IS NULL)", storeID).
 		Group("products.id").
 		Order("total_sold DESC").
 		Limit(limit).
@@ -142,7 +176,7 @@ func (s *ProductService) GetProductSalesStats(productID uint) (map[string]interf
 	err := s.db.Table("order_items").
 		Select("COALESCE(SUM(quantity), 0) as total_sold, COALESCE(SUM(sub_total), 0) as total_revenue, COUNT(DISTINCT order_id) as order_count").
 		Joins("JOIN orders ON order_items.order_id = orders.id").
-		Where("order_items.product_id = ? AND orders.status = 'completed'", productID).
+		Where("order_items.product_id = ? AND orders.status = 'delivered'", productID).
 		Scan(&stats).Error
 	
 	if err != nil {
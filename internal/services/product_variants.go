@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"telegram-store-hub/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxProductVariants keeps a product's variant buttons on one screen
+const maxProductVariants = 30
+
+// ErrVariantNotFound is returned for a variant that doesn't exist
+var ErrVariantNotFound = errors.New("product variant not found")
+
+// VariantSpecError is returned for a variant list the seller mistyped; Part
+// is the line or word that couldn't be understood
+type VariantSpecError struct {
+	Part string
+}
+
+func (e *VariantSpecError) Error() string {
+	return fmt.Sprintf("invalid product variants near %q", e.Part)
+}
+
+// ParseVariantSpec reads the variants of a product from the list sellers
+// send: the option axes joined by "/" on the first line, then one variant
+// per line with its value on each axis and optionally its SKU, price and
+// stock:
+//
+//	Size/Color
+//	M/Red sku=TS-M-R stock=5
+//	L/Red price=130000 stock=2
+//
+// A variant without a price sells at the product's price. It returns the
+// axes, joined by "/", and the variants.
+func ParseVariantSpec(spec string) (string, []models.ProductVariant, error) {
+	lines := strings.Split(latinDigits(strings.TrimSpace(spec)), "\n")
+	axes := splitOptions(lines[0])
+	if len(axes) == 0 {
+		return "", nil, &VariantSpecError{Part: lines[0]}
+	}
+
+	var variants []models.ProductVariant
+	seen := make(map[string]bool)
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		// Options come last so values may have spaces in them
+		fields := strings.Fields(line)
+		variant := models.ProductVariant{IsAvailable: true}
+		for len(fields) > 1 {
+			key, value, ok := strings.Cut(fields[len(fields)-1], "=")
+			if !ok {
+				break
+			}
+			switch key {
+			case "sku":
+				variant.SKU = value
+			case "price":
+				price, err := parseAmount(value)
+				if err != nil {
+					return "", nil, &VariantSpecError{Part: fields[len(fields)-1]}
+				}
+				variant.Price = price
+			case "stock":
+				stock, err := strconv.Atoi(value)
+				if err != nil || stock < 0 {
+					return "", nil, &VariantSpecError{Part: fields[len(fields)-1]}
+				}
+				variant.Stock = stock
+			default:
+				return "", nil, &VariantSpecError{Part: fields[len(fields)-1]}
+			}
+			fields = fields[:len(fields)-1]
+		}
+
+		values := splitOptions(strings.Join(fields, " "))
+		if len(values) != len(axes) {
+			return "", nil, &VariantSpecError{Part: line}
+		}
+		variant.Options = strings.Join(values, "/")
+		if seen[variant.Options] {
+			return "", nil, &VariantSpecError{Part: line}
+		}
+		seen[variant.Options] = true
+		variants = append(variants, variant)
+	}
+
+	if len(variants) == 0 || len(variants) > maxProductVariants {
+		return "", nil, &VariantSpecError{Part: lines[0]}
+	}
+	return strings.Join(axes, "/"), variants, nil
+}
+
+// FormatVariantSpec writes a product's variants the way ParseVariantSpec
+// reads them, for the seller to copy and edit
+func FormatVariantSpec(product *models.Product, variants []models.ProductVariant) string {
+	lines := []string{product.VariantAxes}
+	for _, variant := range variants {
+		if !variant.IsAvailable {
+			continue
+		}
+		line := variant.Options
+		if variant.SKU != "" {
+			line += " sku=" + variant.SKU
+		}
+		if variant.Price > 0 {
+			line += fmt.Sprintf(" price=%d", variant.Price)
+		}
+		if product.TrackStock {
+			line += fmt.Sprintf(" stock=%d", variant.Stock)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SaveVariants replaces the variants of a product with the ones the seller
+// listed. Variants are matched by their options, so the ones kept keep
+// their ID and the ones left out are taken off sale rather than deleted,
+// as orders may still refer to them. The product's stock becomes the sum of
+// its variants' stock. No variants at all turn them off for the product.
+func (s *ProductService) SaveVariants(productID uint, axes string, variants []models.ProductVariant) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, productID).Error; err != nil {
+			return err
+		}
+
+		var existing []models.ProductVariant
+		if err := tx.Where("product_id = ?", productID).Find(&existing).Error; err != nil {
+			return err
+		}
+		byOptions := make(map[string]models.ProductVariant, len(existing))
+		for _, variant := range existing {
+			byOptions[variant.Options] = variant
+		}
+
+		stock := 0
+		for _, variant := range variants {
+			stock += variant.Stock
+			current, ok := byOptions[variant.Options]
+			if !ok {
+				variant.ProductID = productID
+				variant.IsAvailable = true
+				if err := tx.Create(&variant).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			delete(byOptions, variant.Options)
+			if err := tx.Model(&current).Updates(map[string]interface{}{
+				"sku":          variant.SKU,
+				"price":        variant.Price,
+				"stock":        variant.Stock,
+				"is_available": true,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, removed := range byOptions {
+			if err := tx.Model(&removed).Updates(map[string]interface{}{
+				"stock":        0,
+				"is_available": false,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		updates := map[string]interface{}{"variant_axes": axes}
+		if len(variants) == 0 {
+			updates["variant_axes"] = ""
+		} else {
+			updates["stock"] = stock
+		}
+		return tx.Model(&product).Updates(updates).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save product variants: %w", err)
+	}
+	return nil
+}
+
+// GetVariants returns the variants of a product on sale, in the order they
+// were added
+func (s *ProductService) GetVariants(productID uint) ([]models.ProductVariant, error) {
+	var variants []models.ProductVariant
+	err := s.db.Where("product_id = ? AND is_available = ?", productID, true).Order("id ASC").Find(&variants).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variants: %w", err)
+	}
+	return variants, nil
+}
+
+// GetVariant returns a product variant, or ErrVariantNotFound
+func (s *ProductService) GetVariant(variantID uint) (*models.ProductVariant, error) {
+	var variant models.ProductVariant
+	err := s.db.First(&variant, variantID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrVariantNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get product variant: %w", err)
+	}
+	return &variant, nil
+}
+
+// AvailableVariantStock returns the stock of a variant that isn't reserved
+// by pending orders
+func (s *ProductService) AvailableVariantStock(variantID uint) (int, error) {
+	return availableVariantStock(s.db, variantID)
+}
+
+// HasVariants reports whether a product is sold by variant
+func HasVariants(product *models.Product) bool {
+	return product.VariantAxes != ""
+}
+
+// VariantName is how a variant is shown to people, e.g. "M / Red"
+func VariantName(variant *models.ProductVariant) string {
+	return strings.ReplaceAll(variant.Options, "/", " / ")
+}
+
+// OrderItemName is the name of an order item's product, with the variant
+// that was ordered
+func OrderItemName(item models.OrderItem) string {
+	if item.VariantName != "" {
+		return item.Product.Name + " (" + item.VariantName + ")"
+	}
+	return item.Product.Name
+}
+
+// variantTitle is the name of a product with one of its variants
+func variantTitle(product string, variant *models.ProductVariant) string {
+	return product + " (" + VariantName(variant) + ")"
+}
+
+// splitOptions splits "/"-separated option names or values, trimming them;
+// it returns nil when one of them is empty
+func splitOptions(value string) []string {
+	parts := strings.Split(value, "/")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if parts[i] == "" {
+			return nil
+		}
+	}
+	return parts
+}
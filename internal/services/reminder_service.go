@@ -17,6 +17,7 @@ type ReminderService struct {
 	subscriptionSrv   *SubscriptionService
 	reminderDays      []int
 	isRunning         bool
+	leases            *LeaseService // only the leader sends reminders when set
 }
 
 // ReminderType defines the type of reminder
@@ -46,7 +47,7 @@ func NewReminderService(
 	reminderDays []int,
 ) *ReminderService {
 	return &ReminderService{
-		bot:             bot,
+		bot:             BulkSender(bot), // reminders must not delay order and payment notices
 		db:              db,
 		subscriptionSrv: subscriptionSrv,
 		reminderDays:    reminderDays,
@@ -54,6 +55,12 @@ func NewReminderService(
 	}
 }
 
+// SetLeaseService makes the scheduler send reminders only while this
+// instance is the leader. It must be called before StartReminderScheduler.
+func (r *ReminderService) SetLeaseService(leases *LeaseService) {
+	r.leases = leases
+}
+
 // StartReminderScheduler starts the automatic reminder scheduler
 func (r *ReminderService) StartReminderScheduler() {
 	if r.isRunning {
@@ -68,7 +75,9 @@ func (r *ReminderService) StartReminderScheduler() {
 	log.Println("Starting subscription reminder scheduler...")
 
 	// Run initial check
-	r.CheckAndSendReminders()
+	if r.leases.IsLeader() {
+		r.CheckAndSendReminders()
+	}
 
 	// Schedule periodic checks
 	go func() {
@@ -78,7 +87,7 @@ func (r *ReminderService) StartReminderScheduler() {
 		for {
 			select {
 			case <-ticker.C:
-				if r.isRunning {
+				if r.isRunning && r.leases.IsLeader() {
 					r.CheckAndSendReminders()
 				}
 			}
@@ -133,8 +142,10 @@ func (r *ReminderService) checkExpiringSubscriptions() {
 				continue
 			}
 
-			// Send expiring reminder
-			r.sendExpiryReminder(store.Owner.TelegramID, &store, days)
+			// Send expiring reminder, retried on the next check if it fails
+			if err := r.sendExpiryReminder(store.Owner.TelegramID, &store, days); err != nil {
+				continue
+			}
 			
 			// Log the reminder
 			r.logReminder(store.ID, ReminderTypeExpiring, days)
@@ -146,14 +157,15 @@ func (r *ReminderService) checkExpiringSubscriptions() {
 	}
 }
 
-// checkExpiredSubscriptions checks for recently expired subscriptions
+// checkExpiredSubscriptions deactivates the stores whose subscription
+// expired before today and tells their owners
 func (r *ReminderService) checkExpiredSubscriptions() {
-	yesterday := time.Now().AddDate(0, 0, -1).Truncate(24 * time.Hour)
+	today := time.Now().Truncate(24 * time.Hour)
 	
 	var expiredStores []models.Store
 	err := r.db.Preload("Owner").Where(
-		"expires_at::date = ? AND is_active = ?", 
-		yesterday.Format("2006-01-02"), 
+		"expires_at < ? AND is_active = ?", 
+		today, 
 		true,
 	).Find(&expiredStores).Error
 	
@@ -163,24 +175,44 @@ func (r *ReminderService) checkExpiredSubscriptions() {
 	}
 
 	for _, store := range expiredStores {
-		// Check if reminder already sent
-		if r.isReminderAlreadySent(store.ID, ReminderTypeExpired, 0) {
-			continue
+		if err := r.db.Model(&store).Update("is_active", false).Error; err != nil {
+			log.Printf("Error deactivating expired store %d: %v", store.ID, err)
 		}
-
-		// Deactivate store
-		r.db.Model(&store).Update("is_active", false)
-		
-		// Send expiry notification
-		r.sendExpiryNotification(store.Owner.TelegramID, &store)
-		
-		// Log the reminder
-		r.logReminder(store.ID, ReminderTypeExpired, 0)
 	}
 
 	if len(expiredStores) > 0 {
 		log.Printf("Processed %d expired subscriptions", len(expiredStores))
 	}
+
+	r.notifyExpiredStores(today)
+}
+
+// notifyExpiredStores tells the owners of stores deactivated on expiry
+// that haven't been told yet. A notice that fails is sent again on the next
+// check until the overdue reminder takes over.
+func (r *ReminderService) notifyExpiredStores(today time.Time) {
+	var stores []models.Store
+	err := r.db.Preload("Owner").Where(
+		"expires_at < ? AND expires_at >= ? AND is_active = ?",
+		today,
+		today.AddDate(0, 0, -3),
+		false,
+	).Find(&stores).Error
+
+	if err != nil {
+		log.Printf("Error finding expired stores to notify: %v", err)
+		return
+	}
+
+	for _, store := range stores {
+		if r.isReminderSentSince(store.ID, ReminderTypeExpired, 0, store.ExpiresAt) {
+			continue
+		}
+		if err := r.sendExpiryNotification(store.Owner.TelegramID, &store); err != nil {
+			continue
+		}
+		r.logReminder(store.ID, ReminderTypeExpired, 0)
+	}
 }
 
 // checkOverdueSubscriptions checks for overdue subscriptions (expired > 3 days)
@@ -205,8 +237,10 @@ func (r *ReminderService) checkOverdueSubscriptions() {
 			continue
 		}
 
-		// Send overdue reminder
-		r.sendOverdueReminder(store.Owner.TelegramID, &store)
+		// Send overdue reminder, retried on the next check if it fails
+		if err := r.sendOverdueReminder(store.Owner.TelegramID, &store); err != nil {
+			continue
+		}
 		
 		// Log the reminder
 		r.logReminder(store.ID, ReminderTypeOverdue, 0)
@@ -218,7 +252,7 @@ func (r *ReminderService) checkOverdueSubscriptions() {
 }
 
 // sendExpiryReminder sends subscription expiry reminder
-func (r *ReminderService) sendExpiryReminder(chatID int64, store *models.Store, daysRemaining int) {
+func (r *ReminderService) sendExpiryReminder(chatID int64, store *models.Store, daysRemaining int) error {
 	var text string
 	var urgency string
 
@@ -275,11 +309,14 @@ func (r *ReminderService) sendExpiryReminder(chatID int64, store *models.Store,
 
 	if _, err := r.bot.Send(msg); err != nil {
 		log.Printf("Error sending expiry reminder to %d: %v", chatID, err)
+		return err
 	}
+
+	return nil
 }
 
 // sendExpiryNotification sends subscription expired notification
-func (r *ReminderService) sendExpiryNotification(chatID int64, store *models.Store) {
+func (r *ReminderService) sendExpiryNotification(chatID int64, store *models.Store) error {
 	text := fmt.Sprintf(`❌ پلن فروشگاه منقضی شد!
 
 🏪 فروشگاه: "%s"
@@ -305,11 +342,14 @@ func (r *ReminderService) sendExpiryNotification(chatID int64, store *models.Sto
 
 	if _, err := r.bot.Send(msg); err != nil {
 		log.Printf("Error sending expiry notification to %d: %v", chatID, err)
+		return err
 	}
+
+	return nil
 }
 
 // sendOverdueReminder sends overdue subscription reminder
-func (r *ReminderService) sendOverdueReminder(chatID int64, store *models.Store) {
+func (r *ReminderService) sendOverdueReminder(chatID int64, store *models.Store) error {
 	text := fmt.Sprintf(`🚨 پلن فروشگاه شما 3 روز است که منقضی شده!
 
 🏪 فروشگاه: "%s"
@@ -337,19 +377,25 @@ func (r *ReminderService) sendOverdueReminder(chatID int64, store *models.Store)
 
 	if _, err := r.bot.Send(msg); err != nil {
 		log.Printf("Error sending overdue reminder to %d: %v", chatID, err)
+		return err
 	}
+
+	return nil
 }
 
 // isReminderAlreadySent checks if a reminder was already sent
 func (r *ReminderService) isReminderAlreadySent(storeID uint, reminderType ReminderType, daysRemaining int) bool {
-	var count int64
-	
 	// Check if reminder was sent in the last 24 hours
-	cutoff := time.Now().Add(-24 * time.Hour)
+	return r.isReminderSentSince(storeID, reminderType, daysRemaining, time.Now().Add(-24*time.Hour))
+}
+
+// isReminderSentSince checks if a reminder was sent after a time
+func (r *ReminderService) isReminderSentSince(storeID uint, reminderType ReminderType, daysRemaining int, since time.Time) bool {
+	var count int64
 	
 	r.db.Model(&ReminderLog{}).Where(
 		"store_id = ? AND reminder_type = ? AND days_remaining = ? AND sent_at > ?",
-		storeID, reminderType, daysRemaining, cutoff,
+		storeID, reminderType, daysRemaining, since,
 	).Count(&count)
 	
 	return count > 0
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"telegram-store-hub/internal/messages"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Return request limits
+const (
+	MaxReturnPhotos       = 5 // per request
+	maxReturnReasonLength = 500
+)
+
+// Return request errors
+var (
+	ErrReturnNotFound   = errors.New("return request not found")
+	ErrReturnNotAllowed = errors.New("only delivered orders can be returned")
+	ErrReturnExists     = errors.New("the order already has a return request")
+	ErrReturnNoReason   = errors.New("return request has no reason")
+	ErrReturnPhotoLimit = errors.New("return request has too many photos")
+	ErrReturnTooLong    = errors.New("return reason is too long")
+	ErrReturnNotPending = errors.New("return request was already decided")
+)
+
+// ReturnService handles the return requests of delivered orders: customers
+// write them in the store bot and sellers decide them in the mother bot
+type ReturnService struct {
+	db     *gorm.DB
+	orders *OrderService // refunds approved returns and tells the customer
+}
+
+// NewReturnService creates a new return service. Approved returns refund
+// the order through orders, whose notifier also tells the customer about
+// rejected ones.
+func NewReturnService(db *gorm.DB, orders *OrderService) *ReturnService {
+	return &ReturnService{db: db, orders: orders}
+}
+
+// StartReturn opens a draft return request for a delivered order of the
+// customer, or returns the draft already open for it. Orders of other
+// stores or customers are reported as ErrOrderNotFound.
+func (s *ReturnService) StartReturn(storeID uint, customerTelegramID int64, orderID uint) (*models.ReturnRequest, error) {
+	var order models.Order
+	err := s.db.Where("id = ? AND store_id = ? AND customer_telegram_id = ?", orderID, storeID, customerTelegramID).First(&order).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order: %w", err)
+	}
+	if order.Status != models.OrderStatusDelivered {
+		return nil, ErrReturnNotAllowed
+	}
+
+	request := &models.ReturnRequest{
+		StoreID:            storeID,
+		OrderID:            orderID,
+		CustomerTelegramID: customerTelegramID,
+		Status:             models.ReturnDraft,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Omit("Order").Create(request)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create return request: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return request, nil
+	}
+
+	// One request per order; a draft is picked up where it was left
+	var existing models.ReturnRequest
+	if err := s.db.Where("order_id = ?", orderID).First(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to get return request: %w", err)
+	}
+	if existing.Status != models.ReturnDraft {
+		return nil, ErrReturnExists
+	}
+	return &existing, nil
+}
+
+// DraftReturn returns the return request the customer is writing in the
+// store, or nil when there is none
+func (s *ReturnService) DraftReturn(storeID uint, customerTelegramID int64) (*models.ReturnRequest, error) {
+	var requests []models.ReturnRequest
+	err := s.db.Preload("Photos").
+		Where("store_id = ? AND customer_telegram_id = ? AND status = ?", storeID, customerTelegramID, models.ReturnDraft).
+		Order("id DESC").Limit(1).Find(&requests).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get draft return request: %w", err)
+	}
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	return &requests[0], nil
+}
+
+// SetReturnReason saves why the customer returns the order of a draft
+func (s *ReturnService) SetReturnReason(requestID uint, reason string) error {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrReturnNoReason
+	}
+	if len([]rune(reason)) > maxReturnReasonLength {
+		return ErrReturnTooLong
+	}
+	result := s.db.Model(&models.ReturnRequest{}).
+		Where("id = ? AND status = ?", requestID, models.ReturnDraft).Update("reason", reason)
+	if result.Error != nil {
+		return fmt.Errorf("failed to save return reason: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrReturnNotFound
+	}
+	return nil
+}
+
+// AddReturnPhoto adds a photo to a draft and returns how many it has
+func (s *ReturnService) AddReturnPhoto(requestID uint, fileID string) (int, error) {
+	var count int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var request models.ReturnRequest
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND status = ?", requestID, models.ReturnDraft).First(&request).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrReturnNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ReturnPhoto{}).Where("return_request_id = ?", requestID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxReturnPhotos {
+			return ErrReturnPhotoLimit
+		}
+		count++
+		return tx.Create(&models.ReturnPhoto{ReturnRequestID: requestID, FileID: fileID}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to add return photo: %w", err)
+	}
+	return int(count), nil
+}
+
+// SubmitReturn sends the customer's draft to the seller. The draft must
+// have a reason.
+func (s *ReturnService) SubmitReturn(storeID uint, customerTelegramID int64) (*models.ReturnRequest, error) {
+	request, err := s.DraftReturn(storeID, customerTelegramID)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrReturnNotFound
+	}
+	if request.Reason == "" {
+		return nil, ErrReturnNoReason
+	}
+
+	result := s.db.Model(&models.ReturnRequest{}).
+		Where("id = ? AND status = ?", request.ID, models.ReturnDraft).Update("status", models.ReturnRequested)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to submit return request: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrReturnNotFound
+	}
+	request.Status = models.ReturnRequested
+	return request, nil
+}
+
+// DiscardReturn drops the draft the customer is writing, if any
+func (s *ReturnService) DiscardReturn(storeID uint, customerTelegramID int64) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		drafts := tx.Model(&models.ReturnRequest{}).Select("id").
+			Where("store_id = ? AND customer_telegram_id = ? AND status = ?", storeID, customerTelegramID, models.ReturnDraft)
+		if err := tx.Where("return_request_id IN (?)", drafts).Delete(&models.ReturnPhoto{}).Error; err != nil {
+			return fmt.Errorf("failed to discard return photos: %w", err)
+		}
+		err := tx.Where("store_id = ? AND customer_telegram_id = ? AND status = ?", storeID, customerTelegramID, models.ReturnDraft).
+			Delete(&models.ReturnRequest{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to discard return request: %w", err)
+		}
+		return nil
+	})
+}
+
+// CustomerReturns returns the statuses of the customer's submitted return
+// requests in the store, by order
+func (s *ReturnService) CustomerReturns(storeID uint, customerTelegramID int64) (map[uint]string, error) {
+	var requests []models.ReturnRequest
+	err := s.db.Select("order_id", "status").
+		Where("store_id = ? AND customer_telegram_id = ? AND status <> ?", storeID, customerTelegramID, models.ReturnDraft).
+		Find(&requests).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list return requests: %w", err)
+	}
+	statuses := make(map[uint]string, len(requests))
+	for _, request := range requests {
+		statuses[request.OrderID] = request.Status
+	}
+	return statuses, nil
+}
+
+// ListReturns returns the submitted return requests of a store with their
+// orders, the ones waiting for the seller first
+func (s *ReturnService) ListReturns(storeID uint, limit int) ([]models.ReturnRequest, error) {
+	var requests []models.ReturnRequest
+	err := s.db.Preload("Order").
+		Where("store_id = ? AND status <> ?", storeID, models.ReturnDraft).
+		Order(clause.OrderBy{Expression: clause.Expr{SQL: "status = ? DESC, created_at DESC, id DESC", Vars: []interface{}{models.ReturnRequested}}}).
+		Limit(limit).Find(&requests).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list return requests: %w", err)
+	}
+	return requests, nil
+}
+
+// GetReturn returns a submitted return request of a store with its order,
+// items and photos
+func (s *ReturnService) GetReturn(storeID, requestID uint) (*models.ReturnRequest, error) {
+	var request models.ReturnRequest
+	err := s.db.Preload("Order.OrderItems.Product").Preload("Order.Store").Preload("Photos").
+		Where("id = ? AND store_id = ? AND status <> ?", requestID, storeID, models.ReturnDraft).
+		First(&request).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrReturnNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get return request: %w", err)
+	}
+	return &request, nil
+}
+
+// ApproveReturn accepts a return request in one transaction: the order is
+// refunded, its items are put back in stock and a refund of its total is
+// recorded for the store to pay. The customer is told through the order's
+// status notice. It returns the refund.
+func (s *ReturnService) ApproveReturn(storeID, requestID uint, actor OrderActor) (*models.Refund, error) {
+	request, err := s.GetReturn(storeID, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	var refund *models.Refund
+	_, err = s.orders.updateStatus(request.OrderID, models.OrderStatusRefunded, actor, messages.ReturnApprovedNote,
+		func(tx *gorm.DB, order *models.Order) error {
+			if err := decideReturn(tx, requestID, models.ReturnApproved); err != nil {
+				return err
+			}
+			// Delivered units left the store; they are back now
+			if order.StockCommitted {
+				if err := restockOrder(tx, order.ID); err != nil {
+					return err
+				}
+			}
+			refund = &models.Refund{
+				StoreID:            order.StoreID,
+				OrderID:            order.ID,
+				ReturnRequestID:    requestID,
+				CustomerTelegramID: order.CustomerTelegramID,
+				Amount:             order.TotalAmount,
+				Status:             models.PaymentStatusPending,
+			}
+			return tx.Create(refund).Error
+		})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// RejectReturn turns a return request down and tells the customer
+func (s *ReturnService) RejectReturn(storeID, requestID uint) (*models.ReturnRequest, error) {
+	request, err := s.GetReturn(storeID, requestID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		return decideReturn(tx, requestID, models.ReturnRejected)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to reject return request %d: %w", requestID, err)
+	}
+	request.Status = models.ReturnRejected
+
+	if s.orders.notifier != nil {
+		text := fmt.Sprintf(messages.ReturnRejectedNotice, request.OrderID, request.Order.Store.Name)
+		if err := s.orders.notifier.NotifyCustomer(&request.Order, text); err != nil {
+			log.Printf("⚠️ %v", err)
+		}
+	}
+	return request, nil
+}
+
+// decideReturn moves a return request waiting for the seller to its
+// decision, or returns ErrReturnNotPending
+func decideReturn(tx *gorm.DB, requestID uint, status string) error {
+	result := tx.Model(&models.ReturnRequest{}).
+		Where("id = ? AND status = ?", requestID, models.ReturnRequested).
+		Updates(map[string]interface{}{"status": status, "resolved_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReturnNotPending
+	}
+	return nil
+}
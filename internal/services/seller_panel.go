@@ -43,13 +43,20 @@ func NewSellerPanelService(
 	}
 }
 
+// send sends a message, logging when it can't be sent
+func (s *SellerPanelService) send(c tgbotapi.Chattable) {
+	if _, err := s.bot.Send(c); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
+
 // ShowStoreManagement displays the main store management panel
 func (s *SellerPanelService) ShowStoreManagement(chatID int64) {
 	// Get user's store
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -128,7 +135,7 @@ func (s *SellerPanelService) ShowStoreManagement(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // StartProductAddition starts the product addition process
@@ -137,14 +144,14 @@ func (s *SellerPanelService) StartProductAddition(chatID int64) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
 	// Check if store is active
 	if !store.IsActive {
 		msg := tgbotapi.NewMessage(chatID, "❌ فروشگاه شما غیرفعال است. لطفاً پلن خود را تمدید کنید.")
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -153,13 +160,13 @@ func (s *SellerPanelService) StartProductAddition(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting product count: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
 	if store.ProductLimit > 0 && currentProducts >= store.ProductLimit {
 		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("❌ شما به حد مجاز محصولات (%d) رسیده‌اید. برای افزودن محصول بیشتر، پلن خود را ارتقا دهید.", store.ProductLimit))
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -172,7 +179,7 @@ func (s *SellerPanelService) StartProductAddition(chatID int64) {
 	s.sessionService.SetUserState(chatID, messages.StateWaitingProductName, productData)
 
 	msg := tgbotapi.NewMessage(chatID, "📝 لطفاً نام محصول را وارد کنید:")
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // ShowProductList displays all products for the store
@@ -181,7 +188,7 @@ func (s *SellerPanelService) ShowProductList(chatID int64) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -189,7 +196,7 @@ func (s *SellerPanelService) ShowProductList(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting products: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -207,7 +214,7 @@ func (s *SellerPanelService) ShowProductList(chatID int64) {
 
 		msg := tgbotapi.NewMessage(chatID, text)
 		msg.ReplyMarkup = keyboard
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -255,7 +262,7 @@ func (s *SellerPanelService) ShowProductList(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // ShowOrderList displays orders for the store
@@ -264,7 +271,7 @@ func (s *SellerPanelService) ShowOrderList(chatID int64) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -272,7 +279,7 @@ func (s *SellerPanelService) ShowOrderList(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting orders: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -287,7 +294,7 @@ func (s *SellerPanelService) ShowOrderList(chatID int64) {
 
 		msg := tgbotapi.NewMessage(chatID, text)
 		msg.ReplyMarkup = keyboard
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -298,7 +305,7 @@ func (s *SellerPanelService) ShowOrderList(chatID int64) {
 	for i, order := range orders {
 		statusEmoji := "⏳"
 		switch order.Status {
-		case models.OrderStatusCompleted:
+		case models.OrderStatusDelivered:
 			statusEmoji = "✅"
 		case models.OrderStatusCancelled:
 			statusEmoji = "❌"
@@ -336,7 +343,7 @@ func (s *SellerPanelService) ShowOrderList(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // ShowSalesReport displays sales report
@@ -345,7 +352,7 @@ func (s *SellerPanelService) ShowSalesReport(chatID int64) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -354,7 +361,7 @@ func (s *SellerPanelService) ShowSalesReport(chatID int64) {
 	if err != nil {
 		log.Printf("Error getting sales report: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -382,6 +389,19 @@ func (s *SellerPanelService) ShowSalesReport(chatID int64) {
 		store.CommissionRate,
 		formatPrice(salesData.CommissionPaid))
 
+	// Redemption statistics of the store's discount codes
+	if len(salesData.Coupons) > 0 {
+		text += fmt.Sprintf(messages.SalesReportCoupons, formatPrice(salesData.TotalDiscounts))
+		for _, coupon := range salesData.Coupons {
+			status := "⏸"
+			if coupon.IsActive {
+				status = "🟢"
+			}
+			text += fmt.Sprintf(messages.SalesReportCouponLine, status, coupon.Code, coupon.Uses,
+				formatPrice(coupon.Discount), formatPrice(coupon.Sales))
+		}
+	}
+
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("📋 جزئیات سفارشات", "view_orders"),
@@ -394,7 +414,7 @@ func (s *SellerPanelService) ShowSalesReport(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // ShowStoreSettings displays store settings
@@ -403,7 +423,7 @@ func (s *SellerPanelService) ShowStoreSettings(chatID int64) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -467,7 +487,7 @@ func (s *SellerPanelService) ShowStoreSettings(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // Helper methods
@@ -533,6 +553,11 @@ func formatPrice(price int64) string {
 	return result.String()
 }
 
+// FormatPrice formats a price with thousand separators for the store bots
+func FormatPrice(price int64) string {
+	return formatPrice(price)
+}
+
 // truncateString truncates string to specified length
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram's documented limits for a single bot
+const (
+	sendGlobalInterval  = time.Second / 30 // ~30 messages per second overall
+	sendPrivateInterval = time.Second      // 1 message per second to the same chat
+	sendGroupInterval   = 3 * time.Second  // 20 messages per minute to the same group
+	sendMaxAttempts     = 3                // attempts per request when hitting 429
+	sendQueueIdleAfter  = 5 * time.Minute  // a queue without traffic releases its goroutine
+	apiStatsWindow      = 15               // minutes of Bot API calls behind the error rate
+)
+
+// SendPriority ranks the outgoing messages of one bot
+type SendPriority int
+
+const (
+	// SendPriorityTransactional is for replies and order or payment notices
+	SendPriorityTransactional SendPriority = iota
+	// SendPriorityBulk is for broadcasts and reminder sweeps
+	SendPriorityBulk
+)
+
+// sendQueues holds one queue per bot token, shared by every BotAPI using it
+var sendQueues = struct {
+	sync.Mutex
+	byToken map[string]*sendQueue
+}{byToken: make(map[string]*sendQueue)}
+
+// UseSendQueue routes the bot's outgoing messages through the send queue of
+// its token. Requests wait for a free slot under Telegram's global and
+// per-chat limits, 429 answers are retried after retry_after, and the final
+// error is returned to the caller of Send/Request as usual.
+func UseSendQueue(bot *tgbotapi.BotAPI) {
+	if bot == nil {
+		return
+	}
+	if _, ok := bot.Client.(*queuedClient); ok {
+		return
+	}
+
+	bot.Client = &queuedClient{
+		queue:    sendQueueFor(bot.Token),
+		next:     bot.Client,
+		priority: SendPriorityTransactional,
+	}
+}
+
+// BulkSender returns a copy of bot whose messages share the token's queue
+// but only go out when no transactional message is waiting. Use it for
+// broadcasts and reminder sweeps.
+func BulkSender(bot *tgbotapi.BotAPI) *tgbotapi.BotAPI {
+	if bot == nil {
+		return nil
+	}
+
+	next := bot.Client
+	if qc, ok := next.(*queuedClient); ok {
+		next = qc.next
+	}
+
+	bulk := *bot
+	bulk.Client = &queuedClient{
+		queue:    sendQueueFor(bot.Token),
+		next:     next,
+		priority: SendPriorityBulk,
+	}
+	return &bulk
+}
+
+func sendQueueFor(token string) *sendQueue {
+	sendQueues.Lock()
+	defer sendQueues.Unlock()
+
+	q, ok := sendQueues.byToken[token]
+	if !ok {
+		q = &sendQueue{
+			chatNext: make(map[int64]time.Time),
+			wake:     make(chan struct{}, 1),
+		}
+		sendQueues.byToken[token] = q
+	}
+	return q
+}
+
+// queuedClient is the HTTP client installed on a BotAPI by UseSendQueue
+type queuedClient struct {
+	queue    *sendQueue
+	next     tgbotapi.HTTPClient
+	priority SendPriority
+}
+
+func (c *queuedClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.do(req)
+
+	// Calls aborted by our own shutdown say nothing about the bot's health
+	if req.Context().Err() == nil {
+		c.queue.stats.record(isFailedAPICall(resp, err))
+	}
+	return resp, err
+}
+
+func (c *queuedClient) do(req *http.Request) (*http.Response, error) {
+	if !isQueuedMethod(path.Base(req.URL.Path)) {
+		return c.next.Do(req)
+	}
+
+	// Bodies are kept so the request can be replayed after a 429, uploads
+	// included: Telegram takes files of up to 50 MB from bots
+	var body []byte
+	var chatID int64
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		chatID = requestChatID(req.Header.Get("Content-Type"), body)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.queue.acquire(req.Context(), c.priority, chatID); err != nil {
+			return nil, err
+		}
+
+		try := req
+		if body != nil {
+			try = req.Clone(req.Context())
+			try.Body = io.NopCloser(bytes.NewReader(body))
+			try.ContentLength = int64(len(body))
+		}
+
+		resp, err := c.next.Do(try)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+
+		// Flood control: hold back the whole bot for retry_after, then retry
+		respBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+		retryAfter := parseRetryAfter(respBody)
+		c.queue.pause(retryAfter)
+
+		if attempt >= sendMaxAttempts {
+			log.Printf("Telegram rate limit hit for chat %d, giving up after %d attempts", chatID, attempt)
+			return resp, nil
+		}
+	}
+}
+
+// requestChatID reads chat_id from a form or multipart request body, or
+// returns 0 when it has none
+func requestChatID(contentType string, body []byte) int64 {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0
+	}
+
+	var value string
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return 0
+		}
+		value = values.Get("chat_id")
+	case "multipart/form-data":
+		// The params are written before the files, so the scan stops early
+		reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				return 0
+			}
+			if part.FormName() == "chat_id" {
+				field, _ := io.ReadAll(io.LimitReader(part, 32))
+				value = string(field)
+				break
+			}
+		}
+	}
+
+	chatID, _ := strconv.ParseInt(value, 10, 64)
+	return chatID
+}
+
+// isQueuedMethod reports whether a Bot API method sends or edits a message
+func isQueuedMethod(method string) bool {
+	for _, prefix := range []string{"send", "edit", "copy", "forward"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetryAfter reads retry_after from a 429 answer
+func parseRetryAfter(body []byte) time.Duration {
+	var apiResp tgbotapi.APIResponse
+	if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Parameters != nil && apiResp.Parameters.RetryAfter > 0 {
+		return time.Duration(apiResp.Parameters.RetryAfter) * time.Second
+	}
+	return time.Second
+}
+
+// isFailedAPICall reports whether a Bot API call failed in a way that points
+// at the bot rather than the request: network errors, server errors, a
+// revoked token and flood control
+func isFailedAPICall(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError,
+		resp.StatusCode == http.StatusUnauthorized,
+		resp.StatusCode == http.StatusNotFound,
+		resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	}
+	return false
+}
+
+// APIErrorRate returns the share of failed Bot API calls of a token over the
+// last few minutes and how many calls that is based on
+func APIErrorRate(token string) (float64, int) {
+	sendQueues.Lock()
+	q, ok := sendQueues.byToken[token]
+	sendQueues.Unlock()
+
+	if !ok {
+		return 0, 0
+	}
+	return q.stats.rate(time.Now())
+}
+
+// apiStats counts Bot API calls in one-minute buckets over a rolling window
+type apiStats struct {
+	mu      sync.Mutex
+	buckets [apiStatsWindow]apiStatsBucket
+}
+
+type apiStatsBucket struct {
+	minute int64
+	total  int
+	failed int
+}
+
+func (s *apiStats) record(failed bool) {
+	minute := time.Now().Unix() / 60
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket := &s.buckets[minute%apiStatsWindow]
+	if bucket.minute != minute {
+		*bucket = apiStatsBucket{minute: minute}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+}
+
+func (s *apiStats) rate(now time.Time) (float64, int) {
+	minute := now.Unix() / 60
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total, failed int
+	for _, bucket := range s.buckets {
+		if minute-bucket.minute < apiStatsWindow {
+			total += bucket.total
+			failed += bucket.failed
+		}
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(failed) / float64(total), total
+}
+
+// sendQueue hands out send slots for one bot token
+type sendQueue struct {
+	mu          sync.Mutex
+	waiting     [2][]*sendTicket // indexed by SendPriority
+	nextGlobal  time.Time
+	chatNext    map[int64]time.Time
+	pausedUntil time.Time
+	running     bool
+	wake        chan struct{}
+	stats       apiStats
+}
+
+type sendTicket struct {
+	chatID int64
+	ready  chan struct{}
+}
+
+// acquire blocks until the request may be sent or ctx is done
+func (q *sendQueue) acquire(ctx context.Context, priority SendPriority, chatID int64) error {
+	ticket := &sendTicket{chatID: chatID, ready: make(chan struct{})}
+
+	q.mu.Lock()
+	q.waiting[priority] = append(q.waiting[priority], ticket)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	q.mu.Unlock()
+	q.notify()
+
+	select {
+	case <-ticket.ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-ticket.ready:
+			// Granted meanwhile; the slot is simply unused
+		default:
+			q.remove(priority, ticket)
+		}
+		return ctx.Err()
+	}
+}
+
+// pause holds back every request of the bot for d
+func (q *sendQueue) pause(d time.Duration) {
+	q.mu.Lock()
+	if until := time.Now().Add(d); until.After(q.pausedUntil) {
+		q.pausedUntil = until
+	}
+	q.mu.Unlock()
+	q.notify()
+}
+
+func (q *sendQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run grants slots in priority order, respecting the global and per-chat limits
+func (q *sendQueue) run() {
+	idleSince := time.Now()
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		wait := q.grant(now)
+		if wait == 0 {
+			q.mu.Unlock()
+			continue
+		}
+
+		if len(q.waiting[SendPriorityTransactional])+len(q.waiting[SendPriorityBulk]) == 0 {
+			if now.Sub(idleSince) >= sendQueueIdleAfter {
+				q.running = false
+				q.mu.Unlock()
+				return
+			}
+			q.pruneChats(now)
+		} else {
+			idleSince = now
+		}
+		q.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// grant releases the next ticket that may go now and returns 0, or returns
+// how long to wait before anything can go
+func (q *sendQueue) grant(now time.Time) time.Duration {
+	if now.Before(q.pausedUntil) {
+		return q.pausedUntil.Sub(now)
+	}
+	if now.Before(q.nextGlobal) {
+		return q.nextGlobal.Sub(now)
+	}
+
+	wait := sendQueueIdleAfter
+	for priority := range q.waiting {
+		for _, ticket := range q.waiting[priority] {
+			next := q.chatNext[ticket.chatID]
+			if ticket.chatID == 0 || !now.Before(next) {
+				q.remove(SendPriority(priority), ticket)
+				q.nextGlobal = now.Add(sendGlobalInterval)
+				if ticket.chatID != 0 {
+					q.chatNext[ticket.chatID] = now.Add(chatSendInterval(ticket.chatID))
+				}
+				close(ticket.ready)
+				return 0
+			}
+			if d := next.Sub(now); d < wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+func (q *sendQueue) remove(priority SendPriority, ticket *sendTicket) {
+	tickets := q.waiting[priority]
+	for i, t := range tickets {
+		if t == ticket {
+			q.waiting[priority] = append(tickets[:i], tickets[i+1:]...)
+			return
+		}
+	}
+}
+
+// pruneChats forgets chats whose interval has passed
+func (q *sendQueue) pruneChats(now time.Time) {
+	for chatID, next := range q.chatNext {
+		if now.After(next) {
+			delete(q.chatNext, chatID)
+		}
+	}
+}
+
+// chatSendInterval is the minimum gap between two messages to a chat.
+// Group and channel IDs are negative.
+func chatSendInterval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return sendGroupInterval
+	}
+	return sendPrivateInterval
+}
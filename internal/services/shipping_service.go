@@ -0,0 +1,278 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"telegram-store-hub/internal/models"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// maxShippingNameLength keeps shipping method names short enough for a button
+const maxShippingNameLength = 40
+
+// Shipping errors
+var (
+	ErrShippingMethodNotFound = errors.New("shipping method not found")
+	ErrShippingUnavailable    = errors.New("shipping method is no longer available")
+)
+
+// ShippingSpecError is returned for a shipping method description the
+// seller mistyped; Part is the line or word that couldn't be understood
+type ShippingSpecError struct {
+	Part string
+}
+
+func (e *ShippingSpecError) Error() string {
+	return fmt.Sprintf("invalid shipping method description near %q", e.Part)
+}
+
+// ShippingService manages the shipping methods of stores
+type ShippingService struct {
+	db *gorm.DB
+}
+
+// NewShippingService creates a new shipping service
+func NewShippingService(db *gorm.DB) *ShippingService {
+	return &ShippingService{db: db}
+}
+
+// ParseShippingSpec reads a shipping method from the description sellers
+// send: its name on the first line, then its options, then for region-based
+// rates one REGION=FEE line per city or province:
+//
+//	Tehran courier
+//	kind=courier rate=region fee=60000 free=1000000
+//	Tehran=40000
+//
+// kind is courier, post or pickup and rate is flat, region or weight; fee is
+// the flat fee, the fee of other regions or the base of weight-based rates,
+// perkg the fee of each started kilogram and free the item subtotal from
+// which shipping is free.
+func ParseShippingSpec(spec string) (*models.ShippingMethod, error) {
+	lines := strings.Split(latinDigits(strings.TrimSpace(spec)), "\n")
+	method := &models.ShippingMethod{
+		Name:     strings.TrimSpace(lines[0]),
+		Kind:     models.ShippingCourier,
+		RateType: models.ShippingRateFlat,
+		IsActive: true,
+	}
+
+	if len(lines) > 1 {
+		for _, field := range strings.Fields(lines[1]) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				return nil, &ShippingSpecError{Part: field}
+			}
+			switch key {
+			case "kind":
+				method.Kind = value
+			case "rate":
+				method.RateType = value
+			case "fee", "perkg", "free":
+				amount, err := parseAmount(value)
+				if err != nil {
+					return nil, &ShippingSpecError{Part: field}
+				}
+				switch key {
+				case "fee":
+					method.BaseFee = amount
+				case "perkg":
+					method.PerKgFee = amount
+				case "free":
+					method.FreeOver = amount
+				}
+			default:
+				return nil, &ShippingSpecError{Part: field}
+			}
+		}
+	}
+
+	for _, line := range lines[min(len(lines), 2):] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		region, value, ok := strings.Cut(line, "=")
+		fee, err := parseAmount(strings.TrimSpace(value))
+		if !ok || strings.TrimSpace(region) == "" || err != nil {
+			return nil, &ShippingSpecError{Part: line}
+		}
+		method.Rates = append(method.Rates, models.ShippingRate{Region: strings.TrimSpace(region), Fee: fee})
+	}
+	return method, nil
+}
+
+// CreateMethod checks a new shipping method of a store and saves it with
+// its region rates. It returns a *ShippingSpecError for invalid values.
+func (s *ShippingService) CreateMethod(method *models.ShippingMethod) error {
+	if err := validateShippingMethod(method); err != nil {
+		return err
+	}
+	if err := s.db.Create(method).Error; err != nil {
+		return fmt.Errorf("failed to create shipping method: %w", err)
+	}
+	return nil
+}
+
+// ListMethods returns the shipping methods of a store with their rates,
+// only the active ones when activeOnly is set
+func (s *ShippingService) ListMethods(storeID uint, activeOnly bool) ([]models.ShippingMethod, error) {
+	query := s.db.Preload("Rates").Where("store_id = ?", storeID)
+	if activeOnly {
+		query = query.Where("is_active = ?", true)
+	}
+	var methods []models.ShippingMethod
+	if err := query.Order("id ASC").Find(&methods).Error; err != nil {
+		return nil, fmt.Errorf("failed to list shipping methods: %w", err)
+	}
+	return methods, nil
+}
+
+// GetMethod returns a shipping method of a store with its rates
+func (s *ShippingService) GetMethod(storeID, methodID uint) (*models.ShippingMethod, error) {
+	var method models.ShippingMethod
+	err := s.db.Preload("Rates").Where("id = ? AND store_id = ?", methodID, storeID).First(&method).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShippingMethodNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get shipping method: %w", err)
+	}
+	return &method, nil
+}
+
+// SetMethodActive offers or withdraws a shipping method of a store
+func (s *ShippingService) SetMethodActive(storeID, methodID uint, active bool) error {
+	result := s.db.Model(&models.ShippingMethod{}).Where("id = ? AND store_id = ?", methodID, storeID).Update("is_active", active)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update shipping method: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrShippingMethodNotFound
+	}
+	return nil
+}
+
+// DeleteMethod removes a shipping method of a store with its rates. Orders
+// keep the name and fee of the method they were placed with.
+func (s *ShippingService) DeleteMethod(storeID, methodID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND store_id = ?", methodID, storeID).Delete(&models.ShippingMethod{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete shipping method: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrShippingMethodNotFound
+		}
+		if err := tx.Where("shipping_method_id = ?", methodID).Delete(&models.ShippingRate{}).Error; err != nil {
+			return fmt.Errorf("failed to delete shipping rates: %w", err)
+		}
+		return nil
+	})
+}
+
+// ShippingFee is the fee of a shipping method for the available items of a
+// cart delivered to a city or province. Shipping is free from the method's
+// threshold on, compared to the item subtotal before discounts.
+func ShippingFee(method *models.ShippingMethod, city string, items []models.CartItem) int64 {
+	var subtotal int64
+	grams := 0
+	for _, item := range items {
+		if CartItemAvailable(item) {
+			subtotal += CartItemPrice(item) * int64(item.Quantity)
+			grams += item.Product.Weight * item.Quantity
+		}
+	}
+	if method.FreeOver > 0 && subtotal >= method.FreeOver {
+		return 0
+	}
+
+	switch method.RateType {
+	case models.ShippingRateRegion:
+		if rate, ok := regionRate(method, city); ok {
+			return rate.Fee
+		}
+	case models.ShippingRateWeight:
+		kilograms := int64((grams + 999) / 1000)
+		return method.BaseFee + kilograms*method.PerKgFee
+	}
+	return method.BaseFee
+}
+
+// regionRate returns the rate of a region-based method for a city or
+// province
+func regionRate(method *models.ShippingMethod, city string) (models.ShippingRate, bool) {
+	city = strings.TrimSpace(city)
+	for _, rate := range method.Rates {
+		if strings.EqualFold(rate.Region, city) {
+			return rate, true
+		}
+	}
+	return models.ShippingRate{}, false
+}
+
+// NeedsAddress reports whether orders shipped with a method need a delivery
+// address
+func NeedsAddress(method *models.ShippingMethod) bool {
+	return method.Kind != models.ShippingPickup
+}
+
+// loadShippingMethod returns an active shipping method of the store for an
+// order, or ErrShippingUnavailable
+func loadShippingMethod(tx *gorm.DB, storeID, methodID uint) (*models.ShippingMethod, error) {
+	var method models.ShippingMethod
+	err := tx.Preload("Rates").Where("id = ? AND store_id = ? AND is_active = ?", methodID, storeID, true).First(&method).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrShippingUnavailable
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &method, nil
+}
+
+// validateShippingMethod checks the values of a new shipping method
+func validateShippingMethod(method *models.ShippingMethod) error {
+	method.Name = strings.TrimSpace(method.Name)
+	if method.Name == "" || utf8.RuneCountInString(method.Name) > maxShippingNameLength {
+		return &ShippingSpecError{Part: method.Name}
+	}
+	switch method.Kind {
+	case models.ShippingCourier, models.ShippingPost, models.ShippingPickup:
+	default:
+		return &ShippingSpecError{Part: "kind=" + method.Kind}
+	}
+	switch method.RateType {
+	case models.ShippingRateFlat, models.ShippingRateWeight:
+		if len(method.Rates) > 0 {
+			return &ShippingSpecError{Part: method.Rates[0].Region + "=" + strconv.FormatInt(method.Rates[0].Fee, 10)}
+		}
+	case models.ShippingRateRegion:
+		if len(method.Rates) == 0 {
+			return &ShippingSpecError{Part: "rate=" + method.RateType}
+		}
+	default:
+		return &ShippingSpecError{Part: "rate=" + method.RateType}
+	}
+	// Orders picked up have no address to price by
+	if method.Kind == models.ShippingPickup && method.RateType != models.ShippingRateFlat {
+		return &ShippingSpecError{Part: "rate=" + method.RateType}
+	}
+	if method.BaseFee < 0 || method.PerKgFee < 0 || method.FreeOver < 0 {
+		return &ShippingSpecError{Part: method.Name}
+	}
+	return nil
+}
+
+// parseAmount reads an amount in toman, with or without thousands separators
+func parseAmount(value string) (int64, error) {
+	amount, err := strconv.ParseInt(strings.ReplaceAll(value, ",", ""), 10, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	return amount, nil
+}
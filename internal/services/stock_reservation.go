@@ -0,0 +1,211 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Stock reservation timing. Orders are confirmed by hand, so a reservation
+// gives the seller a day before the stock goes back on sale.
+const (
+	stockReservationTTL    = 24 * time.Hour
+	reservationExpiryEvery = 5 * time.Minute
+)
+
+// availableStockSQL is a product's stock minus its live reservations
+const availableStockSQL = `products.stock - COALESCE((SELECT SUM(stock_reservations.quantity) FROM stock_reservations
+	WHERE stock_reservations.product_id = products.id AND stock_reservations.expires_at > NOW()), 0)`
+
+// availableVariantStockSQL is a variant's stock minus its live reservations
+const availableVariantStockSQL = `product_variants.stock - COALESCE((SELECT SUM(stock_reservations.quantity) FROM stock_reservations
+	WHERE stock_reservations.variant_id = product_variants.id AND stock_reservations.expires_at > NOW()), 0)`
+
+// OutOfStockError is returned when a product doesn't have enough stock left
+// for an order
+type OutOfStockError struct {
+	ProductID uint
+	Product   string
+	Available int
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("only %d of product %s left", e.Available, e.Product)
+}
+
+// inStock keeps the products that don't track their stock or have some left
+func inStock(db *gorm.DB) *gorm.DB {
+	return db.Where("products.track_stock = ? OR "+availableStockSQL+" > 0", false)
+}
+
+// availableStock returns how many units of a product can still be sold
+func availableStock(db *gorm.DB, productID uint) (int, error) {
+	var available int
+	err := db.Model(&models.Product{}).Where("products.id = ?", productID).
+		Select(availableStockSQL).Scan(&available).Error
+	return available, err
+}
+
+// availableVariantStock returns how many units of a variant can still be sold
+func availableVariantStock(db *gorm.DB, variantID uint) (int, error) {
+	var available int
+	err := db.Model(&models.ProductVariant{}).Where("product_variants.id = ?", variantID).
+		Select(availableVariantStockSQL).Scan(&available).Error
+	return available, err
+}
+
+// reserveStock holds the stock of the order's tracked products, and of their
+// variants for items of one. The products are locked in ID order so
+// concurrent checkouts can't both take the last unit and can't deadlock.
+func reserveStock(tx *gorm.DB, orderID uint, items []models.CartItem) error {
+	items = append([]models.CartItem(nil), items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].ProductID < items[j].ProductID })
+
+	expiresAt := time.Now().Add(stockReservationTTL)
+	for _, item := range items {
+		if !item.Product.TrackStock {
+			continue
+		}
+
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, item.ProductID).Error; err != nil {
+			return err
+		}
+		available, err := availableStock(tx, item.ProductID)
+		if err != nil {
+			return err
+		}
+		if available < item.Quantity {
+			return &OutOfStockError{ProductID: product.ID, Product: product.Name, Available: max(available, 0)}
+		}
+		if item.VariantID != nil {
+			available, err := availableVariantStock(tx, *item.VariantID)
+			if err != nil {
+				return err
+			}
+			if available < item.Quantity {
+				return &OutOfStockError{ProductID: product.ID, Product: CartItemName(item), Available: max(available, 0)}
+			}
+		}
+
+		reservation := models.StockReservation{
+			OrderID:   orderID,
+			ProductID: item.ProductID,
+			VariantID: item.VariantID,
+			Quantity:  item.Quantity,
+			ExpiresAt: expiresAt,
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitStockReservations takes an order's reserved units off the stock and
+// marks the order as having done so. A reservation may have expired before
+// the order was confirmed and its units been reserved by other orders since,
+// so the units are only taken while no other order holds them: with the
+// product locked, the order may take what's available plus what it still
+// holds itself.
+func commitStockReservations(tx *gorm.DB, orderID uint) error {
+	var reservations []models.StockReservation
+	if err := tx.Where("order_id = ?", orderID).Order("product_id ASC").Find(&reservations).Error; err != nil {
+		return err
+	}
+
+	for _, reservation := range reservations {
+		var product models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&product, reservation.ProductID).Error; err != nil {
+			return err
+		}
+		available, err := availableStock(tx, product.ID)
+		if err != nil {
+			return err
+		}
+		held, err := heldStock(tx, orderID, "product_id", product.ID)
+		if err != nil {
+			return err
+		}
+		if available+held < reservation.Quantity {
+			return &OutOfStockError{ProductID: product.ID, Product: product.Name, Available: max(available+held, 0)}
+		}
+		if err := tx.Model(&product).Update("stock", gorm.Expr("stock - ?", reservation.Quantity)).Error; err != nil {
+			return err
+		}
+		if reservation.VariantID == nil {
+			continue
+		}
+
+		var variant models.ProductVariant
+		if err := tx.First(&variant, *reservation.VariantID).Error; err != nil {
+			return err
+		}
+		available, err = availableVariantStock(tx, variant.ID)
+		if err != nil {
+			return err
+		}
+		held, err = heldStock(tx, orderID, "variant_id", variant.ID)
+		if err != nil {
+			return err
+		}
+		if available+held < reservation.Quantity {
+			return &OutOfStockError{ProductID: product.ID, Product: variantTitle(product.Name, &variant), Available: max(available+held, 0)}
+		}
+		if err := tx.Model(&variant).Update("stock", gorm.Expr("stock - ?", reservation.Quantity)).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(reservations) > 0 {
+		if err := tx.Model(&models.Order{}).Where("id = ?", orderID).Update("stock_committed", true).Error; err != nil {
+			return err
+		}
+	}
+	return releaseStockReservations(tx, orderID)
+}
+
+// heldStock returns how many units of a product or variant, by column, an
+// order holds in live reservations
+func heldStock(tx *gorm.DB, orderID uint, column string, id uint) (int, error) {
+	var held int
+	err := tx.Model(&models.StockReservation{}).
+		Where("order_id = ? AND "+column+" = ? AND expires_at > NOW()", orderID, id).
+		Select("COALESCE(SUM(quantity), 0)").Scan(&held).Error
+	return held, err
+}
+
+// releaseStockReservations drops an order's reservations
+func releaseStockReservations(tx *gorm.DB, orderID uint) error {
+	return tx.Where("order_id = ?", orderID).Delete(&models.StockReservation{}).Error
+}
+
+// restockOrder puts the units an order took off the stock back on sale.
+// License keys that were delivered can't be taken back, so their products
+// are left alone.
+func restockOrder(tx *gorm.DB, orderID uint) error {
+	var items []models.OrderItem
+	if err := tx.Joins("JOIN products ON products.id = order_items.product_id").
+		Where("order_items.order_id = ? AND products.track_stock = ? AND products.digital_type <> ?", orderID, true, models.DigitalLicense).
+		Order("order_items.product_id ASC").Find(&items).Error; err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		if err := tx.Model(&models.Product{}).Where("id = ?", item.ProductID).
+			Update("stock", gorm.Expr("stock + ?", item.Quantity)).Error; err != nil {
+			return err
+		}
+		if item.VariantID != nil {
+			if err := tx.Model(&models.ProductVariant{}).Where("id = ?", *item.VariantID).
+				Update("stock", gorm.Expr("stock + ?", item.Quantity)).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Model(&models.Order{}).Where("id = ?", orderID).Update("stock_committed", false).Error
+}
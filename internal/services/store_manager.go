@@ -68,6 +68,14 @@ func (s *StoreManagerService) GetUserStores(ownerID uint) ([]models.Store, error
 	return stores, err
 }
 
+// GetStoreByOwner gets the store of the seller with a Telegram ID
+func (s *StoreManagerService) GetStoreByOwner(telegramID int64) (*models.Store, error) {
+	var store models.Store
+	err := s.db.Joins("JOIN users ON users.id = stores.owner_id").
+		Where("users.telegram_id = ?", telegramID).First(&store).Error
+	return &store, err
+}
+
 // UpdateStore updates store information
 func (s *StoreManagerService) UpdateStore(store *models.Store) error {
 	return s.db.Save(store).Error
@@ -165,9 +173,9 @@ func (s *StoreManagerService) GetStoreStats(storeID uint) (map[string]interface{
 	// Calculate order stats
 	for _, order := range store.Orders {
 		switch order.Status {
-		case "pending":
+		case models.OrderStatusPending:
 			stats["pending_orders"] = stats["pending_orders"].(int) + 1
-		case "completed":
+		case models.OrderStatusDelivered:
 			stats["completed_orders"] = stats["completed_orders"].(int) + 1
 			stats["total_revenue"] = stats["total_revenue"].(int64) + order.TotalAmount
 		}
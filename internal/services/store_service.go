@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/secrets"
 	"gorm.io/gorm"
 )
 
@@ -52,7 +53,7 @@ func (s *StoreService) GetStoreByID(id uint) (*models.Store, error) {
 func (s *StoreService) ActivateStore(storeID uint, botToken, botUsername string) error {
 	return s.db.Model(&models.Store{}).Where("id = ?", storeID).Updates(map[string]interface{}{
 		"is_active":    true,
-		"bot_token":    botToken,
+		"bot_token":    secrets.Sealed(botToken),
 		"bot_username": botUsername,
 	}).Error
 }
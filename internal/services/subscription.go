@@ -50,6 +50,13 @@ func NewSubscriptionService(
 	}
 }
 
+// send sends a message, logging when it can't be sent
+func (s *SubscriptionService) send(c tgbotapi.Chattable) {
+	if _, err := s.bot.Send(c); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
+
 // GetAvailablePlans returns all available subscription plans
 func (s *SubscriptionService) GetAvailablePlans() []PlanDetails {
 	return []PlanDetails{
@@ -109,7 +116,7 @@ func (s *SubscriptionService) ShowPlanRenewal(chatID int64) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -122,7 +129,7 @@ func (s *SubscriptionService) ShowPlanRenewal(chatID int64) {
 	currentPlan := s.GetPlanByType(string(store.PlanType))
 	if currentPlan == nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorDatabaseError)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -159,7 +166,7 @@ func (s *SubscriptionService) ShowPlanRenewal(chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // HandlePlanRenewal handles plan renewal request
@@ -168,14 +175,14 @@ func (s *SubscriptionService) HandlePlanRenewal(chatID int64, planType string) {
 	store, err := s.getUserStore(chatID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, messages.ErrorNoStore)
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
 	plan := s.GetPlanByType(planType)
 	if plan == nil {
 		msg := tgbotapi.NewMessage(chatID, "❌ پلن انتخابی معتبر نیست.")
-		s.bot.Send(msg)
+		s.send(msg)
 		return
 	}
 
@@ -220,7 +227,7 @@ func (s *SubscriptionService) ShowPaymentInstructions(chatID int64, planType str
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // ProcessPlanRenewal processes the plan renewal
@@ -285,7 +292,7 @@ func (s *SubscriptionService) SendRenewalSuccess(chatID int64, planType string)
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	s.send(msg)
 }
 
 // CheckExpiringSubscriptions checks for expiring subscriptions and sends reminders
@@ -334,7 +341,10 @@ func (s *SubscriptionService) SendExpiryReminder(chatID int64, store *models.Sto
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	// Sent from a sweep, so it queues behind transactional messages
+	if _, err := BulkSender(s.bot).Send(msg); err != nil {
+		log.Printf("Error sending expiry reminder to %d: %v", chatID, err)
+	}
 }
 
 // DeactivateExpiredSubscriptions deactivates expired subscriptions
@@ -379,7 +389,10 @@ func (s *SubscriptionService) SendExpiryNotification(chatID int64, store *models
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyMarkup = keyboard
 
-	s.bot.Send(msg)
+	// Sent from a sweep, so it queues behind transactional messages
+	if _, err := BulkSender(s.bot).Send(msg); err != nil {
+		log.Printf("Error sending expiry notification to %d: %v", chatID, err)
+	}
 }
 
 // Helper methods
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"fmt"
+	"telegram-store-hub/internal/secrets"
+
+	"gorm.io/gorm"
+)
+
+// storedBotToken is a store's bot token as it is in the database, read
+// without the model's decrypting serializer
+type storedBotToken struct {
+	ID       uint
+	BotToken string
+}
+
+// ReencryptBotTokens encrypts every stored bot token with the keyring's
+// primary key. Tokens still in plaintext or under an old key are rewritten;
+// the rest are left alone, so the rotation can be re-run after a failure.
+// It returns the number of tokens rewritten.
+func ReencryptBotTokens(db *gorm.DB, keyring *secrets.Keyring) (int, error) {
+	var rows []storedBotToken
+	if err := db.Table("stores").Select("id, bot_token").Where("bot_token <> ''").Find(&rows).Error; err != nil {
+		return 0, fmt.Errorf("failed to load bot tokens: %w", err)
+	}
+
+	rotated := 0
+	for _, row := range rows {
+		if !keyring.NeedsRotation(row.BotToken) {
+			continue
+		}
+
+		token, err := keyring.Decrypt(row.BotToken)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to decrypt token of store %d: %w", row.ID, err)
+		}
+		sealed, err := keyring.Encrypt(token)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to encrypt token of store %d: %w", row.ID, err)
+		}
+
+		// Skip the row if its token was replaced while rotating
+		result := db.Table("stores").Where("id = ? AND bot_token = ?", row.ID, row.BotToken).Update("bot_token", sealed)
+		if result.Error != nil {
+			return rotated, fmt.Errorf("failed to save token of store %d: %w", row.ID, result.Error)
+		}
+		rotated += int(result.RowsAffected)
+	}
+
+	return rotated, nil
+}
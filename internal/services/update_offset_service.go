@@ -0,0 +1,60 @@
+package services
+
+import (
+	"errors"
+	"telegram-store-hub/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Telegram picks update IDs at random again after a week without updates, so
+// an older offset says nothing about the updates that follow it
+const updateIDResetAfter = 7 * 24 * time.Hour
+
+// UpdateOffsetService persists how far each bot has handled its updates so
+// a restarted bot resumes after the last handled update
+type UpdateOffsetService struct {
+	db *gorm.DB
+}
+
+// NewUpdateOffsetService creates a new update offset service
+func NewUpdateOffsetService(db *gorm.DB) *UpdateOffsetService {
+	return &UpdateOffsetService{db: db}
+}
+
+// GetLastUpdateID returns the last handled update_id of a bot, or 0 when the
+// bot has no recent offset
+func (s *UpdateOffsetService) GetLastUpdateID(botID int64) (int, error) {
+	var offset models.BotUpdateOffset
+	err := s.db.Where("bot_id = ?", botID).First(&offset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if time.Since(offset.UpdatedAt) > updateIDResetAfter {
+		return 0, nil
+	}
+	return offset.LastUpdateID, nil
+}
+
+// SaveLastUpdateID records updateID as handled. The stored value never moves
+// backwards, so a late save from a stopping instance is harmless.
+func (s *UpdateOffsetService) SaveLastUpdateID(botID int64, updateID int) error {
+	offset := models.BotUpdateOffset{
+		BotID:        botID,
+		LastUpdateID: updateID,
+		UpdatedAt:    time.Now(),
+	}
+
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "bot_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_update_id": gorm.Expr("GREATEST(bot_update_offsets.last_update_id, EXCLUDED.last_update_id)"),
+			"updated_at":     offset.UpdatedAt,
+		}),
+	}).Create(&offset).Error
+}
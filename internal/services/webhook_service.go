@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// WebhookPathPrefix is where bots receive updates: /tg/{storeID}
+	WebhookPathPrefix = "/tg/"
+
+	// webhookSecretHeader carries the secret token Telegram echoes back
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	webhookBufferSize      = 100
+	webhookDeliveryTimeout = 10 * time.Second
+	webhookMaxBodyBytes    = 1 << 20
+)
+
+// WebhookService receives updates for every bot over a single HTTP server.
+// Each bot is registered under its own path and secret token.
+type WebhookService struct {
+	listenAddr string
+	baseURL    string
+	secret     []byte
+	server     *http.Server
+
+	mu     sync.RWMutex
+	routes map[string]*webhookRoute
+}
+
+type webhookRoute struct {
+	secretToken string
+	updates     chan tgbotapi.Update
+}
+
+// NewWebhookService creates a new webhook service. baseURL is the public
+// HTTPS address Telegram calls; secret derives the per-bot secret tokens.
+func NewWebhookService(listenAddr, baseURL, secret string) *WebhookService {
+	w := &WebhookService{
+		listenAddr: listenAddr,
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		secret:     []byte(secret),
+		routes:     make(map[string]*webhookRoute),
+	}
+	w.server = &http.Server{
+		Addr:              listenAddr,
+		Handler:           w,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return w
+}
+
+// Start begins listening for webhook requests in the background
+func (w *WebhookService) Start() error {
+	listener, err := net.Listen("tcp", w.listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", w.listenAddr, err)
+	}
+
+	go func() {
+		if err := w.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Webhook server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Webhook server listening on %s", w.listenAddr)
+	return nil
+}
+
+// Shutdown stops the HTTP server, waiting for in-flight requests
+func (w *WebhookService) Shutdown(ctx context.Context) error {
+	return w.server.Shutdown(ctx)
+}
+
+// SetWebhook routes updates for key to the returned channel and points the
+// bot's webhook at it
+func (w *WebhookService) SetWebhook(bot *tgbotapi.BotAPI, key string) (<-chan tgbotapi.Update, error) {
+	url, secretToken, updates := w.register(key)
+
+	params := tgbotapi.Params{
+		"url":          url,
+		"secret_token": secretToken,
+	}
+	if _, err := bot.MakeRequest("setWebhook", params); err != nil {
+		w.unregister(key, updates)
+		return nil, fmt.Errorf("failed to set webhook: %w", err)
+	}
+
+	return updates, nil
+}
+
+// RemoveWebhook deletes the bot's webhook and stops routing updates for key.
+// Pending updates stay with Telegram and are delivered on the next start.
+func (w *WebhookService) RemoveWebhook(bot *tgbotapi.BotAPI, key string, updates <-chan tgbotapi.Update) {
+	w.unregister(key, updates)
+
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Printf("Failed to delete webhook for %s: %v", key, err)
+	}
+}
+
+// ServeHTTP accepts an update from Telegram and hands it to the bot's loop
+func (w *WebhookService) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, WebhookPathPrefix) {
+		http.NotFound(rw, r)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, WebhookPathPrefix)
+
+	w.mu.RLock()
+	route, ok := w.routes[key]
+	w.mu.RUnlock()
+	if !ok {
+		http.NotFound(rw, r)
+		return
+	}
+
+	token := r.Header.Get(webhookSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(route.secretToken)) != 1 {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(http.MaxBytesReader(rw, r.Body, webhookMaxBodyBytes)).Decode(&update); err != nil {
+		http.Error(rw, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	// A non-2xx answer makes Telegram retry later, so never drop an update here
+	select {
+	case route.updates <- update:
+		rw.WriteHeader(http.StatusOK)
+	case <-time.After(webhookDeliveryTimeout):
+		http.Error(rw, "busy", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+// register adds or replaces the route for key
+func (w *WebhookService) register(key string) (string, string, chan tgbotapi.Update) {
+	route := &webhookRoute{
+		secretToken: w.secretToken(key),
+		updates:     make(chan tgbotapi.Update, webhookBufferSize),
+	}
+
+	w.mu.Lock()
+	w.routes[key] = route
+	w.mu.Unlock()
+
+	return w.baseURL + WebhookPathPrefix + key, route.secretToken, route.updates
+}
+
+// unregister removes the route for key unless it was replaced in the meantime
+func (w *WebhookService) unregister(key string, updates <-chan tgbotapi.Update) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if route, ok := w.routes[key]; ok && (<-chan tgbotapi.Update)(route.updates) == updates {
+		delete(w.routes, key)
+	}
+}
+
+// secretToken derives the secret token of a bot from the service secret
+func (w *WebhookService) secretToken(key string) string {
+	mac := hmac.New(sha256.New, w.secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
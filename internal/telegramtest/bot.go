@@ -0,0 +1,627 @@
+package telegramtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// webhookSecretHeader carries the secret token set with setWebhook
+const webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// Bot is a bot registered on the fake server. Its methods stand in for the
+// users talking to the bot and for the assertions on its answers.
+type Bot struct {
+	server *Server
+	token  string
+	user   tgbotapi.User
+
+	mu       sync.Mutex
+	updates  []tgbotapi.Update
+	polling  bool
+	webhook  Webhook
+	sent     []Message
+	answers  []CallbackAnswer
+	requests map[string][]url.Values
+	members  map[string]string // "chat/user" -> status
+	files    map[string]fakeFile
+	wake     chan struct{} // closed and replaced on every change
+}
+
+// Message is a message the bot sent or edited
+type Message struct {
+	Method      string // sendMessage, sendPhoto, editMessageText, ...
+	MessageID   int
+	ChatID      int64
+	Text        string // text, or caption of a photo, video or document
+	Photo       string // file ID, URL or name of the uploaded file
+	Video       string // the same for videos
+	Document    string // the same for documents
+	ParseMode   string
+	ReplyMarkup json.RawMessage
+}
+
+// CallbackAnswer is an answerCallbackQuery call
+type CallbackAnswer struct {
+	CallbackQueryID string
+	Text            string
+	ShowAlert       bool
+}
+
+// Webhook is the webhook set by the bot
+type Webhook struct {
+	URL         string
+	SecretToken string
+}
+
+type fakeFile struct {
+	path    string
+	content []byte
+}
+
+// User returns the bot's own user, as getMe reports it
+func (b *Bot) User() tgbotapi.User {
+	return b.user
+}
+
+// SendText injects a text message from a user in their private chat.
+// Messages starting with a slash carry a bot_command entity like real ones.
+func (b *Bot) SendText(from tgbotapi.User, text string) (tgbotapi.Update, error) {
+	msg := b.userMessage(from)
+	msg.Text = text
+	if strings.HasPrefix(text, "/") {
+		command := strings.SplitN(text, " ", 2)[0]
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len([]rune(command))}}
+	}
+
+	return b.Inject(tgbotapi.Update{Message: msg})
+}
+
+// SendPhoto injects a photo from a user; the photo can be downloaded through
+// getFile like one uploaded to Telegram
+func (b *Bot) SendPhoto(from tgbotapi.User, content []byte, caption string) (tgbotapi.Update, error) {
+	file := b.AddFile(content)
+
+	msg := b.userMessage(from)
+	msg.Caption = caption
+	msg.Photo = []tgbotapi.PhotoSize{{
+		FileID:       file.FileID,
+		FileUniqueID: file.FileUniqueID,
+		Width:        800,
+		Height:       800,
+		FileSize:     file.FileSize,
+	}}
+
+	return b.Inject(tgbotapi.Update{Message: msg})
+}
+
+// SendDocument injects a document from a user; like photos, it can be
+// downloaded through getFile
+func (b *Bot) SendDocument(from tgbotapi.User, content []byte, name, caption string) (tgbotapi.Update, error) {
+	file := b.AddFile(content)
+
+	msg := b.userMessage(from)
+	msg.Caption = caption
+	msg.Document = &tgbotapi.Document{
+		FileID:       file.FileID,
+		FileUniqueID: file.FileUniqueID,
+		FileName:     name,
+		FileSize:     file.FileSize,
+	}
+
+	return b.Inject(tgbotapi.Update{Message: msg})
+}
+
+// SendContact injects a user sharing their own phone number, as the reply
+// keyboard's contact button does
+func (b *Bot) SendContact(from tgbotapi.User, phone string) (tgbotapi.Update, error) {
+	msg := b.userMessage(from)
+	msg.Contact = &tgbotapi.Contact{
+		PhoneNumber: phone,
+		FirstName:   from.FirstName,
+		LastName:    from.LastName,
+		UserID:      from.ID,
+	}
+
+	return b.Inject(tgbotapi.Update{Message: msg})
+}
+
+// Press injects a callback query as if the user pressed a button with data
+// on a message the bot sent
+func (b *Bot) Press(from tgbotapi.User, on Message, data string) (tgbotapi.Update, error) {
+	id := b.server.id()
+
+	message := &tgbotapi.Message{
+		MessageID: on.MessageID,
+		From:      &b.user,
+		Chat:      chatOf(on.ChatID),
+		Date:      int(time.Now().Unix()),
+		Text:      on.Text,
+	}
+	if on.Photo != "" {
+		// A photo's text is its caption
+		message.Text = ""
+		message.Caption = on.Text
+		message.Photo = []tgbotapi.PhotoSize{{FileID: on.Photo}}
+	}
+	return b.Inject(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:           strconv.FormatInt(id, 10),
+		From:         &from,
+		Message:      message,
+		ChatInstance: strconv.FormatInt(on.ChatID, 10),
+		Data:         data,
+	}})
+}
+
+// Inject delivers an update to the bot: posted to its webhook when one is
+// set, otherwise queued for getUpdates. A zero UpdateID is filled in.
+func (b *Bot) Inject(update tgbotapi.Update) (tgbotapi.Update, error) {
+	if update.UpdateID == 0 {
+		update.UpdateID = int(b.server.id())
+	}
+
+	b.mu.Lock()
+	webhook := b.webhook
+	if webhook.URL == "" {
+		b.updates = append(b.updates, update)
+		b.notifyLocked()
+	}
+	b.mu.Unlock()
+
+	if webhook.URL == "" {
+		return update, nil
+	}
+	return update, postUpdate(webhook, update)
+}
+
+// Sent returns the messages the bot sent or edited so far
+func (b *Bot) Sent() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.sent...)
+}
+
+// ClearSent forgets the messages sent so far, so the next assertions only
+// see the answers to what the test does next
+func (b *Bot) ClearSent() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent = nil
+	b.answers = nil
+}
+
+// WaitSent waits until the bot has sent at least n messages and returns them
+func (b *Bot) WaitSent(n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.After(timeout)
+	for {
+		b.mu.Lock()
+		sent := append([]Message(nil), b.sent...)
+		wake := b.wake
+		b.mu.Unlock()
+
+		if len(sent) >= n {
+			return sent, nil
+		}
+
+		select {
+		case <-wake:
+		case <-deadline:
+			return sent, fmt.Errorf("bot sent %d messages, expected %d within %s", len(sent), n, timeout)
+		}
+	}
+}
+
+// CallbackAnswers returns the answerCallbackQuery calls so far
+func (b *Bot) CallbackAnswers() []CallbackAnswer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]CallbackAnswer(nil), b.answers...)
+}
+
+// Requests returns the parameters of every call of a method so far
+func (b *Bot) Requests(method string) []url.Values {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]url.Values(nil), b.requests[method]...)
+}
+
+// Webhook returns the webhook the bot set, if any
+func (b *Bot) Webhook() Webhook {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.webhook
+}
+
+// SetChatMember sets what getChatMember answers for a user in a chat. chat
+// is the chat_id as the bot sends it, e.g. "@channel" or "-100123".
+// Users that were not set are reported as "left".
+func (b *Bot) SetChatMember(chat string, userID int64, status string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members[memberKey(chat, userID)] = status
+}
+
+// AddFile stores a file that getFile and the file endpoint serve
+func (b *Bot) AddFile(content []byte) tgbotapi.File {
+	id := b.server.id()
+	file := tgbotapi.File{
+		FileID:       fmt.Sprintf("file-%d", id),
+		FileUniqueID: fmt.Sprintf("unique-%d", id),
+		FileSize:     len(content),
+		FilePath:     fmt.Sprintf("photos/file_%d.jpg", id),
+	}
+
+	b.mu.Lock()
+	b.files[file.FileID] = fakeFile{path: file.FilePath, content: content}
+	b.mu.Unlock()
+
+	return file
+}
+
+// call answers a Bot API method
+func (b *Bot) call(r *http.Request, method string) (interface{}, error) {
+	form := r.Form
+
+	b.mu.Lock()
+	if b.requests == nil {
+		b.requests = make(map[string][]url.Values)
+	}
+	b.requests[method] = append(b.requests[method], form)
+	b.mu.Unlock()
+
+	switch method {
+	case "getMe":
+		return b.user, nil
+	case "getUpdates":
+		return b.getUpdates(r.Context(), form)
+	case "sendMessage":
+		return b.record(method, form, Message{Text: form.Get("text")})
+	case "sendPhoto":
+		return b.record(method, form, Message{Text: form.Get("caption"), Photo: fileParam(r, "photo")})
+	case "sendVideo":
+		return b.record(method, form, Message{Text: form.Get("caption"), Video: fileParam(r, "video")})
+	case "sendDocument":
+		return b.record(method, form, Message{Text: form.Get("caption"), Document: fileParam(r, "document")})
+	case "sendMediaGroup":
+		return b.recordMediaGroup(form)
+	case "editMessageText":
+		return b.record(method, form, Message{Text: form.Get("text")})
+	case "editMessageCaption":
+		return b.record(method, form, Message{Text: form.Get("caption")})
+	case "editMessageReplyMarkup":
+		return b.record(method, form, Message{})
+	case "answerCallbackQuery":
+		b.mu.Lock()
+		b.answers = append(b.answers, CallbackAnswer{
+			CallbackQueryID: form.Get("callback_query_id"),
+			Text:            form.Get("text"),
+			ShowAlert:       form.Get("show_alert") == "true",
+		})
+		b.notifyLocked()
+		b.mu.Unlock()
+		return true, nil
+	case "getChatMember":
+		userID, _ := strconv.ParseInt(form.Get("user_id"), 10, 64)
+		b.mu.Lock()
+		status, ok := b.members[memberKey(form.Get("chat_id"), userID)]
+		b.mu.Unlock()
+		if !ok {
+			status = "left"
+		}
+		return tgbotapi.ChatMember{User: &tgbotapi.User{ID: userID}, Status: status}, nil
+	case "getFile":
+		b.mu.Lock()
+		file, ok := b.files[form.Get("file_id")]
+		b.mu.Unlock()
+		if !ok {
+			return nil, &apiError{http.StatusBadRequest, "Bad Request: invalid file_id"}
+		}
+		return tgbotapi.File{FileID: form.Get("file_id"), FileSize: len(file.content), FilePath: file.path}, nil
+	case "setWebhook":
+		b.mu.Lock()
+		b.webhook = Webhook{URL: form.Get("url"), SecretToken: form.Get("secret_token")}
+		b.mu.Unlock()
+		return true, nil
+	case "deleteWebhook":
+		b.mu.Lock()
+		b.webhook = Webhook{}
+		b.mu.Unlock()
+		return true, nil
+	case "getWebhookInfo":
+		b.mu.Lock()
+		info := tgbotapi.WebhookInfo{URL: b.webhook.URL, PendingUpdateCount: len(b.updates)}
+		b.mu.Unlock()
+		return info, nil
+	}
+
+	// Methods without an interesting answer, like setMyCommands, just succeed
+	return true, nil
+}
+
+// getUpdates long polls for updates from offset on, confirming the earlier ones
+func (b *Bot) getUpdates(ctx context.Context, form url.Values) ([]tgbotapi.Update, error) {
+	offset, _ := strconv.Atoi(form.Get("offset"))
+	limit, _ := strconv.Atoi(form.Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	timeout, _ := strconv.Atoi(form.Get("timeout"))
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollTimeout {
+		wait = maxPollTimeout
+	}
+
+	b.mu.Lock()
+	switch {
+	case b.webhook.URL != "":
+		b.mu.Unlock()
+		return nil, &apiError{http.StatusConflict, "Conflict: can't use getUpdates method while webhook is active; use deleteWebhook to delete the webhook first"}
+	case b.polling:
+		b.mu.Unlock()
+		return nil, &apiError{http.StatusConflict, "Conflict: terminated by other getUpdates request; make sure that only one bot instance is running"}
+	}
+	b.polling = true
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.polling = false
+		b.mu.Unlock()
+	}()
+
+	deadline := time.After(wait)
+	for {
+		b.mu.Lock()
+		if offset > 0 {
+			kept := b.updates[:0]
+			for _, update := range b.updates {
+				if update.UpdateID >= offset {
+					kept = append(kept, update)
+				}
+			}
+			b.updates = kept
+		}
+		updates := append([]tgbotapi.Update(nil), b.updates...)
+		wake := b.wake
+		b.mu.Unlock()
+
+		if len(updates) > 0 {
+			if len(updates) > limit {
+				updates = updates[:limit]
+			}
+			return updates, nil
+		}
+
+		select {
+		case <-wake:
+		case <-deadline:
+			return []tgbotapi.Update{}, nil
+		case <-b.server.closed:
+			return []tgbotapi.Update{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// record keeps a sent or edited message and returns it the way Telegram does
+func (b *Bot) record(method string, form url.Values, msg Message) (tgbotapi.Message, error) {
+	chatID, err := strconv.ParseInt(form.Get("chat_id"), 10, 64)
+	if err != nil && form.Get("inline_message_id") == "" {
+		return tgbotapi.Message{}, &apiError{http.StatusBadRequest, "Bad Request: chat not found"}
+	}
+
+	msg.Method = method
+	msg.ChatID = chatID
+	msg.ParseMode = form.Get("parse_mode")
+	if markup := form.Get("reply_markup"); markup != "" {
+		msg.ReplyMarkup = json.RawMessage(markup)
+	}
+	if strings.HasPrefix(method, "edit") {
+		msg.MessageID, _ = strconv.Atoi(form.Get("message_id"))
+	} else {
+		msg.MessageID = int(b.server.id())
+	}
+
+	b.mu.Lock()
+	b.sent = append(b.sent, msg)
+	b.notifyLocked()
+	b.mu.Unlock()
+
+	result := tgbotapi.Message{
+		MessageID:   msg.MessageID,
+		From:        &b.user,
+		Chat:        chatOf(chatID),
+		Date:        int(time.Now().Unix()),
+		ReplyMarkup: msg.InlineKeyboard(),
+	}
+	switch {
+	case msg.Photo != "":
+		result.Caption = msg.Text
+		result.Photo = []tgbotapi.PhotoSize{{FileID: msg.Photo, FileUniqueID: msg.Photo}}
+	case msg.Video != "":
+		result.Caption = msg.Text
+		result.Video = &tgbotapi.Video{FileID: msg.Video, FileUniqueID: msg.Video}
+	case msg.Document != "":
+		result.Caption = msg.Text
+		result.Document = &tgbotapi.Document{FileID: msg.Document, FileUniqueID: msg.Document}
+	default:
+		result.Text = msg.Text
+	}
+	return result, nil
+}
+
+// recordMediaGroup records every photo and video of an album as a message
+// of its own, sent with the sendMediaGroup method
+func (b *Bot) recordMediaGroup(form url.Values) ([]tgbotapi.Message, error) {
+	var media []struct {
+		Type    string `json:"type"`
+		Media   string `json:"media"`
+		Caption string `json:"caption"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("media")), &media); err != nil || len(media) < 2 || len(media) > 10 {
+		return nil, &apiError{http.StatusBadRequest, "Bad Request: wrong number of media in the group"}
+	}
+
+	album := make([]tgbotapi.Message, len(media))
+	for i, item := range media {
+		msg := Message{Text: item.Caption}
+		if item.Type == "video" {
+			msg.Video = item.Media
+		} else {
+			msg.Photo = item.Media
+		}
+		result, err := b.record("sendMediaGroup", form, msg)
+		if err != nil {
+			return nil, err
+		}
+		album[i] = result
+	}
+	return album, nil
+}
+
+// fileParam returns the file ID or URL a method was sent in a parameter, or
+// the name of the file uploaded in it
+func fileParam(r *http.Request, name string) string {
+	if r.MultipartForm != nil && len(r.MultipartForm.File[name]) > 0 {
+		return r.MultipartForm.File[name][0].Filename
+	}
+	return r.Form.Get(name)
+}
+
+// serveFile answers a download from the file endpoint
+func (b *Bot) serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, file := range b.files {
+		if file.path == path {
+			w.Write(file.content)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (b *Bot) userMessage(from tgbotapi.User) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: int(b.server.id()),
+		From:      &from,
+		Chat:      chatOf(from.ID),
+		Date:      int(time.Now().Unix()),
+	}
+}
+
+// notify wakes up long polls and waiting tests
+func (b *Bot) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.notifyLocked()
+}
+
+func (b *Bot) notifyLocked() {
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
+
+// InlineKeyboard returns the inline keyboard of the message, if it has one
+func (m Message) InlineKeyboard() *tgbotapi.InlineKeyboardMarkup {
+	if len(m.ReplyMarkup) == 0 {
+		return nil
+	}
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal(m.ReplyMarkup, &keyboard); err != nil || keyboard.InlineKeyboard == nil {
+		return nil
+	}
+	return &keyboard
+}
+
+// Buttons returns the texts of the message's inline or reply keyboard
+func (m Message) Buttons() []string {
+	var texts []string
+	if keyboard := m.InlineKeyboard(); keyboard != nil {
+		for _, row := range keyboard.InlineKeyboard {
+			for _, button := range row {
+				texts = append(texts, button.Text)
+			}
+		}
+		return texts
+	}
+
+	var keyboard tgbotapi.ReplyKeyboardMarkup
+	if err := json.Unmarshal(m.ReplyMarkup, &keyboard); err == nil {
+		for _, row := range keyboard.Keyboard {
+			for _, button := range row {
+				texts = append(texts, button.Text)
+			}
+		}
+	}
+	return texts
+}
+
+// CallbackData returns the data of the first inline button whose text
+// contains text
+func (m Message) CallbackData(text string) (string, bool) {
+	keyboard := m.InlineKeyboard()
+	if keyboard == nil {
+		return "", false
+	}
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if strings.Contains(button.Text, text) && button.CallbackData != nil {
+				return *button.CallbackData, true
+			}
+		}
+	}
+	return "", false
+}
+
+// postUpdate delivers an update to a webhook the way Telegram does
+func postUpdate(webhook Webhook, update tgbotapi.Update) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if webhook.SecretToken != "" {
+		req.Header.Set(webhookSecretHeader, webhook.SecretToken)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+func chatOf(chatID int64) *tgbotapi.Chat {
+	chatType := "private"
+	if chatID < 0 {
+		chatType = "supergroup"
+	}
+	return &tgbotapi.Chat{ID: chatID, Type: chatType}
+}
+
+func memberKey(chat string, userID int64) string {
+	return fmt.Sprintf("%s/%d", chat, userID)
+}
@@ -0,0 +1,172 @@
+// Package telegramtest provides an in-process fake of the Telegram Bot API
+// for end-to-end tests of the mother bot and the store bots. Point a bot at
+// it with tgbotapi.NewBotAPIWithAPIEndpoint(token, server.Endpoint()), inject
+// the updates a user would cause and assert on what the bot sent back.
+package telegramtest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxPollTimeout caps getUpdates long polls so a stopping bot returns quickly
+const maxPollTimeout = 2 * time.Second
+
+// Server is a fake Bot API server. Each registered token is a separate bot
+// with its own update queue and record of sent messages.
+type Server struct {
+	server *httptest.Server
+	closed chan struct{}
+
+	mu     sync.Mutex
+	bots   map[string]*Bot
+	nextID int64
+}
+
+// NewServer starts a fake Bot API server. Close it when the test is done.
+func NewServer() *Server {
+	s := &Server{bots: make(map[string]*Bot), nextID: 1000, closed: make(chan struct{})}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Close releases pending long polls and shuts the server down
+func (s *Server) Close() {
+	close(s.closed)
+	s.server.Close()
+}
+
+// URL returns the base URL of the server
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Endpoint returns the API endpoint to pass to tgbotapi.NewBotAPIWithAPIEndpoint
+func (s *Server) Endpoint() string {
+	return s.server.URL + "/bot%s/%s"
+}
+
+// FileEndpoint returns the URL pattern files are downloaded from
+func (s *Server) FileEndpoint() string {
+	return s.server.URL + "/file/bot%s/%s"
+}
+
+// AddBot registers a bot. Calls with a token that isn't registered are
+// answered with 401 Unauthorized like a revoked token.
+func (s *Server) AddBot(token, username string) *Bot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, _ := strconv.ParseInt(strings.SplitN(token, ":", 2)[0], 10, 64)
+	if id == 0 {
+		id = s.newID()
+	}
+
+	bot := &Bot{
+		server:  s,
+		token:   token,
+		user:    tgbotapi.User{ID: id, IsBot: true, FirstName: username, UserName: username},
+		members: make(map[string]string),
+		files:   make(map[string]fakeFile),
+		wake:    make(chan struct{}),
+	}
+	s.bots[token] = bot
+	return bot
+}
+
+// RemoveBot unregisters a bot, which makes its token fail like a revoked one
+func (s *Server) RemoveBot(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bot, ok := s.bots[token]; ok {
+		bot.notify()
+		delete(s.bots, token)
+	}
+}
+
+// id hands out message, update and file IDs
+func (s *Server) id() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.newID()
+}
+
+// newID is id for callers holding s.mu
+func (s *Server) newID() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	download := strings.HasPrefix(path, "file/")
+	path = strings.TrimPrefix(path, "file/")
+
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "bot") {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	bot, ok := s.bots[strings.TrimPrefix(parts[0], "bot")]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if download {
+		bot.serveFile(w, r, parts[1])
+		return
+	}
+
+	// Multipart uploads and plain forms are both parsed into r.Form
+	if err := r.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	result, err := bot.call(r, parts[1])
+	if err != nil {
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			writeError(w, apiErr.code, apiErr.description)
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+}
+
+// apiError is an error answer with its own status code
+type apiError struct {
+	code        int
+	description string
+}
+
+func (e *apiError) Error() string {
+	return e.description
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: false, ErrorCode: code, Description: description})
+}
@@ -2,9 +2,13 @@ package main
 
 import (
         "log"
+        "os"
+        "os/signal"
+        "syscall"
         "telegram-store-hub/internal/bot"
         "telegram-store-hub/internal/config"
         "telegram-store-hub/internal/database"
+        "telegram-store-hub/internal/secrets"
         "telegram-store-hub/internal/services"
 
         tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -19,6 +23,13 @@ func main() {
                 log.Fatalf("❌ Failed to load config: %v", err)
         }
 
+        // Store bot tokens are encrypted at rest with this keyring
+        keyring, err := secrets.LoadKeyring(cfg.TokenEncryptionKey, cfg.TokenEncryptionOldKeys)
+        if err != nil {
+                log.Fatalf("❌ Invalid token encryption key: %v", err)
+        }
+        secrets.SetKeyring(keyring)
+
         // Initialize database
         db, err := database.Connect(cfg.DatabaseURL)
         if err != nil {
@@ -46,26 +57,48 @@ func main() {
         storeManager := services.NewStoreManagerService(db)
         productService := services.NewProductService(db)
         orderService := services.NewOrderService(db)
+        orderService.SetNotifier(services.NewOrderNotifier(db)) // customers hear about status changes from the store bot
         paymentService := services.NewPaymentService(db)
-        subscriptionService := services.NewSubscriptionService(db)
-        botManager := services.NewBotManagerService(db)
         
         log.Println("✅ Services initialized")
 
+        // Instances share the store bots through leases and elect a leader for singleton jobs
+        leases := services.NewLeaseService(db, cfg.InstanceID)
+        leases.Start()
+
+        // Start the webhook server when bots don't poll
+        var webhooks *services.WebhookService
+        if cfg.UseWebhooks() {
+                webhooks = services.NewWebhookService(cfg.WebhookListenAddr, cfg.WebhookBaseURL, cfg.WebhookSecret)
+                if err := webhooks.Start(); err != nil {
+                        log.Fatalf("❌ Failed to start webhook server: %v", err)
+                }
+                log.Printf("✅ Webhook mode enabled at %s", cfg.WebhookBaseURL)
+        }
+
         // Initialize mother bot
-        motherBot, err := tgbotapi.NewBotAPI(cfg.MotherBotToken)
+        if cfg.BotAPIEndpoint != "" {
+                services.SetBotAPIEndpoint(cfg.BotAPIEndpoint)
+        }
+        motherBot, err := tgbotapi.NewBotAPIWithAPIEndpoint(cfg.MotherBotToken, services.BotAPIEndpoint())
         if err != nil {
                 log.Fatalf("❌ Failed to create mother bot: %v", err)
         }
         
         motherBot.Debug = cfg.Debug
+        services.UseSendQueue(motherBot)
         log.Printf("✅ Mother bot authorized as @%s", motherBot.Self.UserName)
 
+        // Store bots are supervised by the bot manager, which alerts through the mother bot
+        botManager := services.NewBotManagerService(motherBot, db)
+        botManager.SetStoreBotFactory(bot.NewStoreBotFactory(db, webhooks, cfg.UpdateWorkers))
+        botManager.SetAdminChatID(cfg.AdminChatID)
+        botManager.SetLeaseService(leases)
+
         // Initialize channel verification with bot
         channelVerify := services.NewChannelVerificationService(motherBot, cfg.RequiredChannelID)
 
-        // Set bot for subscription service notifications
-        subscriptionService.SetBot(motherBot)
+        subscriptionService := services.NewSubscriptionService(motherBot, db, cfg.PaymentCardNumber, cfg.PaymentCardHolder)
 
         // Initialize bot manager
         mb := bot.NewMotherBot(
@@ -81,6 +114,8 @@ func main() {
                 subscriptionService,
                 botManager,
         )
+        mb.UseWebhooks(webhooks)
+        mb.SetUpdateWorkers(cfg.UpdateWorkers)
 
         // Start all store bots
         log.Println("🤖 Starting store bots...")
@@ -88,11 +123,52 @@ func main() {
                 log.Printf("⚠️ Error starting store bots: %v", err)
         }
 
-        // Start subscription checker
-        log.Println("⏰ Starting subscription checker...")
-        subscriptionService.StartSubscriptionChecker()
+        // Probe store bots and alert owners and the admin about failures (leader only)
+        botManager.StartBotMonitoringRoutine()
+
+        // Start subscription reminders (leader only)
+        log.Println("⏰ Starting subscription reminders...")
+        reminderService := services.NewReminderService(motherBot, db, subscriptionService, cfg.ReminderDaysBeforeExpiry)
+        reminderService.SetLeaseService(leases)
+        reminderService.StartReminderScheduler()
 
-        // Start mother bot
-        log.Println("🤖 Starting mother bot...")
-        mb.Start()
+        // Put the stock of unconfirmed orders back on sale when their reservations run out (leader only)
+        orderService.SetLeaseService(leases)
+        orderService.StartReservationExpiry()
+
+        // Forget old repeated-tap markers (leader only)
+        idempotency := services.NewIdempotencyService(db)
+        idempotency.SetLeaseService(leases)
+        idempotency.StartCleanup()
+
+        // Hand the store bots over to the other instances on shutdown
+        go func() {
+                signals := make(chan os.Signal, 1)
+                signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+                <-signals
+
+                log.Println("🛑 Shutting down...")
+                botManager.StopAllBots()
+                leases.Stop()
+                os.Exit(0)
+        }()
+
+        if cfg.UseWebhooks() {
+                // Start mother bot
+                log.Println("🤖 Starting mother bot...")
+                mb.Start()
+                return
+        }
+
+        // Telegram allows one poller per token, so polling instances take
+        // turns: the mother bot polls while this instance holds its lease
+        // and waits for it again once lost
+        mb.SetLeaseService(leases)
+        for {
+                log.Println("⏳ Waiting for the mother bot lease...")
+                leases.Await(services.MotherBotLeaseName)
+
+                log.Println("🤖 Starting mother bot...")
+                mb.Start()
+        }
 }
\ No newline at end of file
@@ -1,14 +1,24 @@
 package tests
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"telegram-store-hub/internal/bot"
 	"telegram-store-hub/internal/config"
 	"telegram-store-hub/internal/database"
 	"telegram-store-hub/internal/models"
+	"telegram-store-hub/internal/secrets"
 	"telegram-store-hub/internal/services"
+	"telegram-store-hub/internal/telegramtest"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -29,6 +39,13 @@ func setupTestEnvironment(t *testing.T) *TestConfig {
 		t.Skipf("Skipping integration tests: %v", err)
 	}
 
+	// Bot tokens are encrypted at rest
+	keyring, err := secrets.LoadKeyring(cfg.TokenEncryptionKey, cfg.TokenEncryptionOldKeys)
+	if err != nil {
+		t.Fatalf("Invalid token encryption key: %v", err)
+	}
+	secrets.SetKeyring(keyring)
+
 	// Connect to test database
 	db, err := database.Connect(cfg.DatabaseURL)
 	if err != nil {
@@ -331,6 +348,66 @@ func TestReminderService(t *testing.T) {
 	if !found {
 		t.Error("Test store should be in upcoming expirations")
 	}
+
+	// An expired store is deactivated even when its owner can't be told,
+	// and the notice is sent once they can
+	server := telegramtest.NewServer()
+	defer server.Close()
+	fake := server.AddBot("5:reminder-test", "reminder_test_bot")
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("5:reminder-test", server.Endpoint())
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	reminders := services.NewReminderService(api, testConfig.DB, subscriptionSrv, []int{7, 3, 1})
+
+	owner := &models.User{TelegramID: time.Now().UnixNano(), FirstName: "Owner"}
+	if err := testConfig.DB.Create(owner).Error; err != nil {
+		t.Fatalf("Failed to create owner: %v", err)
+	}
+	expiredStore := &models.Store{
+		OwnerID:   owner.ID,
+		Name:      "Expired Reminder Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 0, -1),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Omit("Owner").Create(expiredStore).Error; err != nil {
+		t.Fatalf("Failed to create expired store: %v", err)
+	}
+	isActive := func() bool {
+		t.Helper()
+		var store models.Store
+		if err := testConfig.DB.First(&store, expiredStore.ID).Error; err != nil {
+			t.Fatalf("Failed to reload store: %v", err)
+		}
+		return store.IsActive
+	}
+
+	notices := func() int {
+		count := 0
+		for _, msg := range fake.Sent() {
+			if msg.ChatID == owner.TelegramID && strings.Contains(msg.Text, expiredStore.Name) {
+				count++
+			}
+		}
+		return count
+	}
+
+	server.RemoveBot("5:reminder-test")
+	reminders.CheckAndSendReminders()
+	if isActive() {
+		t.Error("Expected the store to be deactivated while its owner can't be told")
+	}
+
+	fake = server.AddBot("5:reminder-test", "reminder_test_bot")
+	reminders.CheckAndSendReminders()
+	if notices() != 1 {
+		t.Errorf("Expected the owner to get the expiry notification on the next check, got %d", notices())
+	}
+	reminders.CheckAndSendReminders()
+	if notices() != 1 {
+		t.Errorf("Expected the expiry notification to be sent once, got %d", notices())
+	}
 	
 	t.Log("✅ Reminder service tests passed")
 }
@@ -377,6 +454,2073 @@ func TestBotManagerService(t *testing.T) {
 	t.Log("✅ Bot manager service tests passed")
 }
 
+// fakeStoreBot is a store bot runner that blocks until stopped
+type fakeStoreBot struct {
+	stop     chan struct{}
+	panicNow bool
+}
+
+func (f *fakeStoreBot) Run() error {
+	if f.panicNow {
+		panic("simulated crash")
+	}
+	<-f.stop
+	return nil
+}
+
+func (f *fakeStoreBot) Stop() {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+}
+
+// TestBotSupervisor tests that store bots are started, restarted and stopped
+func TestBotSupervisor(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	botManager := services.NewBotManagerService(testConfig.Bot, testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Supervisor Test Store",
+		PlanType:  models.PlanPro,
+		BotToken:  "123456789:AAFakeTokenForSupervisorTests_0123456",
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+
+	builds := 0
+	botManager.SetStoreBotFactory(func(store *models.Store, onUpdate func()) (services.StoreBotRunner, error) {
+		builds++
+		return &fakeStoreBot{stop: make(chan struct{}), panicNow: builds == 1}, nil
+	})
+
+	if err := botManager.StartSubBot(testStore.ID); err != nil {
+		t.Fatalf("Failed to start sub-bot: %v", err)
+	}
+	defer botManager.StopAllBots()
+
+	// The first run panics; the supervisor should restart it after the backoff
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		runtime, ok := botManager.GetBotRuntime(testStore.ID)
+		if ok && runtime.State == services.BotRunStateRunning && runtime.Restarts == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Bot was not restarted, runtime: %+v", runtime)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	status, err := botManager.GetBotStatus(testStore.ID)
+	if err != nil {
+		t.Fatalf("Failed to get bot status: %v", err)
+	}
+	if status != services.BotStatusActive {
+		t.Errorf("Expected bot status to be active, got %s", status)
+	}
+
+	if err := botManager.DeactivateBot(testStore.ID); err != nil {
+		t.Fatalf("Failed to deactivate bot: %v", err)
+	}
+	if _, ok := botManager.GetBotRuntime(testStore.ID); ok {
+		t.Error("Expected bot to be stopped after deactivation")
+	}
+	if err := botManager.StartSubBot(testStore.ID); err == nil {
+		t.Error("Expected deactivated bot not to start")
+	}
+
+	t.Log("✅ Bot supervisor tests passed")
+}
+
+// TestUpdateOffsetsAndIdempotency tests persisted offsets and one-time actions
+func TestUpdateOffsetsAndIdempotency(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	offsets := services.NewUpdateOffsetService(testConfig.DB)
+	botID := time.Now().UnixNano()
+
+	if err := offsets.SaveLastUpdateID(botID, 42); err != nil {
+		t.Fatalf("Failed to save offset: %v", err)
+	}
+	// A late save from a stopping instance must not move the offset back
+	if err := offsets.SaveLastUpdateID(botID, 40); err != nil {
+		t.Fatalf("Failed to save offset: %v", err)
+	}
+	last, err := offsets.GetLastUpdateID(botID)
+	if err != nil {
+		t.Fatalf("Failed to get offset: %v", err)
+	}
+	if last != 42 {
+		t.Errorf("Expected last update 42, got %d", last)
+	}
+
+	idempotency := services.NewIdempotencyService(testConfig.DB)
+	key := fmt.Sprintf("test:%d", botID)
+
+	// A failed action releases its key so it can be retried, and its writes
+	// are undone with it
+	failure := errors.New("temporary failure")
+	written := &models.ProcessedAction{Key: key + ":written"}
+	if err := idempotency.Once(key, func(tx *gorm.DB) error {
+		if err := tx.Create(written).Error; err != nil {
+			return err
+		}
+		return failure
+	}); !errors.Is(err, failure) {
+		t.Fatalf("Expected action error, got %v", err)
+	}
+	var count int64
+	testConfig.DB.Model(&models.ProcessedAction{}).Where("key = ?", written.Key).Count(&count)
+	if count != 0 {
+		t.Error("Expected the write of the failed action to be rolled back")
+	}
+
+	runs := 0
+	for i := 0; i < 3; i++ {
+		err := idempotency.Once(key, func(tx *gorm.DB) error {
+			runs++
+			return nil
+		})
+		if i > 0 && !errors.Is(err, services.ErrActionDone) {
+			t.Errorf("Expected ErrActionDone on repeat %d, got %v", i, err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("Expected action to run once, ran %d times", runs)
+	}
+
+	// Old actions are forgotten
+	stale := &models.ProcessedAction{Key: key + ":stale", CreatedAt: time.Now().AddDate(0, -2, 0)}
+	if err := testConfig.DB.Create(stale).Error; err != nil {
+		t.Fatalf("Failed to create processed action: %v", err)
+	}
+	if err := idempotency.CleanupProcessedActions(); err != nil {
+		t.Fatalf("Failed to clean up processed actions: %v", err)
+	}
+	testConfig.DB.Model(&models.ProcessedAction{}).Where("key IN ?", []string{key, stale.Key}).Count(&count)
+	if count != 1 {
+		t.Errorf("Expected only the recent action to be kept, got %d", count)
+	}
+
+	t.Log("✅ Update offset and idempotency tests passed")
+}
+
+// TestLeaseService tests that a lease is held by one instance at a time
+func TestLeaseService(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	suffix := time.Now().UnixNano()
+	first := services.NewLeaseService(testConfig.DB, fmt.Sprintf("test-a-%d", suffix))
+	second := services.NewLeaseService(testConfig.DB, fmt.Sprintf("test-b-%d", suffix))
+	name := fmt.Sprintf("test:%d", suffix)
+
+	if ok, err := first.Acquire(name); err != nil || !ok {
+		t.Fatalf("Expected first instance to acquire the lease, got %v, %v", ok, err)
+	}
+	if ok, err := second.Acquire(name); err != nil || ok {
+		t.Fatalf("Expected second instance to be refused, got %v, %v", ok, err)
+	}
+	// Renewing a held lease succeeds
+	if ok, err := first.Acquire(name); err != nil || !ok {
+		t.Fatalf("Expected first instance to renew the lease, got %v, %v", ok, err)
+	}
+	if !first.Holds(name) || second.Holds(name) {
+		t.Error("Expected only the first instance to hold the lease")
+	}
+	// Work longer than the lease has left must not start
+	if !first.HoldsFor(name, time.Second) || first.HoldsFor(name, time.Hour) {
+		t.Error("Expected the lease to be held for a second but not an hour")
+	}
+
+	// A released lease is free for the other instance
+	first.Release(name)
+	if ok, err := second.Acquire(name); err != nil || !ok {
+		t.Fatalf("Expected second instance to take over the lease, got %v, %v", ok, err)
+	}
+	second.Release(name)
+
+	t.Log("✅ Lease tests passed")
+}
+
+// TestBotTokenFormat tests that malformed tokens are refused before calling Telegram
+func TestBotTokenFormat(t *testing.T) {
+	botManager := services.NewBotManagerService(nil, nil)
+
+	malformed := []string{"", "invalid_token", "123456:short", "abc:AAFakeTokenForSupervisorTests_0123456"}
+	for _, token := range malformed {
+		if services.IsValidBotToken(token) {
+			t.Errorf("Expected %q to be rejected", token)
+		}
+		if _, err := botManager.VerifyBotToken(token); !errors.Is(err, services.ErrBotTokenMalformed) {
+			t.Errorf("Expected ErrBotTokenMalformed for %q, got %v", token, err)
+		}
+	}
+
+	if !services.IsValidBotToken("123456789:AAFakeTokenForSupervisorTests_0123456") {
+		t.Error("Expected well-formed token to be accepted")
+	}
+
+	t.Log("✅ Bot token format tests passed")
+}
+
+// TestBotTokenEncryption tests token encryption, key rotation and JSON redaction
+func TestBotTokenEncryption(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	token := "123456789:AAFakeTokenForEncryptionTests_012345"
+
+	oldKeyring, err := secrets.NewKeyring(oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	sealed, err := oldKeyring.Encrypt(token)
+	if err != nil {
+		t.Fatalf("Failed to encrypt token: %v", err)
+	}
+	if strings.Contains(sealed, token) || !secrets.IsEncrypted(sealed) {
+		t.Fatalf("Expected an encrypted envelope, got %q", sealed)
+	}
+
+	// After rotation the old key still decrypts until every token is rewritten
+	rotating, err := secrets.NewKeyring(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+	if !rotating.NeedsRotation(sealed) || !rotating.NeedsRotation(token) {
+		t.Error("Expected old and plaintext tokens to need rotation")
+	}
+	if plaintext, err := rotating.Decrypt(sealed); err != nil || plaintext != token {
+		t.Errorf("Failed to decrypt with previous key: %q, %v", plaintext, err)
+	}
+
+	resealed, _ := rotating.Encrypt(token)
+	if rotating.NeedsRotation(resealed) {
+		t.Error("Expected a token sealed with the primary key not to need rotation")
+	}
+
+	newOnly, _ := secrets.NewKeyring(newKey)
+	if _, err := newOnly.Decrypt(sealed); !errors.Is(err, secrets.ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey once the old key is dropped, got %v", err)
+	}
+
+	// Tokens must never appear in JSON output
+	data, err := json.Marshal(models.Store{Name: "Redaction Test", BotToken: token})
+	if err != nil {
+		t.Fatalf("Failed to marshal store: %v", err)
+	}
+	if strings.Contains(string(data), token) || strings.Contains(string(data), "bot_token") {
+		t.Errorf("Expected bot token to be redacted, got %s", data)
+	}
+
+	t.Log("✅ Bot token encryption tests passed")
+}
+
+// TestStoreBotProfile tests that the bot profile follows the store's plan
+func TestStoreBotProfile(t *testing.T) {
+	store := &models.Store{Name: "Profile Test Store", Description: "Handmade goods", PlanType: models.PlanFree}
+
+	commandCounts := make(map[models.PlanType]int)
+	hashes := make(map[string]bool)
+	for _, plan := range []models.PlanType{models.PlanFree, models.PlanPro, models.PlanVIP} {
+		store.PlanType = plan
+		profile := services.StoreBotProfile(store)
+		commandCounts[plan] = len(profile.Commands)
+		hashes[profile.Hash()] = true
+
+		if !strings.Contains(profile.ShortDescription, store.Name) {
+			t.Errorf("Expected %s short description to contain the store name, got %q", plan, profile.ShortDescription)
+		}
+	}
+
+	if !(commandCounts[models.PlanFree] < commandCounts[models.PlanPro] && commandCounts[models.PlanPro] < commandCounts[models.PlanVIP]) {
+		t.Errorf("Expected higher plans to expose more commands, got %v", commandCounts)
+	}
+	if len(hashes) != 3 {
+		t.Error("Expected a different profile hash per plan")
+	}
+
+	store.Description = strings.Repeat("ب", 1000)
+	if profile := services.StoreBotProfile(store); len([]rune(profile.Description)) > 512 {
+		t.Errorf("Expected description to be cut to 512 characters, got %d", len([]rune(profile.Description)))
+	}
+
+	t.Log("✅ Store bot profile tests passed")
+}
+
+// TestSendQueueRetryAfter tests that a 429 answer is retried after retry_after
+func TestSendQueueRetryAfter(t *testing.T) {
+	var sendCalls, photoCalls int32
+	var photoTimes []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"username":"queue_test_bot"}}`)
+		case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+			if atomic.AddInt32(&sendCalls, 1) == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`)
+				return
+			}
+			fmt.Fprint(w, `{"ok":true,"result":{"message_id":7,"chat":{"id":42}}}`)
+		case strings.HasSuffix(r.URL.Path, "/sendPhoto"):
+			if r.FormValue("chat_id") != "42" {
+				t.Errorf("Expected the retried upload to keep its chat, got %q", r.FormValue("chat_id"))
+			}
+			photoTimes = append(photoTimes, time.Now())
+			if atomic.AddInt32(&photoCalls, 1) == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprint(w, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`)
+				return
+			}
+			fmt.Fprint(w, `{"ok":true,"result":{"message_id":8,"chat":{"id":42}}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("1:queue-test", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	services.UseSendQueue(bot)
+
+	start := time.Now()
+	msg, err := bot.Send(tgbotapi.NewMessage(42, "hello"))
+	if err != nil {
+		t.Fatalf("Expected send to succeed after retry, got %v", err)
+	}
+	if msg.MessageID != 7 {
+		t.Errorf("Expected message 7, got %d", msg.MessageID)
+	}
+	if calls := atomic.LoadInt32(&sendCalls); calls != 2 {
+		t.Errorf("Expected 2 sendMessage calls, got %d", calls)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected retry to wait for retry_after, took %s", elapsed)
+	}
+
+	// Uploads are retried too, and keep to the chat's interval
+	upload := func() {
+		t.Helper()
+		photo := tgbotapi.NewPhoto(42, tgbotapi.FileBytes{Name: "photo.jpg", Bytes: []byte("photo")})
+		if _, err := bot.Send(photo); err != nil {
+			t.Fatalf("Expected upload to succeed, got %v", err)
+		}
+	}
+	upload()
+	upload()
+	if len(photoTimes) != 3 {
+		t.Fatalf("Expected 3 sendPhoto calls, got %d", len(photoTimes))
+	}
+	if gap := photoTimes[1].Sub(photoTimes[0]); gap < time.Second {
+		t.Errorf("Expected the upload retry to wait for retry_after, took %s", gap)
+	}
+	if gap := photoTimes[2].Sub(photoTimes[1]); gap < 900*time.Millisecond {
+		t.Errorf("Expected uploads to the same chat a second apart, got %s", gap)
+	}
+
+	t.Log("✅ Send queue tests passed")
+}
+
+// TestBotAPIErrorRate tests that failed Bot API calls show in the error rate
+func TestBotAPIErrorRate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			fmt.Fprint(w, `{"ok":true,"result":{"id":2,"is_bot":true,"username":"health_test_bot"}}`)
+		case strings.HasSuffix(r.URL.Path, "/getWebhookInfo"):
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, `{"ok":false,"error_code":502,"description":"Bad Gateway"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("2:health-test", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	services.UseSendQueue(bot)
+
+	for i := 0; i < 5; i++ {
+		if _, err := bot.GetMe(); err != nil {
+			t.Fatalf("Expected getMe to succeed, got %v", err)
+		}
+		if _, err := bot.GetWebhookInfo(); err == nil {
+			t.Fatal("Expected getWebhookInfo to fail")
+		}
+	}
+
+	rate, calls := services.APIErrorRate(bot.Token)
+	if calls != 10 {
+		t.Errorf("Expected 10 recorded calls, got %d", calls)
+	}
+	if rate != 0.5 {
+		t.Errorf("Expected error rate 0.5, got %v", rate)
+	}
+
+	if rate, calls := services.APIErrorRate("3:unknown"); rate != 0 || calls != 0 {
+		t.Errorf("Expected no calls for an unused token, got %v of %d", rate, calls)
+	}
+
+	t.Log("✅ Bot API error rate tests passed")
+}
+
+// TestCartService tests the persistent store bot carts
+func TestCartService(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Cart Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	mug := &models.Product{StoreID: testStore.ID, Name: "Mug", Price: 1000, IsAvailable: true, TrackStock: true, Stock: 2}
+	pen := &models.Product{StoreID: testStore.ID, Name: "Pen", Price: 300, IsAvailable: true}
+	for _, product := range []*models.Product{mug, pen} {
+		if err := testConfig.DB.Omit("Store").Create(product).Error; err != nil {
+			t.Fatalf("Failed to create product: %v", err)
+		}
+	}
+
+	customerID := time.Now().UnixNano()
+	for i := 0; i < 2; i++ {
+		if _, err := carts.AddItem(testStore.ID, customerID, mug.ID); err != nil {
+			t.Fatalf("Failed to add to cart: %v", err)
+		}
+	}
+	if _, err := carts.AddItem(testStore.ID, customerID, mug.ID); !errors.Is(err, services.ErrCartQuantityLimit) {
+		t.Errorf("Expected the stock to cap the quantity, got %v", err)
+	}
+	if _, err := carts.AddItem(testStore.ID, customerID, pen.ID); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+
+	cart, err := carts.GetCart(testStore.ID, customerID)
+	if err != nil {
+		t.Fatalf("Failed to get cart: %v", err)
+	}
+	if total := services.CartTotal(cart); total != 2300 {
+		t.Errorf("Expected cart total 2300, got %d", total)
+	}
+
+	// Unavailable products can't be added and don't count towards the total
+	if err := testConfig.DB.Model(pen).Update("is_available", false).Error; err != nil {
+		t.Fatalf("Failed to update product: %v", err)
+	}
+	if _, err := carts.AddItem(testStore.ID, customerID, pen.ID); !errors.Is(err, services.ErrProductUnavailable) {
+		t.Errorf("Expected unavailable product to be rejected, got %v", err)
+	}
+	if quantity, err := carts.RemoveOne(testStore.ID, customerID, mug.ID); err != nil || quantity != 1 {
+		t.Errorf("Expected 1 mug left, got %d (%v)", quantity, err)
+	}
+	cart, _ = carts.GetCart(testStore.ID, customerID)
+	if total := services.CartTotal(cart); total != 1000 {
+		t.Errorf("Expected cart total 1000, got %d", total)
+	}
+
+	if err := carts.Clear(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to clear cart: %v", err)
+	}
+	if cart, _ := carts.GetCart(testStore.ID, customerID); len(cart.Items) != 0 {
+		t.Errorf("Expected an empty cart, got %d items", len(cart.Items))
+	}
+
+	t.Log("✅ Cart service tests passed")
+}
+
+// TestStockReservation tests that checkout reserves stock so the last unit
+// can't be sold twice
+func TestStockReservation(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+	products := services.NewProductService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Stock Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	lamp := &models.Product{StoreID: testStore.ID, Name: "Lamp", Price: 5000, IsAvailable: true, TrackStock: true, Stock: 1}
+	if err := testConfig.DB.Omit("Store").Create(lamp).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	// checkout fills a customer's cart with the lamp and orders it
+	checkout := func(customerID int64) (*models.Order, error) {
+		t.Helper()
+		if _, err := carts.AddItem(testStore.ID, customerID, lamp.ID); err != nil && !errors.Is(err, services.ErrCartQuantityLimit) {
+			t.Fatalf("Failed to add to cart: %v", err)
+		}
+		if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+			t.Fatalf("Failed to start checkout: %v", err)
+		}
+		for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+			if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "answer"); err != nil {
+				t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+			}
+		}
+		return orders.CreateOrderFromCart(testStore.ID, customerID, "Customer", "")
+	}
+
+	first := time.Now().UnixNano()
+	second := first + 1
+	// Both customers put the last lamp in their cart before anyone orders
+	if _, err := carts.AddItem(testStore.ID, second, lamp.ID); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	order, err := checkout(first)
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	var stockErr *services.OutOfStockError
+	if _, err := checkout(second); !errors.As(err, &stockErr) {
+		t.Fatalf("Expected the reserved lamp to be out of stock, got %v", err)
+	}
+	if active, _ := products.GetActiveStoreProducts(testStore.ID); len(active) != 0 {
+		t.Errorf("Expected the reserved product to be hidden, got %d products", len(active))
+	}
+
+	// Cancelling the order puts the lamp back on sale
+	if _, err := orders.CancelOrder(order.ID, services.SystemActor, "test"); err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+	order, err = orders.CreateOrderFromCart(testStore.ID, second, "Customer", "")
+	if err != nil {
+		t.Fatalf("Expected the released lamp to be orderable: %v", err)
+	}
+
+	// Confirming takes it off the stock
+	if _, err := orders.UpdateOrderStatus(order.ID, models.OrderStatusConfirmed, services.SystemActor, ""); err != nil {
+		t.Fatalf("Failed to confirm order: %v", err)
+	}
+	testConfig.DB.First(lamp, lamp.ID)
+	if lamp.Stock != 0 {
+		t.Errorf("Expected stock 0 after confirmation, got %d", lamp.Stock)
+	}
+
+	// An unconfirmed order expires with its reservation
+	products.UpdateProductStock(lamp.ID, 1)
+	order, err = checkout(first)
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	testConfig.DB.Model(&models.StockReservation{}).Where("order_id = ?", order.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	expired, err := orders.ExpireStockReservations()
+	if err != nil {
+		t.Fatalf("Failed to expire reservations: %v", err)
+	}
+	testConfig.DB.First(order, order.ID)
+	if len(expired) == 0 || order.Status != models.OrderStatusExpired {
+		t.Errorf("Expected the order to expire, got %s (%v)", order.Status, expired)
+	}
+	if available, _ := products.AvailableStock(lamp.ID); available != 1 {
+		t.Errorf("Expected the lamp back on sale, got %d available", available)
+	}
+
+	// An order whose reservation ran out before it was swept can't take
+	// the lamp another customer reserved since
+	late, err := checkout(first)
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	testConfig.DB.Model(&models.StockReservation{}).Where("order_id = ?", late.ID).Update("expires_at", time.Now().Add(-time.Minute))
+	order, err = checkout(second)
+	if err != nil {
+		t.Fatalf("Expected the lamp of the expired reservation to be orderable: %v", err)
+	}
+	if _, err := orders.UpdateOrderStatus(late.ID, models.OrderStatusConfirmed, services.SystemActor, ""); !errors.As(err, &stockErr) {
+		t.Errorf("Expected the late order to be out of stock, got %v", err)
+	}
+	if _, err := orders.UpdateOrderStatus(order.ID, models.OrderStatusConfirmed, services.SystemActor, ""); err != nil {
+		t.Fatalf("Failed to confirm the order holding the lamp: %v", err)
+	}
+	testConfig.DB.First(lamp, lamp.ID)
+	if lamp.Stock != 0 {
+		t.Errorf("Expected stock 0 after confirmation, got %d", lamp.Stock)
+	}
+
+	t.Log("✅ Stock reservation tests passed")
+}
+
+// TestOrderStateMachine tests that orders only move along the lifecycle and
+// that every status change is recorded
+func TestOrderStateMachine(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	orders := services.NewOrderService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Lifecycle Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	vase := &models.Product{StoreID: testStore.ID, Name: "Vase", Price: 3000, IsAvailable: true, TrackStock: true, Stock: 2}
+	if err := testConfig.DB.Omit("Store").Create(vase).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	customerID := time.Now().UnixNano()
+	order, err := orders.CreateOrder(testStore.ID, customerID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	if err := orders.AddOrderItem(order.ID, vase.ID, 1, vase.Price); err != nil {
+		t.Fatalf("Failed to add order item: %v", err)
+	}
+	seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: 42}
+
+	// A pending order can't be shipped before it is confirmed
+	var transitionErr *services.InvalidTransitionError
+	if _, err := orders.UpdateOrderStatus(order.ID, models.OrderStatusShipped, seller, ""); !errors.As(err, &transitionErr) {
+		t.Fatalf("Expected an invalid transition, got %v", err)
+	}
+	if transitionErr.From != models.OrderStatusPending || transitionErr.To != models.OrderStatusShipped {
+		t.Errorf("Expected pending → shipped in the error, got %s → %s", transitionErr.From, transitionErr.To)
+	}
+
+	for _, status := range []models.OrderStatus{models.OrderStatusConfirmed, models.OrderStatusShipped, models.OrderStatusDelivered} {
+		if order, err = orders.UpdateOrderStatus(order.ID, status, seller, ""); err != nil {
+			t.Fatalf("Failed to move order to %s: %v", status, err)
+		}
+	}
+	if order.PaymentStatus != models.PaymentStatusPaid {
+		t.Errorf("Expected a delivered order to be paid, got %s", order.PaymentStatus)
+	}
+	if _, err := orders.CancelOrder(order.ID, seller, "too late"); !errors.As(err, &transitionErr) {
+		t.Errorf("Expected a delivered order not to be cancellable, got %v", err)
+	}
+
+	history, err := orders.GetOrderStatusHistory(order.ID)
+	if err != nil {
+		t.Fatalf("Failed to get status history: %v", err)
+	}
+	if len(history) != 4 || history[0].ToStatus != models.OrderStatusPending || history[3].ToStatus != models.OrderStatusDelivered {
+		t.Fatalf("Expected 4 status events ending in delivered, got %+v", history)
+	}
+	if history[2].ActorType != models.OrderActorSeller || history[2].ActorTelegramID != 42 {
+		t.Errorf("Expected the seller as actor, got %s %d", history[2].ActorType, history[2].ActorTelegramID)
+	}
+
+	// Cancelling a confirmed order puts its units back on sale
+	order, _ = orders.CreateOrder(testStore.ID, customerID, "Customer", "")
+	orders.AddOrderItem(order.ID, vase.ID, 1, vase.Price)
+	testConfig.DB.Create(&models.StockReservation{OrderID: order.ID, ProductID: vase.ID, Quantity: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	if _, err := orders.UpdateOrderStatus(order.ID, models.OrderStatusConfirmed, seller, ""); err != nil {
+		t.Fatalf("Failed to confirm order: %v", err)
+	}
+	if _, err := orders.CancelOrder(order.ID, seller, "out of paint"); err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+	testConfig.DB.First(vase, vase.ID)
+	if vase.Stock != 2 {
+		t.Errorf("Expected stock 2 after cancellation, got %d", vase.Stock)
+	}
+	history, _ = orders.GetOrderStatusHistory(order.ID)
+	if last := history[len(history)-1]; last.ToStatus != models.OrderStatusCancelled || last.Note != "out of paint" {
+		t.Errorf("Expected the cancel reason in the history, got %+v", last)
+	}
+
+	t.Log("✅ Order state machine tests passed")
+}
+
+// TestOrderStatusNotifications tests that customers hear about status
+// changes from the store bot, in the store's wording when it has one
+func TestOrderStatusNotifications(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	server := telegramtest.NewServer()
+	defer server.Close()
+	services.SetBotAPIEndpoint(server.Endpoint())
+	defer services.SetBotAPIEndpoint("")
+
+	token := fmt.Sprintf("%d:notification-test", time.Now().Unix())
+	fake := server.AddBot(token, "notification_test_bot")
+	testStore := &models.Store{
+		Name:      "Notification Test Store",
+		BotToken:  token,
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+
+	orders := services.NewOrderService(testConfig.DB)
+	orders.SetNotifier(services.NewOrderNotifier(testConfig.DB))
+	templates := services.NewMessageTemplateService(testConfig.DB)
+	shipped := services.OrderStatusTemplateKey(models.OrderStatusShipped)
+	if err := templates.Set(testStore.ID, shipped, "Order {order_id} is on its way: {tracking_code}"); err != nil {
+		t.Fatalf("Failed to set template: %v", err)
+	}
+
+	customerID := int64(3001)
+	seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: 42}
+	order, err := orders.CreateOrder(testStore.ID, customerID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	orders.UpdateOrderStatus(order.ID, models.OrderStatusConfirmed, seller, "")
+	if _, err := orders.ShipOrder(order.ID, seller, "TRK-123"); err != nil {
+		t.Fatalf("Failed to ship order: %v", err)
+	}
+	sent, err := fake.WaitSent(2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Order %d is on its way: TRK-123", order.ID); sent[1].ChatID != customerID || sent[1].Text != want {
+		t.Errorf("Expected %q to the customer, got %q to %d", want, sent[1].Text, sent[1].ChatID)
+	}
+
+	// The default notice gets the cancel reason below it
+	fake.ClearSent()
+	order, _ = orders.CreateOrder(testStore.ID, customerID, "Customer", "")
+	if _, err := orders.CancelOrder(order.ID, seller, "out of stock"); err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+	sent, err = fake.WaitSent(1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := sent[0].Text; !strings.Contains(text, fmt.Sprintf("#%d", order.ID)) || !strings.Contains(text, "out of stock") {
+		t.Errorf("Expected the order number and the reason in the notice, got %q", text)
+	}
+
+	t.Log("✅ Order status notification tests passed")
+}
+
+// TestSellerOrderInbox tests the order listing, lookup and search behind
+// the seller's order inbox
+func TestSellerOrderInbox(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	orders := services.NewOrderService(testConfig.DB)
+
+	var stores [2]*models.Store
+	for i := range stores {
+		stores[i] = &models.Store{
+			Name:      fmt.Sprintf("Inbox Test Store %d", i),
+			PlanType:  models.PlanFree,
+			ExpiresAt: time.Now().AddDate(0, 1, 0),
+			IsActive:  true,
+		}
+		if err := testConfig.DB.Create(stores[i]).Error; err != nil {
+			t.Fatalf("Failed to create test store: %v", err)
+		}
+	}
+
+	var created []*models.Order
+	for i, username := range []string{"ali_r", "alireza", "sara"} {
+		order, err := orders.CreateOrder(stores[0].ID, int64(4001+i), "Customer", username)
+		if err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+		created = append(created, order)
+	}
+	other, _ := orders.CreateOrder(stores[1].ID, 4100, "Customer", "ali_r")
+	seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: 42}
+	orders.UpdateOrderStatus(created[0].ID, models.OrderStatusConfirmed, seller, "")
+
+	page, total, err := orders.ListStoreOrders(stores[0].ID, "", 2, 0)
+	if err != nil || total != 3 || len(page) != 2 || page[0].ID != created[2].ID {
+		t.Errorf("Expected the 2 newest of 3 orders, got %d of %d (%v)", len(page), total, err)
+	}
+	if pending, total, _ := orders.ListStoreOrders(stores[0].ID, models.OrderStatusPending, 10, 0); total != 2 || len(pending) != 2 {
+		t.Errorf("Expected 2 pending orders, got %d", total)
+	}
+
+	if _, err := orders.GetStoreOrder(stores[0].ID, other.ID); !errors.Is(err, services.ErrOrderNotFound) {
+		t.Errorf("Expected another store's order to be hidden, got %v", err)
+	}
+
+	if found, _ := orders.SearchStoreOrders(stores[0].ID, fmt.Sprintf("#%d", created[1].ID), 10); len(found) != 1 || found[0].ID != created[1].ID {
+		t.Errorf("Expected to find order #%d by number, got %+v", created[1].ID, found)
+	}
+	if found, _ := orders.SearchStoreOrders(stores[0].ID, "@ali_", 10); len(found) != 1 || found[0].ID != created[0].ID {
+		t.Errorf("Expected only @ali_r to match @ali_, got %d orders", len(found))
+	}
+	if found, _ := orders.SearchStoreOrders(stores[0].ID, "ALI", 10); len(found) != 2 {
+		t.Errorf("Expected 2 orders of customers starting with ali, got %d", len(found))
+	}
+
+	t.Log("✅ Seller order inbox tests passed")
+}
+
+func TestCouponService(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	coupons := services.NewCouponService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Coupon Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	shoe := &models.Product{StoreID: testStore.ID, Name: "Shoe", Price: 100000, Category: "Shoes", IsAvailable: true}
+	hat := &models.Product{StoreID: testStore.ID, Name: "Hat", Price: 50000, Category: "Hats", IsAvailable: true}
+	for _, product := range []*models.Product{shoe, hat} {
+		if err := testConfig.DB.Omit("Store").Create(product).Error; err != nil {
+			t.Fatalf("Failed to create product: %v", err)
+		}
+	}
+
+	// 10% off shoes for orders of 120,000 and more, once per customer
+	coupon, err := services.ParseCouponSpec("save10 ۱۰% min=120,000 uses=5 per=1 category=Shoes")
+	if err != nil {
+		t.Fatalf("Failed to parse coupon: %v", err)
+	}
+	coupon.StoreID = testStore.ID
+	if err := coupons.CreateCoupon(coupon); err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+	duplicate := *coupon
+	duplicate.ID = 0
+	if err := coupons.CreateCoupon(&duplicate); !errors.Is(err, services.ErrCouponExists) {
+		t.Errorf("Expected the duplicate code to be refused, got %v", err)
+	}
+	var specErr *services.CouponSpecError
+	if _, err := services.ParseCouponSpec("BAD 10% max=3"); !errors.As(err, &specErr) || specErr.Part != "max=3" {
+		t.Errorf("Expected the unknown option to be reported, got %v", err)
+	}
+
+	// checkout fills a customer's cart and brings it to the review
+	checkout := func(customerID int64, items ...*models.Product) {
+		t.Helper()
+		for _, product := range items {
+			if _, err := carts.AddItem(testStore.ID, customerID, product.ID); err != nil {
+				t.Fatalf("Failed to add to cart: %v", err)
+			}
+		}
+		if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+			t.Fatalf("Failed to start checkout: %v", err)
+		}
+		for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+			if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "answer"); err != nil {
+				t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+			}
+		}
+	}
+
+	first := time.Now().UnixNano()
+	second := first + 1
+	checkout(first, shoe, hat)
+	if discount, err := carts.ApplyCoupon(testStore.ID, first, "Save10"); err != nil || discount != 10000 {
+		t.Fatalf("Expected 10,000 off the shoe, got %d (%v)", discount, err)
+	}
+	order, err := orders.CreateOrderFromCart(testStore.ID, first, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	if order.CouponCode != "SAVE10" || order.DiscountAmount != 10000 || order.TotalAmount != 140000 {
+		t.Errorf("Expected a 140,000 order with the discount, got %s %d %d", order.CouponCode, order.DiscountAmount, order.TotalAmount)
+	}
+
+	checkout(first, shoe, hat)
+	if _, err := carts.ApplyCoupon(testStore.ID, first, "SAVE10"); !errors.Is(err, services.ErrCouponCustomerLimit) {
+		t.Errorf("Expected the customer's only use to be taken, got %v", err)
+	}
+	checkout(second, hat)
+	var minErr *services.CouponMinOrderError
+	if _, err := carts.ApplyCoupon(testStore.ID, second, "SAVE10"); !errors.As(err, &minErr) || minErr.MinOrder != 120000 {
+		t.Errorf("Expected the minimum order to be enforced, got %v", err)
+	}
+	checkout(second, hat, hat)
+	if _, err := carts.ApplyCoupon(testStore.ID, second, "SAVE10"); !errors.Is(err, services.ErrCouponNotApplicable) {
+		t.Errorf("Expected a cart without shoes to get no discount, got %v", err)
+	}
+
+	// Cancelling the order gives the use back
+	if _, err := orders.CancelOrder(order.ID, services.SystemActor, "test"); err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+	if _, err := carts.ApplyCoupon(testStore.ID, first, "SAVE10"); err != nil {
+		t.Fatalf("Expected the released coupon to apply again: %v", err)
+	}
+	if _, err := orders.CreateOrderFromCart(testStore.ID, first, "Customer", ""); err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+
+	report, err := orders.GetStoreSalesReport(testStore.ID)
+	if err != nil {
+		t.Fatalf("Failed to get sales report: %v", err)
+	}
+	if len(report.Coupons) != 1 || report.Coupons[0].Uses != 1 || report.Coupons[0].Discount != 10000 || report.TotalDiscounts != 10000 {
+		t.Errorf("Expected one use of SAVE10 in the report, got %+v", report.Coupons)
+	}
+
+	// Disabled and ended coupons can't be used
+	if err := coupons.SetCouponActive(testStore.ID, coupon.ID, false); err != nil {
+		t.Fatalf("Failed to disable coupon: %v", err)
+	}
+	ended, _ := services.ParseCouponSpec("OLD 5000 to=2020-01-01")
+	ended.StoreID = testStore.ID
+	if err := coupons.CreateCoupon(ended); err != nil {
+		t.Fatalf("Failed to create coupon: %v", err)
+	}
+	for _, code := range []string{"SAVE10", "OLD"} {
+		if _, err := carts.ApplyCoupon(testStore.ID, second, code); !errors.Is(err, services.ErrCouponInactive) {
+			t.Errorf("Expected %s to be inactive, got %v", code, err)
+		}
+	}
+
+	t.Log("✅ Coupon service tests passed")
+}
+
+// TestShippingService tests shipping methods, their fees and how checkout
+// keeps the fee apart from the items
+func TestShippingService(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	shipping := services.NewShippingService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:           "Shipping Test Store",
+		PlanType:       models.PlanFree,
+		ExpiresAt:      time.Now().AddDate(0, 1, 0),
+		IsActive:       true,
+		CommissionRate: 10,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	book := &models.Product{StoreID: testStore.ID, Name: "Book", Price: 200000, Weight: 1500, IsAvailable: true}
+	if err := testConfig.DB.Omit("Store").Create(book).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	items := []models.CartItem{{Product: *book, Quantity: 2}}
+
+	courier, err := services.ParseShippingSpec("Courier\nkind=courier rate=region fee=60,000 free=1000000\nTehran=۴۰۰۰۰")
+	if err != nil {
+		t.Fatalf("Failed to parse shipping method: %v", err)
+	}
+	courier.StoreID = testStore.ID
+	if err := shipping.CreateMethod(courier); err != nil {
+		t.Fatalf("Failed to create shipping method: %v", err)
+	}
+	if fee := services.ShippingFee(courier, "tehran", items); fee != 40000 {
+		t.Errorf("Expected 40,000 to Tehran, got %d", fee)
+	}
+	if fee := services.ShippingFee(courier, "Shiraz", items); fee != 60000 {
+		t.Errorf("Expected 60,000 to other cities, got %d", fee)
+	}
+	if fee := services.ShippingFee(courier, "Shiraz", []models.CartItem{{Product: *book, Quantity: 5}}); fee != 0 {
+		t.Errorf("Expected free shipping from 1,000,000, got %d", fee)
+	}
+
+	post := &models.ShippingMethod{StoreID: testStore.ID, Name: "Post", Kind: models.ShippingPost,
+		RateType: models.ShippingRateWeight, BaseFee: 20000, PerKgFee: 10000, IsActive: true}
+	if err := shipping.CreateMethod(post); err != nil {
+		t.Fatalf("Failed to create shipping method: %v", err)
+	}
+	// 3 kg of books
+	if fee := services.ShippingFee(post, "", items); fee != 50000 {
+		t.Errorf("Expected 50,000 for 3 kg, got %d", fee)
+	}
+	var specErr *services.ShippingSpecError
+	pickup := &models.ShippingMethod{StoreID: testStore.ID, Name: "Pickup", Kind: models.ShippingPickup, RateType: models.ShippingRateWeight}
+	if err := shipping.CreateMethod(pickup); !errors.As(err, &specErr) {
+		t.Errorf("Expected a weight-based pickup to be refused, got %v", err)
+	}
+
+	// The order keeps the fee apart from the items and pays no commission on it
+	customerID := time.Now().UnixNano()
+	if _, err := carts.AddItem(testStore.ID, customerID, book.ID); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to start checkout: %v", err)
+	}
+	if step, _ := carts.CheckoutStep(testStore.ID, customerID); step != services.CheckoutStepShipping {
+		t.Fatalf("Expected checkout to start with shipping, got %q", step)
+	}
+	next, err := carts.ChooseShippingMethod(testStore.ID, customerID, courier.ID)
+	if err != nil || next != services.CheckoutStepCity {
+		t.Fatalf("Expected the courier to ask for the city, got %q (%v)", next, err)
+	}
+	for _, step := range []string{services.CheckoutStepCity, services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+		if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "Tehran"); err != nil {
+			t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+		}
+	}
+	order, err := orders.CreateOrderFromCart(testStore.ID, customerID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	if err := testConfig.DB.First(order, order.ID).Error; err != nil {
+		t.Fatalf("Failed to reload order: %v", err)
+	}
+	if order.ShippingMethod != "Courier" || order.ShippingFee != 40000 || order.TotalAmount != 240000 || order.DeliveryCity != "Tehran" {
+		t.Errorf("Expected a 240,000 order with 40,000 shipping, got %s %d %d", order.ShippingMethod, order.ShippingFee, order.TotalAmount)
+	}
+	if order.CommissionAmount != 20000 {
+		t.Errorf("Expected commission on the items only, got %d", order.CommissionAmount)
+	}
+
+	// A withdrawn method can't be chosen
+	if err := shipping.SetMethodActive(testStore.ID, post.ID, false); err != nil {
+		t.Fatalf("Failed to disable shipping method: %v", err)
+	}
+	if _, err := carts.AddItem(testStore.ID, customerID, book.ID); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to start checkout: %v", err)
+	}
+	if _, err := carts.ChooseShippingMethod(testStore.ID, customerID, post.ID); !errors.Is(err, services.ErrShippingUnavailable) {
+		t.Errorf("Expected the disabled method to be unavailable, got %v", err)
+	}
+
+	t.Log("✅ Shipping service tests passed")
+}
+
+// TestProductVariants tests selling a product by variant: each variant has
+// its own price and stock and is recorded on the order item
+func TestProductVariants(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+	products := services.NewProductService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Variant Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	shirt := &models.Product{StoreID: testStore.ID, Name: "Shirt", Price: 100000, IsAvailable: true, TrackStock: true}
+	if err := testConfig.DB.Omit("Store").Create(shirt).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	var specErr *services.VariantSpecError
+	if _, _, err := services.ParseVariantSpec("Size/Color\nM stock=1"); !errors.As(err, &specErr) {
+		t.Errorf("Expected a variant missing a color to be refused, got %v", err)
+	}
+	axes, variants, err := services.ParseVariantSpec("Size/Color\nM/Light blue sku=SH-M stock=۲\nL/Light blue price=130,000 stock=1")
+	if err != nil {
+		t.Fatalf("Failed to parse variants: %v", err)
+	}
+	if axes != "Size/Color" || len(variants) != 2 || variants[0].Options != "M/Light blue" || variants[0].SKU != "SH-M" || variants[1].Price != 130000 {
+		t.Fatalf("Unexpected variants %q %+v", axes, variants)
+	}
+	if err := products.SaveVariants(shirt.ID, axes, variants); err != nil {
+		t.Fatalf("Failed to save variants: %v", err)
+	}
+	saved, err := products.GetVariants(shirt.ID)
+	if err != nil || len(saved) != 2 {
+		t.Fatalf("Expected 2 variants, got %d (%v)", len(saved), err)
+	}
+	medium, large := saved[0], saved[1]
+	testConfig.DB.First(shirt, shirt.ID)
+	if shirt.Stock != 3 {
+		t.Errorf("Expected the product stock to be the variants' total, got %d", shirt.Stock)
+	}
+
+	// The product itself can't go in the cart, its variants can
+	customerID := time.Now().UnixNano()
+	if _, err := carts.AddItem(testStore.ID, customerID, shirt.ID); !errors.Is(err, services.ErrVariantRequired) {
+		t.Errorf("Expected a variant to be required, got %v", err)
+	}
+	if _, err := carts.AddVariant(testStore.ID, customerID, medium.ID); err != nil {
+		t.Fatalf("Failed to add variant to cart: %v", err)
+	}
+	if _, err := carts.AddVariant(testStore.ID, customerID, large.ID); err != nil {
+		t.Fatalf("Failed to add variant to cart: %v", err)
+	}
+	if _, err := carts.AddVariant(testStore.ID, customerID, large.ID); !errors.Is(err, services.ErrCartQuantityLimit) {
+		t.Errorf("Expected the variant's own stock to limit the cart, got %v", err)
+	}
+	cart, err := carts.GetCart(testStore.ID, customerID)
+	if err != nil || len(cart.Items) != 2 {
+		t.Fatalf("Expected 2 cart items, got %d (%v)", len(cart.Items), err)
+	}
+	if total := services.CartTotal(cart); total != 230000 {
+		t.Errorf("Expected the large shirt at its own price, got a total of %d", total)
+	}
+
+	if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to start checkout: %v", err)
+	}
+	for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+		if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "answer"); err != nil {
+			t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+		}
+	}
+	order, err := orders.CreateOrderFromCart(testStore.ID, customerID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	var items []models.OrderItem
+	testConfig.DB.Where("order_id = ?", order.ID).Order("id ASC").Find(&items)
+	if len(items) != 2 || items[0].VariantID == nil || *items[0].VariantID != medium.ID ||
+		items[0].VariantName != "M / Light blue" || items[0].SKU != "SH-M" || items[1].UnitPrice != 130000 {
+		t.Errorf("Expected the variants on the order items, got %+v", items)
+	}
+
+	// Confirming takes the units off the variants and the product
+	if _, err := orders.UpdateOrderStatus(order.ID, models.OrderStatusConfirmed, services.SystemActor, ""); err != nil {
+		t.Fatalf("Failed to confirm order: %v", err)
+	}
+	testConfig.DB.First(&medium, medium.ID)
+	testConfig.DB.First(&large, large.ID)
+	testConfig.DB.First(shirt, shirt.ID)
+	if medium.Stock != 1 || large.Stock != 0 || shirt.Stock != 1 {
+		t.Errorf("Expected stock 1/0/1 after confirmation, got %d/%d/%d", medium.Stock, large.Stock, shirt.Stock)
+	}
+
+	// A variant left out of a new list is taken off sale
+	axes, variants, _ = services.ParseVariantSpec("Size/Color\nM/Light blue stock=1")
+	if err := products.SaveVariants(shirt.ID, axes, variants); err != nil {
+		t.Fatalf("Failed to save variants: %v", err)
+	}
+	if _, err := carts.AddVariant(testStore.ID, customerID, large.ID); !errors.Is(err, services.ErrProductUnavailable) {
+		t.Errorf("Expected the removed variant to be unavailable, got %v", err)
+	}
+
+	t.Log("✅ Product variant tests passed")
+}
+
+// TestProductGallery tests product galleries: their order, limit and the
+// catalog thumbnail taken from the first photo
+func TestProductGallery(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	products := services.NewProductService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Gallery Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	product := &models.Product{StoreID: testStore.ID, Name: "Gallery Product", Price: 10000, IsAvailable: true}
+	if err := testConfig.DB.Omit("Store").Create(product).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+
+	// A video first: the thumbnail is the first photo
+	uploads := []struct{ mediaType, fileID string }{
+		{models.MediaVideo, "video-1"}, {models.MediaPhoto, "photo-1"}, {models.MediaPhoto, "photo-2"},
+	}
+	for i, upload := range uploads {
+		count, err := products.AddProductMedia(testStore.ID, product.ID, upload.mediaType, upload.fileID)
+		if err != nil || count != i+1 {
+			t.Fatalf("Expected gallery of %d, got %d (%v)", i+1, count, err)
+		}
+	}
+	if _, err := products.AddProductMedia(testStore.ID+1, product.ID, models.MediaPhoto, "other"); !errors.Is(err, services.ErrProductNotFound) {
+		t.Errorf("Expected another store's product to be refused, got %v", err)
+	}
+	testConfig.DB.First(product, product.ID)
+	if product.ThumbnailFileID != "photo-1" {
+		t.Errorf("Expected the first photo as thumbnail, got %q", product.ThumbnailFileID)
+	}
+
+	media, err := products.GetProductMedia(product.ID)
+	if err != nil || len(media) != 3 || media[0].FileID != "video-1" || media[2].FileID != "photo-2" {
+		t.Fatalf("Expected the gallery in upload order, got %+v (%v)", media, err)
+	}
+	if _, ok := services.ProductGallery(1, media).(tgbotapi.MediaGroupConfig); !ok {
+		t.Errorf("Expected several items to be sent as a media group")
+	}
+	if _, ok := services.ProductGallery(1, media[1:2]).(tgbotapi.PhotoConfig); !ok {
+		t.Errorf("Expected a single photo to be sent as a photo")
+	}
+
+	// Replacing the gallery reorders it and moves the thumbnail
+	if err := products.SetProductMedia(product.ID, []models.ProductMedia{media[2], media[1]}); err != nil {
+		t.Fatalf("Failed to replace gallery: %v", err)
+	}
+	testConfig.DB.First(product, product.ID)
+	if product.ThumbnailFileID != "photo-2" {
+		t.Errorf("Expected the new first photo as thumbnail, got %q", product.ThumbnailFileID)
+	}
+
+	for i := 2; i < services.MaxProductMedia; i++ {
+		if _, err := products.AddProductMedia(testStore.ID, product.ID, models.MediaPhoto, fmt.Sprintf("photo-%d", i+10)); err != nil {
+			t.Fatalf("Failed to fill gallery: %v", err)
+		}
+	}
+	if _, err := products.AddProductMedia(testStore.ID, product.ID, models.MediaPhoto, "one-too-many"); !errors.Is(err, services.ErrProductMediaLimit) {
+		t.Errorf("Expected a full gallery to be refused, got %v", err)
+	}
+
+	if err := products.ClearProductMedia(testStore.ID, product.ID); err != nil {
+		t.Fatalf("Failed to clear gallery: %v", err)
+	}
+	testConfig.DB.First(product, product.ID)
+	if product.ThumbnailFileID != "" {
+		t.Errorf("Expected no thumbnail without a gallery, got %q", product.ThumbnailFileID)
+	}
+
+	t.Log("✅ Product gallery tests passed")
+}
+
+// TestGalleryUpload tests the owner adding photos to a product's gallery
+// through the store bot the bot manager runs, and customers seeing them
+func TestGalleryUpload(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	f := newStoreBotFixture(t, testConfig, "gallery_upload")
+	product := f.addProduct(&models.Product{Name: "Lamp", Price: 70000})
+	defer startStoreBot(t, testConfig, f.store.ID)()
+
+	fake := f.fake
+	sellerUser := f.ownerUser()
+	customer := tgbotapi.User{ID: 2005, FirstName: "Customer"}
+	caption := fmt.Sprintf("/photo %d", product.ID)
+
+	var first tgbotapi.Update
+	added := f.exchange(1, func() (_ tgbotapi.Update, err error) {
+		first, err = fake.SendPhoto(sellerUser, []byte("front"), caption)
+		return first, err
+	})
+	if !strings.Contains(added[0].Text, "(1 از") {
+		t.Errorf("Expected the first photo to be added, got %q", added[0].Text)
+	}
+	added = f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendPhoto(sellerUser, []byte("side"), caption) })
+	if !strings.Contains(added[0].Text, "(2 از") {
+		t.Errorf("Expected the second photo to be added, got %q", added[0].Text)
+	}
+
+	// Customers' photos are no uploads
+	f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendPhoto(customer, []byte("selfie"), caption) })
+	if media, _ := services.NewProductService(testConfig.DB).GetProductMedia(product.ID); len(media) != 2 {
+		t.Errorf("Expected a customer's photo to be ignored, got a gallery of %d", len(media))
+	}
+
+	// The album goes above the product's card, whose photo is the first one
+	card := f.exchange(3, func() (tgbotapi.Update, error) {
+		return fake.SendText(customer, fmt.Sprintf("/start buy_%d", product.ID))
+	})
+	if card[0].Method != "sendMediaGroup" || card[1].Method != "sendMediaGroup" {
+		t.Errorf("Expected the gallery as an album, got %s and %s", card[0].Method, card[1].Method)
+	}
+	if thumbnail := first.Message.Photo[0].FileID; card[2].Method != "sendPhoto" || card[2].Photo != thumbnail {
+		t.Errorf("Expected the card with photo %s, got %s %q", thumbnail, card[2].Method, card[2].Photo)
+	}
+
+	t.Log("✅ Gallery upload tests passed")
+}
+
+// TestDigitalDelivery tests digital products: license keys are taken from
+// the pool when the order is confirmed and stay with it for re-delivery
+func TestDigitalDelivery(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+	digital := services.NewDigitalService(testConfig.DB)
+
+	testStore := &models.Store{
+		Name:      "Digital Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	license := &models.Product{StoreID: testStore.ID, Name: "License", Price: 50000, IsAvailable: true}
+	course := &models.Product{StoreID: testStore.ID, Name: "Course", Price: 90000, IsAvailable: true}
+	for _, product := range []*models.Product{license, course} {
+		if err := testConfig.DB.Omit("Store").Create(product).Error; err != nil {
+			t.Fatalf("Failed to create product: %v", err)
+		}
+	}
+
+	added, pool, err := digital.AddLicenseKeys(testStore.ID, license.ID, "KEY-1\nKEY-2\n\nKEY-1\nKEY-3")
+	if err != nil || added != 3 || pool != 3 {
+		t.Fatalf("Expected 3 distinct keys in the pool, got %d/%d (%v)", added, pool, err)
+	}
+	if _, err := digital.SetDigitalFile(testStore.ID, course.ID, "file-id"); err != nil {
+		t.Fatalf("Failed to set digital file: %v", err)
+	}
+	if _, err := digital.SetDigitalFile(testStore.ID+1, course.ID, "file-id"); !errors.Is(err, services.ErrDigitalProductNotFound) {
+		t.Errorf("Expected another store's product to be refused, got %v", err)
+	}
+
+	customerID := time.Now().UnixNano()
+	for _, productID := range []uint{license.ID, license.ID, course.ID} {
+		if _, err := carts.AddItem(testStore.ID, customerID, productID); err != nil {
+			t.Fatalf("Failed to add to cart: %v", err)
+		}
+	}
+	if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to start checkout: %v", err)
+	}
+	for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+		if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "answer"); err != nil {
+			t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+		}
+	}
+	order, err := orders.CreateOrderFromCart(testStore.ID, customerID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+
+	// Nothing is delivered before the payment is confirmed
+	if _, err := digital.OrderDeliveries(testStore.ID, customerID, order.ID); !errors.Is(err, services.ErrNoDigitalDelivery) {
+		t.Errorf("Expected nothing to deliver before confirmation, got %v", err)
+	}
+	if _, err := orders.UpdateOrderStatus(order.ID, models.OrderStatusConfirmed, services.SystemActor, ""); err != nil {
+		t.Fatalf("Failed to confirm order: %v", err)
+	}
+	deliveries, err := digital.OrderDeliveries(testStore.ID, customerID, order.ID)
+	if err != nil || len(deliveries) != 2 {
+		t.Fatalf("Expected 2 deliveries, got %d (%v)", len(deliveries), err)
+	}
+	if keys := deliveries[0].Keys; len(keys) != 2 || keys[0] != "KEY-1" || keys[1] != "KEY-2" {
+		t.Errorf("Expected the first two keys, got %v", keys)
+	}
+	if deliveries[1].FileID != "file-id" {
+		t.Errorf("Expected the course file, got %q", deliveries[1].FileID)
+	}
+	if pool, _ := digital.KeyPoolSize(license.ID); pool != 1 {
+		t.Errorf("Expected 1 key left in the pool, got %d", pool)
+	}
+	testConfig.DB.First(license, license.ID)
+	if license.Stock != 1 {
+		t.Errorf("Expected the stock to follow the pool, got %d", license.Stock)
+	}
+
+	// Delivered keys can't go back on sale
+	if _, err := orders.CancelOrder(order.ID, services.SystemActor, "test"); err != nil {
+		t.Fatalf("Failed to cancel order: %v", err)
+	}
+	testConfig.DB.First(license, license.ID)
+	if license.Stock != 1 {
+		t.Errorf("Expected the cancelled order's keys to stay taken, got stock %d", license.Stock)
+	}
+
+	// An order paid while still pending can be delivered again too
+	if _, err := carts.AddItem(testStore.ID, customerID, license.ID); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to start checkout: %v", err)
+	}
+	for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+		if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "answer"); err != nil {
+			t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+		}
+	}
+	paid, err := orders.CreateOrderFromCart(testStore.ID, customerID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	if err := orders.UpdateOrderPaymentStatus(paid.ID, models.PaymentStatusPaid); err != nil {
+		t.Fatalf("Failed to mark order paid: %v", err)
+	}
+	deliveries, err = digital.OrderDeliveries(testStore.ID, customerID, paid.ID)
+	if err != nil || len(deliveries) != 1 || len(deliveries[0].Keys) != 1 || deliveries[0].Keys[0] != "KEY-3" {
+		t.Errorf("Expected the paid order's key to be delivered again, got %+v (%v)", deliveries, err)
+	}
+
+	t.Log("✅ Digital delivery tests passed")
+}
+
+// TestDigitalFileUpload tests the owner attaching a file to a digital
+// product by sending it to the store bot the bot manager runs
+func TestDigitalFileUpload(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	f := newStoreBotFixture(t, testConfig, "digital_upload")
+	course := f.addProduct(&models.Product{Name: "Course", Price: 90000})
+	defer startStoreBot(t, testConfig, f.store.ID)()
+
+	upload := func(from tgbotapi.User) telegramtest.Message {
+		t.Helper()
+		var update tgbotapi.Update
+		sent := f.exchange(1, func() (_ tgbotapi.Update, err error) {
+			update, err = f.fake.SendDocument(from, []byte("course"), "course.pdf", fmt.Sprintf("/file %d", course.ID))
+			return update, err
+		})
+		testConfig.DB.First(course, course.ID)
+		if from.ID == f.owner.TelegramID && course.DigitalFileID != update.Message.Document.FileID {
+			t.Errorf("Expected the document to become the product's file, got %q", course.DigitalFileID)
+		}
+		return sent[0]
+	}
+
+	// Customers' documents are no uploads
+	customer := tgbotapi.User{ID: 2004, FirstName: "Customer"}
+	upload(customer)
+	if course.DigitalType != "" {
+		t.Errorf("Expected a customer's document to be ignored, got a %q product", course.DigitalType)
+	}
+
+	saved := upload(f.ownerUser())
+	if !strings.Contains(saved.Text, "Course") || course.DigitalType != models.DigitalFile {
+		t.Errorf("Expected the file to be saved, got %q and a %q product", saved.Text, course.DigitalType)
+	}
+
+	t.Log("✅ Digital file upload tests passed")
+}
+
+// TestCustomerCancellationAndReturns tests customers cancelling pending
+// orders and returning delivered ones
+func TestCustomerCancellationAndReturns(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+	returns := services.NewReturnService(testConfig.DB, orders)
+
+	testStore := &models.Store{
+		Name:      "Returns Test Store",
+		PlanType:  models.PlanFree,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Create(testStore).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	kettle := &models.Product{StoreID: testStore.ID, Name: "Kettle", Price: 4000, IsAvailable: true, TrackStock: true, Stock: 3}
+	if err := testConfig.DB.Omit("Store").Create(kettle).Error; err != nil {
+		t.Fatalf("Failed to create product: %v", err)
+	}
+	stock := func() int {
+		t.Helper()
+		var product models.Product
+		if err := testConfig.DB.First(&product, kettle.ID).Error; err != nil {
+			t.Fatalf("Failed to reload product: %v", err)
+		}
+		return product.Stock
+	}
+
+	customerID := time.Now().UnixNano()
+	placeOrder := func() *models.Order {
+		t.Helper()
+		if _, err := carts.AddItem(testStore.ID, customerID, kettle.ID); err != nil {
+			t.Fatalf("Failed to add to cart: %v", err)
+		}
+		if err := carts.StartCheckout(testStore.ID, customerID); err != nil {
+			t.Fatalf("Failed to start checkout: %v", err)
+		}
+		for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+			if _, err := carts.SaveCheckoutAnswer(testStore.ID, customerID, step, "answer"); err != nil {
+				t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+			}
+		}
+		order, err := orders.CreateOrderFromCart(testStore.ID, customerID, "Customer", "")
+		if err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+		return order
+	}
+
+	// Only the customer may cancel, and only while the order is pending
+	pending := placeOrder()
+	if _, err := orders.CancelCustomerOrder(testStore.ID, customerID+1, pending.ID); !errors.Is(err, services.ErrOrderNotFound) {
+		t.Errorf("Expected another customer's order to be hidden, got %v", err)
+	}
+	if order, err := orders.CancelCustomerOrder(testStore.ID, customerID, pending.ID); err != nil || order.Status != models.OrderStatusCancelled {
+		t.Fatalf("Expected the pending order to be cancelled, got %v", err)
+	}
+	confirmed := placeOrder()
+	if _, err := orders.UpdateOrderStatus(confirmed.ID, models.OrderStatusConfirmed, services.SystemActor, ""); err != nil {
+		t.Fatalf("Failed to confirm order: %v", err)
+	}
+	var transitionErr *services.InvalidTransitionError
+	if _, err := orders.CancelCustomerOrder(testStore.ID, customerID, confirmed.ID); !errors.As(err, &transitionErr) {
+		t.Errorf("Expected a confirmed order to stay, got %v", err)
+	}
+	if _, err := returns.StartReturn(testStore.ID, customerID, confirmed.ID); !errors.Is(err, services.ErrReturnNotAllowed) {
+		t.Errorf("Expected an undelivered order to be refused, got %v", err)
+	}
+
+	for _, status := range []models.OrderStatus{models.OrderStatusShipped, models.OrderStatusDelivered} {
+		if _, err := orders.UpdateOrderStatus(confirmed.ID, status, services.SystemActor, ""); err != nil {
+			t.Fatalf("Failed to move order to %s: %v", status, err)
+		}
+	}
+	if got := stock(); got != 2 {
+		t.Fatalf("Expected the delivered kettle to leave the stock, got %d", got)
+	}
+
+	// The customer writes the request, which needs a reason to be sent
+	request, err := returns.StartReturn(testStore.ID, customerID, confirmed.ID)
+	if err != nil {
+		t.Fatalf("Failed to start return: %v", err)
+	}
+	if _, err := returns.SubmitReturn(testStore.ID, customerID); !errors.Is(err, services.ErrReturnNoReason) {
+		t.Errorf("Expected a return without reason to be refused, got %v", err)
+	}
+	if err := returns.SetReturnReason(request.ID, "Broken lid"); err != nil {
+		t.Fatalf("Failed to save reason: %v", err)
+	}
+	if count, err := returns.AddReturnPhoto(request.ID, "photo-file-id"); err != nil || count != 1 {
+		t.Fatalf("Expected one photo, got %d (%v)", count, err)
+	}
+	if _, err := returns.SubmitReturn(testStore.ID, customerID); err != nil {
+		t.Fatalf("Failed to submit return: %v", err)
+	}
+	if _, err := returns.StartReturn(testStore.ID, customerID, confirmed.ID); !errors.Is(err, services.ErrReturnExists) {
+		t.Errorf("Expected one return per order, got %v", err)
+	}
+
+	// Approving refunds the order and restocks it
+	seller := services.OrderActor{Type: models.OrderActorSeller, TelegramID: 1}
+	refund, err := returns.ApproveReturn(testStore.ID, request.ID, seller)
+	if err != nil {
+		t.Fatalf("Failed to approve return: %v", err)
+	}
+	if refund.Amount != 4000 || refund.OrderID != confirmed.ID {
+		t.Errorf("Expected a 4,000 refund of the order, got %+v", refund)
+	}
+	if got := stock(); got != 3 {
+		t.Errorf("Expected the returned kettle back in stock, got %d", got)
+	}
+	order, err := orders.GetOrderByID(confirmed.ID)
+	if err != nil || order.Status != models.OrderStatusRefunded {
+		t.Errorf("Expected the order to be refunded, got %s (%v)", order.Status, err)
+	}
+	if _, err := returns.RejectReturn(testStore.ID, request.ID); !errors.Is(err, services.ErrReturnNotPending) {
+		t.Errorf("Expected a decided return to stay decided, got %v", err)
+	}
+
+	t.Log("✅ Cancellation and return tests passed")
+}
+
+// TestReturnOwnerNotice tests that the owner of a store whose bot the bot
+// manager runs is sent a customer's return request with its photos
+func TestReturnOwnerNotice(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	carts := services.NewCartService(testConfig.DB)
+	orders := services.NewOrderService(testConfig.DB)
+
+	f := newStoreBotFixture(t, testConfig, "return_notice")
+	testStore, fake := f.store, f.fake
+	kettle := f.addProduct(&models.Product{Name: "Kettle", Price: 4000})
+
+	customer := tgbotapi.User{ID: 2006, FirstName: "Customer"}
+	if _, err := carts.AddItem(testStore.ID, customer.ID, kettle.ID); err != nil {
+		t.Fatalf("Failed to add to cart: %v", err)
+	}
+	if err := carts.StartCheckout(testStore.ID, customer.ID); err != nil {
+		t.Fatalf("Failed to start checkout: %v", err)
+	}
+	for _, step := range []string{services.CheckoutStepAddress, services.CheckoutStepPhone, services.CheckoutStepNotes} {
+		if _, err := carts.SaveCheckoutAnswer(testStore.ID, customer.ID, step, "answer"); err != nil {
+			t.Fatalf("Failed to answer checkout step %s: %v", step, err)
+		}
+	}
+	order, err := orders.CreateOrderFromCart(testStore.ID, customer.ID, "Customer", "")
+	if err != nil {
+		t.Fatalf("Failed to create order: %v", err)
+	}
+	for _, status := range []models.OrderStatus{models.OrderStatusConfirmed, models.OrderStatusShipped, models.OrderStatusDelivered} {
+		if _, err := orders.UpdateOrderStatus(order.ID, status, services.SystemActor, ""); err != nil {
+			t.Fatalf("Failed to move order to %s: %v", status, err)
+		}
+	}
+	defer startStoreBot(t, testConfig, testStore.ID)()
+
+	press := func(n int, on telegramtest.Message, button string) []telegramtest.Message {
+		t.Helper()
+		data, ok := on.CallbackData(button)
+		if !ok {
+			t.Fatalf("Expected a %q button, got %v", button, on.Buttons())
+		}
+		return f.exchange(n, func() (tgbotapi.Update, error) { return fake.Press(customer, on, data) })
+	}
+
+	history := f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "/orders") })
+	press(1, history[0], "مرجوعی")
+	askPhotos := f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "Arrived broken") })
+	added := f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendPhoto(customer, []byte("crack"), "") })
+	if askPhotos[0].ChatID != customer.ID || added[0].ChatID != customer.ID {
+		t.Fatalf("Expected the return questions in the customer's chat, got %+v", append(askPhotos, added...))
+	}
+
+	// The customer is told it was sent; the owner gets the notice and the photo
+	sent := press(3, added[0], "ارسال درخواست")
+	var notice, photo bool
+	for _, msg := range sent {
+		if msg.ChatID != f.owner.TelegramID {
+			continue
+		}
+		notice = notice || msg.Method == "sendMessage" && strings.Contains(msg.Text, fmt.Sprintf("#%d", order.ID))
+		photo = photo || msg.Method == "sendPhoto"
+	}
+	if !notice || !photo {
+		t.Errorf("Expected the owner to get the return notice and photo, got %+v", sent)
+	}
+
+	t.Log("✅ Return owner notice tests passed")
+}
+
+// TestFakeBotAPI tests the fake Bot API server the end-to-end tests run against
+func TestFakeBotAPI(t *testing.T) {
+	server := telegramtest.NewServer()
+	defer server.Close()
+
+	fake := server.AddBot("4:fake-api-test", "fake_api_test_bot")
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint("4:fake-api-test", server.Endpoint())
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	if api.Self.UserName != "fake_api_test_bot" {
+		t.Errorf("Expected getMe to return fake_api_test_bot, got %s", api.Self.UserName)
+	}
+
+	customer := tgbotapi.User{ID: 1001, FirstName: "Customer"}
+	if _, err := fake.SendText(customer, "/start"); err != nil {
+		t.Fatalf("Failed to inject message: %v", err)
+	}
+	updates, err := api.GetUpdates(tgbotapi.UpdateConfig{Timeout: 1})
+	if err != nil {
+		t.Fatalf("Failed to get updates: %v", err)
+	}
+	if len(updates) != 1 || updates[0].Message == nil || updates[0].Message.Command() != "start" {
+		t.Fatalf("Expected the injected /start command, got %+v", updates)
+	}
+
+	msg := tgbotapi.NewMessage(customer.ID, "menu")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🛍 محصولات", "show_products"),
+	))
+	if _, err := api.Send(msg); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	sent, err := fake.WaitSent(1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := sent[0].CallbackData("محصولات"); !ok || data != "show_products" {
+		t.Errorf("Expected show_products button, got %q", data)
+	}
+
+	fake.SetChatMember("@test_channel", customer.ID, "member")
+	member, err := api.GetChatMember(tgbotapi.GetChatMemberConfig{ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+		SuperGroupUsername: "@test_channel",
+		UserID:             customer.ID,
+	}})
+	if err != nil || member.Status != "member" {
+		t.Errorf("Expected customer to be a channel member, got %q (%v)", member.Status, err)
+	}
+
+	// Documents, videos and albums come back as messages like photos do
+	fake.ClearSent()
+	document := tgbotapi.NewDocument(customer.ID, tgbotapi.FileID("manual-file"))
+	document.Caption = "manual"
+	if got, err := api.Send(document); err != nil || got.Document == nil || got.Document.FileID != "manual-file" {
+		t.Errorf("Expected the document message, got %+v (%v)", got, err)
+	}
+	if got, err := api.Send(tgbotapi.NewVideo(customer.ID, tgbotapi.FileID("demo-video"))); err != nil || got.Video == nil {
+		t.Errorf("Expected the video message, got %+v (%v)", got, err)
+	}
+	album := tgbotapi.NewMediaGroup(customer.ID, []interface{}{
+		tgbotapi.NewInputMediaPhoto(tgbotapi.FileID("album-photo")),
+		tgbotapi.NewInputMediaVideo(tgbotapi.FileID("album-video")),
+	})
+	if _, err := api.SendMediaGroup(album); err != nil {
+		t.Errorf("Failed to send media group: %v", err)
+	}
+	sent, err = fake.WaitSent(4, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent[0].Document != "manual-file" || sent[0].Text != "manual" || sent[1].Video != "demo-video" ||
+		sent[2].Photo != "album-photo" || sent[3].Video != "album-video" || sent[3].Method != "sendMediaGroup" {
+		t.Errorf("Expected the document, video and album to be recorded, got %+v", sent)
+	}
+
+	file := fake.AddFile([]byte("photo"))
+	if got, err := api.GetFile(tgbotapi.FileConfig{FileID: file.FileID}); err != nil || got.FilePath != file.FilePath {
+		t.Errorf("Expected file path %s, got %s (%v)", file.FilePath, got.FilePath, err)
+	}
+
+	webhook, _ := tgbotapi.NewWebhook("https://example.com/webhook")
+	if _, err := api.Request(webhook); err != nil {
+		t.Fatalf("Failed to set webhook: %v", err)
+	}
+	if url := fake.Webhook().URL; url != "https://example.com/webhook" {
+		t.Errorf("Expected webhook to be set, got %q", url)
+	}
+
+	// A removed bot fails like a revoked token
+	server.RemoveBot("4:fake-api-test")
+	var apiErr *tgbotapi.Error
+	if _, err := api.GetMe(); !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a removed bot, got %v", err)
+	}
+
+	t.Log("✅ Fake Bot API tests passed")
+}
+
+// startStoreBot runs the bot of a store the way production does, through
+// the bot manager and the store bot factory, and waits until it is running.
+// The returned function stops it.
+func startStoreBot(t *testing.T, testConfig *TestConfig, storeID uint) func() {
+	t.Helper()
+	botManager := services.NewBotManagerService(testConfig.Bot, testConfig.DB)
+	botManager.SetStoreBotFactory(bot.NewStoreBotFactory(testConfig.DB, nil, bot.DefaultUpdateWorkers))
+	if err := botManager.StartSubBot(storeID); err != nil {
+		t.Fatalf("Failed to start store bot: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime, ok := botManager.GetBotRuntime(storeID)
+		if ok && runtime.State == services.BotRunStateRunning {
+			return botManager.StopAllBots
+		}
+		if time.Now().After(deadline) {
+			botManager.StopAllBots()
+			t.Fatalf("Store bot did not start, runtime: %+v", runtime)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// storeBotFixture is an active store with an owner and a bot on a fake Bot API
+type storeBotFixture struct {
+	t          *testing.T
+	testConfig *TestConfig
+	fake       *telegramtest.Bot
+	store      *models.Store
+	owner      *models.User
+}
+
+// newStoreBotFixture starts a fake Bot API for the test, points the services
+// at it and creates a store whose bot is registered there as name_test_bot.
+// The bot itself is started with startStoreBot once the test data is in.
+func newStoreBotFixture(t *testing.T, testConfig *TestConfig, name string) *storeBotFixture {
+	t.Helper()
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+	services.SetBotAPIEndpoint(server.Endpoint())
+	t.Cleanup(func() { services.SetBotAPIEndpoint("") })
+
+	owner := &models.User{TelegramID: time.Now().UnixNano(), FirstName: "Owner"}
+	if err := testConfig.DB.Create(owner).Error; err != nil {
+		t.Fatalf("Failed to create owner: %v", err)
+	}
+	token := fmt.Sprintf("%d:%s-token-0123456789abcdef", time.Now().Unix(), strings.ReplaceAll(name, "_", "-"))
+	store := &models.Store{
+		OwnerID:   owner.ID,
+		Name:      fmt.Sprintf("%s test store", name),
+		PlanType:  models.PlanFree,
+		BotToken:  token,
+		ExpiresAt: time.Now().AddDate(0, 1, 0),
+		IsActive:  true,
+	}
+	if err := testConfig.DB.Omit("Owner").Create(store).Error; err != nil {
+		t.Fatalf("Failed to create test store: %v", err)
+	}
+	return &storeBotFixture{
+		t:          t,
+		testConfig: testConfig,
+		fake:       server.AddBot(token, name+"_test_bot"),
+		store:      store,
+		owner:      owner,
+	}
+}
+
+// addProduct adds an available product to the fixture's store
+func (f *storeBotFixture) addProduct(product *models.Product) *models.Product {
+	f.t.Helper()
+	product.StoreID = f.store.ID
+	product.IsAvailable = true
+	if err := f.testConfig.DB.Omit("Store").Create(product).Error; err != nil {
+		f.t.Fatalf("Failed to create product: %v", err)
+	}
+	return product
+}
+
+// ownerUser is the store's owner as the fake Bot API sees them
+func (f *storeBotFixture) ownerUser() tgbotapi.User {
+	return tgbotapi.User{ID: f.owner.TelegramID, FirstName: f.owner.FirstName}
+}
+
+// exchange injects an update and returns the n messages the bot sent in answer
+func (f *storeBotFixture) exchange(n int, inject func() (tgbotapi.Update, error)) []telegramtest.Message {
+	f.t.Helper()
+	f.fake.ClearSent()
+	if _, err := inject(); err != nil {
+		f.t.Fatalf("Failed to inject update: %v", err)
+	}
+	sent, err := f.fake.WaitSent(n, 5*time.Second)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return sent
+}
+
+// TestSubBotConversation tests a purchase in a store bot end to end
+func TestSubBotConversation(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	f := newStoreBotFixture(t, testConfig, "conversation")
+	testStore, fake, reply := f.store, f.fake, f.exchange
+	product := f.addProduct(&models.Product{Name: "Test Mug", Price: 250000})
+
+	// The owner is told about the order only if the store bot loads them
+	defer startStoreBot(t, testConfig, testStore.ID)()
+
+	customer := tgbotapi.User{ID: 2001, FirstName: "Customer"}
+
+	welcome := reply(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "/start") })
+	if buttons := strings.Join(welcome[0].Buttons(), " "); !strings.Contains(buttons, "🛍 محصولات") {
+		t.Errorf("Expected the main menu keyboard, got %q", buttons)
+	}
+
+	list := reply(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "🛍 محصولات") })
+	buy, ok := list[0].CallbackData("Test Mug")
+	if !ok || buy != fmt.Sprintf("buy_%d", product.ID) {
+		t.Fatalf("Expected a buy button for the product, got %q", buy)
+	}
+	if !strings.Contains(list[0].Text, "250,000") {
+		t.Errorf("Expected the product price in the list, got %q", list[0].Text)
+	}
+
+	card := reply(1, func() (tgbotapi.Update, error) { return fake.Press(customer, list[0], buy) })
+	add, ok := card[0].CallbackData("افزودن به سبد خرید")
+	if !ok {
+		t.Fatalf("Expected an add to cart button, got %v", card[0].Buttons())
+	}
+
+	// Cart buttons edit the card in place
+	edited := reply(1, func() (tgbotapi.Update, error) { return fake.Press(customer, card[0], add) })
+	if edited[0].Method != "editMessageText" || edited[0].MessageID != card[0].MessageID {
+		t.Errorf("Expected the card to be edited, got %s of message %d", edited[0].Method, edited[0].MessageID)
+	}
+	reply(1, func() (tgbotapi.Update, error) { return fake.Press(customer, edited[0], add) })
+
+	cart := reply(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "/cart") })
+	if !strings.Contains(cart[0].Text, "500,000") {
+		t.Errorf("Expected a cart total of 500,000, got %q", cart[0].Text)
+	}
+	confirmOrder, ok := cart[0].CallbackData("ثبت سفارش")
+	if !ok {
+		t.Fatalf("Expected a checkout button, got %v", cart[0].Buttons())
+	}
+
+	// Checkout asks for the delivery details
+	reply(1, func() (tgbotapi.Update, error) { return fake.Press(customer, cart[0], confirmOrder) })
+	askPhone := reply(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "Tehran, Azadi St. 1") })
+	if buttons := strings.Join(askPhone[0].Buttons(), " "); !strings.Contains(buttons, "ارسال شماره تماس") {
+		t.Errorf("Expected a contact button, got %q", buttons)
+	}
+	reply(1, func() (tgbotapi.Update, error) { return fake.SendContact(customer, "+989121234567") })
+	review := reply(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "بدون توضیحات") })
+	confirmCheckout, ok := review[0].CallbackData("تایید و ثبت سفارش")
+	if !ok {
+		t.Fatalf("Expected the order review, got %q", review[0].Text)
+	}
+
+	// The customer gets the confirmation and the owner is told about the order
+	placed := reply(2, func() (tgbotapi.Update, error) { return fake.Press(customer, review[0], confirmCheckout) })
+	chats := map[int64]bool{}
+	for _, msg := range placed {
+		chats[msg.ChatID] = true
+	}
+	if !chats[customer.ID] || !chats[f.owner.TelegramID] {
+		t.Errorf("Expected messages to the customer and the owner, got %+v", placed)
+	}
+
+	var order models.Order
+	if err := testConfig.DB.Preload("OrderItems").Where("store_id = ?", testStore.ID).First(&order).Error; err != nil {
+		t.Fatalf("Expected an order to be created: %v", err)
+	}
+	if order.TotalAmount != 500000 || len(order.OrderItems) != 1 || order.OrderItems[0].Quantity != 2 {
+		t.Errorf("Expected 2 mugs for 500000, got %+v", order)
+	}
+	if order.DeliveryPhone != "+989121234567" || order.DeliveryAddress != "Tehran, Azadi St. 1" {
+		t.Errorf("Expected the delivery details on the order, got %q, %q", order.DeliveryAddress, order.DeliveryPhone)
+	}
+
+	t.Log("✅ Sub-bot conversation tests passed")
+}
+
+// TestStoreCatalog tests browsing the store bot's catalog: the category
+// menu, product pages edited in place and photo product cards
+func TestStoreCatalog(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	f := newStoreBotFixture(t, testConfig, "catalog")
+	testStore, fake := f.store, f.fake
+	products := []*models.Product{
+		{Name: "Cap", Category: "Hats", Price: 90000, ThumbnailFileID: "cap-photo"},
+		{Name: "Sold Out Boot", Category: "Shoes", Price: 10000, TrackStock: true},
+	}
+	for i := 1; i <= 10; i++ {
+		products = append(products, &models.Product{
+			Name: fmt.Sprintf("Shoe %d", i), Category: "Shoes", Price: 100000,
+		})
+	}
+	for _, product := range products {
+		f.addProduct(product)
+	}
+
+	subBot, err := bot.NewSubBot(testStore.BotToken, testConfig.DB, testStore)
+	if err != nil {
+		t.Fatalf("Failed to create sub-bot: %v", err)
+	}
+	go subBot.Start()
+	defer subBot.Stop()
+
+	customer := tgbotapi.User{ID: 2002, FirstName: "Customer"}
+	press := func(on telegramtest.Message, button string) telegramtest.Message {
+		t.Helper()
+		data, ok := on.CallbackData(button)
+		if !ok {
+			t.Fatalf("Expected a %q button, got %v", button, on.Buttons())
+		}
+		return f.exchange(1, func() (tgbotapi.Update, error) { return fake.Press(customer, on, data) })[0]
+	}
+
+	// Sold out products are left out of the counts
+	menu := f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, "/products") })[0]
+	if buttons := strings.Join(menu.Buttons(), " "); !strings.Contains(buttons, "Hats (1)") || !strings.Contains(buttons, "Shoes (10)") {
+		t.Fatalf("Expected the category menu, got %q", buttons)
+	}
+
+	page := press(menu, "Shoes")
+	if page.Method != "editMessageText" || page.MessageID != menu.MessageID {
+		t.Errorf("Expected the menu to be edited in place, got %s of message %d", page.Method, page.MessageID)
+	}
+	if !strings.Contains(page.Text, "صفحه 1 از 2") || strings.Contains(page.Text, "Sold Out Boot") {
+		t.Errorf("Expected the first of two pages without sold out products, got %q", page.Text)
+	}
+	page = press(page, "▶️")
+	if !strings.Contains(page.Text, "صفحه 2 از 2") || page.MessageID != menu.MessageID {
+		t.Errorf("Expected the second page in place, got %q", page.Text)
+	}
+	if _, ok := page.CallbackData("▶️"); ok {
+		t.Errorf("Expected no next button on the last page")
+	}
+
+	menu = press(page, "دسته‌بندی‌ها")
+	page = press(menu, "Hats")
+	card := press(page, "Cap")
+	if card.Method != "sendPhoto" || card.Photo != "cap-photo" || !strings.Contains(card.Text, "Cap") {
+		t.Fatalf("Expected a photo card of the product, got %s %q", card.Method, card.Photo)
+	}
+	edited := press(card, "افزودن به سبد خرید")
+	if edited.Method != "editMessageCaption" || edited.MessageID != card.MessageID {
+		t.Errorf("Expected the photo card's caption to be edited, got %s of message %d", edited.Method, edited.MessageID)
+	}
+
+	t.Log("✅ Store catalog tests passed")
+}
+
+// TestProductSearch tests /search and sharing products through inline mode
+func TestProductSearch(t *testing.T) {
+	testConfig := setupTestEnvironment(t)
+
+	f := newStoreBotFixture(t, testConfig, "search")
+	testStore, fake := f.store, f.fake
+	shoe := &models.Product{Name: "Running Shoe", Price: 250000, ThumbnailFileID: "shoe-photo"}
+	sock := &models.Product{Name: "Wool Sock", Description: "Warm, for any shoe", Price: 40000}
+	hidden := &models.Product{Name: "Hidden Shoe", Price: 1000}
+	for _, product := range []*models.Product{shoe, sock, hidden} {
+		f.addProduct(product)
+	}
+	// Created available, as a false IsAvailable is left to the column default
+	if err := testConfig.DB.Model(hidden).Update("is_available", false).Error; err != nil {
+		t.Fatalf("Failed to hide product: %v", err)
+	}
+
+	subBot, err := bot.NewSubBot(testStore.BotToken, testConfig.DB, testStore)
+	if err != nil {
+		t.Fatalf("Failed to create sub-bot: %v", err)
+	}
+	go subBot.Start()
+	defer subBot.Stop()
+
+	customer := tgbotapi.User{ID: 2003, FirstName: "Customer"}
+	send := func(text string) telegramtest.Message {
+		t.Helper()
+		return f.exchange(1, func() (tgbotapi.Update, error) { return fake.SendText(customer, text) })[0]
+	}
+
+	// Descriptions match too, unavailable products don't
+	results := send("/search shoe")
+	if !strings.Contains(results.Text, "Running Shoe") || !strings.Contains(results.Text, "Wool Sock") || strings.Contains(results.Text, "Hidden Shoe") {
+		t.Errorf("Expected the two available matches, got %q", results.Text)
+	}
+	if _, ok := results.CallbackData("Running Shoe"); !ok {
+		t.Errorf("Expected a button to the product's card, got %v", results.Buttons())
+	}
+	if none := send("/search hat"); !strings.Contains(none.Text, "پیدا نشد") {
+		t.Errorf("Expected no results, got %q", none.Text)
+	}
+
+	_, err = fake.Inject(tgbotapi.Update{InlineQuery: &tgbotapi.InlineQuery{ID: "inline-1", From: &customer, Query: "shoe"}})
+	if err != nil {
+		t.Fatalf("Failed to inject inline query: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.Requests("answerInlineQuery")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	answers := fake.Requests("answerInlineQuery")
+	if len(answers) != 1 {
+		t.Fatalf("Expected the inline query to be answered, got %d answers", len(answers))
+	}
+	inline := answers[0].Get("results")
+	if !strings.Contains(inline, `"shoe-photo"`) || !strings.Contains(inline, `"type":"article"`) || strings.Contains(inline, "Hidden Shoe") {
+		t.Errorf("Expected a photo and an article result, got %s", inline)
+	}
+	if link := fmt.Sprintf("https://t.me/search_test_bot?start=buy_%d", shoe.ID); !strings.Contains(inline, link) {
+		t.Errorf("Expected the buy link %s, got %s", link, inline)
+	}
+
+	// The shared link opens the product's card
+	card := send(fmt.Sprintf("/start buy_%d", shoe.ID))
+	if card.Method != "sendPhoto" || !strings.Contains(card.Text, "Running Shoe") {
+		t.Errorf("Expected the product's card, got %s %q", card.Method, card.Text)
+	}
+
+	t.Log("✅ Product search tests passed")
+}
+
 // TestCompleteWorkflow tests the complete workflow
 func TestCompleteWorkflow(t *testing.T) {
 	testConfig := setupTestEnvironment(t)